@@ -0,0 +1,96 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package catalog
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// The size (lon, lat) in degrees of each Maidenhead grid level: field, square, subsquare and extended square.
+var gridCellSize = [4][2]float64{
+	{20, 10},
+	{2, 1},
+	{2.0 / 24, 1.0 / 24},
+	{2.0 / 240, 1.0 / 240},
+}
+
+// GridToLatLon returns the position (in decimal degrees) of the center of the given Maidenhead grid square.
+//
+// 2, 4, 6 and 8-character grids (e.g. JO, JO39, JO39EQ and JO39EQ55) are supported.
+func GridToLatLon(grid string) (lat, lon float64, err error) {
+	grid = strings.ToUpper(strings.TrimSpace(grid))
+	if len(grid) < 2 || len(grid) > 8 || len(grid)%2 != 0 {
+		return 0, 0, fmt.Errorf("invalid grid square length: '%s'", grid)
+	}
+
+	lon, lat = -180, -90
+	for i := 0; i < len(grid); i += 2 {
+		level := i / 2
+		var first, max byte
+		switch level {
+		case 0:
+			first, max = 'A', 18
+		case 1, 3:
+			first, max = '0', 10
+		case 2:
+			first, max = 'A', 24
+		}
+
+		x, y := grid[i]-first, grid[i+1]-first
+		if grid[i] < first || grid[i+1] < first || x >= max || y >= max {
+			return 0, 0, fmt.Errorf("invalid grid square: '%s'", grid)
+		}
+		lon += float64(x) * gridCellSize[level][0]
+		lat += float64(y) * gridCellSize[level][1]
+	}
+
+	// Center of the square
+	size := gridCellSize[len(grid)/2-1]
+	return lat + size[1]/2, lon + size[0]/2, nil
+}
+
+// LatLonToGrid returns the Maidenhead grid square containing the given position (in decimal degrees).
+//
+// Precision is the number of characters in the returned grid (2, 4, 6 or 8). Other values are
+// truncated to the nearest supported precision within this range.
+func LatLonToGrid(lat, lon float64, precision int) string {
+	switch {
+	case precision < 2:
+		precision = 2
+	case precision > 8:
+		precision = 8
+	}
+	precision -= precision % 2
+
+	// Keep within bounds (the north pole and anti-meridian belongs to the last square)
+	lon = math.Min(math.Max(lon+180, 0), 360-1e-9)
+	lat = math.Min(math.Max(lat+90, 0), 180-1e-9)
+
+	var grid []byte
+	for level := 0; level < precision/2; level++ {
+		first := byte('A')
+		if level%2 == 1 {
+			first = '0'
+		}
+		x := math.Floor(lon / gridCellSize[level][0])
+		y := math.Floor(lat / gridCellSize[level][1])
+		grid = append(grid, first+byte(x), first+byte(y))
+		lon -= x * gridCellSize[level][0]
+		lat -= y * gridCellSize[level][1]
+	}
+	return string(grid)
+}
+
+// NewPosReportFromGrid returns a PosReport positioned at the center of the given Maidenhead grid square.
+func NewPosReportFromGrid(grid string) (*PosReport, error) {
+	lat, lon, err := GridToLatLon(grid)
+	if err != nil {
+		return nil, err
+	}
+	return &PosReport{Date: time.Now(), Lat: &lat, Lon: &lon}, nil
+}
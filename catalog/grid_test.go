@@ -0,0 +1,84 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package catalog
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGridToLatLon(t *testing.T) {
+	tests := map[string][2]float64{
+		"JO":       {55, 10},
+		"JO39":     {59.5, 7},
+		"JO39EQ":   {59.6875, 6.375},
+		"jo39eq":   {59.6875, 6.375},
+		"JO39EQ55": {59.6895833, 6.3791667},
+		"FN31pr":   {41.7291667, -72.7083333},
+		"AA00aa":   {-89.9791667, -179.9583333},
+		"RR99xx":   {89.9791667, 179.9583333},
+	}
+	for grid, expect := range tests {
+		lat, lon, err := GridToLatLon(grid)
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %s", grid, err)
+		} else if math.Abs(lat-expect[0]) > 1e-6 || math.Abs(lon-expect[1]) > 1e-6 {
+			t.Errorf("%s: Got %f,%f expected %f,%f", grid, lat, lon, expect[0], expect[1])
+		}
+	}
+
+	for _, grid := range []string{"", "J", "JO3", "SO39", "JOA9", "JO39EZ", "JO39EQ5A", "JO39EQ55AA"} {
+		if _, _, err := GridToLatLon(grid); err == nil {
+			t.Errorf("%s: Expected error", grid)
+		}
+	}
+}
+
+func TestLatLonToGrid(t *testing.T) {
+	tests := []struct {
+		lat, lon  float64
+		precision int
+		expect    string
+	}{
+		{59.6875, 6.375, 6, "JO39EQ"},
+		{59.6875, 6.375, 4, "JO39"},
+		{59.6875, 6.375, 8, "JO39EQ55"},
+		{59.6875, 6.375, 7, "JO39EQ"},
+		{59.6875, 6.375, 1, "JO"},
+		{41.714775, -72.727260, 6, "FN31PR"},
+		{90, 180, 6, "RR99XX"},
+		{-90, -180, 6, "AA00AA"},
+	}
+	for _, test := range tests {
+		if got := LatLonToGrid(test.lat, test.lon, test.precision); got != test.expect {
+			t.Errorf("%f,%f (%d): Got %s, expected %s", test.lat, test.lon, test.precision, got, test.expect)
+		}
+	}
+}
+
+func TestGridRoundtrip(t *testing.T) {
+	for _, grid := range []string{"JO", "JO39", "JO39EQ", "JO39EQ55", "FN31PR", "AA00AA", "RR99XX99"} {
+		lat, lon, err := GridToLatLon(grid)
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %s", grid, err)
+		}
+		if got := LatLonToGrid(lat, lon, len(grid)); got != grid {
+			t.Errorf("Got %s, expected %s", got, grid)
+		}
+	}
+}
+
+func TestNewPosReportFromGrid(t *testing.T) {
+	p, err := NewPosReportFromGrid("JO39EQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Lat == nil || p.Lon == nil || *p.Lat != 59.6875 || *p.Lon != 6.375 {
+		t.Errorf("Unexpected position: %v,%v", p.Lat, p.Lon)
+	}
+	if _, err := NewPosReportFromGrid("XX"); err == nil {
+		t.Error("Expected error on invalid grid")
+	}
+}
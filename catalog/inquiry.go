@@ -0,0 +1,102 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package catalog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+// InquiryKind is the kind of product requested by an InquiryRequest.
+type InquiryKind int
+
+const (
+	InquiryText        InquiryKind = iota // Catalog items by name (e.g. US.NWS.ZFP)
+	InquiryMETAR                          // Weather observations (METAR) by ICAO station ID (e.g. ENBR)
+	InquiryPropagation                    // HF propagation reports by Maidenhead locator (e.g. JO39EQ)
+)
+
+// InquiryRequest is a request for one or more data products from the Winlink catalog service.
+type InquiryRequest struct {
+	Kind  InquiryKind
+	Items []string // Catalog item names, station IDs or locators (depending on Kind)
+}
+
+var (
+	reCatalogItem = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._\-]*$`)
+	reICAOStation = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]{3}$`)
+)
+
+// NewTextInquiry returns a request for the given catalog items.
+func NewTextInquiry(items ...string) (*InquiryRequest, error) {
+	r := &InquiryRequest{Kind: InquiryText, Items: items}
+	return r, r.Validate()
+}
+
+// NewMETARInquiry returns a request for the latest weather observation (METAR) from the given ICAO stations.
+func NewMETARInquiry(stations ...string) (*InquiryRequest, error) {
+	r := &InquiryRequest{Kind: InquiryMETAR, Items: stations}
+	return r, r.Validate()
+}
+
+// Validate returns an error if the request is empty or contains malformed item names or station IDs.
+func (r InquiryRequest) Validate() error {
+	if len(r.Items) == 0 {
+		return errors.New("no items requested")
+	}
+	for _, item := range r.Items {
+		switch r.Kind {
+		case InquiryText:
+			if !reCatalogItem.MatchString(item) {
+				return fmt.Errorf("invalid catalog item '%s'", item)
+			}
+		case InquiryMETAR:
+			if !reICAOStation.MatchString(item) {
+				return fmt.Errorf("invalid ICAO station ID '%s'", item)
+			}
+		case InquiryPropagation:
+			if _, _, err := GridToLatLon(item); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown inquiry kind %d", r.Kind)
+		}
+	}
+	return nil
+}
+
+// Message returns the request as a message addressed to the catalog service (INQUIRY).
+//
+// The request is expected to be valid (see Validate).
+func (r InquiryRequest) Message(mycall string) *fbb.Message {
+	var buf bytes.Buffer
+	for _, item := range r.Items {
+		switch r.Kind {
+		case InquiryMETAR:
+			fmt.Fprintf(&buf, "METAR %s\r\n", strings.ToUpper(item))
+		case InquiryPropagation:
+			fmt.Fprintf(&buf, "PROPAGATION %s\r\n", strings.ToUpper(item))
+		default:
+			fmt.Fprintf(&buf, "%s\r\n", item)
+		}
+	}
+
+	msg := fbb.NewMessage(fbb.Inquiry, mycall)
+
+	err := msg.SetBody(buf.String())
+	if err != nil {
+		panic(err)
+	}
+
+	msg.SetSubject("REQUEST")
+	msg.AddTo("INQUIRY")
+
+	return msg
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package catalog
+
+import (
+	"testing"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+func TestInquiryRequestValidate(t *testing.T) {
+	tests := map[*InquiryRequest]bool{
+		{Kind: InquiryText}: false,
+		{Kind: InquiryText, Items: []string{"US.NWS.ZFP"}}:    true,
+		{Kind: InquiryText, Items: []string{"WL2K_NEARBY"}}:   true,
+		{Kind: InquiryText, Items: []string{"FOO BAR"}}:       false,
+		{Kind: InquiryMETAR, Items: []string{"ENBR", "kbos"}}: true,
+		{Kind: InquiryMETAR, Items: []string{"ENB"}}:          false,
+		{Kind: InquiryMETAR, Items: []string{"1ENB"}}:         false,
+		{Kind: InquiryMETAR, Items: []string{"ENBR1"}}:        false,
+		{Kind: InquiryPropagation, Items: []string{"jo39eq"}}: true,
+		{Kind: InquiryPropagation, Items: []string{"JO39E"}}:  false,
+	}
+	for r, valid := range tests {
+		if err := r.Validate(); (err == nil) != valid {
+			t.Errorf("%v: Got error %v, expected valid=%t", r.Items, err, valid)
+		}
+	}
+}
+
+func TestInquiryRequestMessage(t *testing.T) {
+	tests := []struct {
+		req    func() (*InquiryRequest, error)
+		expect string
+	}{
+		{func() (*InquiryRequest, error) { return NewTextInquiry("US.NWS.ZFP", "WL2K_NEARBY") }, "US.NWS.ZFP\r\nWL2K_NEARBY\r\n"},
+		{func() (*InquiryRequest, error) { return NewMETARInquiry("enbr", "KBOS") }, "METAR ENBR\r\nMETAR KBOS\r\n"},
+	}
+	for i, test := range tests {
+		r, err := test.req()
+		if err != nil {
+			t.Fatalf("%d: Unexpected error: %s", i, err)
+		}
+		msg := r.Message("N0CALL")
+		if body, _ := msg.Body(); body != test.expect {
+			t.Errorf("%d: Got body %q, expected %q", i, body, test.expect)
+		}
+		if msg.Subject() != "REQUEST" || msg.Type() != fbb.Inquiry {
+			t.Errorf("%d: Unexpected subject/type: %s/%s", i, msg.Subject(), msg.Type())
+		}
+		if to := msg.To(); len(to) != 1 || to[0].String() != "INQUIRY" {
+			t.Errorf("%d: Unexpected receivers: %v", i, to)
+		}
+		if err := msg.Validate(); err != nil {
+			t.Errorf("%d: Invalid message: %s", i, err)
+		}
+	}
+}
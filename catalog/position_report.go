@@ -10,6 +10,8 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/la5nta/wl2k-go/fbb"
@@ -79,6 +81,98 @@ func (p PosReport) Message(mycall string) *fbb.Message {
 	return msg
 }
 
+// ParsePosReport parses the body of a received position report message (see PosReport.Message).
+//
+// Optional fields missing from the body are left nil (or empty).
+func ParsePosReport(body string) (PosReport, error) {
+	var p PosReport
+	for _, line := range strings.Split(body, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch strings.ToUpper(strings.TrimSpace(key)) {
+		case "DATE":
+			p.Date, err = time.Parse(fbb.DateLayout, value)
+		case "LATITUDE":
+			p.Lat, err = minDecToDec(value, true)
+		case "LONGITUDE":
+			p.Lon, err = minDecToDec(value, false)
+		case "SPEED":
+			var speed float64
+			if speed, err = strconv.ParseFloat(value, 64); err == nil {
+				p.Speed = &speed
+			}
+		case "COURSE":
+			p.Course, err = parseCourse(value)
+		case "COMMENT":
+			p.Comment = value
+		}
+		if err != nil {
+			return p, fmt.Errorf("invalid %s: %w", strings.TrimSpace(key), err)
+		}
+	}
+	return p, nil
+}
+
+// parseCourse parses the format produced by Course.String (e.g. 123T or 045M).
+func parseCourse(str string) (*Course, error) {
+	if len(str) < 2 {
+		return nil, errors.New("too short")
+	}
+
+	var magnetic bool
+	switch str[len(str)-1] {
+	case 'M':
+		magnetic = true
+	case 'T':
+	default:
+		return nil, errors.New("missing T or M suffix")
+	}
+
+	degrees, err := strconv.Atoi(strings.TrimSpace(str[:len(str)-1]))
+	if err != nil {
+		return nil, err
+	}
+	return NewCourse(degrees, magnetic)
+}
+
+// Inverse of decToMinDec. Format: 23-42.3N
+func minDecToDec(str string, latitude bool) (*float64, error) {
+	degStr, minStr, ok := strings.Cut(strings.TrimSpace(str), "-")
+	if !ok || minStr == "" {
+		return nil, errors.New("expected format DD-MM.MMMMH")
+	}
+
+	sign := 1.0
+	switch last := minStr[len(minStr)-1]; {
+	case latitude && last == 'N', !latitude && last == 'E':
+		minStr = minStr[:len(minStr)-1]
+	case latitude && last == 'S', !latitude && last == 'W':
+		minStr = minStr[:len(minStr)-1]
+		sign = -1
+	case last >= '0' && last <= '9':
+		// No hemisphere (zero)
+	default:
+		return nil, fmt.Errorf("unexpected hemisphere '%c'", last)
+	}
+
+	deg, err := strconv.Atoi(degStr)
+	if err != nil {
+		return nil, err
+	}
+	min, err := strconv.ParseFloat(minStr, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := sign * (float64(deg) + min/60.0)
+	return &dec, nil
+}
+
 // Format: 23-42.3N
 func decToMinDec(dec float64, latitude bool) string {
 	var sign byte
@@ -5,7 +5,9 @@
 package catalog
 
 import (
+	"math"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -41,6 +43,77 @@ func TestDecToDM(t *testing.T) {
 	}
 }
 
+func TestMinDecToDec(t *testing.T) {
+	for _, dec := range []float64{-4.974, -0.5, 0.0, 0.5, 60.132} {
+		got, err := minDecToDec(decToMinDec(dec, true), true)
+		if err != nil {
+			t.Errorf("Unexpected error on input %f: %s", dec, err)
+		} else if math.Abs(*got-dec) > 1e-5 {
+			t.Errorf("On input %f, got %f", dec, *got)
+		}
+	}
+	for _, dec := range []float64{-180.0, -60.50, 0.0, 3.50, 153.50, 180.0} {
+		got, err := minDecToDec(decToMinDec(dec, false), false)
+		if err != nil {
+			t.Errorf("Unexpected error on input %f: %s", dec, err)
+		} else if math.Abs(*got-dec) > 1e-5 {
+			t.Errorf("On input %f, got %f", dec, *got)
+		}
+	}
+	for _, str := range []string{"", "60", "60-", "60-07.92X", "060-07.9200N"} {
+		if _, err := minDecToDec(str, false); err == nil {
+			t.Errorf("Expected error on input %q", str)
+		}
+	}
+}
+
+func TestParsePosReportRoundtrip(t *testing.T) {
+	lat, lon, speed := 60.18, -5.3972, 12.5
+	tests := []PosReport{
+		{
+			Date:    time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC),
+			Lat:     &lat,
+			Lon:     &lon,
+			Speed:   &speed,
+			Course:  omitErr(NewCourse(45, true)),
+			Comment: "Hjemme QTH",
+		},
+		{
+			// Optional fields omitted
+			Date: time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC),
+		},
+	}
+	for i, expect := range tests {
+		body, _ := expect.Message("N0CALL").Body()
+		got, err := ParsePosReport(body)
+		if err != nil {
+			t.Fatalf("%d: Unexpected error: %s", i, err)
+		}
+		if !got.Date.Equal(expect.Date) {
+			t.Errorf("%d: Got date %s, expected %s", i, got.Date, expect.Date)
+		}
+		if !floatPtrEqual(got.Lat, expect.Lat) || !floatPtrEqual(got.Lon, expect.Lon) {
+			t.Errorf("%d: Got position %v,%v expected %v,%v", i, got.Lat, got.Lon, expect.Lat, expect.Lon)
+		}
+		if !floatPtrEqual(got.Speed, expect.Speed) {
+			t.Errorf("%d: Got speed %v, expected %v", i, got.Speed, expect.Speed)
+		}
+		if !reflect.DeepEqual(got.Course, expect.Course) {
+			t.Errorf("%d: Got course %v, expected %v", i, got.Course, expect.Course)
+		}
+		if got.Comment != expect.Comment {
+			t.Errorf("%d: Got comment %q, expected %q", i, got.Comment, expect.Comment)
+		}
+	}
+}
+
+func floatPtrEqual(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return math.Abs(*a-*b) < 1e-5
+}
+
 func omitErr(v *Course, _ error) *Course { return v }
 
 func TestCourseStringer2(t *testing.T) {
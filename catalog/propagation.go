@@ -0,0 +1,120 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+// SolarReportItem is the catalog item holding the latest solar-terrestrial indices
+// (NOAA SWPC's geophysical alert message, as broadcast on WWV).
+const SolarReportItem = "WWV"
+
+// SolarReport holds the solar-terrestrial indices of a solar report (see ParseSolarReport).
+type SolarReport struct {
+	Issued    time.Time
+	SolarFlux int     // 10.7 cm solar flux
+	AIndex    int     // Estimated planetary A-index
+	KIndex    float64 // Estimated planetary K-index
+}
+
+// BandCondition is the predicted HF propagation conditions for a range of bands.
+type BandCondition struct {
+	Bands      string // E.g. 80m-40m
+	Day, Night string // E.g. Poor, Fair or Good
+}
+
+// PropagationReport holds the HF propagation conditions of a propagation report (see ParsePropagationReport).
+type PropagationReport struct {
+	Grid  string // The locator the report applies to (if given)
+	Bands []BandCondition
+}
+
+var (
+	reSolarIssued = regexp.MustCompile(`(?m)^:Issued:\s*(\d{4} \w{3} \d{2} \d{4}) UTC`)
+	reSolarFlux   = regexp.MustCompile(`(?i)solar flux\s+(\d+)\s+and estimated planetary A-index\s+(\d+)`)
+	reSolarKIndex = regexp.MustCompile(`(?i)estimated planetary K-index at .* was\s+([\d.]*\d)`)
+	reBandRange   = regexp.MustCompile(`^\d+m(-\d+m)?$`)
+)
+
+// SolarReportRequest returns an inquiry message requesting the latest solar report (see SolarReportItem).
+func SolarReportRequest(mycall string) *fbb.Message {
+	return InquiryRequest{Kind: InquiryText, Items: []string{SolarReportItem}}.Message(mycall)
+}
+
+// PropagationRequest returns an inquiry message requesting a HF propagation report for the given Maidenhead locator.
+func PropagationRequest(mycall, grid string) (*fbb.Message, error) {
+	r := InquiryRequest{Kind: InquiryPropagation, Items: []string{grid}}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r.Message(mycall), nil
+}
+
+// ParseSolarReport parses the body of a received solar report (NOAA SWPC's geophysical alert message).
+//
+// The issue time is optional, but the solar flux and A-index are required.
+func ParseSolarReport(body string) (SolarReport, error) {
+	var r SolarReport
+	if m := reSolarIssued.FindStringSubmatch(body); m != nil {
+		t, err := time.Parse("2006 Jan 02 1504", m[1])
+		if err != nil {
+			return r, fmt.Errorf("invalid issue time: %w", err)
+		}
+		r.Issued = t
+	}
+
+	m := reSolarFlux.FindStringSubmatch(body)
+	if m == nil {
+		return r, errors.New("solar flux and A-index not found")
+	}
+	r.SolarFlux, _ = strconv.Atoi(m[1])
+	r.AIndex, _ = strconv.Atoi(m[2])
+
+	if m := reSolarKIndex.FindStringSubmatch(body); m != nil {
+		k, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return r, fmt.Errorf("invalid K-index: %w", err)
+		}
+		r.KIndex = k
+	}
+	return r, nil
+}
+
+// ParsePropagationReport parses the body of a received propagation report.
+//
+// The report is expected to hold one line per range of bands with the day and night conditions,
+// optionally preceded by the locator:
+//
+//	GRID: JO39EQ
+//	80m-40m  Fair  Poor
+//	30m-20m  Good  Fair
+func ParsePropagationReport(body string) (PropagationReport, error) {
+	var r PropagationReport
+	for _, line := range strings.Split(body, "\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			if strings.EqualFold(strings.TrimSpace(key), "GRID") {
+				r.Grid = strings.ToUpper(strings.TrimSpace(value))
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !reBandRange.MatchString(fields[0]) {
+			continue
+		}
+		r.Bands = append(r.Bands, BandCondition{Bands: fields[0], Day: fields[1], Night: fields[2]})
+	}
+	if len(r.Bands) == 0 {
+		return r, errors.New("no band conditions found")
+	}
+	return r, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package catalog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+func TestPropagationRequests(t *testing.T) {
+	prop, err := PropagationRequest("N0CALL", "jo39eq")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	tests := map[*fbb.Message]string{
+		SolarReportRequest("N0CALL"): "WWV\r\n",
+		prop:                         "PROPAGATION JO39EQ\r\n",
+	}
+	for msg, expect := range tests {
+		if body, _ := msg.Body(); body != expect {
+			t.Errorf("Got body %q, expected %q", body, expect)
+		}
+		if msg.Subject() != "REQUEST" || msg.Type() != fbb.Inquiry {
+			t.Errorf("Unexpected subject/type: %s/%s", msg.Subject(), msg.Type())
+		}
+		if to := msg.To(); len(to) != 1 || to[0].String() != "INQUIRY" {
+			t.Errorf("Unexpected receivers: %v", to)
+		}
+		if err := msg.Validate(); err != nil {
+			t.Errorf("Invalid message: %s", err)
+		}
+	}
+
+	if _, err := PropagationRequest("N0CALL", "JO39E"); err == nil {
+		t.Error("Expected error for invalid locator")
+	}
+}
+
+func TestParseSolarReport(t *testing.T) {
+	body := ":Product: Geophysical Alert Message wwv.txt\r\n" +
+		":Issued: 2023 Jan 01 0605 UTC\r\n" +
+		"# Prepared by the US Dept. of Commerce, NOAA, Space Weather Prediction Center\r\n" +
+		"#\r\n" +
+		"Solar-terrestrial indices for 31 December follow.\r\n" +
+		"Solar flux 147 and estimated planetary A-index 5.\r\n" +
+		"The estimated planetary K-index at 0600 UTC on 01 January was 1.67.\r\n"
+
+	got, err := ParseSolarReport(body)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expect := SolarReport{
+		Issued:    time.Date(2023, 1, 1, 6, 5, 0, 0, time.UTC),
+		SolarFlux: 147,
+		AIndex:    5,
+		KIndex:    1.67,
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got %+v, expected %+v", got, expect)
+	}
+
+	if _, err := ParseSolarReport("No indices here\r\n"); err == nil {
+		t.Error("Expected error for report without indices")
+	}
+}
+
+func TestParsePropagationReport(t *testing.T) {
+	body := "GRID: jo39eq\r\n" +
+		"Band     Day   Night\r\n" +
+		"80m-40m  Fair  Poor\r\n" +
+		"30m-20m  Good  Fair\r\n" +
+		"17m-15m  Good  Poor\r\n"
+
+	got, err := ParsePropagationReport(body)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expect := PropagationReport{
+		Grid: "JO39EQ",
+		Bands: []BandCondition{
+			{Bands: "80m-40m", Day: "Fair", Night: "Poor"},
+			{Bands: "30m-20m", Day: "Good", Night: "Fair"},
+			{Bands: "17m-15m", Day: "Good", Night: "Poor"},
+		},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got %+v, expected %+v", got, expect)
+	}
+
+	if _, err := ParsePropagationReport("GRID: JO39EQ\r\n"); err == nil {
+		t.Error("Expected error for report without band conditions")
+	}
+}
@@ -14,12 +14,16 @@ import (
 	"mime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/la5nta/wl2k-go/transport"
 )
 
-var ErrOffsetLimitExceeded error = errors.New("Protocol does not support offset larger than 6 digits")
+var (
+	ErrOffsetLimitExceeded error = errors.New("Protocol does not support offset larger than 6 digits")
+	ErrOffsetOutOfRange    error = errors.New("Offset out of range")
+)
 
 const (
 	ProtocolOffsetSizeLimit = 999999
@@ -50,8 +54,13 @@ const (
 	_CHRSOH      = 1
 	_CHRSTX      = 2
 	_CHREOT      = 4
+	_CHRSUB      = 26 // Ctrl-Z (end of plain ASCII message)
 )
 
+// plainSizeSlack is the number of bytes a plain ASCII (FA) message may exceed its proposed size by,
+// allowing for the title and the line endings of legacy systems.
+const plainSizeSlack = 1024
+
 func (s *Session) handleOutbound(rw io.ReadWriter) (quitSent bool, err error) {
 	var sent map[string]bool
 
@@ -77,17 +86,18 @@ func (s *Session) handleOutbound(rw io.ReadWriter) (quitSent bool, err error) {
 	}
 
 	// Handle session turnover
-	switch {
+	switch policy := s.turnover(); {
 	case len(sent) > 0:
 		// Turnover is implied
-	case s.remoteNoMsgs && len(sent) == 0:
+	case policy == TurnoverQuitWhenDone,
+		policy == TurnoverDefault && s.remoteNoMsgs:
 		s.pLog.Print(">FQ")
-		fmt.Fprint(rw, "FQ\r")
+		fmt.Fprint(s.tx(rw), "FQ\r")
 		quitSent = true
 		return // No need to check for remote error since we did not send any messages
 	default:
 		s.pLog.Print(">FF")
-		fmt.Fprint(rw, "FF\r")
+		fmt.Fprint(s.tx(rw), "FF\r")
 	}
 
 	// Error reporting from remote is not defined by the protocol,
@@ -104,7 +114,7 @@ func (s *Session) handleOutbound(rw io.ReadWriter) (quitSent bool, err error) {
 		if err != nil {
 			return
 		}
-		err = fmt.Errorf("Unexpected response: '%s'", line)
+		err = s.protocolError(fmt.Errorf("Unexpected response: '%s'", line))
 		return
 	}
 
@@ -127,6 +137,16 @@ func (s *Session) sendOutbound(rw io.ReadWriter) (sent map[string]bool, err erro
 		outbound = outbound[0:MaxBlockSize]
 	}
 
+	if s.outboundBlockHook != nil {
+		block := make([]Proposal, len(outbound))
+		for i, prop := range outbound {
+			block[i] = *prop
+		}
+		if err = s.outboundBlockHook(block); err != nil {
+			return sent, fmt.Errorf("Outbound block cancelled: %w", err)
+		}
+	}
+
 	for _, prop := range outbound {
 		sp := fmt.Sprintf("F%c %s %s %d %d %d",
 			prop.code,           // Proposal code
@@ -137,7 +157,7 @@ func (s *Session) sendOutbound(rw io.ReadWriter) (sent map[string]bool, err erro
 			0)                   // ?
 
 		s.pLog.Printf(">%s", sp)
-		fmt.Fprintf(rw, "%s\r", sp)
+		fmt.Fprintf(s.tx(rw), "%s\r", sp)
 		for _, c := range sp {
 			checksum += int64(c)
 		}
@@ -146,7 +166,7 @@ func (s *Session) sendOutbound(rw io.ReadWriter) (sent map[string]bool, err erro
 	checksum = (-checksum) & 0xff
 
 	s.log.Printf(`Sending checksum %02X`, checksum)
-	fmt.Fprintf(rw, "F> %02X\r", checksum)
+	fmt.Fprintf(s.tx(rw), "F> %02X\r", checksum)
 
 	var reply string
 	for reply == "" {
@@ -159,7 +179,7 @@ func (s *Session) sendOutbound(rw io.ReadWriter) (sent map[string]bool, err erro
 		case strings.HasPrefix(line, ";"):
 			continue // Ignore comment
 		default:
-			return sent, fmt.Errorf("Expected proposal answer from remote. Got: '%s'", reply)
+			return sent, s.protocolError(fmt.Errorf("Expected proposal answer from remote. Got: '%s'", line))
 		}
 	}
 
@@ -171,7 +191,9 @@ func (s *Session) sendOutbound(rw io.ReadWriter) (sent map[string]bool, err erro
 		return
 	}
 
-	if r, ok := rw.(transport.Robust); ok && s.robustMode == RobustAuto {
+	s.progress = newTransferProgress(outbound)
+
+	if r, ok := transport.AsRobust(rw); ok && s.robustMode == RobustAuto {
 		r.SetRobust(false)
 		defer r.SetRobust(true)
 	}
@@ -183,9 +205,13 @@ func (s *Session) sendOutbound(rw io.ReadWriter) (sent map[string]bool, err erro
 		case Reject:
 			sent[prop.mid] = true
 		case Accept:
-			if err = s.writeCompressed(rw, prop); err != nil {
+			s.progress.index++
+			err = s.writeCompressed(rw, prop)
+			prop.unload()
+			if err != nil {
 				return
 			}
+			s.progress.bytesDone += prop.compressedSize
 			sent[prop.mid] = false
 		}
 	}
@@ -205,6 +231,10 @@ Loop:
 			return
 		}
 
+		if strings.HasPrefix(line, ";PM: ") {
+			s.addPendingMessage(line)
+		}
+
 		// Ignore comments and empty lines
 		if line == "" || line[0] == ';' {
 			continue
@@ -212,7 +242,7 @@ Loop:
 
 		// The line should be prefixed F? (? is the command character)
 		if len(line) < 2 || line[0] != 'F' {
-			return false, fmt.Errorf("Got unexpected protocol line: '%s'", line)
+			return false, s.protocolError(fmt.Errorf("Got unexpected protocol line: '%s'", line))
 		}
 
 		switch line[:2] {
@@ -229,20 +259,23 @@ Loop:
 			}
 			proposals = append(proposals, prop)
 
-		case "FF": // No more messages
-			break Loop
-
-		case "FQ": // Quit
-			quitReceived = true
+		case "FF", "FQ": // No more messages or quit
+			// A conforming remote never ends its turn in the middle of a proposal block
+			if len(proposals) > 0 {
+				return false, s.protocolError(fmt.Errorf("Got '%s' before end of proposal block (%d proposal(s) pending)", line, len(proposals)))
+			}
+			quitReceived = line[:2] == "FQ"
 			break Loop
 
 		case "F>": // Prompt (end of proposal block)
 			// Verify checksum
 			ourChecksum = (-ourChecksum) & 0xff
-			their, _ := strconv.ParseInt(line[3:], 16, 64)
-			if their != ourChecksum {
-				err = errors.New(fmt.Sprintf(`Checksum error (%d-%d)`, ourChecksum, their))
-				return
+			their, perr := strconv.ParseUint(strings.TrimSpace(line[2:]), 16, 8)
+			if perr != nil {
+				return false, s.protocolError(fmt.Errorf("Malformed proposal block checksum: '%s'", line))
+			}
+			if int64(their) != ourChecksum {
+				return false, s.protocolError(fmt.Errorf("Checksum error (%d-%d)", ourChecksum, their))
 			}
 
 			// If we didn't get any proposals, return
@@ -263,39 +296,104 @@ Loop:
 
 			// Continue receiving proposals if all where rejected/deferred
 			return s.handleInbound(rw)
-		default: //TODO: Ignore?
-			return false, fmt.Errorf("Unknown protocol command %c", line[1])
-		}
-	}
+		default:
+			if !s.lenientProtocol {
+				return false, s.protocolError(fmt.Errorf("Unknown protocol command %c", line[1]))
+			}
 
-	if quitReceived && nAccepted > 0 {
-		return true, errors.New("Got quit command when inbound proposals were pending")
+			prop, ok := unknownProposal(line)
+			if !ok {
+				s.log.Printf("Ignoring unknown protocol command: '%s'", line)
+				continue
+			}
+			for _, c := range line {
+				ourChecksum += int64(c)
+			}
+			ourChecksum += int64('\r')
+			proposals = append(proposals, prop) // Deferred as unsupported format (see writeProposalsAnswer)
+		}
 	}
 
 	// Fetch and decompress accepted
 	s.remoteNoMsgs = true
+	s.progress = newTransferProgress(proposals)
 	for _, prop := range proposals {
 		if prop.answer != Accept {
 			continue
 		}
 		s.remoteNoMsgs = false
+		s.progress.index++
+
+		// The remote is not allowed to quit before the accepted messages are delivered
+		if quitReceived, err = s.peekQuit(); quitReceived || err != nil {
+			break
+		}
 
 		var msg *Message
-		if err = s.readCompressed(rw, prop); err != nil {
+		if prop.code == AsciiProposal {
+			err = s.readPlain(prop)
+		} else {
+			err = s.readCompressed(rw, prop)
+		}
+		if err != nil {
 			return
 		} else if msg, err = prop.Message(); err != nil {
 			return
 		}
 
+		s.progress.bytesDone += prop.compressedSize
+
+		msg.duplicateProposal = prop.duplicate
+		if s.inboundRewriter != nil {
+			if err = s.inboundRewriter(msg); err != nil {
+				return false, fmt.Errorf("Inbound rewrite of %s failed: %w", prop.MID(), err)
+			}
+		}
 		if err = s.h.ProcessInbound(msg); err != nil {
 			return
 		}
 		s.trafficStats.Received = append(s.trafficStats.Received, prop.MID())
+		nAccepted-- // Delivered
 	}
 
+	if quitReceived && nAccepted > 0 {
+		return true, fmt.Errorf("Got quit command when %d accepted inbound proposal(s) were pending", nAccepted)
+	}
 	return
 }
 
+// peekQuit consumes the next line if it is a quit command (FQ).
+func (s *Session) peekQuit() (bool, error) {
+	p, err := s.rd.Peek(2)
+	if err != nil || string(p) != "FQ" {
+		return false, err
+	}
+	_, err = s.nextLine()
+	return true, err
+}
+
+// predicateAnswer returns the answer given by the accept predicate, or NoOpinion if there is none.
+func (s *Session) predicateAnswer(p Proposal) ProposalAnswer {
+	if s.acceptPredicate == nil {
+		return NoOpinion
+	}
+	switch answer := s.acceptPredicate(p); answer {
+	case Accept, Reject, Defer:
+		return answer
+	default:
+		return NoOpinion
+	}
+}
+
+// filterProposal returns the answer given by the handler's ProposalFilter, or Accept if the handler has none.
+func (s *Session) filterProposal(p Proposal) ProposalAnswer {
+	f, ok := s.h.(ProposalFilter)
+	if !ok {
+		return Accept
+	}
+	return f.FilterProposal(p)
+}
+
 // The B2F protocol does not support offsets larger than 6 digits, the author of the protocol
 // seems to have thrown away the idea of supporting transfer of fragmented messages.
 //
@@ -305,19 +403,43 @@ func (s *Session) writeProposalsAnswer(rw io.ReadWriter, proposals []*Proposal)
 	answers := make([]byte, len(proposals))
 
 	seen := make(map[string]bool)
+	count := make(map[string]int)
+	for _, prop := range proposals {
+		count[prop.MID()]++
+	}
 
 	for i, prop := range proposals {
-		if seen[prop.MID()] {
+		prop.duplicate = count[prop.MID()] > 1
+
+		if s.sniff {
+			s.log.Printf("Sniffed %s (type %s, %d bytes, %d compressed)", prop.MID(), prop.msgType, prop.size, prop.compressedSize)
+			s.sniffed = append(s.sniffed, *prop)
+			prop.answer = Reject
+		} else if s.dryRun {
+			s.log.Printf("Defering %s (dry run)", prop.MID())
+			prop.answer = Defer
+		} else if seen[prop.MID()] {
 			// Radio Only gateways will sometimes send multiple proposals for the same MID in the same batch.
 			// Instead of rejecting them right away, let's defer the dups until we know we have sucessfully received at least one of the copies.
 			s.log.Printf("Defering duplicate message %s", prop.MID())
 			prop.answer = Defer
-		} else if prop.code != Wl2kProposal && prop.code != GzipProposal {
+		} else if prop.code != Wl2kProposal && prop.code != GzipProposal && prop.code != AsciiProposal {
 			s.log.Printf("Defering %s (unsupported format)", prop.MID())
 			prop.answer = Defer
+		} else if s.heldMIDs[prop.MID()] {
+			s.log.Printf("Rejecting %s (already held)", prop.MID())
+			prop.answer = Reject
 		} else if s.h == nil {
 			s.log.Printf("Defering %s (missing handler)", prop.MID())
 			prop.answer = Defer
+		} else if answer := s.predicateAnswer(*prop); answer != NoOpinion {
+			s.log.Printf("Answered %s by predicate (%c)", prop.MID(), answer)
+			if prop.answer = answer; answer == Accept {
+				nAccepted++
+			}
+		} else if answer := s.filterProposal(*prop); answer != Accept {
+			s.log.Printf("Filtered %s (%c)", prop.MID(), answer)
+			prop.answer = answer
 		} else if prop.answer = s.h.GetInboundAnswer(*prop); prop.answer == Accept {
 			s.log.Printf("Accepting %s", prop.MID()) //TODO: Remove?
 			nAccepted++
@@ -327,7 +449,7 @@ func (s *Session) writeProposalsAnswer(rw io.ReadWriter, proposals []*Proposal)
 		answers[i] = byte(prop.answer)
 	}
 
-	_, err = fmt.Fprintf(rw, "FS %s\r", answers)
+	_, err = fmt.Fprintf(s.tx(rw), "FS %s\r", answers)
 	return
 }
 
@@ -388,6 +510,18 @@ func parseProposalAnswer(str string, props []*Proposal, l *log.Logger) error {
 }
 
 func (s *Session) writeCompressed(rw io.ReadWriter, p *Proposal) (err error) {
+	if err := p.load(); err != nil {
+		return err
+	}
+
+	// Guard against a corrupt or malicious offset before anything is written
+	switch {
+	case p.offset > ProtocolOffsetSizeLimit:
+		return ErrOffsetLimitExceeded
+	case p.offset < 0 || p.offset > len(p.compressedData):
+		return fmt.Errorf("%w: %d (%s is %d bytes)", ErrOffsetOutOfRange, p.offset, p.MID(), len(p.compressedData))
+	}
+
 	s.log.Printf("Transmitting [%s] [offset %d]", p.title, p.offset)
 
 	if p.code == GzipProposal {
@@ -415,6 +549,11 @@ func (s *Session) writeCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 	}
 
 	buffer := bytes.NewBuffer(p.compressedData[p.offset:])
+	progress := s.progress
+
+	// The number of bytes not yet written, for the status goroutine (buffer is not safe for concurrent use)
+	var remaining atomic.Int64
+	remaining.Store(int64(buffer.Len()))
 
 	// Update Status of message transfer every 250ms
 	statusTicker := time.NewTicker(250 * time.Millisecond)
@@ -423,7 +562,7 @@ func (s *Session) writeCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 		for {
 			select {
 			case <-statusTicker.C:
-				if s.statusUpdater == nil || buffer == nil {
+				if s.statusUpdater == nil {
 					continue
 				}
 
@@ -433,26 +572,28 @@ func (s *Session) writeCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 					txBufLen = b.TxBufferLen()
 				}
 
-				transferred := p.compressedSize - buffer.Len() - txBufLen
+				transferred := p.compressedSize - int(remaining.Load()) - txBufLen
 				if transferred < 0 {
 					transferred = 0
 				}
 
 				if s.statusUpdater != nil {
-					s.statusUpdater.UpdateStatus(Status{
+					s.statusUpdater.UpdateStatus(progress.status(Status{
 						Sending:          p,
 						BytesTransferred: transferred,
 						BytesTotal:       p.compressedSize,
-					})
+						When:             s.now(),
+					}))
 				}
 			case <-statusDone:
 				if s.statusUpdater != nil {
-					s.statusUpdater.UpdateStatus(Status{
+					s.statusUpdater.UpdateStatus(progress.status(Status{
 						Sending:          p,
-						BytesTransferred: p.compressedSize - buffer.Len(),
+						BytesTransferred: p.compressedSize - int(remaining.Load()),
 						BytesTotal:       p.compressedSize,
 						Done:             true,
-					})
+						When:             s.now(),
+					}))
 				}
 				return
 			}
@@ -482,6 +623,8 @@ func (s *Session) writeCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 		if err = writer.Flush(); err != nil {
 			return err
 		}
+		remaining.Store(int64(buffer.Len()))
+		s.resetIdle()
 	}
 
 	// Checksum
@@ -500,6 +643,45 @@ func (s *Session) writeCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 	return err
 }
 
+// readPlain reads an uncompressed legacy ASCII (FA) message: the title line followed by the message text terminated by Ctrl-Z.
+//
+// This is a compatibility extension. FBB specifies a compressed message for FA proposals.
+func (s *Session) readPlain(p *Proposal) error {
+	title, err := s.nextLine()
+	if err != nil {
+		return fmt.Errorf("Unable to read title: %w", err)
+	}
+	p.title = title
+
+	s.log.Printf("Receiving [%s] (uncompressed)", p.title)
+
+	// Bound the read by the proposed size, so that a remote never sending Ctrl-Z can't make us buffer without limit
+	limit := p.compressedSize + plainSizeSlack
+	var body []byte
+	for {
+		c, err := s.rd.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c == _CHRSUB {
+			break
+		}
+		if len(body) == limit {
+			return s.protocolError(fmt.Errorf("Plain message exceeds proposed size (%d bytes)", p.compressedSize))
+		}
+		body = append(body, c)
+	}
+	p.compressedData = body
+
+	// Consume the line ending following Ctrl-Z (if any). Don't block, as it's our turn if this was the last message.
+	if s.rd.Buffered() > 0 {
+		if c, _ := s.rd.Peek(1); c[0] == '\r' {
+			s.rd.ReadByte()
+		}
+	}
+	return nil
+}
+
 func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 	var (
 		ourChecksum int
@@ -514,8 +696,12 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 	case _CHRSOH:
 		// what we expected...
 	case '*':
-		line, _ := s.nextLine()
-		return errors.New(fmt.Sprintf(`Got error from CMS: %s`, line))
+		line, _ := s.nextLineRemoteErr(false)
+		err := errLine("*" + line)
+		if err == nil {
+			err = &RemoteError{strings.TrimSpace(strings.TrimLeft(line, "*"))}
+		}
+		return fmt.Errorf("Got error from CMS: %w", err)
 	default:
 		return errors.New(fmt.Sprintf(`First byte not as expected, got %d`, int(c)))
 	}
@@ -534,7 +720,7 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 
 	// The proposal title should be ASCII-only according to the protocol specification. Since RMS Express and CMS puts
 	// the raw subject header here, we need to handle this by decoding it the same way as the subject header.
-	p.title, _ = new(WordDecoder).DecodeHeader(title)
+	p.title = DecodeHeaderValue(title)
 
 	// Read offset part
 	var offsetStr string
@@ -565,27 +751,57 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 		s.log.Println("GZIP_EXPERIMENT:", "Receiving gzip compressed message.")
 	}
 
-	statusUpdate := make(chan struct{})
+	// Track the position within the decompressed message (which attachment is being transferred).
+	// A resumed transfer can't be decompressed before it is complete.
+	// Plain ASCII (FA) messages are not compressed, and not B2 messages either.
+	var (
+		position *messagePosition
+		tracker  io.WriteCloser
+	)
+	if s.statusUpdater != nil && p.offset == 0 && p.code != AsciiProposal {
+		position = new(messagePosition)
+		tracker = trackPosition(p.code, position)
+	}
+
+	// The status is reported from a separate goroutine, so that a slow StatusUpdater doesn't stall
+	// the transfer. It is given the number of bytes received with each update, as buf is not safe
+	// for concurrent use.
+	progress := s.progress
+	reportStatus := func(transferred int, done bool) {
+		if s.statusUpdater == nil {
+			return
+		}
+		st := progress.status(Status{
+			Receiving:        p,
+			BytesTransferred: transferred,
+			BytesTotal:       p.compressedSize,
+			Done:             done,
+			When:             s.now(),
+		})
+		if position != nil {
+			st = position.status(st)
+		}
+		s.statusUpdater.UpdateStatus(st)
+	}
+	statusUpdate := make(chan int)
+	statusDone := make(chan struct{})
 	go func() {
-		for {
-			_, ok := <-statusUpdate
-			if s.statusUpdater != nil {
-				s.statusUpdater.UpdateStatus(Status{
-					Receiving:        p,
-					BytesTransferred: buf.Len(),
-					BytesTotal:       p.compressedSize,
-					Done:             !ok,
-				})
-			}
-			if !ok {
-				return
-			}
+		defer close(statusDone)
+		for n := range statusUpdate {
+			reportStatus(n, false)
 		}
 	}()
-	defer func() { close(statusUpdate) }()
+	defer func() {
+		close(statusUpdate)
+		<-statusDone
+		reportStatus(buf.Len(), true)
+	}()
+	if tracker != nil {
+		defer tracker.Close() // Before the final status update
+	}
 	updateStatus := func() {
 		select {
-		case statusUpdate <- struct{}{}:
+		case statusUpdate <- buf.Len():
 		default:
 		}
 	}
@@ -604,6 +820,7 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 			if length == 0 {
 				length = 256
 			}
+			start := buf.Len()
 			for i := 0; i < length; i++ {
 				c, err = s.rd.ReadByte()
 				if err != nil {
@@ -615,6 +832,10 @@ func (s *Session) readCompressed(rw io.ReadWriter, p *Proposal) (err error) {
 					updateStatus()
 				}
 			}
+			if tracker != nil {
+				tracker.Write(buf.Bytes()[start:])
+			}
+			s.resetIdle()
 		case _CHREOT:
 			c, _ = s.rd.ReadByte()
 			ourChecksum = (ourChecksum + int(c)) % 256
@@ -4,7 +4,11 @@
 
 package fbb
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
 
 func TestParseProposalAnswer(t *testing.T) {
 	tests := map[string][]*Proposal{
@@ -43,3 +47,28 @@ func TestParseProposalAnswer(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteCompressedOffset(t *testing.T) {
+	s := NewSession("N0CALL", "LA1B", "JO39EQ", nil)
+
+	tests := map[int]error{
+		-1:                           ErrOffsetOutOfRange,
+		1024:                         ErrOffsetOutOfRange,
+		ProtocolOffsetSizeLimit + 1:  ErrOffsetLimitExceeded,
+		10 * ProtocolOffsetSizeLimit: ErrOffsetLimitExceeded,
+		0:                            nil,
+	}
+	for offset, expect := range tests {
+		p := NewProposal("TJKYEIMMHSRB", "Test", Wl2kProposal, []byte("Hello, world!"))
+		p.offset = offset
+
+		var buf bytes.Buffer
+		err := s.writeCompressed(&buf, p)
+		if !errors.Is(err, expect) {
+			t.Errorf("Offset %d: Got %v, expected %v", offset, err, expect)
+		}
+		if expect != nil && buf.Len() > 0 {
+			t.Errorf("Offset %d: Unexpected data written: %q", offset, buf.Bytes())
+		}
+	}
+}
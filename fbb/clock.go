@@ -0,0 +1,24 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import "time"
+
+// Clock provides the current time.
+//
+// A custom Clock can be used to get deterministic dates and MIDs (e.g. in tests), or to use another
+// time source than the system clock (e.g. GPS time).
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is an adapter to allow the use of ordinary functions as Clock.
+type ClockFunc func() time.Time
+
+// Now returns f().
+func (f ClockFunc) Now() time.Time { return f() }
+
+// SystemClock is the default Clock, returning time.Now().
+var SystemClock Clock = ClockFunc(time.Now)
@@ -0,0 +1,262 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"strings"
+	"time"
+)
+
+// Winlink specific header fields without an RFC 5322 equivalent.
+const (
+	HEADER_EML_TYPE = `X-Winlink-Type`
+	HEADER_EML_MBO  = `X-Winlink-Mbo`
+)
+
+// The domain of winlink addresses (and MIDs) when converted to RFC 5322.
+const emlDomain = "winlink.org"
+
+// WriteEML writes the message to w as a RFC 5322/MIME email (.eml).
+//
+// Winlink addresses are written as N0CALL@winlink.org and SMTP addresses as-is. The MID is
+// written as the Message-ID, and the Type and Mbo fields as X-Winlink-Type and X-Winlink-Mbo.
+// The body is written as UTF-8 (quoted-printable) and attachments as base64 encoded MIME parts.
+func (m *Message) WriteEML(w io.Writer) error {
+	body, err := m.Body()
+	if err != nil {
+		return err
+	}
+
+	// We use a bufio.Writer to defer error handling until Flush
+	writer := bufio.NewWriter(w)
+
+	fmt.Fprintf(writer, "Message-ID: <%s@%s>\r\n", m.MID(), emlDomain)
+	fmt.Fprintf(writer, "Date: %s\r\n", m.Date().Format(time.RFC1123Z))
+	fmt.Fprintf(writer, "From: %s\r\n", emlAddress(m.From()))
+	if to := m.To(); len(to) > 0 {
+		fmt.Fprintf(writer, "To: %s\r\n", emlAddressList(to))
+	}
+	if cc := m.Cc(); len(cc) > 0 {
+		fmt.Fprintf(writer, "Cc: %s\r\n", emlAddressList(cc))
+	}
+	fmt.Fprintf(writer, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", m.Subject()))
+	if t := m.Type(); t != "" {
+		fmt.Fprintf(writer, "%s: %s\r\n", HEADER_EML_TYPE, t)
+	}
+	if mbo := m.Mbo(); mbo != "" {
+		fmt.Fprintf(writer, "%s: %s\r\n", HEADER_EML_MBO, mbo)
+	}
+	writer.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(m.Files()) == 0 {
+		fmt.Fprintf(writer, "Content-Type: text/plain; charset=UTF-8\r\n")
+		fmt.Fprintf(writer, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuotedPrintable(writer, body)
+		return writer.Flush()
+	}
+
+	mw := multipart.NewWriter(writer)
+	fmt.Fprintf(writer, "Content-Type: %s\r\n\r\n", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+
+	part, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	writeQuotedPrintable(part, body)
+
+	for _, f := range m.Files() {
+		// Non-ASCII file names are Q-encoded the same way as in the File header field.
+		name := mime.QEncoding.Encode("UTF-8", f.Name())
+
+		contentType := mime.TypeByExtension(path.Ext(f.Name()))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		part, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		writeBase64(part, f.data)
+	}
+	mw.Close()
+
+	return writer.Flush()
+}
+
+// ReadEML reads a RFC 5322/MIME email (.eml) from r and converts it to a Message.
+//
+// This is the inverse of Message.WriteEML. If the Message-ID is not a valid MID, a new MID is
+// generated. The first text/plain part becomes the body, all other parts become attachments.
+func ReadEML(r io.Reader) (*Message, error) {
+	em, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := mail.ParseAddress(em.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid From header: %w", err)
+	}
+	fromAddr := AddressFromString(from.Address)
+
+	mbo := em.Header.Get(HEADER_EML_MBO)
+	switch {
+	case mbo != "":
+	case fromAddr.Proto == "":
+		mbo = fromAddr.Addr
+	default:
+		mbo = "SMTP"
+	}
+
+	m := NewMessage(MsgType(em.Header.Get(HEADER_EML_TYPE)), mbo)
+	m.Header.Set(HEADER_FROM, fromAddr.String())
+	if mid := midFromMessageID(em.Header.Get("Message-Id")); mid != "" {
+		m.Header.Set(HEADER_MID, mid)
+	}
+	if date, err := em.Header.Date(); err == nil {
+		m.SetDate(date)
+	}
+
+	for _, key := range []string{HEADER_TO, HEADER_CC} {
+		list, err := em.Header.AddressList(key)
+		if err != nil && err != mail.ErrHeaderNotPresent {
+			return nil, fmt.Errorf("Invalid %s header: %w", key, err)
+		}
+		for _, addr := range list {
+			m.Header.Add(key, AddressFromString(addr.Address).String())
+		}
+	}
+
+	subject := DecodeHeaderValue(em.Header.Get(HEADER_SUBJECT))
+	m.SetSubject(subject)
+
+	var hasBody bool
+	if err := readEMLPart(m, &hasBody, textproto.MIMEHeader(em.Header), em.Body); err != nil {
+		return nil, err
+	}
+	if !hasBody {
+		m.SetBody("")
+	}
+
+	return m, nil
+}
+
+func readEMLPart(m *Message, hasBody *bool, h textproto.MIMEHeader, r io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if params["boundary"] == "" {
+			return errors.New("Missing multipart boundary")
+		}
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := readEMLPart(m, hasBody, part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(h.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+
+	if !*hasBody && mediaType == "text/plain" && disposition != "attachment" && name == "" {
+		charset := params["charset"]
+		if charset == "" || strings.EqualFold(charset, "us-ascii") {
+			charset = DefaultCharset
+		}
+		body, err := BodyFromBytes(data, charset)
+		if err != nil {
+			return fmt.Errorf("Unable to decode body: %w", err)
+		}
+		*hasBody = true
+		return m.SetBody(body)
+	}
+
+	if name, _ = new(WordDecoder).DecodeHeader(name); name == "" {
+		name = fmt.Sprintf("attachment%d", len(m.Files())+1)
+	}
+	m.AddFile(NewFile(name, data))
+	return nil
+}
+
+// midFromMessageID returns the MID part of a Message-ID (<MID@domain>), or "" if it's not a valid MID.
+func midFromMessageID(id string) string {
+	id = strings.Trim(strings.TrimSpace(id), "<>")
+	if i := strings.LastIndex(id, "@"); i >= 0 {
+		id = id[:i]
+	}
+	if id == "" || len(id) > 12 {
+		return ""
+	}
+	return id
+}
+
+func emlAddress(a Address) string {
+	if a.Proto == "" {
+		return a.Addr + "@" + emlDomain
+	}
+	return a.Addr
+}
+
+func emlAddressList(addrs []Address) string {
+	strs := make([]string, len(addrs))
+	for i, a := range addrs {
+		strs[i] = emlAddress(a)
+	}
+	return strings.Join(strs, ", ")
+}
+
+func writeQuotedPrintable(w io.Writer, s string) {
+	qp := quotedprintable.NewWriter(w)
+	io.WriteString(qp, s)
+	qp.Close()
+}
+
+// writeBase64 writes data base64 encoded, wrapped at 76 characters per line (RFC 2045).
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := min(len(encoded), 76)
+		io.WriteString(w, encoded[:n]+"\r\n")
+		encoded = encoded[n:]
+	}
+}
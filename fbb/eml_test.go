@@ -0,0 +1,134 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEMLRoundTrip(t *testing.T) {
+	msg := NewMessage(Private, "LA5NTA")
+	msg.AddTo("N0CALL", "foo@example.com")
+	msg.AddCc("LA1B")
+	msg.SetSubject("Hei på deg")
+	msg.SetBody("Test æøå\r\nLine two\r\n")
+	msg.SetDate(time.Date(2016, 10, 18, 12, 34, 0, 0, time.UTC))
+	msg.AddFile(NewFile("æøå.txt", []byte("foo")))
+	msg.AddFile(NewFile("image.bin", []byte{0x00, 0xff, 0x10}))
+
+	var buf bytes.Buffer
+	if err := msg.WriteEML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	eml := buf.String()
+	for _, expect := range []string{
+		"Message-ID: <" + msg.MID() + "@winlink.org>\r\n",
+		"From: LA5NTA@winlink.org\r\n",
+		"To: N0CALL@winlink.org, foo@example.com\r\n",
+		"Cc: LA1B@winlink.org\r\n",
+		"Date: Tue, 18 Oct 2016 12:34:00 +0000\r\n",
+	} {
+		if !strings.Contains(eml, expect) {
+			t.Errorf("Expected %q in EML:\n%s", expect, eml)
+		}
+	}
+
+	got, err := ReadEML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MID() != msg.MID() {
+		t.Errorf("Got MID %q, expected %q", got.MID(), msg.MID())
+	}
+	if got.Type() != msg.Type() || got.Mbo() != msg.Mbo() {
+		t.Errorf("Got type/mbo %q/%q, expected %q/%q", got.Type(), got.Mbo(), msg.Type(), msg.Mbo())
+	}
+	if got.From() != msg.From() {
+		t.Errorf("Got From %s, expected %s", got.From(), msg.From())
+	}
+	if !reflect.DeepEqual(got.To(), msg.To()) || !reflect.DeepEqual(got.Cc(), msg.Cc()) {
+		t.Errorf("Got receivers %v/%v, expected %v/%v", got.To(), got.Cc(), msg.To(), msg.Cc())
+	}
+	if !got.Date().Equal(msg.Date()) {
+		t.Errorf("Got date %s, expected %s", got.Date(), msg.Date())
+	}
+	if got.Subject() != msg.Subject() {
+		t.Errorf("Got subject %q, expected %q", got.Subject(), msg.Subject())
+	}
+	gotBody, _ := got.Body()
+	expectBody, _ := msg.Body()
+	if gotBody != expectBody {
+		t.Errorf("Got body %q, expected %q", gotBody, expectBody)
+	}
+	if len(got.Files()) != len(msg.Files()) {
+		t.Fatalf("Got %d files, expected %d", len(got.Files()), len(msg.Files()))
+	}
+	for i, f := range msg.Files() {
+		if got.Files()[i].Name() != f.Name() {
+			t.Errorf("Got file name %q, expected %q", got.Files()[i].Name(), f.Name())
+		}
+		if !bytes.Equal(got.Files()[i].Data(), f.Data()) {
+			t.Errorf("Got file data %v, expected %v", got.Files()[i].Data(), f.Data())
+		}
+	}
+	if err := got.Validate(); err != nil {
+		t.Errorf("Imported message does not satisfy validation: %v", err)
+	}
+
+	// The imported message must be a valid Winlink message
+	var b2f bytes.Buffer
+	if err := got.Write(&b2f); err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(Message)
+	if err := decoded.ReadFrom(&b2f); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Files()[0].Name() != "æøå.txt" {
+		t.Errorf("Got file name %q after B2F round trip", decoded.Files()[0].Name())
+	}
+}
+
+func TestReadEMLFromSMTP(t *testing.T) {
+	eml := "From: Foo Bar <foo@example.com>\r\n" +
+		"To: la5nta@winlink.org\r\n" +
+		"Subject: =?ISO-8859-1?Q?Gr=F8t?=\r\n" +
+		"Date: Mon, 17 Oct 2016 08:00:00 +0200\r\n" +
+		"Message-ID: <CAF1234567890abcdef@mail.example.com>\r\n" +
+		"Content-Type: text/plain; charset=ISO-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Smakte godt =E6\r\n"
+
+	msg, err := ReadEML(strings.NewReader(eml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.From().String() != "SMTP:foo@example.com" {
+		t.Errorf("Got From %s, expected SMTP:foo@example.com", msg.From())
+	}
+	if msg.Mbo() != "SMTP" {
+		t.Errorf("Got Mbo %q, expected SMTP", msg.Mbo())
+	}
+	if to := msg.To(); len(to) != 1 || to[0].String() != "LA5NTA" {
+		t.Errorf("Got To %v, expected [LA5NTA]", to)
+	}
+	if msg.Subject() != "Grøt" {
+		t.Errorf("Got subject %q, expected Grøt", msg.Subject())
+	}
+	if body, _ := msg.Body(); body != "Smakte godt æ\r\n" {
+		t.Errorf("Got body %q", body)
+	}
+	if len(msg.MID()) == 0 || len(msg.MID()) > 12 {
+		t.Errorf("Got invalid MID %q", msg.MID())
+	}
+	if expect := time.Date(2016, 10, 17, 6, 0, 0, 0, time.UTC); !msg.Date().Equal(expect) {
+		t.Errorf("Got date %s, expected %s", msg.Date(), expect)
+	}
+}
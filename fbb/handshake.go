@@ -16,12 +16,27 @@ import (
 
 var ErrNoFB2 = errors.New("Remote does not support B2 Forwarding Protocol")
 
+// LoginError is returned by Session.Exchange when the remote reports that the secure login failed.
+//
+// The link itself is fine, so the user should be prompted to fix the password instead of retrying the connection.
+type LoginError struct {
+	Err error // The error reported by the remote
+}
+
+func (e *LoginError) Error() string { return e.Err.Error() }
+
+func (e *LoginError) Unwrap() error { return e.Err }
+
 // IsLoginFailure returns a boolean indicating whether the error is known to
 // report that the secure login failed.
 func IsLoginFailure(err error) bool {
 	if err == nil {
 		return false
 	}
+	var loginErr *LoginError
+	if errors.As(err, &loginErr) {
+		return true
+	}
 	errStr := strings.ToLower(err.Error())
 	return strings.Contains(errStr, "secure login failed")
 }
@@ -30,7 +45,7 @@ func (s *Session) handshake(rw io.ReadWriter) error {
 	if s.master {
 		// Send MOTD lines
 		for _, line := range s.motd {
-			fmt.Fprintf(rw, "%s\r", line)
+			fmt.Fprintf(s.tx(rw), "%s\r", line)
 		}
 
 		if err := s.sendHandshake(rw, ""); err != nil {
@@ -49,8 +64,13 @@ func (s *Session) handshake(rw io.ReadWriter) error {
 	}
 
 	s.remoteSID = hs.SID
+	s.remoteSIDInfo = hs.SIDInfo
 	s.remoteFW = hs.FW
 
+	if s.remoteSIDHandleFunc != nil {
+		s.remoteSIDHandleFunc(hs.SIDInfo)
+	}
+
 	if !s.master {
 		return s.sendHandshake(rw, hs.SecureChallenge)
 	} else {
@@ -60,6 +80,7 @@ func (s *Session) handshake(rw io.ReadWriter) error {
 
 type handshakeData struct {
 	SID             sid
+	SIDInfo         SID
 	FW              []Address
 	SecureChallenge string
 }
@@ -92,6 +113,7 @@ func (s *Session) readHandshake() (handshakeData, error) {
 			if err != nil {
 				return data, err
 			}
+			data.SIDInfo = ParseSID(line)
 
 			// Do we support the remote's SID codes?
 			if !data.SID.Has(sFBComp2) { // We require FBB compressed protocol v2 for now
@@ -118,7 +140,7 @@ func (s *Session) sendHandshake(writer io.Writer, secureChallenge string) error
 		return errors.New("Got secure login challenge, please register a SecureLoginHandleFunc.")
 	}
 
-	w := bufio.NewWriter(writer)
+	w := bufio.NewWriter(s.tx(writer))
 
 	// Request messages on behalf of every localFW
 	fmt.Fprintf(w, ";FW:")
@@ -142,7 +164,7 @@ func (s *Session) sendHandshake(writer io.Writer, secureChallenge string) error
 	}
 	fmt.Fprintf(w, "\r")
 
-	writeSID(w, s.ua.Name, s.ua.Version)
+	writeSID(w, s.ua.Name, s.ua.Version, s.localSIDCaps())
 
 	if secureChallenge != "" {
 		password, err := s.secureLoginHandleFunc(s.localFW[0])
@@ -201,14 +223,41 @@ const (
 
 func gzipExperimentEnabled() bool { return os.Getenv("GZIP_EXPERIMENT") == "1" }
 
-func writeSID(w io.Writer, appName, appVersion string) error {
-	sid := localSID
+// SIDCapabilities holds the optional capabilities advertised in the local session's SID.
+//
+// The feature codes required by this implementation (B2, F, H, M and $) are always advertised.
+type SIDCapabilities struct {
+	Identify bool // I: The remote may send identification lines (";TARGET DE MYCALL QTC n"). These are ignored.
+	Gzip     bool // G: Gzip compressed messages. Requires GZIP_EXPERIMENT=1.
+}
+
+// DefaultSIDCapabilities returns the capabilities advertised unless overridden by Session.SetSID.
+func DefaultSIDCapabilities() SIDCapabilities {
+	return SIDCapabilities{Gzip: gzipExperimentEnabled()}
+}
 
-	if gzipExperimentEnabled() {
-		sid = sid[0:len(sid)-1] + sGzip + sid[len(sid)-1:]
+// validate returns an error if any of the capabilities are not supported by this implementation.
+func (c SIDCapabilities) validate() error {
+	if c.Gzip && !gzipExperimentEnabled() {
+		return errors.New("Gzip capability requires GZIP_EXPERIMENT=1")
+	}
+	return nil
+}
+
+// features returns the SID feature codes for the given capabilities.
+func (c SIDCapabilities) features() string {
+	codes := localSID[:len(localSID)-1] // Everything but sBID, as it must be last
+	if c.Identify {
+		codes += sI
+	}
+	if c.Gzip {
+		codes += sGzip
 	}
+	return codes + sBID
+}
 
-	_, err := fmt.Fprintf(w, "[%s-%s-%s]\r", appName, appVersion, sid)
+func writeSID(w io.Writer, appName, appVersion string, caps SIDCapabilities) error {
+	_, err := fmt.Fprintf(w, "[%s-%s-%s]\r", appName, appVersion, caps.features())
 	return err
 }
 
@@ -235,3 +284,51 @@ func parseSID(str string) (sid, error) {
 func (s sid) Has(code string) bool {
 	return strings.Contains(string(s), strings.ToUpper(code))
 }
+
+// SID holds the identity and capabilities reported by a system identifier (SID) line.
+//
+// E.g. [WL2K-2.8.4.8-B2FWIHJM$] is software WL2K version 2.8.4.8 with features B2FWIHJM$.
+type SID struct {
+	Software string // The name of the software (e.g. WL2K).
+	Version  string // The software version (e.g. 2.8.4.8). Empty if not given.
+	Features string // The upper-cased feature codes (e.g. B2FWIHJM$).
+}
+
+// ParseSID parses the given SID line (e.g. [WL2K-2.8.4.8-B2FWIHJM$]).
+//
+// Malformed lines yield a zero-value SID. The brackets are optional.
+func ParseSID(str string) SID {
+	parts := strings.Split(strings.Trim(strings.TrimSpace(str), "[]"), "-")
+	switch len(parts) {
+	case 0, 1:
+		return SID{}
+	case 2:
+		return SID{Software: parts[0], Features: strings.ToUpper(parts[1])}
+	default:
+		return SID{
+			Software: parts[0],
+			Version:  strings.Join(parts[1:len(parts)-1], "-"),
+			Features: strings.ToUpper(parts[len(parts)-1]),
+		}
+	}
+}
+
+// Has returns true if the given feature code (e.g. B2) is reported.
+func (s SID) Has(code string) bool { return sid(s.Features).Has(code) }
+
+// SupportsB2F returns true if the FBB compressed protocol v2 (B2F) is supported.
+func (s SID) SupportsB2F() bool { return s.Has(sFBComp2) }
+
+// SupportsGzip returns true if gzip compressed messages are supported.
+func (s SID) SupportsGzip() bool { return s.Has(sGzip) }
+
+// SupportsHierarchicalLocation returns true if hierarchical location designators are supported.
+func (s SID) SupportsHierarchicalLocation() bool { return s.Has(sHL) }
+
+// String returns the SID in it's line format (e.g. [WL2K-2.8.4.8-B2FWIHJM$]).
+func (s SID) String() string {
+	if s.Version == "" {
+		return fmt.Sprintf("[%s-%s]", s.Software, s.Features)
+	}
+	return fmt.Sprintf("[%s-%s-%s]", s.Software, s.Version, s.Features)
+}
@@ -34,6 +34,8 @@ func TestIsLoginFailure(t *testing.T) {
 		fmt.Errorf("[1] Secure login failed - account password does not match. - Disconnecting (88.90.2.192)"): true,
 		io.EOF:              false,
 		io.ErrUnexpectedEOF: false,
+
+		fmt.Errorf("Got error from CMS: %w", &LoginError{fmt.Errorf("Login refused")}): true,
 	}
 
 	for err, expect := range tests {
@@ -42,3 +44,32 @@ func TestIsLoginFailure(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSID(t *testing.T) {
+	tests := map[string]SID{
+		"[WL2K-2.8.4.8-B2FWIHJM$]":      {"WL2K", "2.8.4.8", "B2FWIHJM$"},
+		"[WL2K-4.0-B2FWIHJM$]":          {"WL2K", "4.0", "B2FWIHJM$"},
+		"[wl2kgo-0.1a-B2FHM$]":          {"wl2kgo", "0.1a", "B2FHM$"},
+		"[RMS Trimode-1.3.3.0-B2FHIM$]": {"RMS Trimode", "1.3.3.0", "B2FHIM$"},
+		"[FBB-7.00-abfhm$]":             {"FBB", "7.00", "ABFHM$"},
+		"[FBB-ABFHM$]":                  {"FBB", "", "ABFHM$"},
+		"foobar":                        {},
+	}
+	for str, expect := range tests {
+		got := ParseSID(str)
+		if got != expect {
+			t.Errorf("'%s': Got %#v, expected %#v", str, got, expect)
+		}
+		if expect.Software != "" && ParseSID(got.String()) != got {
+			t.Errorf("'%s': String() %s does not roundtrip", str, got)
+		}
+	}
+
+	sid := ParseSID("[WL2K-2.8.4.8-B2FWIHJM$]")
+	if !sid.SupportsB2F() || !sid.SupportsHierarchicalLocation() || sid.SupportsGzip() {
+		t.Errorf("Unexpected capabilities of %s", sid)
+	}
+	if ParseSID("[FBB-7.00-ABFHM$]").SupportsB2F() {
+		t.Errorf("Expected no B2F support")
+	}
+}
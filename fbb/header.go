@@ -163,6 +163,18 @@ func (d *WordDecoder) DecodeHeader(header string) (string, error) {
 	return string(decoded), nil
 }
 
+// DecodeHeaderValue decodes a header value (e.g. the Subject or a File header) the same way as this package does internally.
+//
+// RFC 2047 encoded-words are decoded. Values without encoded-words are returned as is if valid UTF-8,
+// otherwise decoded as ISO-8859-1 (see WordDecoder). If decoding fails, the raw value is returned.
+func DecodeHeaderValue(value string) string {
+	decoded, err := new(WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
 func toCharset(set, s string) (string, error) {
 	buf := new(bytes.Buffer)
 	w, err := charset.NewWriter(set, buf)
@@ -46,3 +46,24 @@ func TestSubjectRoundtrip(t *testing.T) {
 		t.Errorf("Subject encode/decode roundtrip failed. (%s)", msg.Subject())
 	}
 }
+
+func TestDecodeHeaderValue(t *testing.T) {
+	msg := NewMessage(Private, "N0CALL")
+	msg.SetSubject("Blåbær æøå")
+
+	samples := []string{
+		msg.Header.Get("Subject"),   // Word encoded
+		"Blåbær æøå",                // UTF8
+		"Bl\xE5b\xE6r \xE6\xF8\xE5", // Latin1
+	}
+	for i, v := range samples {
+		if got := DecodeHeaderValue(v); got != "Blåbær æøå" {
+			t.Errorf("Sample %d: Got %q, expected %q", i, got, "Blåbær æøå")
+		}
+	}
+
+	// Undecodable values are returned as is
+	if got := DecodeHeaderValue("=?x-unknown?q?foo?="); got != "=?x-unknown?q?foo?=" {
+		t.Errorf("Got %q, expected the raw value", got)
+	}
+}
@@ -17,6 +17,40 @@ func (d ByDate) Len() int           { return len(d) }
 func (d ByDate) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
 func (d ByDate) Less(i, j int) bool { return d[i].Date().Before(d[j].Date()) }
 
+// ProtocolErrorContextSize is the maximum number of received bytes included in a ProtocolError.
+const ProtocolErrorContextSize = 128
+
+// ProtocolError is returned when the remote violates the protocol.
+//
+// Context holds the last bytes received from the remote at the time of the error (including
+// bytes received but not yet processed), to aid debugging of interoperability issues.
+type ProtocolError struct {
+	Err     error
+	Context []byte
+}
+
+func (e *ProtocolError) Error() string { return fmt.Sprintf("%s (received: %q)", e.Err, e.Context) }
+
+func (e *ProtocolError) Unwrap() error { return e.Err }
+
+func (s *Session) protocolError(err error) error {
+	return &ProtocolError{Err: err, Context: s.rxTail.Bytes()}
+}
+
+// tailBuffer is an io.Writer keeping the last ProtocolErrorContextSize bytes written.
+type tailBuffer struct{ buf []byte }
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if n := len(t.buf); n > ProtocolErrorContextSize {
+		t.buf = append(t.buf[:0], t.buf[n-ProtocolErrorContextSize:]...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the buffered bytes.
+func (t *tailBuffer) Bytes() []byte { return append([]byte(nil), t.buf...) }
+
 func ReadLine(rd io.Reader) (string, error) {
 	var lineBuffer bytes.Buffer
 
@@ -45,7 +79,9 @@ func (s *Session) nextLineRemoteErr(parseErr bool) (string, error) {
 	if err != nil {
 		return line, err
 	}
+	s.resetIdle()
 
+	s.rx(line)
 	line = cleanString(line)
 	s.pLog.Println(line)
 
@@ -60,6 +96,9 @@ func (s *Session) nextLine() (string, error) {
 	return s.nextLineRemoteErr(true)
 }
 
+// errLine returns a *RemoteError if the given line is an error reported by the remote (prefixed with '*').
+//
+// If the error reports a failed secure login, the *RemoteError is wrapped in a *LoginError.
 func errLine(str string) error {
 	if len(str) == 0 || str[0] != '*' {
 		return nil
@@ -70,7 +109,11 @@ func errLine(str string) error {
 		return nil
 	}
 
-	return fmt.Errorf(strings.TrimSpace(str[idx+1:]))
+	err := &RemoteError{strings.TrimSpace(str[idx+1:])}
+	if IsLoginFailure(err) {
+		return &LoginError{err}
+	}
+	return err
 }
 
 func cleanString(str string) string {
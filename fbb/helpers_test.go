@@ -4,7 +4,10 @@
 
 package fbb
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestErrLine(t *testing.T) {
 	err := errLine("*** Unable to decompress received binary compressed message - Disconnecting (88.89.220.254)")
@@ -29,3 +32,18 @@ func TestErrLine(t *testing.T) {
 		t.Errorf("Expected no error, got non nil")
 	}
 }
+
+func TestTailBuffer(t *testing.T) {
+	var tb tailBuffer
+	data := strings.Repeat("0123456789", 2*ProtocolErrorContextSize/10)
+	for i := 0; i < len(data); i += 7 {
+		end := i + 7
+		if end > len(data) {
+			end = len(data)
+		}
+		tb.Write([]byte(data[i:end]))
+	}
+	if got, expect := string(tb.Bytes()), data[len(data)-ProtocolErrorContextSize:]; got != expect {
+		t.Errorf("Got %q, expected %q", got, expect)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// KeyboardSession drives a Winlink CMS/RMS in keyboard (command prompt) mode.
+//
+// It is a building block for interactive clients, allowing commands like LM (list messages),
+// R (read), K (kill) and BYE to be sent over an established connection.
+type KeyboardSession struct {
+	mycall string
+	conn   net.Conn
+	rd     *bufio.Reader
+	out    io.Writer
+
+	lastByte byte // The last byte read (for CRLF detection)
+
+	// Callback when secure login password is needed
+	secureLoginHandleFunc func(addr Address) (password string, err error)
+}
+
+// NewKeyboardSession wraps an established connection to a CMS/RMS as a KeyboardSession.
+//
+// Every line of text received from the remote is written to out (if not nil) as it arrives.
+func NewKeyboardSession(conn net.Conn, mycall string, out io.Writer) *KeyboardSession {
+	return &KeyboardSession{
+		mycall: strings.ToUpper(mycall),
+		conn:   conn,
+		rd:     bufio.NewReader(conn),
+		out:    out,
+	}
+}
+
+// SetSecureLoginHandleFunc registers a callback function used to prompt for password when a secure login challenge is received.
+func (k *KeyboardSession) SetSecureLoginHandleFunc(f func(addr Address) (password string, err error)) {
+	k.secureLoginHandleFunc = f
+}
+
+// Login reads the remote's greeting until the first command prompt, answering the secure login challenge if requested.
+//
+// The greeting is returned.
+func (k *KeyboardSession) Login() (greeting string, err error) {
+	lines, err := k.readUntilPrompt(true)
+	return strings.Join(lines, "\n"), err
+}
+
+// SendCommand sends the command to the remote and returns the reply, excluding the following prompt.
+//
+// If the remote closes the connection (e.g. after BYE), the reply received so far is returned with io.EOF.
+func (k *KeyboardSession) SendCommand(cmd string) (reply string, err error) {
+	if _, err := fmt.Fprintf(k.conn, "%s\r", cmd); err != nil {
+		return "", err
+	}
+	lines, err := k.readUntilPrompt(false)
+	return strings.Join(lines, "\n"), err
+}
+
+// Close closes the underlying connection.
+func (k *KeyboardSession) Close() error { return k.conn.Close() }
+
+func (k *KeyboardSession) readUntilPrompt(login bool) (lines []string, err error) {
+	for {
+		line, isPrompt, err := k.readLine()
+		if err != nil {
+			return lines, err
+		}
+
+		if k.out != nil {
+			fmt.Fprintln(k.out, line)
+		}
+
+		switch {
+		case isPrompt:
+			return lines, nil
+		case login && strings.HasPrefix(line, ";PQ: "): // Secure password challenge
+			if err := k.answerChallenge(line[5:]); err != nil {
+				return lines, err
+			}
+		}
+		lines = append(lines, line)
+	}
+}
+
+func (k *KeyboardSession) answerChallenge(challenge string) error {
+	if k.secureLoginHandleFunc == nil {
+		return errors.New("Got secure login challenge, please register a SecureLoginHandleFunc.")
+	}
+	password, err := k.secureLoginHandleFunc(AddressFromString(k.mycall))
+	if err != nil {
+		return err
+	}
+	return writeSecureLoginResponse(k.conn, secureLoginResponse(strings.TrimSpace(challenge), password))
+}
+
+// readLine reads the next line of text.
+//
+// The command prompt is not necessarily followed by a line break, so a line ending with '>' is treated as
+// complete (and a prompt) if no more data is immediately available.
+func (k *KeyboardSession) readLine() (line string, isPrompt bool, err error) {
+	var buf bytes.Buffer
+	for {
+		c, err := k.rd.ReadByte()
+		if err != nil {
+			return buf.String(), false, err
+		}
+
+		switch c {
+		case '\n':
+			if k.lastByte == '\r' && buf.Len() == 0 {
+				k.lastByte = c
+				continue // Second half of CRLF
+			}
+			fallthrough
+		case '\r':
+			k.lastByte = c
+			line = strings.TrimSpace(buf.String())
+			return line, strings.HasSuffix(line, ">"), nil
+		default:
+			k.lastByte = c
+			buf.WriteByte(c)
+		}
+
+		if c == '>' && k.rd.Buffered() == 0 {
+			return strings.TrimSpace(buf.String()), true, nil
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestKeyboardSession(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+
+	var out bytes.Buffer
+	k := NewKeyboardSession(client, "la5nta", &out)
+	k.SetSecureLoginHandleFunc(func(addr Address) (string, error) {
+		if addr.Addr != "LA5NTA" {
+			t.Errorf("Unexpected password request for %s", addr)
+		}
+		return "FOOBAR", nil
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		rd := bufio.NewReader(srv)
+		fmt.Fprint(srv, "Welcome to Test CMS\r\n;PQ: 23753528\r\n")
+		if line, _ := rd.ReadString('\r'); line != ";PR: 72768415\r" {
+			errs <- fmt.Errorf("Unexpected challenge response: %q", line)
+			return
+		}
+		fmt.Fprint(srv, "CMS>")
+
+		if line, _ := rd.ReadString('\r'); line != "LM\r" {
+			errs <- fmt.Errorf("Unexpected command: %q", line)
+			return
+		}
+		fmt.Fprint(srv, "ABCDEFGHIJKL 2026/10/18 10:00 Test message\r\n\r\n1 message\r\nCMS>")
+
+		if line, _ := rd.ReadString('\r'); line != "BYE\r" {
+			errs <- fmt.Errorf("Unexpected command: %q", line)
+			return
+		}
+		fmt.Fprint(srv, "73\r\n")
+		srv.Close()
+		errs <- nil
+	}()
+
+	greeting, err := k.Login()
+	if err != nil {
+		t.Fatalf("Login failed: %s", err)
+	} else if greeting != "Welcome to Test CMS\n;PQ: 23753528" {
+		t.Errorf("Unexpected greeting: %q", greeting)
+	}
+
+	reply, err := k.SendCommand("LM")
+	if err != nil {
+		t.Fatalf("LM failed: %s", err)
+	} else if reply != "ABCDEFGHIJKL 2026/10/18 10:00 Test message\n\n1 message" {
+		t.Errorf("Unexpected reply: %q", reply)
+	}
+
+	if reply, err = k.SendCommand("BYE"); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	} else if reply != "73" {
+		t.Errorf("Unexpected reply: %q", reply)
+	}
+
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("1 message\n")) {
+		t.Errorf("Server text not written to output: %q", out.String())
+	}
+}
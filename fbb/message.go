@@ -12,10 +12,12 @@ import (
 	"fmt"
 	"io"
 	"mime"
+	"net/mail"
 	"net/textproto"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 // ValidationError is the error type returned by functions validating a message.
@@ -26,6 +28,39 @@ type ValidationError struct {
 
 func (e ValidationError) Error() string { return e.Err }
 
+// ValidationErrors is the error type returned by Message.Validate, holding every problem found.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// As makes errors.As match a ValidationError target, for compatibility with callers written before
+// Validate returned ValidationErrors. The target is set to the first problem found.
+//
+// Note that a type assertion to ValidationError no longer matches the error returned by Validate.
+func (e ValidationErrors) As(target interface{}) bool {
+	t, ok := target.(*ValidationError)
+	if !ok || len(e) == 0 {
+		return false
+	}
+	*t = e[0]
+	return true
+}
+
+// Fields returns the name of each field/part of the message that is not valid.
+func (e ValidationErrors) Fields() []string {
+	fields := make([]string, 0, len(e))
+	for _, err := range e {
+		fields = append(fields, err.Field)
+	}
+	return fields
+}
+
 // Representation of a receiver/sender address.
 type Address struct {
 	Proto string
@@ -37,6 +72,9 @@ type File struct {
 	data []byte
 	name string
 	err  error
+
+	size    int  // Only set for attachments skipped by Message.ReadHeaderFrom
+	skipped bool // The data was not read (see Message.ReadHeaderFrom)
 }
 
 // Message represent the Winlink 2000 Message Structure as defined in http://winlink.org/B2F.
@@ -49,6 +87,8 @@ type Message struct {
 
 	body  []byte
 	files []*File
+
+	duplicateProposal bool // See DuplicateProposal
 }
 
 type MsgType string
@@ -62,6 +102,9 @@ const (
 	System                 = "System"
 )
 
+// ErrFileNotLoaded is returned when writing a message with attachments read by Message.ReadHeaderFrom.
+var ErrFileNotLoaded = errors.New("Attachment not loaded")
+
 // Slice of date layouts that should be tried when parsing the Date header.
 var dateLayouts = []string{
 	DateLayout,         // The correct layout according to Winlink (2006/01/02 15:04).
@@ -99,13 +142,22 @@ func init() {
 //
 // If the message type t is empty, it defaults to Private.
 func NewMessage(t MsgType, mycall string) *Message {
+	return NewMessageWithClock(t, mycall, SystemClock)
+}
+
+// NewMessageWithClock is like NewMessage, but the Date header and MID is generated from the time
+// given by clock instead of the system clock.
+//
+// With a fixed clock, the same MID is generated for the same mycall.
+func NewMessageWithClock(t MsgType, mycall string, clock Clock) *Message {
 	msg := &Message{
 		Header: make(Header),
 	}
 
-	msg.Header.Set(HEADER_MID, GenerateMid(mycall))
+	now := clock.Now()
+	msg.Header.Set(HEADER_MID, generateMid(mycall, now))
 
-	msg.SetDate(time.Now())
+	msg.SetDate(now)
 	msg.SetFrom(mycall)
 	msg.Header.Set(HEADER_MBO, mycall)
 
@@ -117,38 +169,96 @@ func NewMessage(t MsgType, mycall string) *Message {
 	return msg
 }
 
-// Validate returns an error if this message violates any Winlink Message Structure constraints
+// Validate returns an error if this message violates any Winlink Message Structure constraints.
+//
+// The returned error is of type ValidationErrors, enumerating each problem found. Previously, the error
+// was a ValidationError describing the first problem found. It can still be retrieved with errors.As.
 func (m *Message) Validate() error {
-	switch {
-	case m.MID() == "":
-		return ValidationError{"MID", "Empty MID"}
-	case len(m.MID()) > 12:
-		return ValidationError{"MID", "MID too long"}
-	case len(m.Receivers()) == 0:
+	if errs, _ := m.validate(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate returns every problem found by Validate, and the subset of those that prevents the message
+// from being sent.
+//
+// Subjects with non-ASCII characters and bodies that can't be decoded are problems, but they have
+// always been sent as is (e.g. raw 8-bit subjects written by other clients).
+func (m *Message) validate() (errs, blocking ValidationErrors) {
+	add := func(field, msg string) {
+		errs = append(errs, ValidationError{field, msg})
+		blocking = append(blocking, ValidationError{field, msg})
+	}
+	report := func(field, msg string) { errs = append(errs, ValidationError{field, msg}) }
+
+	switch mid := m.MID(); {
+	case mid == "":
+		add("MID", "Empty MID")
+	case len(mid) > 12:
+		add("MID", "MID too long")
+	}
+
+	if len(m.Receivers()) == 0 {
 		// This is not documented, but the CMS refuses to accept such messages (with good reason)
-		return ValidationError{"To/Cc", "No recipient"}
-	case m.Header.Get(HEADER_FROM) == "":
-		return ValidationError{"From", "Empty From field"}
-	case m.BodySize() == 0:
-		return ValidationError{"Body", "Empty body"}
-	case len(m.Header.Get(HEADER_SUBJECT)) == 0:
+		add("To/Cc", "No recipient")
+	}
+
+	for _, addr := range m.Receivers() {
+		if addr.Proto != "SMTP" {
+			continue
+		}
+		if err := validateSMTPAddress(addr.Addr); err != nil {
+			add("To/Cc", fmt.Sprintf("Invalid SMTP recipient %q: %s", addr.Addr, err))
+		}
+	}
+
+	if m.Header.Get(HEADER_FROM) == "" {
+		add("From", "Empty From field")
+	}
+
+	if m.BodySize() == 0 {
+		add("Body", "Empty body")
+	} else if _, err := m.Body(); err != nil {
+		report("Body", fmt.Sprintf("Unable to decode body (%s): %s", m.Charset(), err))
+	}
+
+	// The subject header is stored in its (RFC 2047) encoded form, and must only contain printable ASCII characters.
+	switch subject := m.Header.Get(HEADER_SUBJECT); {
+	case len(subject) == 0:
 		// This is not documented, but the CMS writes the proposal title if this is empty
 		// (which I guess is a compatibility hack on their end).
-		return ValidationError{HEADER_SUBJECT, "Empty subject"}
-	case len(m.Header.Get(HEADER_SUBJECT)) > 128:
-		return ValidationError{HEADER_SUBJECT, "Subject too long"}
+		add(HEADER_SUBJECT, "Empty subject")
+	case len(subject) > 128:
+		add(HEADER_SUBJECT, "Subject too long")
+	case hasIllegalHeaderChars(subject):
+		report(HEADER_SUBJECT, "Subject contains illegal characters")
 	}
 
 	// The CMS seems to accept this, but according to the winlink.org/B2F document it is not allowed:
 	//  "... and the file name (up to 50 characters) of the original file."
 	// WDT made an amendment to the B2F specification 2020-05-27: New limit is 255 characters.
 	for _, f := range m.Files() {
-		if len(f.Name()) > 255 {
-			return ValidationError{"Files", fmt.Sprintf("Attachment file name too long: %s", f.Name())}
+		switch name := f.Name(); {
+		case name == "":
+			add("Files", "Empty attachment file name")
+		case len(name) > 255:
+			add("Files", fmt.Sprintf("Attachment file name too long: %s", name))
+		case strings.IndexFunc(name, unicode.IsControl) >= 0:
+			add("Files", fmt.Sprintf("Attachment file name contains control characters: %q", name))
 		}
 	}
+	return errs, blocking
+}
 
-	return nil
+// hasIllegalHeaderChars returns true if str contains any other characters than printable ASCII.
+func hasIllegalHeaderChars(str string) bool {
+	for _, c := range str {
+		if c > unicode.MaxASCII || !unicode.IsPrint(c) {
+			return true
+		}
+	}
+	return false
 }
 
 // MID returns the unique identifier of this message across the winlink system.
@@ -164,11 +274,8 @@ func (m *Message) SetSubject(str string) {
 	m.Header.Set(HEADER_SUBJECT, encoded)
 }
 
-// Subject returns this message's subject header decoded using WordDecoder.
-func (m *Message) Subject() string {
-	str, _ := new(WordDecoder).DecodeHeader(m.Header.Get(HEADER_SUBJECT))
-	return str
-}
+// Subject returns this message's subject header decoded using DecodeHeaderValue.
+func (m *Message) Subject() string { return DecodeHeaderValue(m.Header.Get(HEADER_SUBJECT)) }
 
 // Type returns the message type.
 //
@@ -181,9 +288,33 @@ func (m *Message) Mbo() string { return m.Header.Get(HEADER_MBO) }
 // Body returns this message's body encoded as utf8.
 func (m *Message) Body() (string, error) { return BodyFromBytes(m.body, m.Charset()) }
 
+// BodyPreview returns the first n runes of the decoded body.
+//
+// Useful for listing messages, together with ReadHeaderFrom to avoid reading the attachments.
+func (m *Message) BodyPreview(n int) (string, error) {
+	body, err := m.Body()
+	if err != nil {
+		return "", err
+	}
+	for i := range body {
+		if n == 0 {
+			return body[:i], nil
+		}
+		n--
+	}
+	return body, nil
+}
+
 // Files returns the message attachments.
 func (m *Message) Files() []*File { return m.files }
 
+// DuplicateProposal returns true if this inbound message was proposed more than once in the same
+// proposal block (see Proposal.IsDuplicate).
+//
+// The deferred copies will most likely be proposed again by the remote, so a mailbox handler can use
+// this to avoid warning about duplicate delivery when they are rejected later on.
+func (m *Message) DuplicateProposal() bool { return m.duplicateProposal }
+
 // SetFrom sets the From header field.
 //
 // SMTP: prefix is automatically added if needed, see AddressFromString.
@@ -274,6 +405,40 @@ func (m *Message) AddCc(addr ...string) {
 	}
 }
 
+// AddRecipientSMTP adds an internet email recipient to this message.
+//
+// The message is routed by the CMS through the Winlink SMTP gateway. The
+// address must be a plain email address (e.g. foo@bar.baz) without a
+// display name. Note that the recipient must have whitelisted the sender's
+// Winlink address for the message to be accepted by the gateway.
+func (m *Message) AddRecipientSMTP(email string) error {
+	if err := validateSMTPAddress(email); err != nil {
+		return fmt.Errorf("Invalid SMTP address %q: %w", email, err)
+	}
+	m.Header.Add(HEADER_TO, Address{Proto: "SMTP", Addr: email}.String())
+	return nil
+}
+
+func validateSMTPAddress(email string) error {
+	for _, r := range email {
+		if r > unicode.MaxASCII || !unicode.IsGraphic(r) || unicode.IsSpace(r) {
+			return errors.New("Address contains illegal characters")
+		}
+	}
+	parsed, err := mail.ParseAddress(email)
+	if err != nil || parsed.Name != "" || parsed.Address != email {
+		return errors.New("Not a plain email address")
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	switch {
+	case strings.EqualFold(domain, "winlink.org"):
+		return errors.New("Winlink addresses are not routed via SMTP")
+	case !strings.Contains(domain, ".") || strings.HasSuffix(domain, "."):
+		return errors.New("Domain is not fully qualified")
+	}
+	return nil
+}
+
 // To returns primary receivers of this message.
 func (m *Message) To() (to []Address) {
 	for _, str := range m.Header[HEADER_TO] {
@@ -307,7 +472,16 @@ func trimLeftSpace(r *bufio.Reader) {
 // Implements ReaderFrom for Message.
 //
 // Reads the given io.Reader and fills in values fetched from the stream.
-func (m *Message) ReadFrom(r io.Reader) error {
+func (m *Message) ReadFrom(r io.Reader) error { return m.readFrom(r, false) }
+
+// ReadHeaderFrom reads a message from r like ReadFrom, but skips the content of the attachments.
+//
+// Files returns the attachments' names and sizes, but their data is not read. Such a message can
+// not be written (see Write). This is cheaper than ReadFrom when only the header and body is needed,
+// e.g. when listing a mailbox with large attachments.
+func (m *Message) ReadHeaderFrom(r io.Reader) error { return m.readFrom(r, true) }
+
+func (m *Message) readFrom(r io.Reader, skipFiles bool) error {
 	reader := bufio.NewReader(r)
 
 	// Trim leading whitespace before reading the header:
@@ -333,23 +507,22 @@ func (m *Message) ReadFrom(r io.Reader) error {
 
 	// Read files
 	m.files = make([]*File, len(m.Header[HEADER_FILE]))
-	dec := new(WordDecoder)
 	for i, value := range m.Header[HEADER_FILE] {
 		file := new(File)
 		m.files[i] = file
 
-		slice := strings.SplitN(value, ` `, 2)
-		if len(slice) != 2 {
-			file.err = errors.New(`Failed to parse file header. Got: ` + value)
+		var size int
+		file.name, size, file.err = parseFileHeader(value)
+		if file.err != nil {
 			continue
 		}
 
-		size, _ := strconv.Atoi(slice[0])
-
-		// The name part of this header may be utf8 encoded by Winlink Express. Use WordDecoder to be safe.
-		file.name, _ = dec.DecodeHeader(slice[1])
-
-		file.data, err = readSection(reader, size)
+		if skipFiles {
+			file.size, file.skipped = size, true
+			err = skipSection(reader, size)
+		} else {
+			file.data, err = readSection(reader, size)
+		}
 		if err != nil {
 			file.err = err
 		}
@@ -363,6 +536,35 @@ func (m *Message) ReadFrom(r io.Reader) error {
 	return err
 }
 
+// parseFileHeader parses the value of a File header field (size and name).
+func parseFileHeader(value string) (name string, size int, err error) {
+	slice := strings.SplitN(value, ` `, 2)
+	if len(slice) != 2 {
+		return "", 0, errors.New(`Failed to parse file header. Got: ` + value)
+	}
+
+	size, _ = strconv.Atoi(slice[0])
+
+	// The name part of this header may be utf8 encoded by Winlink Express. Use DecodeHeaderValue to be safe.
+	return DecodeHeaderValue(slice[1]), size, nil
+}
+
+// skipSection discards a section of length n without reading it into memory.
+func skipSection(reader *bufio.Reader, n int) error {
+	if _, err := reader.Discard(n); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	switch end, err := reader.ReadString('\n'); {
+	case err == io.EOF:
+		return nil // That's ok
+	case err != nil:
+		return err
+	case end != "\r\n":
+		return errors.New("Unexpected end of section")
+	}
+	return nil
+}
+
 func readSection(reader *bufio.Reader, readN int) ([]byte, error) {
 	buf := make([]byte, readN)
 
@@ -405,7 +607,8 @@ func (m *Message) IsOnlyReceiver(addr Address) bool {
 
 // Method for generating a proposal of the message.
 //
-// An error is returned if the Validate method fails.
+// An error is returned if the Validate method fails. The error is of type ValidationErrors,
+// detailing each field that must be fixed.
 func (m *Message) Proposal(code PropCode) (*Proposal, error) {
 	data, err := m.Bytes()
 	if err != nil {
@@ -452,11 +655,17 @@ func (m *Message) Bytes() ([]byte, error) {
 // Writes Message to the given Writer in the Winlink Message format.
 //
 // If the Date header field is not formatted correctly, an error will be returned.
+// ErrFileNotLoaded is returned if the message was read by ReadHeaderFrom and has attachments.
 func (m *Message) Write(w io.Writer) (err error) {
 	// Ensure Date field is in correct format
 	if _, err = ParseDate(m.Header.Get(HEADER_DATE)); err != nil {
 		return
 	}
+	for _, f := range m.Files() {
+		if f.skipped {
+			return ErrFileNotLoaded
+		}
+	}
 
 	// We use a bufio.Writer to defer error handling until Flush
 	writer := bufio.NewWriter(w)
@@ -521,10 +730,20 @@ func (f *File) MarshalJSON() ([]byte, error) {
 func (f *File) Name() string { return f.name }
 
 // Size returns the attachments's size in bytes.
-func (f *File) Size() int { return len(f.data) }
+func (f *File) Size() int {
+	if f.skipped {
+		return f.size
+	}
+	return len(f.data)
+}
 
 // Data returns a copy of the attachment content.
+//
+// Data returns nil if the attachment was skipped by Message.ReadHeaderFrom.
 func (f *File) Data() []byte {
+	if f.skipped {
+		return nil
+	}
 	cpy := make([]byte, len(f.data))
 	copy(cpy, f.data)
 	return cpy
@@ -6,7 +6,10 @@ package fbb
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -163,3 +166,184 @@ func IsIllegalHeader(str string) bool {
 func IsGraphicASCII(c rune) bool {
 	return c <= unicode.MaxASCII && unicode.IsGraphic(c)
 }
+
+func TestValidate(t *testing.T) {
+	newMessage := func() *Message {
+		msg := NewMessage(Private, "N0CALL")
+		msg.AddTo("LA5NTA")
+		msg.SetSubject("Test")
+		msg.SetBody("Test")
+		return msg
+	}
+	if err := newMessage().Validate(); err != nil {
+		t.Fatalf("Got unexpected error on valid message: %v", err)
+	}
+
+	tests := map[string]struct {
+		modify func(m *Message)
+		expect []string
+	}{
+		"empty MID":       {func(m *Message) { m.Header.Del(HEADER_MID) }, []string{"MID"}},
+		"long MID":        {func(m *Message) { m.Header.Set(HEADER_MID, "ABCDEFGHIJKLM") }, []string{"MID"}},
+		"no recipients":   {func(m *Message) { m.Header.Del(HEADER_TO) }, []string{"To/Cc"}},
+		"empty from":      {func(m *Message) { m.Header.Del(HEADER_FROM) }, []string{"From"}},
+		"empty body":      {func(m *Message) { m.SetBody("") }, []string{"Body"}},
+		"body encoding":   {func(m *Message) { m.Header.Set(HEADER_CONTENT_TYPE, "text/plain; charset=x-bogus") }, []string{"Body"}},
+		"empty subject":   {func(m *Message) { m.Header.Set(HEADER_SUBJECT, "") }, []string{HEADER_SUBJECT}},
+		"long subject":    {func(m *Message) { m.SetSubject(strings.Repeat("a", 129)) }, []string{HEADER_SUBJECT}},
+		"illegal subject": {func(m *Message) { m.Header.Set(HEADER_SUBJECT, "æøå") }, []string{HEADER_SUBJECT}},
+		"empty file name": {func(m *Message) { m.files = append(m.files, &File{data: []byte("foo")}) }, []string{"Files"}},
+		"long file name":  {func(m *Message) { m.AddFile(NewFile(strings.Repeat("a", 256), []byte("foo"))) }, []string{"Files"}},
+		"file name control characters": {
+			func(m *Message) { m.AddFile(NewFile("foo\r\nbar.txt", []byte("foo"))) },
+			[]string{"Files"},
+		},
+		"invalid SMTP recipient": {func(m *Message) { m.AddCc("SMTP:foo@bar") }, []string{"To/Cc"}},
+		"multiple": {
+			func(m *Message) { m.Header.Del(HEADER_TO); m.SetSubject(strings.Repeat("a", 129)) },
+			[]string{"To/Cc", HEADER_SUBJECT},
+		},
+	}
+	for name, test := range tests {
+		msg := newMessage()
+		test.modify(msg)
+
+		err := msg.Validate()
+		errs, ok := err.(ValidationErrors)
+		if !ok {
+			t.Errorf("%s: Got %#v, expected ValidationErrors", name, err)
+			continue
+		}
+		if got := errs.Fields(); !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%s: Got fields %q, expected %q (%s)", name, got, test.expect, err)
+		}
+
+		// The first problem is available as a ValidationError for compatibility
+		var first ValidationError
+		if !errors.As(err, &first) || first != errs[0] {
+			t.Errorf("%s: Got ValidationError %#v, expected %#v", name, first, errs[0])
+		}
+
+		if _, err := msg.Proposal(BasicProposal); err == nil || err.Error() != errs.Error() {
+			t.Errorf("%s: Got proposal error %v, expected %v", name, err, errs)
+		}
+	}
+}
+
+func TestAddRecipientSMTP(t *testing.T) {
+	tests := map[string]bool{
+		"foo@bar.baz":              true,
+		"Foo.Bar+wl2k@example.com": true,
+		"foo@sub.example.co.uk":    true,
+		"":                         false,
+		"foo":                      false,
+		"foo@":                     false,
+		"@bar.baz":                 false,
+		"foo@bar":                  false,
+		"foo@bar.":                 false,
+		"foo bar@bar.baz":          false,
+		"Foo <foo@bar.baz>":        false,
+		"foo@bar.baz,bar@baz.no":   false,
+		"fø@bar.baz":               false,
+		"LA5NTA@winlink.org":       false,
+	}
+	for addr, valid := range tests {
+		msg := NewMessage(Private, "N0CALL")
+		err := msg.AddRecipientSMTP(addr)
+		switch {
+		case valid && err != nil:
+			t.Errorf("%q: Got unexpected error: %v", addr, err)
+		case !valid && err == nil:
+			t.Errorf("%q: Expected error", addr)
+		}
+		if !valid {
+			if to := msg.Header[HEADER_TO]; len(to) != 0 {
+				t.Errorf("%q: Got To %q, expected none", addr, to)
+			}
+			continue
+		}
+
+		if got, expect := msg.Header[HEADER_TO], []string{"SMTP:" + addr}; !reflect.DeepEqual(got, expect) {
+			t.Errorf("%q: Got To %q, expected %q", addr, got, expect)
+		}
+		if got, expect := msg.To(), []Address{{Proto: "SMTP", Addr: addr}}; !reflect.DeepEqual(got, expect) {
+			t.Errorf("%q: Got To() %v, expected %v", addr, got, expect)
+		}
+
+		msg.SetSubject("Test")
+		msg.SetBody("Test")
+		if _, err := msg.Proposal(BasicProposal); err != nil {
+			t.Errorf("%q: Got unexpected proposal error: %v", addr, err)
+		}
+	}
+}
+
+func TestBodyPreviewSkipsAttachments(t *testing.T) {
+	const attachmentSize = 4 << 20
+
+	msg := NewMessage(Private, "LA5NTA")
+	msg.AddTo("N0CALL")
+	msg.SetSubject("Photo")
+	_ = msg.SetBody("Blåbærsyltetøy på skiva")
+	msg.AddFile(NewFile("photo.jpg", make([]byte, attachmentSize)))
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		m             Message
+		before, after runtime.MemStats
+		preview       string
+	)
+	runtime.ReadMemStats(&before)
+	if err := m.ReadHeaderFrom(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	preview, err = m.BodyPreview(9)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if preview != "Blåbærsyl" {
+		t.Errorf("Got preview %q, expected %q", preview, "Blåbærsyl")
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated >= attachmentSize {
+		t.Errorf("Got %d bytes allocated, expected less than the attachment size", allocated)
+	}
+	if m.Subject() != "Photo" || len(m.Files()) != 1 || m.Files()[0].Size() != attachmentSize || m.Files()[0].Name() != "photo.jpg" {
+		t.Errorf("Unexpected message read: %s", m.String())
+	}
+	if m.Files()[0].Data() != nil {
+		t.Error("Got data of skipped attachment")
+	}
+	if err := m.Write(io.Discard); err != ErrFileNotLoaded {
+		t.Errorf("Got %v writing message with skipped attachment, expected %v", err, ErrFileNotLoaded)
+	}
+
+	if preview, _ := m.BodyPreview(100); !strings.HasPrefix(preview, "Blåbærsyltetøy på skiva") {
+		t.Errorf("Got preview %q, expected the whole body", preview)
+	}
+}
+
+func TestNewMessageWithClock(t *testing.T) {
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	clock := ClockFunc(func() time.Time { return now })
+
+	msg := NewMessageWithClock(Private, "LA5NTA", clock)
+	if got := msg.Header.Get(HEADER_DATE); got != "2023/01/02 02:04" {
+		t.Errorf("Got date header %q, expected %q", got, "2023/01/02 02:04")
+	}
+	if got := msg.Date(); !got.Equal(now.Truncate(time.Minute)) {
+		t.Errorf("Got date %s, expected %s", got, now.Truncate(time.Minute))
+	}
+
+	// The MID is deterministic given the same time and callsign
+	if mid := NewMessageWithClock(Private, "LA5NTA", clock).MID(); mid != msg.MID() {
+		t.Errorf("Got MID %s, expected %s", mid, msg.MID())
+	}
+	if mid := NewMessageWithClock(Private, "N0CALL", clock).MID(); mid == msg.MID() {
+		t.Errorf("Got the same MID for different callsigns")
+	}
+}
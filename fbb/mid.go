@@ -14,11 +14,14 @@ import (
 const MaxMIDLength = 12
 
 // Generates a unique message ID in the format specified by the protocol.
-func GenerateMid(callsign string) string {
-	sum := md5.Sum(midPayload(callsign, time.Now()))
+func GenerateMid(callsign string) string { return generateMid(callsign, time.Now()) }
+
+// generateMid generates a message ID for a message created by callsign at time t.
+func generateMid(callsign string, t time.Time) string {
+	sum := md5.Sum(midPayload(callsign, t))
 	return base32.StdEncoding.EncodeToString(sum[0:])[0:MaxMIDLength]
 }
 
 func midPayload(callsign string, t time.Time) []byte {
-	return []byte(fmt.Sprintf("%s-%s", time.Now(), callsign))
+	return []byte(fmt.Sprintf("%s-%s", t, callsign))
 }
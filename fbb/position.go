@@ -0,0 +1,148 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/textproto"
+	"sync"
+
+	"github.com/la5nta/wl2k-go/lzhuf"
+)
+
+// maxPositionHeaderSize limits the amount of data buffered while looking for the end of the message header.
+const maxPositionHeaderSize = 64 * 1024
+
+// messagePosition tracks the logical position within a decompressed message as it is written to it.
+//
+// The header is parsed as soon as it is complete, and the following bytes are attributed to the body and
+// the attachments in the order given by the header. It is safe for concurrent use.
+type messagePosition struct {
+	mu       sync.Mutex
+	header   []byte    // The header data received so far (nil once parsed)
+	sections []section // The body followed by the attachments (nil until the header is parsed)
+	n        int       // Number of bytes written after the header
+	failed   bool      // The header could not be parsed
+}
+
+type section struct {
+	name string
+	size int
+}
+
+func (mp *messagePosition) Write(p []byte) (int, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if mp.failed {
+		return len(p), nil
+	}
+	if mp.sections != nil {
+		mp.n += len(p)
+		return len(p), nil
+	}
+
+	mp.header = append(mp.header, p...)
+	trimmed := bytes.TrimLeft(mp.header, " \t\r\n") // Leading whitespace is ignored by Message.ReadFrom
+	idx := bytes.Index(trimmed, []byte("\r\n\r\n"))
+	switch {
+	case idx < 0 && len(mp.header) > maxPositionHeaderSize:
+		mp.failed, mp.header = true, nil
+		return len(p), nil
+	case idx < 0:
+		return len(p), nil
+	}
+
+	h, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(trimmed[:idx+4]))).ReadMIMEHeader()
+	if err != nil {
+		mp.failed, mp.header = true, nil
+		return len(p), nil
+	}
+	m := &Message{Header: Header(h)}
+	mp.sections = append(mp.sections, section{size: m.BodySize()})
+	for _, value := range m.Header[HEADER_FILE] {
+		name, size, err := parseFileHeader(value)
+		if err != nil {
+			mp.failed = true
+			break
+		}
+		mp.sections = append(mp.sections, section{name: name, size: size})
+	}
+	mp.n = len(trimmed) - (idx + 4)
+	mp.header = nil
+	return len(p), nil
+}
+
+// status returns st with the attachment position fields set.
+func (mp *messagePosition) status(st Status) Status {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if mp.failed || mp.sections == nil {
+		return st
+	}
+	st.FileCount = len(mp.sections) - 1
+
+	// Each section is followed by CRLF
+	remaining := mp.n
+	for i, s := range mp.sections {
+		if remaining < s.size+2 || i == len(mp.sections)-1 {
+			if i > 0 {
+				st.FileIndex, st.FileName, st.FileSize = i, s.name, s.size
+				st.FileBytesTransferred = remaining
+				if remaining > s.size {
+					st.FileBytesTransferred = s.size
+				}
+			}
+			break
+		}
+		remaining -= s.size + 2
+	}
+	return st
+}
+
+// trackPosition decompresses the data written to the returned io.WriteCloser in the background, and
+// writes the result to mp.
+//
+// The decompression is best-effort: If it fails, any further data written is discarded.
+// Close blocks until all data written has been decompressed.
+func trackPosition(code PropCode, mp *messagePosition) io.WriteCloser {
+	pr, pw := io.Pipe()
+	t := &positionTracker{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		defer pr.Close()
+
+		var r io.Reader
+		var err error
+		switch code {
+		case GzipProposal:
+			r, err = gzip.NewReader(pr)
+		default:
+			r, err = lzhuf.NewB2Reader(pr)
+		}
+		if err == nil {
+			io.Copy(mp, r)
+		}
+		io.Copy(io.Discard, pr) // Don't block the writer
+	}()
+	return t
+}
+
+type positionTracker struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+}
+
+func (t *positionTracker) Write(p []byte) (int, error) { return t.pw.Write(p) }
+
+func (t *positionTracker) Close() error {
+	err := t.pw.Close()
+	<-t.done
+	return err
+}
@@ -12,6 +12,7 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/la5nta/wl2k-go/lzhuf"
 )
@@ -32,9 +33,27 @@ const (
 	Reject                = '-'
 	Defer                 = '='
 
+	// NoOpinion leaves the decision to the mailbox handler (see Session.SetAcceptPredicate).
+	NoOpinion ProposalAnswer = 0
+
 	// Offset not supported yet
 )
 
+// CompressionStats holds the time spent compressing a message for an outbound proposal (see Session.SetCompressionHook).
+type CompressionStats struct {
+	MID            string
+	Code           PropCode
+	Size           int
+	CompressedSize int
+	Duration       time.Duration
+
+	// SizeOnly is true if the compressed data was discarded after determining the compressed size.
+	//
+	// This is the case when an outbound proposal is prepared by a Session, unless the handler implements
+	// CompressedCache. The message is then compressed again when it's about to be sent.
+	SizeOnly bool
+}
+
 // Proposal is the type representing a inbound or outbound proposal.
 type Proposal struct {
 	code           PropCode
@@ -47,6 +66,13 @@ type Proposal struct {
 	size           int
 	compressedData []byte
 	compressedSize int
+	duplicate      bool // The MID was proposed more than once in the same proposal block
+
+	open  func() (io.ReadCloser, error) // Source of the raw message for lazily compressed proposals (see load)
+	cache CompressedCache               // Optional cache of the compressed data for lazily compressed proposals
+	hook  func(CompressionStats)        // Called after each compression (see Session.SetCompressionHook)
+
+	from, to string // Only set for legacy ASCII (FA) proposals
 }
 
 // Constructor for a new Proposal given a Winlink Message.
@@ -69,27 +95,241 @@ func NewProposal(MID, title string, code PropCode, data []byte) *Proposal {
 	}
 
 	var (
-		z   io.WriteCloser
-		buf bytes.Buffer
+		buf   bytes.Buffer
+		start = time.Now()
 	)
-	switch prop.code {
-	case GzipProposal:
-		z, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
-	default:
-		z = lzhuf.NewB2Writer(&buf)
-	}
-
-	z.Write(data)
-	if err := z.Close(); err != nil {
+	if _, err := prop.compress(&buf, bytes.NewReader(data)); err != nil {
 		panic(err)
 	}
 
 	prop.compressedData = buf.Bytes()
 	prop.compressedSize = len(prop.compressedData)
+	prop.compressed(start)
 
 	return prop
 }
 
+// newLazyProposal returns a proposal for the raw message read from open.
+//
+// The message is compressed once to determine the compressed size, but the compressed data is not kept
+// in memory until the proposal is about to be sent (see load). This caps memory use at one in-flight message,
+// at the cost of compressing the message a second time when it's sent.
+//
+// The message is read once and compressed with each of the given codes in a single pass. The code giving the
+// smallest compressed size is used (the first one on a tie).
+//
+// If cache is non-nil, a previously cached compressed form of the message is used instead of compressing it.
+// Otherwise the compressed data is stored in the cache, so that it's not compressed again when it's sent.
+// If hook is non-nil, it's called after each compression of the message.
+func newLazyProposal(MID, title string, codes []PropCode, open func() (io.ReadCloser, error), cache CompressedCache, hook func(CompressionStats)) (*Proposal, error) {
+	prop := &Proposal{
+		mid:     MID,
+		msgType: "EM",
+		title:   title,
+		open:    open,
+		cache:   cache,
+		hook:    hook,
+	}
+
+	if prop.title == `` {
+		prop.title = `No title`
+	}
+
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for _, prop.code = range codes {
+		if data := prop.cached(); data != nil {
+			n, err := io.Copy(io.Discard, r)
+			prop.size, prop.compressedSize = int(n), len(data)
+			return prop, err
+		}
+	}
+
+	encoders := make([]*encoder, len(codes))
+	writers := make([]io.Writer, len(codes))
+	for i, code := range codes {
+		encoders[i] = newEncoder(code, cache != nil)
+		writers[i] = encoders[i]
+	}
+	n, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return nil, err
+	}
+	prop.size = int(n)
+
+	var best *encoder
+	for _, e := range encoders {
+		if err := e.Close(); err != nil {
+			return nil, err
+		}
+		if best == nil || int(e.n) < int(best.n) {
+			best = e
+		}
+	}
+	prop.code, prop.compressedSize = best.code, int(best.n)
+
+	for _, e := range encoders {
+		prop.report(e.code, int(e.n), e.duration, e.buf == nil)
+	}
+	if cache != nil {
+		cache.StoreCompressedData(MID, best.code, best.buf.Bytes())
+	}
+	return prop, nil
+}
+
+// reset returns a copy of the lazily compressed proposal p as it was when prepared, before it was
+// answered or loaded.
+func (p *Proposal) reset() *Proposal {
+	return &Proposal{
+		code:           p.code,
+		msgType:        p.msgType,
+		mid:            p.mid,
+		title:          p.title,
+		size:           p.size,
+		compressedSize: p.compressedSize,
+		open:           p.open,
+		cache:          p.cache,
+		hook:           p.hook,
+	}
+}
+
+// encoder compresses the data written to it, keeping track of the compressed size and the time spent.
+type encoder struct {
+	code     PropCode
+	z        io.WriteCloser
+	n        countingWriter
+	buf      *bytes.Buffer // The compressed data (nil if not kept)
+	duration time.Duration
+}
+
+func newEncoder(code PropCode, keep bool) *encoder {
+	e := &encoder{code: code}
+	var w io.Writer = &e.n
+	if keep {
+		e.buf = new(bytes.Buffer)
+		w = io.MultiWriter(&e.n, e.buf)
+	}
+	e.z = newCompressor(code, w)
+	return e
+}
+
+func (e *encoder) Write(p []byte) (int, error) {
+	defer func(start time.Time) { e.duration += time.Since(start) }(time.Now())
+	return e.z.Write(p)
+}
+
+func (e *encoder) Close() error {
+	defer func(start time.Time) { e.duration += time.Since(start) }(time.Now())
+	return e.z.Close()
+}
+
+// cached returns the compressed data of p held by the proposal's CompressedCache, or nil if none.
+func (p *Proposal) cached() []byte {
+	if p.cache == nil {
+		return nil
+	}
+	data, ok := p.cache.CompressedData(p.mid, p.code)
+	if !ok || len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+// load reads and compresses the message of a lazy proposal, unless the compressed data is already loaded.
+func (p *Proposal) load() error {
+	if p.open == nil || p.compressedData != nil {
+		return nil
+	}
+
+	// Serve the exact bytes from the cache, so that offsets requested by the remote
+	// refer to the same data as previously transmitted.
+	if data := p.cached(); data != nil && len(data) == p.compressedSize {
+		p.compressedData = data
+		return nil
+	}
+
+	r, err := p.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var (
+		buf   bytes.Buffer
+		start = time.Now()
+	)
+	if _, err := p.compress(&buf, r); err != nil {
+		return err
+	}
+	if buf.Len() != p.compressedSize {
+		return fmt.Errorf("Compressed size of %s changed since proposed (%d, expected %d)", p.mid, buf.Len(), p.compressedSize)
+	}
+
+	p.compressedData = buf.Bytes()
+	p.compressed(start)
+	if p.cache != nil {
+		p.cache.StoreCompressedData(p.mid, p.code, p.compressedData)
+	}
+	return nil
+}
+
+// unload releases the compressed data of a lazy proposal.
+func (p *Proposal) unload() {
+	if p.open != nil {
+		p.compressedData = nil
+	}
+}
+
+// compress writes the compressed data read from r to w, returning the uncompressed size.
+func (p *Proposal) compress(w io.Writer, r io.Reader) (int, error) {
+	z := newCompressor(p.code, w)
+	n, err := io.Copy(z, r)
+	if err != nil {
+		return 0, err
+	}
+	return int(n), z.Close()
+}
+
+// newCompressor returns a WriteCloser compressing the data written to w using the compression of the given code.
+func newCompressor(code PropCode, w io.Writer) io.WriteCloser {
+	if code == GzipProposal {
+		z, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return z
+	}
+	return lzhuf.NewB2Writer(w)
+}
+
+// compressed calls the proposal's compression hook (if set) with the stats of a compression started at start.
+func (p *Proposal) compressed(start time.Time) {
+	p.report(p.code, p.compressedSize, time.Since(start), false)
+}
+
+// report calls the proposal's compression hook (if set) with the given compression stats.
+func (p *Proposal) report(code PropCode, compressedSize int, d time.Duration, sizeOnly bool) {
+	if p.hook == nil {
+		return
+	}
+	p.hook(CompressionStats{
+		MID:            p.mid,
+		Code:           code,
+		Size:           p.size,
+		CompressedSize: compressedSize,
+		Duration:       d,
+		SizeOnly:       sizeOnly,
+	})
+}
+
+type countingWriter int
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
 // Method for checking if the Proposal is completely
 // downloaded/loaded and ready to be read/sent.
 //
@@ -110,35 +350,107 @@ func (p *Proposal) Title() string {
 	return p.title
 }
 
+// Size returns the uncompressed size of the message (as announced in the proposal).
+func (p *Proposal) Size() int { return p.size }
+
+// CompressedSize returns the compressed size of the message (as announced in the proposal).
+func (p *Proposal) CompressedSize() int { return p.compressedSize }
+
+// IsDuplicate returns true if the remote proposed this MID more than once in the same proposal block.
+//
+// Only one copy is accepted, the rest are deferred. The remote will most likely propose them again.
+func (p *Proposal) IsDuplicate() bool { return p.duplicate }
+
 func (p *Proposal) Message() (*Message, error) {
-	buf := bytes.NewBuffer(p.Data())
+	if p.code == AsciiProposal {
+		return p.plainMessage()
+	}
+
+	data, err := p.DataErr()
+	if err != nil {
+		return nil, err
+	}
 	m := new(Message)
-	err := m.ReadFrom(buf)
+	err = m.ReadFrom(bytes.NewBuffer(data))
 	return m, err
 }
 
-// Data returns the decompressed raw message
+// Data returns the decompressed raw message.
+//
+// Nil is returned if the message can't be read or decompressed. See DataErr.
 func (p *Proposal) Data() []byte {
-	var r io.ReadCloser
-	var err error
+	data, _ := p.DataErr()
+	return data
+}
 
+// DataErr returns the decompressed raw message.
+//
+// An error is returned if the message can't be decompressed, or if the message of an outbound
+// proposal can't be read (e.g. OpenOutbound failed).
+func (p *Proposal) DataErr() ([]byte, error) {
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+
+	var (
+		r   io.ReadCloser
+		err error
+	)
 	switch p.code {
+	case AsciiProposal:
+		return p.compressedData, nil // Not compressed
 	case GzipProposal:
 		r, err = gzip.NewReader(bytes.NewBuffer(p.compressedData))
 	default:
 		r, err = lzhuf.NewB2Reader(bytes.NewBuffer(p.compressedData))
 	}
-
 	if err != nil {
-		panic(err) //TODO: Should return error
+		return nil, err
 	}
 
 	var buf bytes.Buffer
 	if _, err := io.Copy(&buf, r); err != nil {
-		panic(err) //TODO
+		return nil, err
+	}
+	return buf.Bytes(), r.Close()
+}
+
+// PendingMessage describes a message the remote (CMS) has waiting for us, as advertised by a ;PM line.
+//
+// The remote may advertise more pending messages than it proposes in one session.
+type PendingMessage struct {
+	To      Address // The recipient (one of the addresses we request messages on behalf of)
+	MID     string
+	Size    int // Size of the message (bytes)
+	From    Address
+	Subject string // Empty if not given by the remote
+}
+
+// parsePM parses a pending message line (;PM: <to> <MID> <size> <from> [subject]).
+func parsePM(line string) (PendingMessage, error) {
+	if !strings.HasPrefix(line, ";PM: ") {
+		return PendingMessage{}, errors.New("Malformed pending message line")
 	}
 
-	return buf.Bytes()
+	fields := strings.SplitN(line[5:], " ", 5)
+	if len(fields) < 4 {
+		return PendingMessage{}, fmt.Errorf("Expected at least 4 fields in pending message line, got %d", len(fields))
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return PendingMessage{}, fmt.Errorf("Invalid size in pending message line: %w", err)
+	}
+
+	pm := PendingMessage{
+		To:   AddressFromString(fields[0]),
+		MID:  fields[1],
+		Size: size,
+		From: AddressFromString(fields[3]),
+	}
+	if len(fields) == 5 {
+		pm.Subject = strings.TrimSpace(fields[4])
+	}
+	return pm, nil
 }
 
 func parseProposal(line string, prop *Proposal) (err error) {
@@ -151,7 +463,9 @@ func parseProposal(line string, prop *Proposal) (err error) {
 	prop.code = PropCode(line[1])
 
 	switch prop.code {
-	case BasicProposal, AsciiProposal: // TODO: implement
+	case BasicProposal: // TODO: implement
+	case AsciiProposal:
+		err = parseAsciiProposal(line, prop)
 	case Wl2kProposal, GzipProposal:
 		err = parseB2Proposal(line, prop)
 	default:
@@ -160,6 +474,58 @@ func parseProposal(line string, prop *Proposal) (err error) {
 	return
 }
 
+// unknownProposal returns a proposal for a line that looks like a proposal with an unknown proposal code.
+//
+// Only the code and MID is known, which is enough to defer it.
+//
+//	FX EM TJKYEIMMHSRB ...
+func unknownProposal(line string) (*Proposal, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || len(fields[0]) != 2 || line[1] < 'A' || line[1] > 'Z' {
+		return nil, false
+	}
+	return &Proposal{code: PropCode(line[1]), msgType: fields[1], mid: fields[2]}, true
+}
+
+// parseAsciiProposal parses a legacy FBB (FA) proposal for an ASCII message.
+//
+// In FBB, the message of an FA proposal is compressed. Receiving it as plain (uncompressed) text is a
+// compatibility extension for legacy systems sending it that way (see Session.readPlain).
+//
+//	FA P LA5NTA LA1B N0CALL 12345_LA5NTA 1234
+//	   type from @bbs to bid size
+func parseAsciiProposal(line string, prop *Proposal) error {
+	parts := strings.Fields(line[2:])
+	if len(parts) != 6 {
+		return errors.New(`Malformed proposal: ` + line)
+	}
+
+	size, err := strconv.Atoi(parts[5])
+	if err != nil {
+		return fmt.Errorf("Malformed proposal size: %w", err)
+	}
+
+	prop.msgType = parts[0]
+	prop.from = parts[1]
+	prop.to = parts[3]
+	prop.mid = parts[4]
+	prop.size, prop.compressedSize = size, size
+	return nil
+}
+
+// plainMessage constructs a Message from a received legacy ASCII (FA) proposal.
+func (p *Proposal) plainMessage() (*Message, error) {
+	msg := NewMessage(Private, p.from)
+	msg.Header.Set(HEADER_MID, p.mid)
+	msg.AddTo(p.to)
+	msg.SetSubject(p.title)
+
+	// Legacy systems use CR line endings
+	body := strings.ReplaceAll(string(p.compressedData), "\r\n", "\r")
+	body = strings.ReplaceAll(body, "\r", "\r\n")
+	return msg, msg.SetBody(body)
+}
+
 func parseB2Proposal(line string, prop *Proposal) (err error) {
 	if len(line) < 4 {
 		return errors.New("Unexpected end of proposal line")
@@ -198,25 +564,44 @@ func parseB2Proposal(line string, prop *Proposal) (err error) {
 	return
 }
 
-// precedence returns the priority level of the message. Lower precedence value is more important
-// and should be handled sooner.
+// Priority is the message precedence level as used by the Winlink system.
+//
+// Lower value is more important and should be handled sooner.
 //
 // See https://www.winlink.org/content/how_use_message_precedence_precedence.
-func (p *Proposal) precedence() int {
-	const (
-		Flash = iota
-		Immediate
-		Priority
-		Routine
-	)
+type Priority int
+
+// The Winlink precedence levels (most important first).
+const (
+	PriorityFlash Priority = iota
+	PriorityImmediate
+	PriorityPriority
+	PriorityRoutine
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityFlash:
+		return "Flash"
+	case PriorityImmediate:
+		return "Immediate"
+	case PriorityPriority:
+		return "Priority"
+	default:
+		return "Routine"
+	}
+}
+
+// Priority returns the precedence level of the message as indicated by the title.
+func (p *Proposal) Priority() Priority {
 	switch {
 	case strings.Contains(p.title, "//WL2K Z/"):
-		return Flash
+		return PriorityFlash
 	case strings.Contains(p.title, "//WL2K O/"):
-		return Immediate
+		return PriorityImmediate
 	case strings.Contains(p.title, "//WL2K P/"):
-		return Priority
+		return PriorityPriority
 	default:
-		return Routine
+		return PriorityRoutine
 	}
 }
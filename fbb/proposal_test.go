@@ -5,7 +5,10 @@
 package fbb
 
 import (
+	"errors"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -19,6 +22,15 @@ func TestParseProposal(t *testing.T) {
 			size:           527,
 			compressedSize: 123,
 		},
+		"FA P LA5NTA LA1B N0CALL 12345_LA5NTA 1234": Proposal{
+			code:           AsciiProposal,
+			msgType:        "P",
+			mid:            "12345_LA5NTA",
+			size:           1234,
+			compressedSize: 1234,
+			from:           "LA5NTA",
+			to:             "N0CALL",
+		},
 	}
 
 	for input, expected := range tests {
@@ -31,3 +43,80 @@ func TestParseProposal(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePM(t *testing.T) {
+	got, err := parsePM(";PM: LA5NTA TJKYEIMMHSRB 123 N0CALL@winlink.org Re: Test  message")
+	expect := PendingMessage{
+		To:      Address{Addr: "LA5NTA"},
+		MID:     "TJKYEIMMHSRB",
+		Size:    123,
+		From:    Address{Addr: "N0CALL"},
+		Subject: "Re: Test  message",
+	}
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	} else if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got %#v, expected %#v", got, expect)
+	}
+
+	for _, line := range []string{";PM:", ";PM: LA5NTA TJKYEIMMHSRB 123", ";PM: LA5NTA TJKYEIMMHSRB foo N0CALL", ";FW: LA5NTA"} {
+		if _, err := parsePM(line); err == nil {
+			t.Errorf("Expected error while parsing '%s'", line)
+		}
+	}
+}
+
+func TestCompressionHook(t *testing.T) {
+	var got []CompressionStats
+	hook := func(stats CompressionStats) { got = append(got, stats) }
+	open := func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("Hello, world!")), nil }
+
+	p, err := newLazyProposal("TJKYEIMMHSRB", "Test", []PropCode{Wl2kProposal}, open, nil, hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.load(); err != nil {
+		t.Fatal(err)
+	}
+	// Once to determine the size, and again when loaded
+	if len(got) != 2 {
+		t.Fatalf("Got %d calls, expected 2", len(got))
+	}
+	for i, stats := range got {
+		switch {
+		case stats.MID != p.MID() || stats.Code != Wl2kProposal:
+			t.Errorf("Unexpected stats: %+v", stats)
+		case stats.Size != p.Size() || stats.CompressedSize != p.CompressedSize():
+			t.Errorf("Unexpected sizes: %+v", stats)
+		case stats.Duration <= 0:
+			t.Errorf("Expected positive duration, got %s", stats.Duration)
+		case stats.SizeOnly != (i == 0):
+			t.Errorf("Got SizeOnly %t for compression %d", stats.SizeOnly, i+1)
+		}
+	}
+}
+
+func TestProposalDataOpenError(t *testing.T) {
+	errGone := errors.New("message gone")
+	var opened int
+	open := func() (io.ReadCloser, error) {
+		if opened++; opened > 1 {
+			return nil, errGone
+		}
+		return io.NopCloser(strings.NewReader("Hello, world!")), nil
+	}
+
+	p, err := newLazyProposal("TJKYEIMMHSRB", "Test", []PropCode{Wl2kProposal}, open, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.DataErr(); err != errGone {
+		t.Errorf("Got %v, expected %v", err, errGone)
+	}
+	if data := p.Data(); data != nil {
+		t.Errorf("Got %q, expected nil", data)
+	}
+	if _, err := p.Message(); err != errGone {
+		t.Errorf("Got %v, expected %v from Message", err, errGone)
+	}
+}
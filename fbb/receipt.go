@@ -0,0 +1,64 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Header fields used for delivery receipts.
+//
+// The Winlink system has no notion of delivery receipts, so these are only understood by clients
+// implementing them. Disposition-Notification-To is borrowed from RFC 3798.
+const (
+	HEADER_DISPOSITION_NOTIFICATION_TO = `Disposition-Notification-To`
+	HEADER_DELIVERY_RECEIPT            = `X-Delivery-Receipt` // The MID of the delivered message
+)
+
+// RequestReceipt requests a delivery receipt from the receivers of this message.
+//
+// The receipt is requested to be sent to the message's sender (From).
+func (m *Message) RequestReceipt() {
+	m.Header.Set(HEADER_DISPOSITION_NOTIFICATION_TO, m.From().String())
+}
+
+// ReceiptRequested returns the address a delivery receipt should be sent to, if requested by the sender.
+func (m *Message) ReceiptRequested() (addr Address, ok bool) {
+	str := strings.TrimSpace(m.Header.Get(HEADER_DISPOSITION_NOTIFICATION_TO))
+	if str == "" {
+		return Address{}, false
+	}
+	return AddressFromString(str), true
+}
+
+// NewDeliveryReceipt returns a delivery receipt for the given message, sent from mycall.
+//
+// The receipt is addressed as requested by the sender (see ReceiptRequested), or to the sender
+// of m if no receipt was requested.
+func NewDeliveryReceipt(m *Message, mycall string) *Message {
+	to, ok := m.ReceiptRequested()
+	if !ok {
+		to = m.From()
+	}
+
+	receipt := NewMessage(Private, mycall)
+	receipt.Header.Set(HEADER_DELIVERY_RECEIPT, m.MID())
+	receipt.AddTo(to.String())
+	receipt.SetSubject("Delivered: " + m.Subject())
+	receipt.SetBody(fmt.Sprintf(
+		"Your message %s (%s) sent %s was delivered to %s.\r\n",
+		m.MID(), m.Subject(), m.Date().Format(DateLayout), mycall,
+	))
+	return receipt
+}
+
+// IsDeliveryReceipt returns true if this message is a delivery receipt (see NewDeliveryReceipt).
+func (m *Message) IsDeliveryReceipt() bool { return m.ReceiptFor() != "" }
+
+// ReceiptFor returns the MID of the delivered message, if this message is a delivery receipt.
+func (m *Message) ReceiptFor() string {
+	return strings.TrimSpace(m.Header.Get(HEADER_DELIVERY_RECEIPT))
+}
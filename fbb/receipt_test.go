@@ -0,0 +1,57 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeliveryReceipt(t *testing.T) {
+	msg := NewMessage(Private, "LA5NTA")
+	msg.AddTo("N0CALL", "foo@example.com")
+	msg.SetSubject("Hello")
+	msg.SetBody("Hi there")
+
+	if _, ok := msg.ReceiptRequested(); ok {
+		t.Error("Receipt requested without calling RequestReceipt")
+	}
+	msg.RequestReceipt()
+
+	// Roundtrip to make sure the request survives transfer
+	var buf bytes.Buffer
+	if err := msg.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	received := new(Message)
+	if err := received.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	addr, ok := received.ReceiptRequested()
+	if !ok || addr.String() != "LA5NTA" {
+		t.Fatalf("Got receipt request (%t) to '%s', expected 'LA5NTA'", ok, addr)
+	}
+	if received.IsDeliveryReceipt() {
+		t.Error("Message with receipt request is not a delivery receipt")
+	}
+
+	receipt := NewDeliveryReceipt(received, "N0CALL")
+	switch {
+	case !receipt.IsDeliveryReceipt():
+		t.Error("Expected message to be a delivery receipt")
+	case receipt.ReceiptFor() != msg.MID():
+		t.Errorf("Got receipt for '%s', expected '%s'", receipt.ReceiptFor(), msg.MID())
+	case len(receipt.To()) != 1 || receipt.To()[0].String() != "LA5NTA":
+		t.Errorf("Unexpected receivers: %v", receipt.To())
+	case receipt.From().String() != "N0CALL":
+		t.Errorf("Unexpected sender: '%s'", receipt.From())
+	case receipt.Subject() != "Delivered: Hello":
+		t.Errorf("Unexpected subject: '%s'", receipt.Subject())
+	}
+	if err := receipt.Validate(); err != nil {
+		t.Errorf("Invalid receipt: %s", err)
+	}
+}
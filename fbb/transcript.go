@@ -0,0 +1,94 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directions of a TranscriptEntry.
+const (
+	TranscriptRx = "rx" // Received from the remote
+	TranscriptTx = "tx" // Sent to the remote
+)
+
+// TranscriptEntry is a protocol line as written to a session transcript (see Session.SetTranscript).
+type TranscriptEntry struct {
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"` // TranscriptRx or TranscriptTx
+	Line string    `json:"line"`
+}
+
+// transcript writes TranscriptEntries as JSON lines.
+type transcript struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	tx  []byte // Sent bytes not yet terminated by a line break
+	now func() time.Time
+}
+
+func newTranscript(w io.Writer, now func() time.Time) *transcript {
+	return &transcript{enc: json.NewEncoder(w), now: now}
+}
+
+func (t *transcript) record(dir, line string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enc.Encode(TranscriptEntry{Time: t.now().UTC(), Dir: dir, Line: line})
+}
+
+// Write records each (non-empty) line written as sent.
+func (t *transcript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.tx = append(t.tx, p...)
+	var lines []string
+	for {
+		idx := bytes.IndexAny(t.tx, "\r\n")
+		if idx < 0 {
+			break
+		}
+		if line := string(t.tx[:idx]); line != "" {
+			lines = append(lines, line)
+		}
+		t.tx = t.tx[idx+1:]
+	}
+	t.mu.Unlock()
+
+	for _, line := range lines {
+		t.record(TranscriptTx, line)
+	}
+	return len(p), nil
+}
+
+// SetTranscript sets the writer of a machine-readable transcript of the protocol lines sent and received.
+//
+// Each line is written as a JSON encoded TranscriptEntry followed by a newline (JSON lines). Unlike the
+// protocol logger (see SetLogger), this includes the raw handshake. The binary message transfers are not included.
+func (s *Session) SetTranscript(w io.Writer) {
+	if w == nil {
+		s.transcript = nil
+		return
+	}
+	s.transcript = newTranscript(w, s.now)
+}
+
+// tx returns a writer that writes to w and records the protocol lines written in the transcript (if set).
+func (s *Session) tx(w io.Writer) io.Writer {
+	if s.transcript == nil {
+		return w
+	}
+	return io.MultiWriter(w, s.transcript)
+}
+
+// rx records a received protocol line in the transcript (if set).
+func (s *Session) rx(line string) { s.transcript.record(TranscriptRx, strings.TrimRight(line, "\r\n")) }
@@ -0,0 +1,72 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package fbb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSessionTranscript(t *testing.T) {
+	client, srv := net.Pipe()
+
+	clock := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var buf bytes.Buffer
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+		s.SetTranscript(&buf)
+		s.SetClock(ClockFunc(func() time.Time { return clock }))
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+	fmt.Fprint(srv, "FQ\r")
+	srv.Close()
+
+	if err := <-cerrs; err != nil {
+		t.Fatalf("Session exchange returned error: %s", err)
+	}
+
+	expect := []TranscriptEntry{
+		{Dir: TranscriptRx, Line: "[WL2K-2.8.4.8-B2FWIHJM$]"},
+		{Dir: TranscriptRx, Line: "Test CMS >"},
+		{Dir: TranscriptTx, Line: ";FW: LA5NTA"},
+		{Dir: TranscriptTx, Line: "[wl2kgo-0.1a-B2FHM$]"},
+		{Dir: TranscriptTx, Line: "; LA1B-10 DE LA5NTA (JO39EQ)"},
+		{Dir: TranscriptTx, Line: "FF"},
+		{Dir: TranscriptRx, Line: "FQ"},
+	}
+	var got []TranscriptEntry
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e TranscriptEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("Unable to parse transcript: %s", err)
+		}
+		if !e.Time.Equal(clock) {
+			t.Errorf("Got timestamp %s, expected %s (from the session's clock)", e.Time, clock)
+		}
+		got = append(got, TranscriptEntry{Dir: e.Dir, Line: e.Line})
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got transcript %+v, expected %+v", got, expect)
+	}
+}
@@ -9,6 +9,7 @@ package fbb
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -18,6 +19,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/la5nta/wl2k-go/transport"
@@ -26,6 +28,9 @@ import (
 // ErrConnLost is returned by Session.Exchange if the connection is prematurely closed.
 var ErrConnLost = errors.New("connection lost")
 
+// ErrIdleTimeout is returned by Session.Exchange if no protocol progress was made within the idle timeout (see SetIdleTimeout).
+var ErrIdleTimeout = errors.New("idle timeout")
+
 // Objects implementing the MBoxHandler interface can be used to handle inbound and outbound messages for a Session.
 type MBoxHandler interface {
 	InboundHandler
@@ -72,6 +77,39 @@ type InboundHandler interface {
 	GetInboundAnswer(p Proposal) ProposalAnswer
 }
 
+// An OutboundOpener can be implemented by an OutboundHandler to stream outbound messages from disk.
+//
+// The raw message is then read through OpenOutbound when its proposal is prepared, and again when
+// it's about to be sent (unless the handler implements CompressedCache), instead of being serialized
+// from the Message returned by GetOutbound.
+type OutboundOpener interface {
+	// OpenOutbound should open the raw (serialized) outbound message identified by MID for reading.
+	OpenOutbound(MID string) (io.ReadCloser, error)
+}
+
+// A CompressedCache can be implemented by an OutboundHandler to keep the compressed form of outbound messages.
+//
+// The cached data is used instead of compressing the message again, also in later sessions. This
+// guarantees that a remote requesting a message at an offset (resuming a partial transfer, as RMS Relay
+// sometimes does) is served the same bytes as it received previously. The cache entry can be dropped
+// once the message is marked as sent (see SetSent).
+type CompressedCache interface {
+	// CompressedData returns the cached compressed data for the given MID and compression (proposal code).
+	CompressedData(MID string, code PropCode) (data []byte, ok bool)
+
+	// StoreCompressedData is called with the compressed data of an outbound message when its proposal is prepared.
+	StoreCompressedData(MID string, code PropCode, data []byte)
+}
+
+// A ProposalFilter can be implemented by an InboundHandler to screen inbound proposals, e.g. to reject
+// messages that are too large to be transferred over a slow link.
+type ProposalFilter interface {
+	// FilterProposal is called with each inbound proposal before GetInboundAnswer.
+	//
+	// Returning Accept leaves the decision to GetInboundAnswer, while Reject or Defer is used as the answer.
+	FilterProposal(p Proposal) ProposalAnswer
+}
+
 // Session represents a B2F exchange session.
 //
 // A session should only be used once.
@@ -87,12 +125,20 @@ type Session struct {
 	// Callback when secure login password is needed
 	secureLoginHandleFunc func(addr Address) (password string, err error)
 
+	// Callback when the remote's SID is received
+	remoteSIDHandleFunc func(sid SID)
+
 	master     bool
 	robustMode robustMode
 
-	remoteSID sid
-	remoteFW  []Address // Addresses the remote requests messages on behalf of
-	localFW   []Address // Addresses we request messages on behalf of
+	localSIDCapabilities *SIDCapabilities // Nil means DefaultSIDCapabilities (see SetSID)
+
+	remoteSID     sid
+	remoteSIDInfo SID
+	remoteFW      []Address // Addresses the remote requests messages on behalf of
+	localFW       []Address // Addresses we request messages on behalf of
+
+	pendingMessages []PendingMessage // Advertised by the remote (see PendingMessages)
 
 	trafficStats TrafficStats
 
@@ -100,11 +146,38 @@ type Session struct {
 	quitSent     bool
 	remoteNoMsgs bool // True if last remote turn had no more messages
 
-	rd *bufio.Reader
+	turnoverPolicy atomic.Int32 // The turnoverPolicy (see SetTurnoverPolicy and SetKeepOpen)
+
+	idleTimeout time.Duration // See SetIdleTimeout
+	idleTimer   *time.Timer   // Closes the connection when the idle timeout expires (nil if disabled)
+	idleExpired atomic.Bool
+
+	outboundOrder   func(a, b *Proposal) bool       // Custom outbound order (see SetOutboundOrder)
+	acceptPredicate func(p Proposal) ProposalAnswer // Consulted before the handler (see SetAcceptPredicate)
+	heldMIDs        map[string]bool                 // Inbound proposals to reject (see SetHeldMIDs)
+	inboundRewriter func(*Message) error            // Applied to inbound messages before the handler (see SetInboundRewriter)
+
+	outboundBlockHook func(block []Proposal) error // Called before proposing a block (see SetOutboundBlockHook)
+	compressionHook   func(stats CompressionStats) // Called after compressing an outbound message (see SetCompressionHook)
+	prepared          map[string]*Proposal         // Outbound proposals already sized in this session (see outboundProposal)
+
+	progress transferProgress // The current block of accepted messages (for Status)
+
+	sniff   bool       // Observe only (see SetSniff)
+	sniffed []Proposal // Inbound proposals seen in sniff mode
+	dryRun  bool       // Negotiate, but transfer nothing (see SetDryRun)
+
+	lenientProtocol bool // Defer unknown proposal codes instead of failing (see SetStrictProtocol)
+
+	clock Clock // Nil means SystemClock (see SetClock)
 
-	log  *log.Logger
-	pLog *log.Logger
-	ua   UserAgent
+	rd     *bufio.Reader
+	rxTail tailBuffer // The last bytes received from the remote (for protocol error context)
+
+	log        *log.Logger
+	pLog       *log.Logger
+	transcript *transcript // Machine-readable protocol transcript (see SetTranscript)
+	ua         UserAgent
 }
 
 // Struct used to hold information that is reported during B2F handshake.
@@ -128,6 +201,46 @@ type Status struct {
 	BytesTotal       int
 	Done             bool
 	When             time.Time
+
+	// Progress of the current block of accepted messages (in the same direction).
+	MessageIndex            int // The message being transferred (1-based), e.g. message 3 of 7.
+	MessageCount            int // The number of accepted messages in the block.
+	SessionBytesTransferred int // Bytes transferred in the block, including previously transferred messages.
+	SessionBytesTotal       int // The total (compressed) size of all accepted messages in the block.
+
+	// Position within the decompressed message being received (optional).
+	//
+	// These fields are only set when receiving, once the message header has been decompressed. They
+	// are not set for resumed transfers (non-zero offset).
+	FileIndex            int    // The attachment being transferred (1-based), or 0 while receiving the body.
+	FileCount            int    // The number of attachments in the message.
+	FileName             string // The name of the attachment being transferred.
+	FileBytesTransferred int    // Bytes of the (decompressed) attachment received so far.
+	FileSize             int    // The size of the (decompressed) attachment.
+}
+
+// transferProgress tracks the progress of the current block of accepted messages.
+type transferProgress struct {
+	index, count          int // The message being transferred (1-based) and the number of accepted messages
+	bytesDone, bytesTotal int // The size of the completed messages and of all accepted messages
+}
+
+func newTransferProgress(props []*Proposal) transferProgress {
+	var tp transferProgress
+	for _, p := range props {
+		if p.answer == Accept {
+			tp.count++
+			tp.bytesTotal += p.compressedSize
+		}
+	}
+	return tp
+}
+
+// status returns st with the block progress fields set.
+func (tp transferProgress) status(st Status) Status {
+	st.MessageIndex, st.MessageCount = tp.index, tp.count
+	st.SessionBytesTransferred, st.SessionBytesTotal = tp.bytesDone+st.BytesTransferred, tp.bytesTotal
+	return st
 }
 
 // TrafficStats holds exchange message traffic statistics.
@@ -174,7 +287,8 @@ const (
 
 // SetRobustMode sets the RobustMode for this exchange.
 //
-// The mode is ignored if the exchange connection does not implement the transport.Robust interface.
+// The mode is ignored if the exchange connection does not implement the transport.Robust interface
+// (directly or through transport.RobustWrapper).
 //
 // Default is RobustAuto.
 func (s *Session) SetRobustMode(mode robustMode) {
@@ -182,6 +296,149 @@ func (s *Session) SetRobustMode(mode robustMode) {
 	//TODO: If NewSession took the net.Conn (not Exchange), we could return an error here to indicate that the operation was unsupported.
 }
 
+type turnoverPolicy int
+
+// The different turnover policies, deciding whether to quit (FQ) or yield (FF) when we have no more messages to send.
+const (
+	TurnoverDefault      turnoverPolicy = iota // Quit if the remote had no messages in its last turn.
+	TurnoverAlwaysYield                        // Never quit, leave it to the remote to end the session (see SetKeepOpen).
+	TurnoverQuitWhenDone                       // Quit as soon as all outbound messages are sent, even if the remote has more messages.
+)
+
+// SetTurnoverPolicy sets the policy for deciding whether to quit or yield the turn when there are no more
+// outbound messages to send.
+//
+// TurnoverQuitWhenDone is useful for "push and hang up" workflows, where inbound messages are of no interest.
+// Note that the session is quit on the first turn if there are no outbound messages to send.
+//
+// Default is TurnoverDefault.
+func (s *Session) SetTurnoverPolicy(policy turnoverPolicy) { s.turnoverPolicy.Store(int32(policy)) }
+
+func (s *Session) turnover() turnoverPolicy { return turnoverPolicy(s.turnoverPolicy.Load()) }
+
+// SetKeepOpen sets whether the session should be held open when neither party has more messages to send.
+//
+// By default, the session is ended (FQ) as soon as there are no more messages to exchange. When keep-open
+// is enabled, the session instead keeps turning over the link (FF) so that messages added to the mailbox
+// handler during the session are picked up. Call Quit to end the session.
+//
+// Keep-open is the TurnoverAlwaysYield policy. Disabling it restores TurnoverDefault.
+func (s *Session) SetKeepOpen(keepOpen bool) {
+	if keepOpen {
+		s.SetTurnoverPolicy(TurnoverAlwaysYield)
+	} else {
+		s.SetTurnoverPolicy(TurnoverDefault)
+	}
+}
+
+// Quit ends a session held open by SetKeepOpen (or TurnoverAlwaysYield).
+//
+// The session quits (FQ) the next time neither party has more messages to send. It is safe to call
+// Quit from another goroutine while Exchange is running.
+func (s *Session) Quit() {
+	s.turnoverPolicy.CompareAndSwap(int32(TurnoverAlwaysYield), int32(TurnoverDefault))
+}
+
+// SetOutboundOrder sets the order in which outbound messages are proposed (and sent) to the remote.
+//
+// less reports whether proposal a should be sent before b. Proposals considered equal are ordered by MID,
+// so the order is deterministic regardless of the order given by the mailbox handler.
+//
+// The default order (less == nil) is by priority, then by ascending compressed size and finally by MID.
+func (s *Session) SetOutboundOrder(less func(a, b *Proposal) bool) { s.outboundOrder = less }
+
+// SetAcceptPredicate sets a function that is consulted for each inbound proposal before the mailbox handler.
+//
+// The predicate may answer Accept, Reject or Defer based on the proposal's MID, title, size etc. This is
+// useful for relays that should only carry traffic for specific destinations. Any other answer (NoOpinion)
+// leaves the decision to the handler's ProposalFilter and GetInboundAnswer, as if no predicate was set.
+func (s *Session) SetAcceptPredicate(f func(p Proposal) ProposalAnswer) { s.acceptPredicate = f }
+
+// SetInboundRewriter sets a function that is applied to every inbound message before it is passed on to
+// the mailbox handler's ProcessInbound.
+//
+// The rewriter may modify the message in place, e.g. to adjust routing headers or strip private headers
+// when relaying between networks. Returning an error aborts the session.
+func (s *Session) SetInboundRewriter(f func(*Message) error) { s.inboundRewriter = f }
+
+// SetHeldMIDs sets the MIDs of messages already held by the local mailbox.
+//
+// Inbound proposals with a MID in this set are rejected right away, without consulting the mailbox
+// handler. This saves handler round-trips on large proposal blocks. Duplicate proposals in the same
+// block are still deferred as usual. Calling SetHeldMIDs with no MIDs clears the set.
+func (s *Session) SetHeldMIDs(mids []string) {
+	s.heldMIDs = make(map[string]bool, len(mids))
+	for _, mid := range mids {
+		s.heldMIDs[mid] = true
+	}
+}
+
+// SetOutboundBlockHook sets a function called with each block of outbound proposals before it is proposed to the remote.
+//
+// The block is sorted and truncated to MaxBlockSize, i.e. exactly the messages about to be proposed. This allows
+// a client to display (or confirm) what is about to be sent, e.g. over a metered link. Returning an error aborts
+// the session.
+func (s *Session) SetOutboundBlockHook(f func(block []Proposal) error) { s.outboundBlockHook = f }
+
+// SetCompressionHook sets a function called after an outbound message has been compressed.
+//
+// The stats include the time spent compressing the message, which is useful for measuring the
+// compression cost on slow hardware.
+func (s *Session) SetCompressionHook(f func(stats CompressionStats)) { s.compressionHook = f }
+
+// SetSniff sets whether the session should only observe the traffic (e.g. for passive monitoring of a link under test).
+//
+// In sniff mode every inbound proposal is logged and answered with Reject (already received), no outbound
+// messages are proposed and nothing is passed on to the mailbox handler. The handler may be nil. The
+// proposals seen are available through SniffedProposals.
+//
+// This differs from a session without a mailbox handler, which defers all inbound proposals.
+func (s *Session) SetSniff(sniff bool) { s.sniff = sniff }
+
+// SetIdleTimeout sets the maximum time Exchange waits for protocol progress before failing with ErrIdleTimeout.
+//
+// Progress is any protocol line or data block received, and any data block sent. When the timeout expires, the connection is
+// closed to abort any blocking operation. This does not depend on the transport's deadline support, which
+// is missing for some transports (e.g. AX.25). Note that waiting for the transport to flush the transmit
+// buffer after sending a message is not progress. Zero (the default) disables the timeout.
+func (s *Session) SetIdleTimeout(d time.Duration) { s.idleTimeout = d }
+
+// resetIdle resets the idle timeout (see SetIdleTimeout).
+func (s *Session) resetIdle() {
+	if s.idleTimer != nil {
+		s.idleTimer.Reset(s.idleTimeout)
+	}
+}
+
+// SetDryRun sets whether the session should only negotiate, without transferring any messages.
+//
+// In dry-run mode the handshake and proposal exchange is performed as usual, but every inbound proposal
+// is deferred and no outbound messages are proposed. The session quits once the remote has no more
+// messages to propose. Useful for verifying a path and the remote's identity before a big transfer.
+func (s *Session) SetDryRun(dryRun bool) { s.dryRun = dryRun }
+
+// SetStrictProtocol sets whether unknown protocol commands from the remote are fatal.
+//
+// In strict mode (the default), an unknown F-command aborts the session with a ProtocolError. Otherwise,
+// unknown commands that look like proposals (e.g. a future proposal code) are deferred, and other unknown
+// commands are ignored. This allows interoperability with remotes using newer protocol extensions.
+func (s *Session) SetStrictProtocol(strict bool) { s.lenientProtocol = !strict }
+
+// SetClock sets the Clock used for timestamps produced by this session (Status.When and the transcript entries).
+//
+// Default is SystemClock.
+func (s *Session) SetClock(c Clock) { s.clock = c }
+
+func (s *Session) now() time.Time {
+	if s.clock == nil {
+		return SystemClock.Now()
+	}
+	return s.clock.Now()
+}
+
+// SniffedProposals returns the inbound proposals seen in sniff mode, in the order they were received.
+func (s *Session) SniffedProposals() []Proposal { return s.sniffed }
+
 // SetMOTD sets one or more lines to be sent before handshake.
 //
 // The MOTD is only sent if the local node is session master.
@@ -190,17 +447,28 @@ func (s *Session) SetMOTD(line ...string) { s.motd = line }
 // IsMaster sets whether this end should initiate the handshake.
 func (s *Session) IsMaster(isMaster bool) { s.master = isMaster }
 
-// RemoteSID returns the remote's SID (if available).
+// RemoteSID returns the remote's SID feature codes (if available).
 func (s *Session) RemoteSID() string { return string(s.remoteSID) }
 
+// RemoteSIDInfo returns the remote's software identity and feature codes, as reported by its SID.
+//
+// The SID is available after the handshake (e.g. after Exchange). See also SetRemoteSIDHandleFunc.
+func (s *Session) RemoteSIDInfo() SID { return s.remoteSIDInfo }
+
+// SetRemoteSIDHandleFunc registers a callback that is called with the remote's SID during handshake.
+//
+// The callback is called before any messages are exchanged, and can be used to adapt the session
+// to the remote's capabilities.
+func (s *Session) SetRemoteSIDHandleFunc(f func(sid SID)) { s.remoteSIDHandleFunc = f }
+
 // Exchange is the main method for exchanging messages with a remote over the B2F protocol.
 //
 // Sends outbound messages and downloads inbound messages prepared for this session.
 //
 // Outbound messages should be added as proposals before calling the Exchange() method.
 //
-// If conn implements the transport.Robust interface, the connection is run in robust-mode
-// except when an outbound message is transferred.
+// If conn implements the transport.Robust interface (directly or through transport.RobustWrapper),
+// the connection is run in robust-mode except when an outbound message is transferred.
 //
 // After Exchange(), messages that was accepted and delivered successfully to the RMS is
 // available through a call to Sent(). Messages downloaded successfully from the RMS is
@@ -230,6 +498,9 @@ func (s *Session) Exchange(conn net.Conn) (stats TrafficStats, err error) {
 		case err == nil:
 			// Success :-)
 			return
+		case s.idleExpired.Load():
+			// The connection was closed by the idle timer.
+			err = fmt.Errorf("%w: no protocol progress for %s", ErrIdleTimeout, s.idleTimeout)
 		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
 			// Connection closed prematurely by modem (link failure) or
 			// remote peer.
@@ -237,11 +508,24 @@ func (s *Session) Exchange(conn net.Conn) (stats TrafficStats, err error) {
 		case errors.Is(err, net.ErrClosed):
 			// Closed locally, but still...
 			err = ErrConnLost
+		case IsLoginFailure(err):
+			// Reported by the remote, no need to echo it back.
+			var loginErr *LoginError
+			if !errors.As(err, &loginErr) {
+				err = &LoginError{err}
+			}
+		case errors.As(err, new(*RemoteError)):
+			// Reported by the remote, no need to echo it back.
 		default:
 			// Probably a protocol related error.
 			// Echo the error to the remote peer and disconnect.
+			echo := err
+			var pErr *ProtocolError
+			if errors.As(err, &pErr) {
+				echo = pErr.Err // The context is for local debugging only
+			}
 			conn.SetDeadline(time.Now().Add(time.Minute))
-			fmt.Fprintf(conn, "*** %s\r\n", err)
+			fmt.Fprintf(s.tx(conn), "*** %s\r\n", echo)
 		}
 	}()
 
@@ -253,20 +537,28 @@ func (s *Session) Exchange(conn net.Conn) (stats TrafficStats, err error) {
 		}
 	}
 
+	if s.idleTimeout > 0 {
+		s.idleTimer = time.AfterFunc(s.idleTimeout, func() {
+			s.idleExpired.Store(true)
+			conn.Close()
+		})
+		defer s.idleTimer.Stop()
+	}
+
 	// Set connection's robust-mode according to setting
-	if r, ok := conn.(transport.Robust); ok {
+	if r, ok := transport.AsRobust(conn); ok {
 		r.SetRobust(s.robustMode != RobustDisabled)
 		defer r.SetRobust(false)
 	}
 
-	s.rd = bufio.NewReader(conn)
+	s.rd = bufio.NewReader(io.TeeReader(conn, &s.rxTail))
 
 	err = s.handshake(conn)
 	if err != nil {
 		return
 	}
 
-	if gzipExperimentEnabled() && s.remoteSID.Has(sGzip) {
+	if s.localSIDCaps().Gzip && s.remoteSID.Has(sGzip) {
 		s.log.Println("GZIP_EXPERIMENT:", "Gzip compression enabled in this session.")
 	}
 
@@ -305,19 +597,15 @@ func waitRemoteHangup(conn net.Conn) error {
 	return scanner.Err()
 }
 
-func remoteErr(str string) error {
-	if !strings.HasPrefix(str, "***") {
-		return nil
-	}
-
-	idx := strings.LastIndex(str, "*")
-	if idx+1 >= len(str) {
-		return nil
-	}
-
-	return fmt.Errorf(strings.TrimSpace(str[idx+1:]))
+// RemoteError is an error reported by the remote peer, usually on a line prefixed with '*' or '***'.
+//
+// Use errors.As to distinguish errors originating from the server from local and link errors.
+type RemoteError struct {
+	Message string // The error message as reported by the remote, with the '*' prefix removed
 }
 
+func (e *RemoteError) Error() string { return e.Message }
+
 // Mycall returns this stations call sign.
 func (s *Session) Mycall() string { return s.mycall }
 
@@ -335,11 +623,58 @@ func (s *Session) SetSecureLoginHandleFunc(f func(addr Address) (password string
 // It will typically be the call sign of the remote P2P station and empty when the remote is a Winlink CMS.
 func (s *Session) RemoteForwarders() []Address { return s.remoteFW }
 
+// PendingMessages returns the messages the remote has advertised as waiting for us (;PM lines).
+//
+// A Winlink CMS advertises the pending messages before proposing them, so the list is available once the first
+// inbound proposal block is received (e.g. from a ProposalFilter or the mailbox handler's GetInboundAnswer). This
+// allows for informed decisions on what to download over slow links. Messages advertised more than once are only
+// listed once.
+func (s *Session) PendingMessages() []PendingMessage {
+	return append([]PendingMessage(nil), s.pendingMessages...)
+}
+
+// addPendingMessage parses and adds the pending message line (;PM) to the session's pending messages.
+func (s *Session) addPendingMessage(line string) {
+	pm, err := parsePM(line)
+	if err != nil {
+		s.log.Printf("Ignoring pending message line: %s", err)
+		return
+	}
+	for _, v := range s.pendingMessages {
+		if v.MID == pm.MID {
+			return
+		}
+	}
+	s.pendingMessages = append(s.pendingMessages, pm)
+}
+
 // AddAuxiliaryAddress adds one or more addresses to request messages on behalf of.
 //
 // Currently the Winlink System only support requesting messages for call signs, not full email addresses.
 func (s *Session) AddAuxiliaryAddress(aux ...Address) { s.localFW = append(s.localFW, aux...) }
 
+// SetForwardedAddresses replaces the auxiliary addresses to request messages on behalf of (in addition to mycall).
+//
+// This allows a station to forward traffic for several call signs (e.g. tactical or club call signs) in one
+// session. All addresses are sent on the combined ;FW: line. An error is returned if the given addresses
+// contain duplicates, zero addresses or the session's mycall.
+func (s *Session) SetForwardedAddresses(addrs []Address) error {
+	seen := make(map[Address]bool, len(addrs))
+	for _, addr := range addrs {
+		switch {
+		case addr.IsZero():
+			return errors.New("Empty forwarded address")
+		case addr == s.localFW[0]:
+			return fmt.Errorf("Forwarded address %s is mycall", addr)
+		case seen[addr]:
+			return fmt.Errorf("Duplicate forwarded address %s", addr)
+		}
+		seen[addr] = true
+	}
+	s.localFW = append(s.localFW[:1:1], addrs...)
+	return nil
+}
+
 // Set callback for status updates on receiving / sending messages
 func (s *Session) SetStatusUpdater(updater StatusUpdater) { s.statusUpdater = updater }
 
@@ -359,36 +694,185 @@ func (s *Session) SetUserAgent(ua UserAgent) { s.ua = ua }
 // Get this session's user agent
 func (s *Session) UserAgent() UserAgent { return s.ua }
 
+// SetSID sets the software name, version and optional capabilities advertised in this session's SID
+// (e.g. [wl2kgo-0.1a-B2FHM$]).
+//
+// The software name and version replaces the session's UserAgent. An error is returned if any of the
+// fields contain a dash (-), or if the capabilities are not supported by this implementation (e.g. Gzip
+// without GZIP_EXPERIMENT=1).
+func (s *Session) SetSID(software, version string, caps SIDCapabilities) error {
+	switch {
+	case software == "":
+		return errors.New("Empty SID software name")
+	case strings.Contains(software, "-") || strings.Contains(version, "-"):
+		return errors.New("SID software name and version must not contain a dash (-)")
+	}
+	if err := caps.validate(); err != nil {
+		return err
+	}
+
+	s.ua = UserAgent{Name: software, Version: version}
+	s.localSIDCapabilities = &caps
+	return nil
+}
+
+// localSIDCaps returns the capabilities advertised in this session's SID.
+func (s *Session) localSIDCaps() SIDCapabilities {
+	if s.localSIDCapabilities == nil {
+		return DefaultSIDCapabilities()
+	}
+	return *s.localSIDCapabilities
+}
+
 func (s *Session) outbound() []*Proposal {
-	if s.h == nil {
+	if s.h == nil || s.sniff || s.dryRun {
 		return []*Proposal{}
 	}
 
-	msgs := s.h.GetOutbound(s.remoteFW...)
-	props := make([]*Proposal, 0, len(msgs))
+	props, _ := prepareOutbound(s.h.GetOutbound(s.remoteFW...), s.outboundProposal, s.log)
+
+	if s.outboundOrder == nil {
+		sortProposals(props)
+		return props
+	}
+
+	// Sort by MID first, so that the order is deterministic for proposals considered equal by the custom order
+	sort.Slice(props, func(i, j int) bool { return props[i].MID() < props[j].MID() })
+	sort.SliceStable(props, func(i, j int) bool { return s.outboundOrder(props[i], props[j]) })
+	return props
+}
+
+// outboundProposal returns a lazily compressed proposal for the outbound message m.
+//
+// The message is compressed with each of the proposal codes negotiated with the remote, and the smallest
+// is proposed. When gzip is negotiated, incompressible content (e.g. jpg or zip attachments) is not inflated
+// on the wire, as gzip falls back to stored blocks. Short messages tend to be smaller with LZHUF. LZHUF is the
+// only compression available unless gzip is negotiated.
+//
+// The outcome is kept for the rest of the session, so that a message is compressed only once to size it
+// no matter how many times the outbound queue is consulted.
+func (s *Session) outboundProposal(m *Message) (*Proposal, error) {
+	codes := []PropCode{Wl2kProposal}
+	if s.highestPropCode() == GzipProposal {
+		codes = append(codes, GzipProposal)
+	}
 
+	key := fmt.Sprint(m.MID(), codes)
+	if p, ok := s.prepared[key]; ok {
+		return p.reset(), nil
+	}
+	p, err := outboundProposal(s.h, m, codes, s.compressionHook)
+	if err != nil {
+		return nil, err
+	}
+	if s.prepared == nil {
+		s.prepared = make(map[string]*Proposal)
+	}
+	s.prepared[key] = p.reset()
+	return p, nil
+}
+
+// prepareOutbound returns a proposal for each valid message in msgs, prepared by prepare.
+//
+// Invalid messages are skipped, except for problems that have never prevented a message from being
+// sent (see Message.validate). If logger is non-nil, messages that can't be prepared are logged and
+// skipped as well. Otherwise the first such error is returned.
+func prepareOutbound(msgs []*Message, prepare func(*Message) (*Proposal, error), logger *log.Logger) ([]*Proposal, error) {
+	props := make([]*Proposal, 0, len(msgs))
 	for _, m := range msgs {
-		// It seems reasonable to ignore these with a warning
-		if err := m.Validate(); err != nil {
-			s.log.Printf("Ignoring invalid outbound message '%s': %s", m.MID(), err)
+		// It seems reasonable to ignore these (with a warning, if logging)
+		errs, blocking := m.validate()
+		switch {
+		case len(blocking) > 0:
+			if logger != nil {
+				logger.Printf("Ignoring invalid outbound message '%s': %s", m.MID(), blocking)
+			}
 			continue
+		case len(errs) > 0 && logger != nil:
+			logger.Printf("Sending outbound message '%s' despite: %s", m.MID(), errs)
 		}
 
-		prop, err := m.Proposal(s.highestPropCode())
-		if err != nil {
-			s.log.Printf("Unable to prepare proposal for '%s'. Corrupt message? Ignoring...", m.MID())
+		prop, err := prepare(m)
+		switch {
+		case err != nil && logger != nil:
+			logger.Printf("Unable to prepare proposal for '%s'. Corrupt message? Ignoring...", m.MID())
 			continue
+		case err != nil:
+			return props, fmt.Errorf("Unable to prepare proposal for '%s': %w", m.MID(), err)
 		}
 
 		props = append(props, prop)
 	}
+	return props, nil
+}
 
-	sortProposals(props)
-	return props
+// outboundProposal returns a lazily compressed proposal for the outbound message m offered by h, using the
+// smallest of the given proposal codes.
+func outboundProposal(h OutboundHandler, m *Message, codes []PropCode, hook func(CompressionStats)) (*Proposal, error) {
+	open := func() (io.ReadCloser, error) {
+		data, err := m.Bytes()
+		return io.NopCloser(bytes.NewReader(data)), err
+	}
+	if o, ok := h.(OutboundOpener); ok {
+		mid := m.MID()
+		open = func() (io.ReadCloser, error) { return o.OpenOutbound(mid) }
+	}
+	cache, _ := h.(CompressedCache)
+	return newLazyProposal(m.MID(), m.Subject(), codes, open, cache, hook)
+}
+
+// OutboundSummary returns the number of outbound messages queued by h and their total compressed size
+// (bytes to transfer), without starting a session.
+//
+// This can be used to check the size of the outbound traffic before connecting over a slow link.
+// Invalid messages are not counted, as they are ignored by Session as well. An error is returned if
+// a message could not be read or compressed.
+func OutboundSummary(h OutboundHandler) (count int, totalCompressed int, err error) {
+	prepare := func(m *Message) (*Proposal, error) {
+		return outboundProposal(h, m, []PropCode{Wl2kProposal}, nil)
+	}
+	props, err := prepareOutbound(h.GetOutbound(), prepare, nil)
+	for _, q := range summarize(props) {
+		count++
+		totalCompressed += q.Size
+	}
+	return count, totalCompressed, err
+}
+
+// QueuedMessage holds information about an outbound message waiting to be sent.
+type QueuedMessage struct {
+	MID      string
+	Subject  string
+	Size     int // Size of the compressed message (bytes to transfer).
+	Priority Priority
+}
+
+// OutboundSummary returns the messages this session will propose to the remote, in the order they will be sent.
+//
+// The remote's forward addresses are not known until the handshake is done. Before that, the summary
+// includes every outbound message that can be delivered through a Winlink CMS.
+func (s *Session) OutboundSummary() []QueuedMessage {
+	return summarize(s.outbound())
+}
+
+// summarize returns the QueuedMessage of each proposal.
+func summarize(props []*Proposal) []QueuedMessage {
+	summary := make([]QueuedMessage, len(props))
+	for i, p := range props {
+		summary[i] = QueuedMessage{
+			MID:      p.MID(),
+			Subject:  p.Title(),
+			Size:     p.compressedSize,
+			Priority: p.Priority(),
+		}
+	}
+	return summary
 }
 
+// sortProposals sorts the proposals in the default outbound order: by priority, then by ascending
+// compressed size and finally by MID.
 func sortProposals(props []*Proposal) {
-	// sort first by ascending size, then stable sort by descending precedence
+	// sort first by ascending size (and MID), then stable sort by descending precedence
 	sort.Sort(bySize(props))
 	sort.Stable(byPrecedence(props))
 }
@@ -409,11 +893,11 @@ type byPrecedence []*Proposal
 func (s byPrecedence) Len() int      { return len(s) }
 func (s byPrecedence) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 func (s byPrecedence) Less(i, j int) bool {
-	return s[i].precedence() < s[j].precedence()
+	return s[i].Priority() < s[j].Priority()
 }
 
 func (s *Session) highestPropCode() PropCode {
-	if s.remoteSID.Has(sGzip) && gzipExperimentEnabled() {
+	if s.remoteSID.Has(sGzip) && s.localSIDCaps().Gzip {
 		return GzipProposal
 	}
 	return Wl2kProposal
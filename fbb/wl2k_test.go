@@ -6,11 +6,20 @@ package fbb
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
 )
 
 //[WL2K-2.8.4.8-B2FWIHJM$]
@@ -83,7 +92,135 @@ func TestFWAuxOnlyExperiment(t *testing.T) {
 	}
 }
 
+func TestSessionForwardedAddresses(t *testing.T) {
+	client, srv := net.Pipe()
+
+	s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+	for _, addrs := range [][]Address{
+		{AddressFromString("LE1OF"), AddressFromString("le1of")},
+		{AddressFromString("LE1OF"), AddressFromString("LA5NTA")},
+		{{}},
+	} {
+		if err := s.SetForwardedAddresses(addrs); err == nil {
+			t.Errorf("Expected error for %v", addrs)
+		}
+	}
+	if err := s.SetForwardedAddresses([]Address{AddressFromString("LE1OF"), AddressFromString("EMCOMM-1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	cerrs := make(chan error)
+	go func() {
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	rd := bufio.NewReader(srv)
+	if line, _ := rd.ReadString('\r'); line != ";FW: LA5NTA LE1OF EMCOMM-1\r" {
+		t.Errorf("Got '%s', expected ';FW: LA5NTA LE1OF EMCOMM-1'", strings.TrimSpace(line))
+	}
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+	fmt.Fprint(srv, "FQ\r")
+
+	if err := <-cerrs; err != nil {
+		t.Errorf("Session exchange returned error: %s", err)
+	}
+}
+
 func TestSessionCMS(t *testing.T) {
+	tests := map[string]struct {
+		setup     func(s *Session) error
+		expectSID string
+	}{
+		"default": {
+			setup:     func(s *Session) error { return nil },
+			expectSID: "[wl2kgo-0.1a-B2FHM$]\r",
+		},
+		"custom": {
+			setup:     func(s *Session) error { return s.SetSID("Pat", "0.9.0", SIDCapabilities{Identify: true}) },
+			expectSID: "[Pat-0.9.0-B2FHMI$]\r",
+		},
+	}
+	for name, test := range tests {
+		client, srv := net.Pipe()
+
+		cerrs := make(chan error)
+		go func() {
+			s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+			if err := test.setup(s); err != nil {
+				client.Close()
+				cerrs <- err
+				return
+			}
+			_, err := s.Exchange(client)
+			cerrs <- err
+		}()
+
+		fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+		fmt.Fprint(srv, "Foobar should be ignored\r")
+		fmt.Fprint(srv, "Test CMS >\r")
+
+		expectLines := []string{
+			";FW: LA5NTA\r",
+			test.expectSID,
+			"; LA1B-10 DE LA5NTA (JO39EQ)\r",
+			"FF\r",
+		}
+
+		// Read until FF
+		rd := bufio.NewReader(srv)
+		for i, expected := range expectLines {
+			line, _ := rd.ReadString('\r')
+			if line != expected {
+				line, expected = strings.TrimSpace(line), strings.TrimSpace(expected)
+				t.Fatalf("%s: Unexpected line [%d]: Got '%s', expected '%s'.", name, i, line, expected)
+			}
+		}
+
+		fmt.Fprint(srv, "FQ\r")
+		srv.Close()
+
+		if err := <-cerrs; err != nil {
+			t.Errorf("%s: Session exchange returned error: %s", name, err)
+		}
+	}
+}
+
+func TestSessionSetSID(t *testing.T) {
+	t.Setenv("GZIP_EXPERIMENT", "")
+
+	s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+	if err := s.SetSID("Pat-Winlink", "0.9.0", SIDCapabilities{}); err == nil {
+		t.Error("Expected error on software name with dash")
+	}
+	if err := s.SetSID("", "0.9.0", SIDCapabilities{}); err == nil {
+		t.Error("Expected error on empty software name")
+	}
+	if err := s.SetSID("Pat", "0.9.0", SIDCapabilities{Gzip: true}); err == nil {
+		t.Error("Expected error on gzip capability with GZIP_EXPERIMENT disabled")
+	}
+	if ua := s.UserAgent(); ua != StdUA {
+		t.Errorf("Got user agent %v after failed SetSID, expected %v", ua, StdUA)
+	}
+
+	t.Setenv("GZIP_EXPERIMENT", "1")
+	if err := s.SetSID("Pat", "0.9.0", SIDCapabilities{Gzip: true, Identify: true}); err != nil {
+		t.Fatal(err)
+	}
+	if ua := s.UserAgent(); ua != (UserAgent{"Pat", "0.9.0"}) {
+		t.Errorf("Got user agent %v, expected Pat 0.9.0", ua)
+	}
+	if got := s.localSIDCaps().features(); got != "B2FHMIG$" {
+		t.Errorf("Got features %q, expected B2FHMIG$", got)
+	}
+}
+
+func TestSessionCMDWithMessage(t *testing.T) {
 	client, srv := net.Pipe()
 
 	cerrs := make(chan error)
@@ -94,7 +231,6 @@ func TestSessionCMS(t *testing.T) {
 	}()
 
 	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
-	fmt.Fprint(srv, "Foobar should be ignored\r")
 	fmt.Fprint(srv, "Test CMS >\r")
 
 	expectLines := []string{
@@ -114,20 +250,33 @@ func TestSessionCMS(t *testing.T) {
 		}
 	}
 
-	fmt.Fprint(srv, "FQ\r")
-	srv.Close()
+	// Send one proposal
+	fmt.Fprintf(srv, "FC EM TJKYEIMMHSRB 527 123 0\r")
+	fmt.Fprintf(srv, "F> 3b\r") // No more proposals + checksum
+
+	propAnswer, _ := rd.ReadString('\r')
+	if propAnswer != "FS =\r" {
+		t.Errorf("Expected 'FS =', got '%s'", propAnswer)
+	}
+	fmt.Fprintf(srv, "FF\r") // No more messages
+
+	if line, _ := rd.ReadString('\r'); line != "FQ\r" {
+		t.Errorf("Expected 'FQ', got '%s'", line)
+	}
 
 	if err := <-cerrs; err != nil {
 		t.Errorf("Session exchange returned error: %s", err)
 	}
 }
 
-func TestSessionCMDWithMessage(t *testing.T) {
+func TestSessionKeepOpen(t *testing.T) {
 	client, srv := net.Pipe()
 
+	s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+	s.SetKeepOpen(true)
+
 	cerrs := make(chan error)
 	go func() {
-		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
 		_, err := s.Exchange(client)
 		cerrs <- err
 	}()
@@ -152,31 +301,88 @@ func TestSessionCMDWithMessage(t *testing.T) {
 		}
 	}
 
-	// Send one proposal
-	fmt.Fprintf(srv, "FC EM TJKYEIMMHSRB 527 123 0\r")
-	fmt.Fprintf(srv, "F> 3b\r") // No more proposals + checksum
-
-	propAnswer, _ := rd.ReadString('\r')
-	if propAnswer != "FS =\r" {
-		t.Errorf("Expected 'FS =', got '%s'", propAnswer)
+	// Neither party has messages, but the session should be held open
+	fmt.Fprint(srv, "FF\r")
+	if line, _ := rd.ReadString('\r'); line != "FF\r" {
+		t.Errorf("Expected 'FF', got '%s'", line)
 	}
-	fmt.Fprintf(srv, "FF\r") // No more messages
 
+	// Until we quit
+	s.Quit()
+	fmt.Fprint(srv, "FF\r")
 	if line, _ := rd.ReadString('\r'); line != "FQ\r" {
 		t.Errorf("Expected 'FQ', got '%s'", line)
 	}
+	srv.Close()
 
 	if err := <-cerrs; err != nil {
 		t.Errorf("Session exchange returned error: %s", err)
 	}
 }
 
+func TestSessionKeepOpenPolicy(t *testing.T) {
+	s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+	s.SetKeepOpen(true)
+	if got := s.turnover(); got != TurnoverAlwaysYield {
+		t.Errorf("Got policy %d with keep-open, expected TurnoverAlwaysYield", got)
+	}
+	s.Quit()
+	if got := s.turnover(); got != TurnoverDefault {
+		t.Errorf("Got policy %d after Quit, expected TurnoverDefault", got)
+	}
+
+	// Quit leaves other policies alone
+	s.SetTurnoverPolicy(TurnoverQuitWhenDone)
+	s.Quit()
+	if got := s.turnover(); got != TurnoverQuitWhenDone {
+		t.Errorf("Got policy %d after Quit, expected TurnoverQuitWhenDone", got)
+	}
+}
+
+func TestSessionRemoteSID(t *testing.T) {
+	client, srv := net.Pipe()
+
+	s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+	var callbackSID SID
+	s.SetRemoteSIDHandleFunc(func(sid SID) { callbackSID = sid })
+
+	cerrs := make(chan error)
+	go func() {
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+	fmt.Fprint(srv, "FQ\r")
+	if err := <-cerrs; err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expect := SID{Software: "WL2K", Version: "2.8.4.8", Features: "B2FWIHJM$"}
+	if got := s.RemoteSIDInfo(); got != expect {
+		t.Errorf("Got %#v, expected %#v", got, expect)
+	}
+	if callbackSID != expect {
+		t.Errorf("Callback got %#v, expected %#v", callbackSID, expect)
+	}
+	if s.RemoteSID() != "B2FWIHJM$" {
+		t.Errorf("Got RemoteSID %s, expected B2FWIHJM$", s.RemoteSID())
+	}
+}
+
 func TestSessionCMSv4(t *testing.T) {
 	client, srv := net.Pipe()
 
+	s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
 	cerrs := make(chan error)
 	go func() {
-		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
 		_, err := s.Exchange(client)
 		cerrs <- err
 	}()
@@ -203,6 +409,7 @@ func TestSessionCMSv4(t *testing.T) {
 
 	// Send some CMS v4 ; lines
 	fmt.Fprintf(srv, ";PM: LA5NTA TJKYEIMMHSRB 123 martin.h.pedersen@gmail.com\r")
+	fmt.Fprintf(srv, ";PM: LA5NTA 2HD2B6DJ3PUT 4567 N0CALL Weather report\r")
 	fmt.Fprintf(srv, ";WARNING: Foo bar baz\r")
 
 	// Send one proposal
@@ -224,52 +431,1709 @@ func TestSessionCMSv4(t *testing.T) {
 	if err := <-cerrs; err != nil {
 		t.Errorf("Session exchange returned error: %s", err)
 	}
+
+	expectPending := []PendingMessage{
+		{To: Address{Addr: "LA5NTA"}, MID: "TJKYEIMMHSRB", Size: 123, From: Address{Proto: "SMTP", Addr: "martin.h.pedersen@gmail.com"}},
+		{To: Address{Addr: "LA5NTA"}, MID: "2HD2B6DJ3PUT", Size: 4567, From: Address{Addr: "N0CALL"}, Subject: "Weather report"},
+	}
+	if got := s.PendingMessages(); !reflect.DeepEqual(got, expectPending) {
+		t.Errorf("Got pending messages %+v, expected %+v", got, expectPending)
+	}
 }
 
-func TestSortProposals(t *testing.T) {
-	props := []*Proposal{
-		mustProposalWithSubject("Just a test"),
-		mustProposalWithSubject("Re://WL2K O/Very important"),
-		mustProposalWithSubject("//WL2K R/Read this sometime, or don't"),
-		mustProposalWithSubject("//WL2K P/ Pretty important"),
-		mustProposalWithSubject("//WL2K Z/The world is on fire!"),
+func TestProposalFilter(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+
+	// Reject messages larger than 10 KB
+	h := &filterHandler{filter: func(p Proposal) ProposalAnswer {
+		if p.Size() > 10*1024 {
+			return Reject
+		}
+		return Accept
+	}}
+
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", h)
+		s.Exchange(client)
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
 	}
 
-	sortProposals(props)
+	fmt.Fprint(srv, proposalBlock(
+		"FC EM BIGMSG123456 20480 15000 0",
+		"FC EM SMALLMSG1234 527 123 0",
+	))
 
-	// Flash
-	if props[0].Title() != "//WL2K Z/The world is on fire!" {
-		t.Error("Flash precedence was not in order")
+	if line, _ := rd.ReadString('\r'); line != "FS -+\r" {
+		t.Errorf("Expected 'FS -+', got '%s'", line)
 	}
-	// Immediate
-	if props[1].Title() != "Re://WL2K O/Very important" {
-		t.Error("Immediate precedence was not in order")
+}
+
+func TestSessionAcceptPredicate(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", &testHandler{})
+		// Reject messages with MID prefix RELAY, leave the rest to the handler
+		s.SetAcceptPredicate(func(p Proposal) ProposalAnswer {
+			if strings.HasPrefix(p.MID(), "RELAY") {
+				return Reject
+			}
+			return NoOpinion
+		})
+		s.Exchange(client)
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
 	}
-	// Priority
-	if props[2].Title() != "//WL2K P/ Pretty important" {
-		t.Error("Priority precedence was not in order")
+
+	fmt.Fprint(srv, proposalBlock(
+		"FC EM RELAY1234567 527 123 0",
+		"FC EM SMALLMSG1234 527 123 0",
+		"FC EM RELAY7654321 527 123 0",
+	))
+
+	if line, _ := rd.ReadString('\r'); line != "FS -+-\r" {
+		t.Errorf("Expected 'FS -+-', got '%s'", line)
 	}
-	// Everything else is Routine, so goes by increasing size
-	if props[3].Title() != "Just a test" {
-		t.Error("Routine precedence was not in order")
+}
+
+func TestSessionHeldMIDs(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+
+	consulted := make(chan string, 10)
+	h := &filterHandler{filter: func(p Proposal) ProposalAnswer {
+		consulted <- p.MID()
+		return Accept
+	}}
+
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", h)
+		s.SetHeldMIDs([]string{"HELDMSG12345"})
+		s.Exchange(client)
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
 	}
-	if props[4].Title() != "//WL2K R/Read this sometime, or don't" {
-		t.Error("Routine precedence was not in order")
+
+	fmt.Fprint(srv, proposalBlock(
+		"FC EM HELDMSG12345 527 123 0",
+		"FC EM SMALLMSG1234 527 123 0",
+	))
+
+	if line, _ := rd.ReadString('\r'); line != "FS -+\r" {
+		t.Errorf("Expected 'FS -+', got '%s'", line)
+	}
+	close(consulted)
+	for mid := range consulted {
+		if mid == "HELDMSG12345" {
+			t.Errorf("Handler consulted for held MID %s", mid)
+		}
 	}
 }
 
-func mustProposalWithSubject(subject string) *Proposal {
-	p, err := proposalWithSubject(subject)
-	if err != nil {
-		panic(err)
+func TestSessionSniff(t *testing.T) {
+	client, srv := net.Pipe()
+
+	msg := NewMessage(Private, "N0CALL")
+	msg.AddTo("LA5NTA")
+	msg.SetSubject("Should not be sent")
+	msg.SetBody("Test")
+	h := &testHandler{outbound: []*Message{msg}}
+
+	s := NewSession("N0CALL", "LA1B-10", "JO39EQ", h)
+	s.SetSniff(true)
+	type result struct {
+		stats TrafficStats
+		err   error
+	}
+	results := make(chan result)
+	go func() {
+		stats, err := s.Exchange(client)
+		results <- result{stats, err}
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until end of the session's first turn (should be FF, as nothing is proposed)
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+		if strings.HasPrefix(line, "FC") {
+			t.Errorf("Unexpected outbound proposal: %q", line)
+		}
+	}
+
+	fmt.Fprint(srv, proposalBlock(
+		"FC EM BIGMSG123456 20480 15000 0",
+		"FC EM SMALLMSG1234 527 123 0",
+	))
+	if line, _ := rd.ReadString('\r'); line != "FS --\r" {
+		t.Errorf("Expected 'FS --', got '%s'", line)
+	}
+
+	// All rejected, so the remote still holds the turn
+	fmt.Fprint(srv, "FQ\r")
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("Unexpected error: %s", res.err)
+	}
+	if len(res.stats.Received) > 0 || len(res.stats.Sent) > 0 {
+		t.Errorf("Unexpected traffic: %+v", res.stats)
+	}
+	if len(h.inbound) > 0 || len(h.sent) > 0 || len(h.deferred) > 0 {
+		t.Errorf("Handler was used in sniff mode: %+v", h)
+	}
+
+	sniffed := s.SniffedProposals()
+	if len(sniffed) != 2 {
+		t.Fatalf("Got %d sniffed proposals, expected 2", len(sniffed))
+	}
+	for i, mid := range []string{"BIGMSG123456", "SMALLMSG1234"} {
+		if sniffed[i].MID() != mid {
+			t.Errorf("Got sniffed proposal %s, expected %s", sniffed[i].MID(), mid)
+		}
+	}
+	if sniffed[0].Size() != 20480 || sniffed[0].CompressedSize() != 15000 {
+		t.Errorf("Unexpected sizes of %s: %d/%d", sniffed[0].MID(), sniffed[0].Size(), sniffed[0].CompressedSize())
 	}
-	return p
 }
 
-func proposalWithSubject(subject string) (*Proposal, error) {
-	msg := NewMessage(Private, "N0CALL")
-	msg.AddTo("N0CALL")
-	msg.SetSubject(subject)
-	_ = msg.SetBody("Satisfies validation")
-	return msg.Proposal(BasicProposal)
+// proposalBlock returns the given proposal lines terminated by the F> line with checksum.
+func proposalBlock(lines ...string) string {
+	var block string
+	var sum int64
+	for _, line := range lines {
+		block += line + "\r"
+		for _, c := range line + "\r" {
+			sum += int64(c)
+		}
+	}
+	return block + fmt.Sprintf("F> %02X\r", (-sum)&0xff)
+}
+
+func TestSessionMalformedProposalChecksum(t *testing.T) {
+	tests := map[string]string{
+		"F> ZZ":  "Malformed proposal block checksum",
+		"F>":     "Malformed proposal block checksum",
+		"F> 100": "Malformed proposal block checksum",
+		"F> 00":  "Checksum error",
+	}
+	for prompt, expect := range tests {
+		client, srv := net.Pipe()
+
+		cerrs := make(chan error)
+		go func() {
+			s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", &testHandler{})
+			_, err := s.Exchange(client)
+			cerrs <- err
+		}()
+
+		fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+		fmt.Fprint(srv, "Test CMS >\r")
+
+		// Read until FF
+		rd := bufio.NewReader(srv)
+		for line := ""; line != "FF\r"; {
+			line, _ = rd.ReadString('\r')
+		}
+
+		go io.Copy(io.Discard, rd)
+		fmt.Fprint(srv, "FC EM TJKYEIMMHSRB 527 123 0\r")
+		fmt.Fprint(srv, prompt+"\r")
+
+		err := <-cerrs
+		var pErr *ProtocolError
+		if !errors.As(err, &pErr) {
+			t.Errorf("%s: Got %v, expected *ProtocolError", prompt, err)
+		} else if !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: Got unexpected error '%s', expected '%s'", prompt, err, expect)
+		}
+		srv.Close()
+	}
+}
+
+func TestSessionUnknownProposalCode(t *testing.T) {
+	for _, strict := range []bool{true, false} {
+		client, srv := net.Pipe()
+
+		cerrs := make(chan error)
+		go func() {
+			s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", &testHandler{})
+			s.SetStrictProtocol(strict)
+			_, err := s.Exchange(client)
+			cerrs <- err
+		}()
+
+		fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+		fmt.Fprint(srv, "Test CMS >\r")
+
+		// Read until FF
+		rd := bufio.NewReader(srv)
+		for line := ""; line != "FF\r"; {
+			line, _ = rd.ReadString('\r')
+		}
+
+		if strict {
+			go io.Copy(io.Discard, rd)
+			fmt.Fprint(srv, "FX EM TJKYEIMMHSRB 527 123 0\r")
+			var pErr *ProtocolError
+			if err := <-cerrs; !errors.As(err, &pErr) {
+				t.Errorf("Strict: Got %v, expected *ProtocolError", err)
+			}
+			srv.Close()
+			continue
+		}
+
+		fmt.Fprint(srv, "FZ\r") // Not a proposal, ignored
+		fmt.Fprint(srv, proposalBlock("FX EM TJKYEIMMHSRB 527 123 0"))
+		if line, _ := rd.ReadString('\r'); line != "FS =\r" {
+			t.Errorf("Lenient: Got '%s', expected 'FS ='", line)
+		}
+		fmt.Fprint(srv, "FQ\r")
+		if err := <-cerrs; err != nil {
+			t.Errorf("Lenient: Got error %v, expected session to continue", err)
+		}
+		srv.Close()
+	}
+}
+
+func TestSessionPlainASCIIProposal(t *testing.T) {
+	client, srv := net.Pipe()
+
+	h := &testHandler{}
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("N0CALL", "LA1B", "JO39EQ", h)
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test BBS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+
+	fmt.Fprint(srv, proposalBlock("FA P LA5NTA LA1B N0CALL 12345_LA5NTA 27"))
+	if line, _ := rd.ReadString('\r'); line != "FS +\r" {
+		t.Fatalf("Expected 'FS +', got '%s'", line)
+	}
+
+	fmt.Fprint(srv, "Hello\rLine one\rLine two\r\x1a\r")
+	if line, _ := rd.ReadString('\r'); line != "FF\r" {
+		t.Errorf("Expected 'FF', got '%s'", line)
+	}
+	fmt.Fprint(srv, "FQ\r")
+
+	if err := <-cerrs; err != nil {
+		t.Fatalf("Session exchange returned error: %s", err)
+	}
+	if len(h.inbound) != 1 {
+		t.Fatalf("Got %d inbound messages, expected 1", len(h.inbound))
+	}
+
+	msg := h.inbound[0]
+	body, _ := msg.Body()
+	switch {
+	case msg.MID() != "12345_LA5NTA":
+		t.Errorf("Unexpected MID: '%s'", msg.MID())
+	case msg.Subject() != "Hello":
+		t.Errorf("Unexpected subject: '%s'", msg.Subject())
+	case msg.From().String() != "LA5NTA":
+		t.Errorf("Unexpected sender: '%s'", msg.From())
+	case len(msg.To()) != 1 || msg.To()[0].String() != "N0CALL":
+		t.Errorf("Unexpected receivers: %v", msg.To())
+	case body != "Line one\r\nLine two\r\n":
+		t.Errorf("Unexpected body: %q", body)
+	}
+}
+
+func TestSessionPlainASCIIProposalTooLarge(t *testing.T) {
+	client, srv := net.Pipe()
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("N0CALL", "LA1B", "JO39EQ", &testHandler{})
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test BBS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+
+	fmt.Fprint(srv, proposalBlock("FA P LA5NTA LA1B N0CALL 12345_LA5NTA 27"))
+	if line, _ := rd.ReadString('\r'); line != "FS +\r" {
+		t.Fatalf("Expected 'FS +', got '%s'", line)
+	}
+
+	// Never terminated by Ctrl-Z
+	go io.Copy(io.Discard, rd)
+	go fmt.Fprint(srv, "Hello\r"+strings.Repeat("Line\r", 1000))
+
+	err := <-cerrs
+	var pErr *ProtocolError
+	if !errors.As(err, &pErr) {
+		t.Errorf("Got %v, expected *ProtocolError", err)
+	}
+	srv.Close()
+}
+
+func TestSessionDuplicateProposal(t *testing.T) {
+	client, srv := net.Pipe()
+
+	h := &testHandler{}
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("N0CALL", "LA1B", "JO39EQ", h)
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test BBS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+
+	fmt.Fprint(srv, proposalBlock(
+		"FA P LA5NTA LA1B N0CALL 12345_LA5NTA 27",
+		"FA P LA5NTA LA1B N0CALL 12345_LA5NTA 27",
+		"FA P LA5NTA LA1B N0CALL 54321_LA5NTA 27",
+	))
+	if line, _ := rd.ReadString('\r'); line != "FS +=+\r" {
+		t.Fatalf("Expected 'FS +=+', got '%s'", line)
+	}
+
+	fmt.Fprint(srv, "Hello\rLine one\rLine two\r\x1a\r")
+	fmt.Fprint(srv, "Hello\rLine one\rLine two\r\x1a\r")
+	if line, _ := rd.ReadString('\r'); line != "FF\r" {
+		t.Errorf("Expected 'FF', got '%s'", line)
+	}
+	fmt.Fprint(srv, "FQ\r")
+
+	if err := <-cerrs; err != nil {
+		t.Fatalf("Session exchange returned error: %s", err)
+	}
+	if len(h.inbound) != 2 {
+		t.Fatalf("Got %d inbound messages, expected 2", len(h.inbound))
+	}
+	if msg := h.inbound[0]; !msg.DuplicateProposal() {
+		t.Errorf("Expected %s to be marked as duplicate proposal", msg.MID())
+	}
+	if msg := h.inbound[1]; msg.DuplicateProposal() {
+		t.Errorf("Expected %s not to be marked as duplicate proposal", msg.MID())
+	}
+}
+
+type statusRecorder chan Status
+
+func (r statusRecorder) UpdateStatus(s Status) {
+	if s.Done {
+		r <- s
+	}
+}
+
+func TestSessionStatusProgress(t *testing.T) {
+	client, srv := net.Pipe()
+
+	var props []*Proposal
+	for _, subject := range []string{"First", "Second"} {
+		msg := NewMessage(Private, "LA5NTA")
+		msg.AddTo("N0CALL")
+		msg.SetSubject(subject)
+		msg.SetBody(strings.Repeat(subject, 100))
+		prop, err := msg.Proposal(Wl2kProposal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		props = append(props, prop)
+	}
+
+	h := &testHandler{}
+	statuses := make(statusRecorder, 2)
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("N0CALL", "LA1B", "JO39EQ", h)
+		s.SetStatusUpdater(statuses)
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test BBS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+
+	var lines []string
+	for _, p := range props {
+		lines = append(lines, fmt.Sprintf("FC EM %s %d %d 0", p.MID(), p.size, p.compressedSize))
+	}
+	fmt.Fprint(srv, proposalBlock(lines...))
+	if line, _ := rd.ReadString('\r'); line != "FS ++\r" {
+		t.Fatalf("Expected 'FS ++', got '%s'", line)
+	}
+
+	sender := NewSession("LA5NTA", "N0CALL", "JO39EQ", nil)
+	for _, p := range props {
+		if err := sender.writeCompressed(srv, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if line, _ := rd.ReadString('\r'); line != "FF\r" {
+		t.Errorf("Expected 'FF', got '%s'", line)
+	}
+	fmt.Fprint(srv, "FQ\r")
+
+	if err := <-cerrs; err != nil {
+		t.Fatalf("Session exchange returned error: %s", err)
+	}
+
+	total := props[0].compressedSize + props[1].compressedSize
+	for i, p := range props {
+		var st Status
+		select {
+		case st = <-statuses:
+		case <-time.After(time.Second):
+			t.Fatalf("Missing status for message %d", i+1)
+		}
+		transferred := props[0].compressedSize
+		if i == 1 {
+			transferred = total
+		}
+		switch {
+		case st.Receiving == nil || st.Receiving.MID() != p.MID():
+			t.Errorf("Got status for %v, expected %s", st.Receiving, p.MID())
+		case st.MessageIndex != i+1 || st.MessageCount != 2:
+			t.Errorf("Got message %d of %d, expected %d of 2", st.MessageIndex, st.MessageCount, i+1)
+		case st.SessionBytesTransferred != transferred || st.SessionBytesTotal != total:
+			t.Errorf("Got session bytes %d/%d, expected %d/%d", st.SessionBytesTransferred, st.SessionBytesTotal, transferred, total)
+		}
+	}
+}
+
+// statusLog records all status updates, and signals done on the final one.
+type statusLog struct {
+	mu       sync.Mutex
+	statuses []Status
+	done     chan struct{}
+}
+
+func (l *statusLog) UpdateStatus(s Status) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.statuses = append(l.statuses, s)
+	if s.Done {
+		close(l.done)
+	}
+}
+
+func TestSessionStatusAttachmentProgress(t *testing.T) {
+	client, srv := net.Pipe()
+
+	msg := NewMessage(Private, "LA5NTA")
+	msg.AddTo("N0CALL")
+	msg.SetSubject("Attachments")
+	msg.SetBody("See attached")
+	msg.SetDate(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	rnd := rand.New(rand.NewSource(1))
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		data := make([]byte, 4096)
+		rnd.Read(data)
+		msg.AddFile(NewFile(name, data))
+	}
+	prop, err := msg.Proposal(Wl2kProposal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &testHandler{}
+	statuses := &statusLog{done: make(chan struct{})}
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("N0CALL", "LA1B", "JO39EQ", h)
+		s.SetStatusUpdater(statuses)
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test BBS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+
+	fmt.Fprint(srv, proposalBlock(fmt.Sprintf("FC EM %s %d %d 0", prop.MID(), prop.size, prop.compressedSize)))
+	if line, _ := rd.ReadString('\r'); line != "FS +\r" {
+		t.Fatalf("Expected 'FS +', got '%s'", line)
+	}
+	sender := NewSession("LA5NTA", "N0CALL", "JO39EQ", nil)
+	if err := sender.writeCompressed(srv, prop); err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := rd.ReadString('\r'); line != "FF\r" {
+		t.Errorf("Expected 'FF', got '%s'", line)
+	}
+	fmt.Fprint(srv, "FQ\r")
+
+	if err := <-cerrs; err != nil {
+		t.Fatalf("Session exchange returned error: %s", err)
+	}
+
+	select {
+	case <-statuses.done:
+	case <-time.After(time.Second):
+		t.Fatal("Missing final status")
+	}
+	statuses.mu.Lock()
+	defer statuses.mu.Unlock()
+	var last Status
+	seen := map[int]bool{}
+	for _, st := range statuses.statuses {
+		if st.Receiving == nil {
+			continue
+		}
+		if st.FileIndex < last.FileIndex {
+			t.Fatalf("Got file index %d after %d, expected it to advance", st.FileIndex, last.FileIndex)
+		}
+		if st.FileIndex > 0 && st.FileName != msg.Files()[st.FileIndex-1].Name() {
+			t.Errorf("Got file name %q for file %d", st.FileName, st.FileIndex)
+		}
+		seen[st.FileIndex] = true
+		last = st
+	}
+	switch {
+	case last.FileCount != 3 || last.FileIndex != 3:
+		t.Errorf("Got file %d of %d, expected 3 of 3", last.FileIndex, last.FileCount)
+	case last.FileBytesTransferred != 4096 || last.FileSize != 4096:
+		t.Errorf("Got file bytes %d/%d, expected 4096/4096", last.FileBytesTransferred, last.FileSize)
+	case len(seen) < 2:
+		t.Errorf("Got file indices %v, expected the file index to advance", seen)
+	}
+}
+
+func TestSessionLoginFailure(t *testing.T) {
+	client, srv := net.Pipe()
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+		s.SetSecureLoginHandleFunc(func(addr Address) (string, error) { return "wrong", nil })
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-4.0-B2FWIHJM$]\r")
+	fmt.Fprint(srv, ";PQ: 23753528\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+	fmt.Fprint(srv, "*** [1] Secure login failed - account password does not match. - Disconnecting (88.90.2.192)\r")
+
+	// The session should hang up without echoing the error
+	go io.Copy(io.Discard, rd)
+
+	err := <-cerrs
+	var loginErr *LoginError
+	if !errors.As(err, &loginErr) {
+		t.Fatalf("Got %#v, expected *LoginError", err)
+	}
+	if !IsLoginFailure(err) {
+		t.Error("IsLoginFailure returned false for *LoginError")
+	}
+	if expect := "[1] Secure login failed - account password does not match. - Disconnecting (88.90.2.192)"; err.Error() != expect {
+		t.Errorf("Got error '%s', expected '%s'", err, expect)
+	}
+}
+
+func TestSessionRemoteErrorMidStream(t *testing.T) {
+	client, srv := net.Pipe()
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", &testHandler{})
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+
+	fmt.Fprint(srv, proposalBlock("FC EM TJKYEIMMHSRB 527 123 0"))
+	if line, _ := rd.ReadString('\r'); line != "FS +\r" {
+		t.Fatalf("Expected 'FS +', got '%s'", line)
+	}
+
+	// Report an error instead of sending the accepted message
+	fmt.Fprint(srv, "*** Message store unavailable\r")
+
+	// The session should hang up without echoing the error
+	go io.Copy(io.Discard, rd)
+
+	err := <-cerrs
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) {
+		t.Fatalf("Got %#v, expected *RemoteError", err)
+	}
+	if remoteErr.Message != "Message store unavailable" {
+		t.Errorf("Got message '%s', expected 'Message store unavailable'", remoteErr.Message)
+	}
+}
+
+func TestSessionRemoteErrorTurnover(t *testing.T) {
+	client, srv := net.Pipe()
+
+	msg := NewMessage(Private, "LA5NTA")
+	msg.AddTo("N0CALL")
+	msg.SetSubject("Test")
+	_ = msg.SetBody("Test")
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", &testHandler{outbound: []*Message{msg}})
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until the end of the proposal block
+	rd := bufio.NewReader(srv)
+	for line := ""; !strings.HasPrefix(line, "F>"); {
+		line, _ = rd.ReadString('\r')
+	}
+	fmt.Fprint(srv, "FS +\r")
+
+	// Reject the block at turnover
+	go io.Copy(io.Discard, rd)
+	fmt.Fprint(srv, "*** Message rejected by CMS\r")
+
+	err := <-cerrs
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) {
+		t.Fatalf("Got %#v, expected *RemoteError", err)
+	}
+	if remoteErr.Message != "Message rejected by CMS" {
+		t.Errorf("Got message '%s', expected 'Message rejected by CMS'", remoteErr.Message)
+	}
+	if IsLoginFailure(err) {
+		t.Error("IsLoginFailure returned true for a non-login error")
+	}
+}
+
+func TestSessionQuitWithPendingInbound(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", &testHandler{})
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+
+	fmt.Fprint(srv, proposalBlock("FC EM TJKYEIMMHSRB 527 123 0"))
+	if line, _ := rd.ReadString('\r'); line != "FS +\r" {
+		t.Fatalf("Expected 'FS +', got '%s'", line)
+	}
+
+	// Quit without delivering the accepted message
+	fmt.Fprint(srv, "FQ\r")
+	if line, _ := rd.ReadString('\n'); !strings.HasPrefix(line, "*** Got quit command") {
+		t.Errorf("Expected error to be echoed to remote, got '%s'", line)
+	}
+
+	err := <-cerrs
+	if err == nil || !strings.Contains(err.Error(), "1 accepted inbound proposal(s) were pending") {
+		t.Errorf("Expected pending proposals error, got %v", err)
+	}
+}
+
+func TestSessionProtocolErrorContext(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+
+	// Respond with garbage
+	fmt.Fprint(srv, "Unexpected garbage\rFollowed by more\r")
+
+	// The context should not be echoed to the remote
+	if line, _ := rd.ReadString('\n'); line != "*** Unexpected response: 'Unexpected garbage'\r\n" {
+		t.Errorf("Unexpected error echoed to remote: %q", line)
+	}
+
+	err := <-cerrs
+	var pErr *ProtocolError
+	if !errors.As(err, &pErr) {
+		t.Fatalf("Expected ProtocolError, got %v", err)
+	}
+	if !strings.HasSuffix(string(pErr.Context), "Unexpected garbage\rFollowed by more\r") {
+		t.Errorf("Unexpected context: %q", pErr.Context)
+	}
+	if !strings.Contains(err.Error(), `Followed by more`) {
+		t.Errorf("Error message does not include context: %s", err)
+	}
+}
+
+func TestSessionDryRun(t *testing.T) {
+	newMsg := func(from, to string) *Message {
+		msg := NewMessage(Private, from)
+		msg.AddTo(to)
+		msg.SetSubject("Pending")
+		_ = msg.SetBody("Should not be transferred")
+		return msg
+	}
+	peer := &streamHandler{opened: make(map[string]int)}
+	peer.outbound = []*Message{newMsg("N0CALL", "LA5NTA"), newMsg("N0CALL", "LA5NTA")}
+	local := &testHandler{outbound: []*Message{newMsg("LA5NTA", "N0CALL")}}
+
+	client, master := net.Pipe()
+	errs := make(chan error, 2)
+	var stats TrafficStats
+	go func() {
+		s := NewSession("LA5NTA", "N0CALL", "JO39EQ", local)
+		s.SetDryRun(true)
+		var err error
+		stats, err = s.Exchange(client)
+		errs <- err
+	}()
+	go func() {
+		s := NewSession("N0CALL", "LA5NTA", "JO39EQ", peer)
+		s.IsMaster(true)
+		_, err := s.Exchange(master)
+		errs <- err
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Session exchange returned error: %s", err)
+		}
+	}
+
+	switch {
+	case len(stats.Received) != 0 || len(stats.Sent) != 0:
+		t.Errorf("Got traffic %+v, expected none", stats)
+	case len(local.inbound) != 0 || len(local.sent) != 0 || len(local.deferred) != 0:
+		t.Errorf("Local handler was used: %+v", local)
+	case len(peer.inbound) != 0 || len(peer.sent) != 0:
+		t.Errorf("Got messages transferred to/from peer: %+v", peer)
+	case len(peer.deferred) != 2:
+		t.Errorf("Got %d deferred by peer, expected 2", len(peer.deferred))
+	}
+}
+
+func TestSessionInboundRewriter(t *testing.T) {
+	errRewrite := errors.New("rewrite failed")
+	tests := map[string]func(*Message) error{
+		"add header": func(m *Message) error { m.Header.Set("X-Received-Via", "N0CALL"); return nil },
+		"error":      func(m *Message) error { return errRewrite },
+	}
+	for name, rewriter := range tests {
+		msg := NewMessage(Private, "LA5NTA")
+		msg.AddTo("N0CALL")
+		msg.SetSubject("Relayed")
+		_ = msg.SetBody("Test")
+		sender := &streamHandler{opened: make(map[string]int), testHandler: testHandler{outbound: []*Message{msg}}}
+		receiver := &testHandler{}
+
+		client, master := net.Pipe()
+		errs := make(chan error, 1)
+		go func() {
+			_, err := NewSession("LA5NTA", "N0CALL", "JO39EQ", sender).Exchange(client)
+			client.Close()
+			errs <- err
+		}()
+		s := NewSession("N0CALL", "LA5NTA", "JO39EQ", receiver)
+		s.IsMaster(true)
+		s.SetInboundRewriter(rewriter)
+		_, err := s.Exchange(master)
+		master.Close()
+		<-errs
+
+		switch name {
+		case "add header":
+			if err != nil {
+				t.Fatalf("%s: Session exchange returned error: %s", name, err)
+			}
+			if len(receiver.inbound) != 1 {
+				t.Fatalf("%s: Got %d messages, expected 1", name, len(receiver.inbound))
+			}
+			if got := receiver.inbound[0].Header.Get("X-Received-Via"); got != "N0CALL" {
+				t.Errorf("%s: Got X-Received-Via '%s', expected 'N0CALL'", name, got)
+			}
+		case "error":
+			if !errors.Is(err, errRewrite) || !strings.Contains(err.Error(), msg.MID()) {
+				t.Errorf("%s: Got %v, expected rewrite error with MID", name, err)
+			}
+			if len(receiver.inbound) != 0 {
+				t.Errorf("%s: Got %d messages passed to the handler, expected none", name, len(receiver.inbound))
+			}
+		}
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+
+	cerrs := make(chan error)
+	go func() {
+		s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+		s.SetIdleTimeout(100 * time.Millisecond)
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	// Read until FF
+	rd := bufio.NewReader(srv)
+	for line := ""; line != "FF\r"; {
+		line, _ = rd.ReadString('\r')
+	}
+
+	// Stall in the middle of a proposal block
+	fmt.Fprint(srv, "FC EM TJKYEIMMHSRB 527 123 0\r")
+
+	select {
+	case err := <-cerrs:
+		if !errors.Is(err, ErrIdleTimeout) {
+			t.Errorf("Got %v, expected ErrIdleTimeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Exchange did not return on idle timeout")
+	}
+}
+
+func TestSessionTurnoverMidProposalBlock(t *testing.T) {
+	for _, cmd := range []string{"FF", "FQ"} {
+		client, srv := net.Pipe()
+
+		cerrs := make(chan error)
+		go func() {
+			s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", nil)
+			_, err := s.Exchange(client)
+			cerrs <- err
+		}()
+
+		fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+		fmt.Fprint(srv, "Test CMS >\r")
+
+		// Read until FF
+		rd := bufio.NewReader(srv)
+		for line := ""; line != "FF\r"; {
+			line, _ = rd.ReadString('\r')
+		}
+
+		// Send a proposal, followed by turnover without the F> prompt
+		fmt.Fprint(srv, "FC EM TJKYEIMMHSRB 527 123 0\r")
+		fmt.Fprint(srv, cmd+"\r")
+		go io.Copy(io.Discard, srv) // Drain the error echoed to the remote
+
+		err := <-cerrs
+		srv.Close()
+		var pErr *ProtocolError
+		switch {
+		case !errors.As(err, &pErr):
+			t.Errorf("%s: Expected ProtocolError, got %v", cmd, err)
+		case !strings.Contains(err.Error(), "Got '"+cmd+"' before end of proposal block (1 proposal(s) pending)"):
+			t.Errorf("%s: Unexpected error: %s", cmd, err)
+		}
+	}
+}
+
+func TestSortProposals(t *testing.T) {
+	props := []*Proposal{
+		mustProposalWithSubject("Just a test"),
+		mustProposalWithSubject("Re://WL2K O/Very important"),
+		mustProposalWithSubject("//WL2K R/Read this sometime, or don't"),
+		mustProposalWithSubject("//WL2K P/ Pretty important"),
+		mustProposalWithSubject("//WL2K Z/The world is on fire!"),
+	}
+
+	sortProposals(props)
+
+	// Flash
+	if props[0].Title() != "//WL2K Z/The world is on fire!" {
+		t.Error("Flash precedence was not in order")
+	}
+	// Immediate
+	if props[1].Title() != "Re://WL2K O/Very important" {
+		t.Error("Immediate precedence was not in order")
+	}
+	// Priority
+	if props[2].Title() != "//WL2K P/ Pretty important" {
+		t.Error("Priority precedence was not in order")
+	}
+	// Everything else is Routine, so goes by increasing size
+	if props[3].Title() != "Just a test" {
+		t.Error("Routine precedence was not in order")
+	}
+	if props[4].Title() != "//WL2K R/Read this sometime, or don't" {
+		t.Error("Routine precedence was not in order")
+	}
+}
+
+func TestOutboundRaw8BitSubject(t *testing.T) {
+	// A stored message with a raw 8-bit (ISO-8859-1) subject, as written by other clients
+	msg := NewMessage(Private, "N0CALL")
+	msg.AddTo("LA5NTA")
+	msg.Header.Set(HEADER_SUBJECT, "Bl\xe5b\xe6r")
+	_ = msg.SetBody("Satisfies validation")
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := new(Message)
+	if err := stored.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The problem is reported, but the message is still proposed
+	var errs ValidationErrors
+	if !errors.As(stored.Validate(), &errs) || !reflect.DeepEqual(errs.Fields(), []string{HEADER_SUBJECT}) {
+		t.Errorf("Got %v, expected a Subject validation error", stored.Validate())
+	}
+	h := &testHandler{outbound: []*Message{stored}}
+	if summary := NewSession("N0CALL", "LA1B-10", "JO39EQ", h).OutboundSummary(); len(summary) != 1 || summary[0].MID != stored.MID() {
+		t.Errorf("Got %v, expected %s to be proposed", summary, stored.MID())
+	}
+	if count, _, err := OutboundSummary(h); count != 1 || err != nil {
+		t.Errorf("Got %d, %v, expected 1, nil", count, err)
+	}
+}
+
+func TestOutboundSummary(t *testing.T) {
+	h := &testHandler{}
+	for _, subject := range []string{
+		"Just a test",
+		"//WL2K P/ Pretty important",
+		"//WL2K Z/The world is on fire!",
+		"Re://WL2K O/Very important",
+	} {
+		msg := NewMessage(Private, "N0CALL")
+		msg.AddTo("LA5NTA")
+		msg.SetSubject(subject)
+		_ = msg.SetBody("Satisfies validation")
+		h.outbound = append(h.outbound, msg)
+	}
+
+	s := NewSession("N0CALL", "LA1B-10", "JO39EQ", h)
+	summary := s.OutboundSummary()
+	if len(summary) != len(h.outbound) {
+		t.Fatalf("Got %d messages, expected %d", len(summary), len(h.outbound))
+	}
+
+	expect := []struct {
+		subject  string
+		priority Priority
+	}{
+		{"//WL2K Z/The world is on fire!", PriorityFlash},
+		{"Re://WL2K O/Very important", PriorityImmediate},
+		{"//WL2K P/ Pretty important", PriorityPriority},
+		{"Just a test", PriorityRoutine},
+	}
+	for i, e := range expect {
+		got := summary[i]
+		if got.Subject != e.subject || got.Priority != e.priority {
+			t.Errorf("[%d] Got '%s' (%s), expected '%s' (%s)", i, got.Subject, got.Priority, e.subject, e.priority)
+		}
+		if got.MID == "" || got.Size <= 0 {
+			t.Errorf("[%d] Missing MID or size: %+v", i, got)
+		}
+	}
+}
+
+func TestOutboundOrder(t *testing.T) {
+	var msgs []*Message
+	for i, subject := range []string{"Routine", "//WL2K P/Priority", "Routine", "//WL2K Z/Flash", "Routine"} {
+		msgs = append(msgs, fixedMessage(5-i, "N0CALL", "LA5NTA", subject, "Satisfies validation"))
+	}
+	reversed := make([]*Message, len(msgs))
+	for i, msg := range msgs {
+		reversed[len(msgs)-1-i] = msg
+	}
+
+	mids := func(summary []QueuedMessage) (mids []string) {
+		for _, m := range summary {
+			mids = append(mids, m.MID)
+		}
+		return mids
+	}
+	tests := []struct {
+		name   string
+		less   func(a, b *Proposal) bool
+		expect []string
+	}{
+		{
+			name:   "default",
+			expect: []string{"MID000000002", "MID000000004", "MID000000001", "MID000000003", "MID000000005"},
+		},
+		{
+			name:   "all equal",
+			less:   func(a, b *Proposal) bool { return false },
+			expect: []string{"MID000000001", "MID000000002", "MID000000003", "MID000000004", "MID000000005"},
+		},
+		{
+			name:   "priority only",
+			less:   func(a, b *Proposal) bool { return a.Priority() < b.Priority() },
+			expect: []string{"MID000000002", "MID000000004", "MID000000001", "MID000000003", "MID000000005"},
+		},
+	}
+	for _, test := range tests {
+		// The order must not depend on the handler's order
+		for _, out := range [][]*Message{msgs, reversed} {
+			s := NewSession("N0CALL", "LA1B-10", "JO39EQ", &testHandler{outbound: out})
+			s.SetOutboundOrder(test.less)
+			if got := mids(s.OutboundSummary()); strings.Join(got, ",") != strings.Join(test.expect, ",") {
+				t.Errorf("%s: Got %v, expected %v", test.name, got, test.expect)
+			}
+		}
+	}
+}
+
+type robustConn struct {
+	net.Conn
+	mu    sync.Mutex
+	calls []bool
+}
+
+func (c *robustConn) SetRobust(r bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, r)
+	return nil
+}
+
+// wrappedConn is a decorator that does not implement transport.Robust, but exposes the wrapped conn's.
+type wrappedConn struct{ net.Conn }
+
+func (c wrappedConn) RobustConn() transport.Robust { return c.Conn.(transport.Robust) }
+
+func TestSessionRobustWrappedConn(t *testing.T) {
+	msg := NewMessage(Private, "LA5NTA")
+	msg.AddTo("N0CALL")
+	msg.SetSubject("Test")
+	_ = msg.SetBody("Test")
+
+	client, master := net.Pipe()
+	robust := &robustConn{Conn: client}
+	errs := make(chan error, 2)
+	go func() {
+		sender := &streamHandler{opened: make(map[string]int)}
+		sender.outbound = []*Message{msg}
+		_, err := NewSession("LA5NTA", "N0CALL", "JO39EQ", sender).Exchange(wrappedConn{robust})
+		errs <- err
+	}()
+	go func() {
+		s := NewSession("N0CALL", "LA5NTA", "JO39EQ", &testHandler{})
+		s.IsMaster(true)
+		_, err := s.Exchange(master)
+		errs <- err
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Session exchange returned error: %s", err)
+		}
+	}
+
+	// Robust during the session, except while sending the outbound message
+	if expect := []bool{true, false, true, false}; !reflect.DeepEqual(robust.calls, expect) {
+		t.Errorf("Got SetRobust calls %v, expected %v", robust.calls, expect)
+	}
+}
+
+func TestSessionTurnoverPolicy(t *testing.T) {
+	newMessage := func(from, to string) *Message {
+		msg := NewMessage(Private, from)
+		msg.AddTo(to)
+		msg.SetSubject("Test")
+		_ = msg.SetBody("Test")
+		return msg
+	}
+
+	for policy, expectQuitSent := range map[turnoverPolicy]bool{TurnoverDefault: false, TurnoverQuitWhenDone: true} {
+		client, master := net.Pipe()
+
+		// The client pushes one message, and receives one from the master
+		sender := &streamHandler{opened: make(map[string]int)}
+		sender.outbound = []*Message{newMessage("LA5NTA", "N0CALL")}
+		s := NewSession("LA5NTA", "N0CALL", "JO39EQ", sender)
+		s.SetTurnoverPolicy(policy)
+
+		receiver := &streamHandler{opened: make(map[string]int)}
+		receiver.outbound = []*Message{newMessage("N0CALL", "LA5NTA")}
+
+		errs := make(chan error, 2)
+		go func() {
+			_, err := s.Exchange(client)
+			errs <- err
+		}()
+		go func() {
+			s := NewSession("N0CALL", "LA5NTA", "JO39EQ", receiver)
+			s.IsMaster(true)
+			_, err := s.Exchange(master)
+			errs <- err
+		}()
+		for i := 0; i < 2; i++ {
+			if err := <-errs; err != nil {
+				t.Fatalf("Policy %d: Session exchange returned error: %s", policy, err)
+			}
+		}
+
+		if s.quitSent != expectQuitSent {
+			t.Errorf("Policy %d: Got quitSent %t, expected %t", policy, s.quitSent, expectQuitSent)
+		}
+		if len(receiver.inbound) != 1 || len(sender.inbound) != 1 {
+			t.Errorf("Policy %d: Got %d/%d messages, expected 1/1", policy, len(receiver.inbound), len(sender.inbound))
+		}
+	}
+}
+
+func TestSessionOutboundBlockHook(t *testing.T) {
+	sender := &streamHandler{opened: make(map[string]int)}
+	for i, subject := range []string{"Routine", "//WL2K P/Priority", "Routine", "//WL2K Z/Flash", "Routine", "Routine", "//WL2K O/Immediate"} {
+		sender.outbound = append(sender.outbound, fixedMessage(i, "LA5NTA", "N0CALL", subject, strings.Repeat("Lorem ipsum ", i+1)))
+	}
+
+	s := NewSession("LA5NTA", "LA1B-10", "JO39EQ", sender)
+	var expect []string
+	for _, m := range s.OutboundSummary()[:MaxBlockSize] {
+		expect = append(expect, m.MID)
+	}
+
+	cancelled := errors.New("cancelled by user")
+	var got []string
+	s.SetOutboundBlockHook(func(block []Proposal) error {
+		for _, p := range block {
+			got = append(got, p.MID())
+		}
+		return cancelled
+	})
+
+	client, srv := net.Pipe()
+	cerrs := make(chan error)
+	go func() {
+		_, err := s.Exchange(client)
+		cerrs <- err
+	}()
+	go io.Copy(io.Discard, srv)
+	fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+	fmt.Fprint(srv, "Test CMS >\r")
+
+	if err := <-cerrs; !errors.Is(err, cancelled) {
+		t.Errorf("Got %v, expected the hook's error", err)
+	}
+	srv.Close()
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got block %v, expected %v", got, expect)
+	}
+}
+
+func TestSessionLazyCompression(t *testing.T) {
+	var compressed, sized []string
+
+	// More than one block, of which only two messages are accepted by the remote
+	sender := &streamHandler{opened: make(map[string]int)}
+	for i := 0; i < MaxBlockSize+2; i++ {
+		sender.outbound = append(sender.outbound, fixedMessage(i, "LA5NTA", "N0CALL", "Test", strings.Repeat("Lorem ipsum ", 100)))
+	}
+	receiver := &filterHandler{filter: func(p Proposal) ProposalAnswer {
+		if p.MID() == "MID000000001" || p.MID() == "MID000000003" {
+			return Accept
+		}
+		return Reject
+	}}
+
+	client, master := net.Pipe()
+	errs := make(chan error, 2)
+	go func() {
+		s := NewSession("LA5NTA", "N0CALL", "JO39EQ", sender)
+		s.SetCompressionHook(func(stats CompressionStats) {
+			if stats.SizeOnly {
+				sized = append(sized, stats.MID)
+			} else {
+				compressed = append(compressed, stats.MID)
+			}
+		})
+		_, err := s.Exchange(client)
+		errs <- err
+	}()
+	go func() {
+		s := NewSession("N0CALL", "LA5NTA", "JO39EQ", receiver)
+		s.IsMaster(true)
+		_, err := s.Exchange(master)
+		errs <- err
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Session exchange returned error: %s", err)
+		}
+	}
+
+	// Every message is compressed once to determine its size, but only the sent messages are kept compressed
+	if got := strings.Join(compressed, ","); got != "MID000000001,MID000000003" {
+		t.Errorf("Got compressed %s, expected MID000000001,MID000000003", got)
+	}
+	if len(sized) != len(sender.outbound) {
+		t.Errorf("Got %d size-only compressions, expected %d", len(sized), len(sender.outbound))
+	}
+	for _, m := range sender.outbound {
+		if !containsString(sized, m.MID()) {
+			t.Errorf("Missing size-only compression of %s", m.MID())
+		}
+	}
+	if len(receiver.inbound) != 2 {
+		t.Errorf("Got %d messages, expected 2", len(receiver.inbound))
+	}
+	if len(sender.opened) != MaxBlockSize+2 {
+		t.Errorf("Got %d messages read through OpenOutbound, expected %d", len(sender.opened), MaxBlockSize+2)
+	}
+}
+
+func TestSessionIncompressibleMessage(t *testing.T) {
+	t.Setenv("GZIP_EXPERIMENT", "1")
+
+	var (
+		mu    sync.Mutex
+		stats = make(map[string]CompressionStats)
+	)
+	hook := func(s CompressionStats) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !s.SizeOnly {
+			stats[s.MID] = s
+		}
+	}
+
+	noise := make([]byte, 20000)
+	rand.New(rand.NewSource(1)).Read(noise)
+
+	sender := &streamHandler{opened: make(map[string]int)}
+	for i, file := range []*File{NewFile("photo.jpg", noise), nil} {
+		msg := NewMessage(Private, "LA5NTA")
+		msg.Header.Set(HEADER_MID, fmt.Sprintf("MID%09d", i))
+		msg.AddTo("N0CALL")
+		msg.SetSubject("Test")
+		_ = msg.SetBody("Short")
+		if file != nil {
+			msg.AddFile(file)
+		}
+		sender.outbound = append(sender.outbound, msg)
+	}
+	receiver := &testHandler{}
+
+	client, master := net.Pipe()
+	errs := make(chan error, 2)
+	go func() {
+		s := NewSession("LA5NTA", "N0CALL", "JO39EQ", sender)
+		s.SetCompressionHook(hook)
+		if err := s.SetSID("Pat", "0.9.0", SIDCapabilities{Gzip: true}); err != nil {
+			errs <- err
+			return
+		}
+		_, err := s.Exchange(client)
+		errs <- err
+	}()
+	go func() {
+		s := NewSession("N0CALL", "LA5NTA", "JO39EQ", receiver)
+		s.IsMaster(true)
+		if err := s.SetSID("Pat", "0.9.0", SIDCapabilities{Gzip: true}); err != nil {
+			errs <- err
+			return
+		}
+		_, err := s.Exchange(master)
+		errs <- err
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Session exchange returned error: %s", err)
+		}
+	}
+
+	if s := stats["MID000000000"]; s.Code != GzipProposal || s.CompressedSize > s.Size+64 {
+		t.Errorf("Got incompressible message sent as %c (%d bytes, %d uncompressed), expected D without inflation", s.Code, s.CompressedSize, s.Size)
+	}
+	if s := stats["MID000000001"]; s.Code != Wl2kProposal {
+		t.Errorf("Got short message sent as %c, expected C", s.Code)
+	}
+	if len(receiver.inbound) != 2 {
+		t.Fatalf("Got %d messages, expected 2", len(receiver.inbound))
+	}
+	for _, m := range receiver.inbound {
+		if m.MID() != "MID000000000" {
+			continue
+		}
+		if files := m.Files(); len(files) != 1 || !bytes.Equal(files[0].Data(), noise) {
+			t.Error("Incompressible attachment did not survive the transfer")
+		}
+	}
+}
+
+func TestSessionOutboundProposalCodes(t *testing.T) {
+	noise := make([]byte, 20000)
+	rand.New(rand.NewSource(1)).Read(noise)
+
+	h := &streamHandler{opened: make(map[string]int)}
+	for i, file := range []*File{NewFile("photo.jpg", noise), nil} {
+		msg := NewMessage(Private, "LA5NTA")
+		msg.Header.Set(HEADER_MID, fmt.Sprintf("MID%09d", i))
+		msg.AddTo("N0CALL")
+		msg.SetSubject("Test")
+		_ = msg.SetBody("Short")
+		if file != nil {
+			msg.AddFile(file)
+		}
+		h.outbound = append(h.outbound, msg)
+	}
+	photo, short := h.outbound[0], h.outbound[1]
+
+	var stats []CompressionStats
+	s := NewSession("LA5NTA", "N0CALL", "JO39EQ", h)
+	s.SetCompressionHook(func(st CompressionStats) { stats = append(stats, st) })
+
+	// LZHUF is the only compression unless gzip is negotiated
+	if p, err := s.outboundProposal(photo); err != nil {
+		t.Fatal(err)
+	} else if p.code != Wl2kProposal {
+		t.Errorf("Got %c without gzip, expected C", p.code)
+	}
+
+	// Gzip negotiated (set directly, as SetSID requires GZIP_EXPERIMENT)
+	s.remoteSID = sid(localSID + sGzip)
+	s.localSIDCapabilities = &SIDCapabilities{Gzip: true}
+	stats = nil
+	p, err := s.outboundProposal(photo)
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case p.code != GzipProposal || p.compressedSize > p.size+64:
+		t.Errorf("Got incompressible message as %c (%d bytes, %d uncompressed), expected D without inflation", p.code, p.compressedSize, p.size)
+	case len(stats) != 2 || stats[0].Code != Wl2kProposal || stats[1].Code != GzipProposal:
+		t.Errorf("Got compressions %+v, expected C and D", stats)
+	case h.opened[photo.MID()] != 2:
+		t.Errorf("Got %d reads of the message, expected one per proposal", h.opened[photo.MID()])
+	}
+	if data, err := p.DataErr(); err != nil || !bytes.Contains(data, noise) {
+		t.Errorf("Got %v, expected the message to decompress", err)
+	}
+
+	if p, err := s.outboundProposal(short); err != nil {
+		t.Fatal(err)
+	} else if p.code != Wl2kProposal {
+		t.Errorf("Got short message as %c, expected C", p.code)
+	}
+}
+
+func TestSessionResumeFromCache(t *testing.T) {
+	var compressed int
+
+	msg := NewMessage(Private, "LA5NTA")
+	msg.AddTo("N0CALL")
+	msg.SetSubject("Test")
+	var body strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&body, "Line %d of a message that is transferred in more than one session\n", i)
+	}
+	_ = msg.SetBody(body.String())
+
+	h := &cacheHandler{
+		streamHandler: streamHandler{opened: make(map[string]int), testHandler: testHandler{outbound: []*Message{msg}}},
+		compressed:    make(map[string][]byte),
+	}
+
+	// exchange runs a session against a fake CMS answering the proposal with answer.
+	// The fake CMS returns the offset and data received, or disconnects after the first block if abort is set.
+	exchange := func(answer string, abort bool) (offset int, data []byte, err error) {
+		client, srv := net.Pipe()
+		defer srv.Close()
+
+		cerrs := make(chan error, 1)
+		go func() {
+			s := NewSession("LA5NTA", "N0CALL", "JO39EQ", h)
+			s.SetCompressionHook(func(stats CompressionStats) { compressed++ })
+			_, err := s.Exchange(client)
+			cerrs <- err
+		}()
+
+		fmt.Fprint(srv, "[WL2K-2.8.4.8-B2FWIHJM$]\r")
+		fmt.Fprint(srv, "Test CMS >\r")
+
+		// Read until end of proposal block
+		rd := bufio.NewReader(srv)
+		for line := ""; !strings.HasPrefix(line, "F>"); {
+			if line, err = rd.ReadString('\r'); err != nil {
+				return 0, nil, err
+			}
+		}
+		fmt.Fprintf(srv, "FS %s\r", answer)
+
+		// Header: SOH, length, title, NUL, offset, NUL
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(rd, header); err != nil || header[0] != _CHRSOH {
+			go io.Copy(io.Discard, rd) // Drain the error echoed to the remote
+			return 0, nil, <-cerrs
+		}
+		header = make([]byte, header[1])
+		if _, err := io.ReadFull(rd, header); err != nil {
+			return 0, nil, err
+		}
+		fields := bytes.Split(header, []byte{_CHRNUL})
+		fmt.Sscan(string(fields[1]), &offset)
+
+		// Data blocks: STX, length, data (terminated by EOT, checksum)
+		for {
+			b := make([]byte, 2)
+			if _, err := io.ReadFull(rd, b); err != nil {
+				return offset, data, err
+			}
+			if b[0] == _CHREOT {
+				break
+			}
+			n := int(b[1])
+			if n == 0 {
+				n = 256
+			}
+			block := make([]byte, n)
+			if _, err := io.ReadFull(rd, block); err != nil {
+				return offset, data, err
+			}
+			data = append(data, block...)
+			if abort {
+				srv.Close()
+				<-cerrs
+				return offset, data, nil
+			}
+		}
+		fmt.Fprint(srv, "FQ\r")
+		go io.Copy(io.Discard, srv)
+		return offset, data, <-cerrs
+	}
+
+	// First session is disconnected mid transfer
+	if _, _, err := exchange("+", true); err != nil {
+		t.Fatalf("First session: %v", err)
+	}
+	cached, ok := h.compressed[msg.MID()]
+	if !ok {
+		t.Fatalf("Compressed data was not cached")
+	}
+	if compressed != 1 {
+		t.Errorf("Got %d compressions in first session, expected 1", compressed)
+	}
+
+	// Second session resumes at an offset
+	const offset = 300
+	gotOffset, data, err := exchange(fmt.Sprintf("A%d", offset), false)
+	if err != nil {
+		t.Fatalf("Second session: %v", err)
+	}
+	if gotOffset != offset {
+		t.Errorf("Got offset %d, expected %d", gotOffset, offset)
+	}
+	if !bytes.Equal(data, cached[offset:]) {
+		t.Errorf("Resumed data does not match the cached data from offset %d", offset)
+	}
+	if compressed != 1 {
+		t.Errorf("Got %d compressions, expected the second session to be served from cache", compressed)
+	}
+	if !containsString(h.sent, msg.MID()) {
+		t.Errorf("Message was not marked as sent")
+	}
+
+	// An offset beyond the cached data is rejected
+	h.sent = nil
+	if _, _, err := exchange(fmt.Sprintf("A%d", len(cached)+1), false); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Errorf("Got %v, expected ErrOffsetOutOfRange", err)
+	}
+}
+
+func mustProposalWithSubject(subject string) *Proposal {
+	p, err := proposalWithSubject(subject)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func proposalWithSubject(subject string) (*Proposal, error) {
+	msg := NewMessage(Private, "N0CALL")
+	msg.AddTo("N0CALL")
+	msg.SetSubject(subject)
+	_ = msg.SetBody("Satisfies validation")
+	return msg.Proposal(BasicProposal)
+}
+
+// fixedMessage returns a valid message with MID "MID<n>" (zero padded) and a fixed date, so that
+// its compressed size (and thus the default outbound order) does not depend on the current time.
+func fixedMessage(n int, from, to, subject, body string) *Message {
+	msg := NewMessage(Private, from)
+	msg.Header.Set(HEADER_MID, fmt.Sprintf("MID%09d", n))
+	msg.SetDate(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	msg.AddTo(to)
+	msg.SetSubject(subject)
+	_ = msg.SetBody(body)
+	return msg
+}
+
+// testHandler is a minimal in-memory MBoxHandler.
+type testHandler struct {
+	outbound []*Message
+	inbound  []*Message
+	sent     []string
+	deferred []string
+}
+
+func (h *testHandler) Prepare() error                             { return nil }
+func (h *testHandler) GetOutbound(fw ...Address) []*Message       { return h.outbound }
+func (h *testHandler) SetSent(MID string, rejected bool)          { h.sent = append(h.sent, MID) }
+func (h *testHandler) SetDeferred(MID string)                     { h.deferred = append(h.deferred, MID) }
+func (h *testHandler) GetInboundAnswer(p Proposal) ProposalAnswer { return Accept }
+func (h *testHandler) ProcessInbound(msgs ...*Message) error {
+	h.inbound = append(h.inbound, msgs...)
+	return nil
+}
+
+// filterHandler is a testHandler implementing ProposalFilter.
+type filterHandler struct {
+	testHandler
+	filter func(p Proposal) ProposalAnswer
+}
+
+func (h *filterHandler) FilterProposal(p Proposal) ProposalAnswer { return h.filter(p) }
+
+// streamHandler is a testHandler implementing OutboundOpener, which stops offering a message once sent or deferred.
+type streamHandler struct {
+	testHandler
+	opened map[string]int // Number of OpenOutbound calls by MID
+}
+
+func (h *streamHandler) GetOutbound(fw ...Address) []*Message {
+	var out []*Message
+	for _, m := range h.outbound {
+		if !containsString(h.sent, m.MID()) && !containsString(h.deferred, m.MID()) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func (h *streamHandler) OpenOutbound(MID string) (io.ReadCloser, error) {
+	h.opened[MID]++
+	for _, m := range h.outbound {
+		if m.MID() == MID {
+			data, err := m.Bytes()
+			return io.NopCloser(bytes.NewReader(data)), err
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// cacheHandler is a streamHandler implementing CompressedCache.
+type cacheHandler struct {
+	streamHandler
+	compressed map[string][]byte
+	codes      map[string]PropCode
+}
+
+func (h *cacheHandler) CompressedData(MID string, code PropCode) ([]byte, bool) {
+	if c, ok := h.codes[MID]; ok && c != code {
+		return nil, false
+	}
+	data, ok := h.compressed[MID]
+	return data, ok
+}
+
+func (h *cacheHandler) StoreCompressedData(MID string, code PropCode, data []byte) {
+	if h.codes == nil {
+		h.codes = make(map[string]PropCode)
+	}
+	h.compressed[MID], h.codes[MID] = data, code
+}
+
+func containsString(s []string, str string) bool {
+	for _, v := range s {
+		if v == str {
+			return true
+		}
+	}
+	return false
 }
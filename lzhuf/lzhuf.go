@@ -5,6 +5,9 @@
 // Package lzhuf implements the lzhuf compression used by the binary FBB protocols B, B1 and B2.
 //
 // The compression is LZHUF with a CRC16 checksum of the compressed data prepended (B2F option).
+//
+// This is a pure Go implementation (no cgo). The output is byte-for-byte compatible with
+// the reference C implementation, see the .lzh files in testdata.
 package lzhuf
 
 const (
@@ -53,6 +56,13 @@ func (z *lzhuf) InitTree() {
 // TODO: Should not be exported
 func newLZHUFF() *lzhuf {
 	z := new(lzhuf)
+	z.reset()
+	return z
+}
+
+// reset restores the initial state of z, as returned by newLZHUFF.
+func (z *lzhuf) reset() {
+	*z = lzhuf{}
 
 	for i := 0; i < _NumChar; i++ {
 		z.freq[i] = 1
@@ -70,8 +80,6 @@ func newLZHUFF() *lzhuf {
 	}
 	z.freq[_T] = 0xffff
 	z.prnt[_R] = 0
-
-	return z
 }
 
 // Delete from tree
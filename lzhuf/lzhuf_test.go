@@ -7,8 +7,10 @@ package lzhuf
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
@@ -116,6 +118,57 @@ func TestReaderInvalidHeader(t *testing.T) {
 	if err != io.ErrUnexpectedEOF {
 		t.Error("Expected io.ErrUnexpectedEOF on too filesize header, got:", err)
 	}
+
+	tests := map[string][]byte{
+		"negative size": {0xff, 0xff, 0xff, 0xff},
+		"huge size":     {0xff, 0xff, 0xff, 0x7f},
+	}
+	for name, header := range tests {
+		lz, err := NewReader(bytes.NewReader(append(header, samples[0].compressed[6:]...)), false)
+		if err != ErrInvalidHeader {
+			t.Errorf("%s: Expected ErrInvalidHeader, got: %v", name, err)
+			continue
+		}
+		if _, err := lz.Read(make([]byte, 10)); err != ErrInvalidHeader {
+			t.Errorf("%s: Expected ErrInvalidHeader from Read, got: %v", name, err)
+		}
+		if err := lz.Close(); err != ErrInvalidHeader {
+			t.Errorf("%s: Expected ErrInvalidHeader from Close, got: %v", name, err)
+		}
+	}
+}
+
+func TestReaderMaxSize(t *testing.T) {
+	sample := samples[1] // "foo"
+	if _, err := NewReaderMaxSize(bytes.NewReader(sample.compressed), true, int32(len(sample.plain))-1); err != ErrInvalidHeader {
+		t.Errorf("Expected ErrInvalidHeader when exceeding max size, got: %v", err)
+	}
+	if _, err := NewReaderMaxSize(bytes.NewReader(sample.compressed), true, 0); err != nil {
+		t.Errorf("Unexpected error without max size: %v", err)
+	}
+
+	// The limit is kept across Reset
+	lz, err := NewReaderMaxSize(bytes.NewReader(sample.compressed), true, int32(len(sample.plain)))
+	if err != nil {
+		t.Fatalf("Unexpected error within max size: %v", err)
+	}
+	if err := lz.Reset(bytes.NewReader(samples[2].compressed)); err != ErrInvalidHeader {
+		t.Errorf("Expected ErrInvalidHeader when exceeding max size after Reset, got: %v", err)
+	}
+}
+
+func TestReaderTruncatedHeader(t *testing.T) {
+	sample := samples[1]
+	lz, err := NewB2Reader(bytes.NewReader(sample.compressed[:4])) // Checksum and half the file size
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF, got: %v", err)
+	}
+	if _, err := lz.Read(make([]byte, 10)); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF from Read, got: %v", err)
+	}
+	if err := lz.Close(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF from Close, got: %v", err)
+	}
 }
 
 func TestReader(t *testing.T) {
@@ -142,6 +195,19 @@ func TestReader(t *testing.T) {
 	}
 }
 
+func TestReaderSize(t *testing.T) {
+	for i, sample := range samples {
+		lz, err := NewB2Reader(bytes.NewReader(sample.compressed))
+		if err != nil {
+			t.Errorf("Unexpected NewReader error: %s", err)
+			continue
+		}
+		if got := lz.Size(); got != int32(len(sample.plain)) {
+			t.Errorf("Sample %d: Got size %d, expected %d", i, got, len(sample.plain))
+		}
+	}
+}
+
 func TestWriterTestdata(t *testing.T) {
 	files, err := ioutil.ReadDir(testdataPath)
 	if err != nil {
@@ -165,24 +231,22 @@ func TestWriterTestdata(t *testing.T) {
 		io.Copy(w, file)
 		w.Close()
 
-		f, err := os.Open(filepath.Join("testdata", fi.Name()+".lzh"))
+		expect, err := ioutil.ReadFile(filepath.Join(testdataPath, fi.Name()+".lzh"))
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		rd := bufio.NewReader(f)
-		for i := 0; compressed.Len() > 0; i++ {
-			c, _ := compressed.ReadByte()
-			d, _ := rd.ReadByte()
-
-			if c != d {
+		if compressed.Len() != len(expect) {
+			t.Errorf("%s: Got %d bytes, expected %d.", fi.Name(), compressed.Len(), len(expect))
+		}
+		for i, c := range compressed.Bytes() {
+			if i >= len(expect) || c != expect[i] {
 				t.Errorf("%s: Byte idx %d not matching. Skipping rest of compare.", fi.Name(), i)
 				break
 			}
 		}
 
 		file.Close()
-		f.Close()
 	}
 }
 
@@ -214,6 +278,218 @@ func TestWriter(t *testing.T) {
 	}
 }
 
+func TestWriterReset(t *testing.T) {
+	var buf bytes.Buffer
+	lz := NewB2Writer(&buf)
+	for i, sample := range samples {
+		buf.Reset()
+		lz.Reset(&buf)
+		lz.Write(sample.plain)
+		if err := lz.Close(); err != nil {
+			t.Errorf("Close error on sample %d: %s", i, err)
+		}
+		if !bytes.Equal(buf.Bytes(), sample.compressed) {
+			t.Errorf("Sample %d failed", i)
+		}
+	}
+}
+
+func TestReaderReset(t *testing.T) {
+	lz, _ := NewB2Reader(bytes.NewReader(samples[0].compressed))
+	for i, sample := range samples {
+		if err := lz.Reset(bytes.NewReader(sample.compressed)); err != nil {
+			t.Errorf("Unexpected Reset error: %s", err)
+			continue
+		}
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, lz); err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if !bytes.Equal(buf.Bytes(), sample.plain) {
+			t.Errorf("Sample %d failed", i)
+		}
+		if err := lz.Close(); err != nil {
+			t.Errorf("Sample %d failed on close: %s", i, err)
+		}
+	}
+}
+
+// Compress and decompress 100 small messages per iteration, allocating new Writers/Readers.
+func BenchmarkRoundtrip100(b *testing.B) {
+	b.ReportAllocs()
+	var compressed, plain bytes.Buffer
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < 100; i++ {
+			compressed.Reset()
+			w := NewB2Writer(&compressed)
+			w.Write(samples[i%len(samples)].plain)
+			w.Close()
+
+			plain.Reset()
+			r, _ := NewB2Reader(&compressed)
+			io.Copy(&plain, r)
+			r.Close()
+		}
+	}
+}
+
+// Compress and decompress 100 small messages per iteration, reusing the same Writer/Reader.
+func BenchmarkRoundtrip100Reset(b *testing.B) {
+	b.ReportAllocs()
+	var compressed, plain bytes.Buffer
+	w := NewB2Writer(&compressed)
+	r, _ := NewB2Reader(bytes.NewReader(samples[0].compressed))
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < 100; i++ {
+			compressed.Reset()
+			w.Reset(&compressed)
+			w.Write(samples[i%len(samples)].plain)
+			w.Close()
+
+			plain.Reset()
+			r.Reset(&compressed)
+			io.Copy(&plain, r)
+			r.Close()
+		}
+	}
+}
+
+// BenchmarkWriterThroughput measures compression throughput (MB/s) for various input sizes and compressibility.
+func BenchmarkWriterThroughput(b *testing.B) {
+	text, err := ioutil.ReadFile(filepath.Join(testdataPath, "Mark.Twain-Tom.Sawyer.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	random := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	inputs := map[string][]byte{"text": text, "random": random}
+	for _, kind := range []string{"text", "random"} {
+		for _, size := range []int{1 << 10, 64 << 10, 1 << 20} {
+			data := bytes.Repeat(inputs[kind], size/len(inputs[kind])+1)[:size]
+			b.Run(fmt.Sprintf("%s/%dKB", kind, size>>10), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				w := NewB2Writer(ioutil.Discard)
+				for n := 0; n < b.N; n++ {
+					w.Reset(ioutil.Discard)
+					w.Write(data)
+					w.Close()
+				}
+			})
+		}
+	}
+}
+
+// onlyReader and onlyWriter hide any io.WriterTo/io.ReaderFrom implementation, forcing io.Copy to use Read/Write.
+type onlyReader struct{ io.Reader }
+type onlyWriter struct{ io.Writer }
+
+func TestWriteToReadFrom(t *testing.T) {
+	text, err := ioutil.ReadFile(filepath.Join(testdataPath, "Mark.Twain-Tom.Sawyer.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := [][]byte{{}, text}
+	for _, s := range samples {
+		inputs = append(inputs, s.plain)
+	}
+
+	for i, plain := range inputs {
+		// Compress using Write and ReadFrom
+		var viaWrite, viaReadFrom bytes.Buffer
+		w := NewB2Writer(&viaWrite)
+		io.Copy(onlyWriter{w}, onlyReader{bytes.NewReader(plain)})
+		w.Close()
+		w = NewB2Writer(&viaReadFrom)
+		if n, err := w.ReadFrom(bytes.NewReader(plain)); err != nil || n != int64(len(plain)) {
+			t.Fatalf("Input %d: ReadFrom returned %d, %v. Expected %d, nil", i, n, err, len(plain))
+		}
+		w.Close()
+		if !bytes.Equal(viaWrite.Bytes(), viaReadFrom.Bytes()) {
+			t.Errorf("Input %d: ReadFrom output differs from Write", i)
+		}
+
+		// Decompress using Read and WriteTo
+		var viaRead, viaWriteTo bytes.Buffer
+		r, _ := NewB2Reader(bytes.NewReader(viaWrite.Bytes()))
+		io.Copy(onlyWriter{&viaRead}, onlyReader{r})
+		if err := r.Close(); err != nil {
+			t.Errorf("Input %d: Unexpected Close error after Read: %s", i, err)
+		}
+		r, _ = NewB2Reader(bytes.NewReader(viaWrite.Bytes()))
+		if n, err := r.WriteTo(&viaWriteTo); err != nil || n != int64(len(plain)) {
+			t.Fatalf("Input %d: WriteTo returned %d, %v. Expected %d, nil", i, n, err, len(plain))
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("Input %d: Unexpected Close error after WriteTo: %s", i, err)
+		}
+		if !bytes.Equal(viaRead.Bytes(), plain) || !bytes.Equal(viaWriteTo.Bytes(), plain) {
+			t.Errorf("Input %d: Decompressed data does not match input", i)
+		}
+	}
+}
+
+func TestReaderWriteToUnexpectedEOF(t *testing.T) {
+	r, _ := NewB2Reader(bytes.NewReader(samples[2].compressed[:len(samples[2].compressed)-2]))
+	if _, err := r.WriteTo(ioutil.Discard); err != io.ErrUnexpectedEOF {
+		t.Errorf("Got %v, expected io.ErrUnexpectedEOF", err)
+	}
+}
+
+// BenchmarkCopy compares io.Copy through Read/Write with the WriteTo/ReadFrom fast path.
+func BenchmarkCopy(b *testing.B) {
+	text, err := ioutil.ReadFile(filepath.Join(testdataPath, "Mark.Twain-Tom.Sawyer.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	plain := text[:64<<10]
+	var compressed bytes.Buffer
+	w := NewB2Writer(&compressed)
+	w.Write(plain)
+	w.Close()
+
+	b.Run("Write", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(plain)))
+		w := NewB2Writer(ioutil.Discard)
+		for n := 0; n < b.N; n++ {
+			w.Reset(ioutil.Discard)
+			io.Copy(onlyWriter{w}, onlyReader{bytes.NewReader(plain)})
+			w.Close()
+		}
+	})
+	b.Run("ReadFrom", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(plain)))
+		w := NewB2Writer(ioutil.Discard)
+		for n := 0; n < b.N; n++ {
+			w.Reset(ioutil.Discard)
+			io.Copy(w, onlyReader{bytes.NewReader(plain)})
+			w.Close()
+		}
+	})
+	b.Run("Read", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(plain)))
+		r, _ := NewB2Reader(bytes.NewReader(compressed.Bytes()))
+		for n := 0; n < b.N; n++ {
+			r.Reset(bytes.NewReader(compressed.Bytes()))
+			io.Copy(onlyWriter{ioutil.Discard}, onlyReader{r})
+			r.Close()
+		}
+	})
+	b.Run("WriteTo", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(plain)))
+		r, _ := NewB2Reader(bytes.NewReader(compressed.Bytes()))
+		for n := 0; n < b.N; n++ {
+			r.Reset(bytes.NewReader(compressed.Bytes()))
+			io.Copy(onlyWriter{ioutil.Discard}, r)
+			r.Close()
+		}
+	})
+}
+
 type sample struct {
 	plain      []byte
 	compressed []byte
@@ -5,6 +5,7 @@
 package lzhuf
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
@@ -14,6 +15,15 @@ import (
 // ErrChecksum indicates a checksum or file size mismatch on decode.
 var ErrChecksum = errors.New("lzhuf: invalid checksum")
 
+// ErrInvalidHeader indicates a negative file size or a file size exceeding the Reader's max size on decode.
+var ErrInvalidHeader = errors.New("lzhuf: invalid header")
+
+// DefaultMaxSize is the largest uncompressed file size accepted by NewReader and NewB2Reader.
+//
+// Headers declaring a larger file size are rejected with ErrInvalidHeader, to guard against
+// corrupt data driving the decoder for longer than any sane message would.
+const DefaultMaxSize = 16 << 20 // 16 MiB
+
 // A Reader is an io.Reader that can be read to retrieve
 // uncompressed data from a lzhuf-compressed file.
 //
@@ -25,11 +35,13 @@ var ErrChecksum = errors.New("lzhuf: invalid checksum")
 // marking the end of the data. Data consistency should then be verified by calling Close.
 type Reader struct {
 	r   bitReader
+	br  *bufio.Reader
 	z   *lzhuf
 	err error
 
-	crc16 bool
-	crcw  *crcWriter
+	crc16   bool
+	crcw    *crcWriter
+	maxSize int32 // Largest file size accepted from the header (zero means no limit)
 
 	header struct {
 		crc  crc16 // 2 bytes (only in B2 mode)
@@ -41,6 +53,8 @@ type Reader struct {
 		r   int
 		buf bytes.Buffer // Buffer to hold decoded but not yet Read
 	}
+
+	copyBuf []byte // Buffer used by WriteTo, kept across Reset
 }
 
 // NewB2Reader creates a new Reader expecting the extended FBB B2 format used by Winlink.
@@ -54,8 +68,29 @@ func NewB2Reader(r io.Reader) (*Reader, error) { return NewReader(r, true) }
 //
 // It is the caller's responsibility to call Close on the Reader when done.
 func NewReader(r io.Reader, crc16 bool) (*Reader, error) {
-	d := &Reader{z: newLZHUFF(), crc16: crc16, crcw: newCRCWriter()}
+	return NewReaderMaxSize(r, crc16, DefaultMaxSize)
+}
+
+// NewReaderMaxSize is like NewReader, but rejects headers declaring a file size larger than maxSize
+// (with ErrInvalidHeader) instead of DefaultMaxSize. Zero means no limit.
+func NewReaderMaxSize(r io.Reader, crc16 bool, maxSize int32) (*Reader, error) {
+	d := &Reader{z: new(lzhuf), crc16: crc16, crcw: newCRCWriter(), maxSize: maxSize}
+	return d, d.Reset(r)
+}
+
+// Reset discards the Reader's state and makes it equivalent to the result of NewReader,
+// but reading from r instead. This permits reusing a Reader rather than allocating a new one.
+//
+// As with NewReader, the header is read from r and any error is returned. ErrInvalidHeader is
+// returned if the declared file size is negative or exceeds the Reader's max size.
+func (d *Reader) Reset(r io.Reader) error {
+	d.z.reset()
+	d.err = nil
+	d.crcw.sum = 0
+	d.header.crc, d.header.size = 0, 0
+	d.state.pos = 0
 	d.state.r = _N - _R
+	d.state.buf.Reset()
 	for i := 0; i < _N-_F; i++ {
 		d.z.textBuf[i] = ' '
 	}
@@ -63,17 +98,37 @@ func NewReader(r io.Reader, crc16 bool) (*Reader, error) {
 	if d.crc16 {
 		err := binary.Read(r, binary.LittleEndian, &d.header.crc)
 		if err != nil {
-			return nil, err
+			d.err = err
+			return err
 		}
 	}
 
 	// Copy every byte read into our CRC writer (for checksum)
 	r = io.TeeReader(r, d.crcw)
-	d.r = newBitReader(r)
+	if d.br == nil {
+		d.br = bufio.NewReader(r)
+	} else {
+		d.br.Reset(r)
+	}
+	d.r = newBitReader(d.br)
 
-	return d, binary.Read(r, binary.LittleEndian, &d.header.size)
+	if err := binary.Read(r, binary.LittleEndian, &d.header.size); err != nil {
+		d.err = err
+		return err
+	}
+	if d.header.size < 0 || (d.maxSize > 0 && d.header.size > d.maxSize) {
+		d.err = ErrInvalidHeader
+		return d.err
+	}
+	return nil
 }
 
+// Size returns the uncompressed data size as declared by the header.
+//
+// The value is the sender's claim, available as soon as NewReader returns. It must not be trusted
+// until the data has been read and Close has verified it (ErrChecksum is returned on mismatch).
+func (d *Reader) Size() int32 { return d.header.size }
+
 // Close closes the Reader. It does not close the underlying io.Reader.
 //
 // If an error was encountered during Read, the error will be returned.
@@ -102,6 +157,8 @@ func (d *Reader) Close() error {
 // At EOF, count is 0 and err is io.EOF (unless len(p) is zero).
 func (d *Reader) Read(p []byte) (n int, err error) {
 	switch {
+	case d.err != nil:
+		// Sticky (e.g. from reading the header)
 	case d.r.Err() == io.EOF && d.state.pos < d.header.size:
 		d.err = io.ErrUnexpectedEOF
 	case d.r.Err() != nil:
@@ -146,6 +203,36 @@ func (d *Reader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// WriteTo implements io.WriterTo. It writes the remaining uncompressed data to w until there's no
+// more data to write or an error occurs.
+//
+// Unlike Read, io.EOF is not returned at the end of the data. As with Read, Close should be
+// called to verify data consistency.
+func (d *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	if d.copyBuf == nil {
+		d.copyBuf = make([]byte, copyBufSize)
+	}
+	for {
+		nr, rerr := d.Read(d.copyBuf)
+		if nr > 0 {
+			nw, werr := w.Write(d.copyBuf[:nr])
+			n += int64(nw)
+			switch {
+			case werr != nil:
+				return n, werr
+			case nw != nr:
+				return n, io.ErrShortWrite
+			}
+		}
+		switch {
+		case rerr == io.EOF:
+			return n, nil
+		case rerr != nil:
+			return n, rerr
+		}
+	}
+}
+
 func (d *Reader) advanceState() {
 	d.state.r++
 	d.state.r &= (_N - 1)
@@ -27,8 +27,15 @@ type Writer struct {
 	lastMatchLength int
 	preFilled       bool
 	fileSize        int32
+
+	copyBuf []byte // Buffer used by ReadFrom, kept across Reset
 }
 
+// copyBufSize is the size of the intermediate buffer used by Reader.WriteTo and Writer.ReadFrom.
+//
+// The codec works on one byte at a time, so there is nothing to gain from io.Copy's 32 KiB buffer.
+const copyBufSize = 4096
+
 // NewB2Writer returns a new Writer with the extended FBB B2 format used by Winlink.
 //
 // It is the caller's responsibility to call Close on the WriteCloser when done.
@@ -42,17 +49,31 @@ func NewB2Writer(w io.Writer) *Writer { return NewWriter(w, true) }
 // It is the caller's responsibility to call Close on the WriteCloser when done.
 // Writes may be buffered and not flushed until Close.
 func NewWriter(w io.Writer, crc16 bool) *Writer {
-	wr := &Writer{w: bufio.NewWriter(w), buf: new(bytes.Buffer), crc16: crc16}
-
-	wr.z = newLZHUFF()
-	wr.z.InitTree()
+	wr := &Writer{w: bufio.NewWriter(w), z: new(lzhuf), buf: new(bytes.Buffer), crc16: crc16}
+	wr.Reset(w)
+	return wr
+}
 
-	wr.r = _N - _F
-	for i := 0; i < wr.r; i++ {
-		wr.z.textBuf[i] = ' '
+// Reset discards the Writer's state and makes it equivalent to the result of NewWriter,
+// but writing to w instead. This permits reusing a Writer rather than allocating a new one.
+func (w *Writer) Reset(wr io.Writer) {
+	w.w.Reset(wr)
+	w.buf.Reset()
+	w.err = nil
+
+	w.z.reset()
+	w.z.InitTree()
+
+	w.putbuf, w.putlen = 0, 0
+	w.len, w.s = 0, 0
+	w.lastMatchLength = 0
+	w.preFilled = false
+	w.fileSize = 0
+
+	w.r = _N - _F
+	for i := 0; i < w.r; i++ {
+		w.z.textBuf[i] = ' '
 	}
-
-	return wr
 }
 
 // Write writes a compressed form of p to the underlying io.Writer. The
@@ -82,6 +103,33 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// ReadFrom implements io.ReaderFrom. It compresses data from r until EOF or error, and returns
+// the number of uncompressed bytes read. Any error except io.EOF encountered during the read is
+// returned.
+//
+// As with Write, the compressed bytes are not necessarily flushed until the Writer is closed.
+func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	if w.copyBuf == nil {
+		w.copyBuf = make([]byte, copyBufSize)
+	}
+	for {
+		nr, rerr := r.Read(w.copyBuf)
+		if nr > 0 {
+			nw, werr := w.Write(w.copyBuf[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+		}
+		switch {
+		case rerr == io.EOF:
+			return n, nil
+		case rerr != nil:
+			return n, rerr
+		}
+	}
+}
+
 // Close closes the Writer, flushing any unwritten data to the underlying
 // io.Writer, but does not close the underlying io.Writer.
 func (w *Writer) Close() error {
@@ -0,0 +1,100 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package mailbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+type cachedMessage struct {
+	modTime time.Time
+	size    int64
+	msg     *fbb.Message
+}
+
+// Messages returns the messages in the given mailbox folder (DIR_INBOX, DIR_OUTBOX, DIR_SENT or DIR_ARCHIVE).
+//
+// Parsed messages are cached by the DirHandler, and only re-read from disk when the file's modification
+// time or size has changed.
+func (h *DirHandler) Messages(box string) ([]*fbb.Message, error) { return h.Search(box, nil) }
+
+// Search returns the messages in the given mailbox folder for which pred returns true.
+//
+// A nil pred matches all messages. See Messages.
+func (h *DirHandler) Search(box string, pred func(*fbb.Message) bool) ([]*fbb.Message, error) {
+	switch strings.Trim(box, "/") {
+	case "in", "out", "sent", "archive":
+	default:
+		return nil, fmt.Errorf("Unknown mailbox folder '%s'", box)
+	}
+
+	dirPath := path.Join(h.MBoxPath, box)
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read dir (%s): %s", dirPath, err)
+	}
+
+	h.cacheMu.Lock()
+	defer h.cacheMu.Unlock()
+	if h.cache == nil {
+		h.cache = make(map[string]cachedMessage)
+	}
+
+	seen := make(map[string]bool, len(files))
+	msgs := make([]*fbb.Message, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || file.Name()[0] == '.' {
+			continue
+		}
+
+		if !strings.EqualFold(filepath.Ext(file.Name()), Ext) {
+			continue
+		}
+
+		filePath := path.Join(dirPath, file.Name())
+		seen[filePath] = true
+
+		entry, ok := h.cache[filePath]
+		if !ok || !entry.modTime.Equal(file.ModTime()) || entry.size != file.Size() {
+			msg, err := OpenMessage(filePath)
+			if err != nil {
+				return nil, err
+			}
+			entry = cachedMessage{modTime: file.ModTime(), size: file.Size(), msg: msg}
+			h.cache[filePath] = entry
+		}
+
+		// Return a copy, so that the caller can modify the headers (e.g. SetUnread) without affecting the cache
+		msg := copyMessage(entry.msg)
+		if pred == nil || pred(msg) {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	// Forget removed files
+	for filePath := range h.cache {
+		if path.Dir(filePath) == dirPath && !seen[filePath] {
+			delete(h.cache, filePath)
+		}
+	}
+
+	return msgs, nil
+}
+
+func copyMessage(msg *fbb.Message) *fbb.Message {
+	cp := *msg
+	cp.Header = make(fbb.Header, len(msg.Header))
+	for k, v := range msg.Header {
+		cp.Header[k] = append([]string(nil), v...)
+	}
+	return &cp
+}
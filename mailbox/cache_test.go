@@ -0,0 +1,71 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package mailbox
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+func TestDirHandlerSearch(t *testing.T) {
+	h := NewDirHandler(t.TempDir(), false)
+	if err := h.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	newMessage := func(subject string) *fbb.Message {
+		msg := fbb.NewMessage(fbb.Private, "N0CALL")
+		msg.AddTo("LA5NTA")
+		msg.SetSubject(subject)
+		msg.SetBody("Test")
+		if err := h.AddOut(msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	foo, bar := newMessage("foo"), newMessage("bar")
+
+	msgs, err := h.Messages(DIR_OUTBOX)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(msgs) != 2 {
+		t.Fatalf("Got %d messages, expected 2", len(msgs))
+	}
+
+	// Modifying returned messages should not affect the cache
+	msgs[0].SetSubject("modified")
+
+	isFoo := func(m *fbb.Message) bool { return m.Subject() == "foo" }
+	if msgs, _ := h.Search(DIR_OUTBOX, isFoo); len(msgs) != 1 || msgs[0].MID() != foo.MID() {
+		t.Errorf("Search: Got %v, expected only %s", msgs, foo.MID())
+	}
+
+	// Rewrite bar as foo, the cache should be invalidated
+	bar.SetSubject("foo")
+	h.AddOut(bar)
+	filePath := path.Join(h.MBoxPath, DIR_OUTBOX, bar.MID()+Ext)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filePath, future, future)
+	if msgs, _ := h.Search(DIR_OUTBOX, isFoo); len(msgs) != 2 {
+		t.Errorf("Search after rewrite: Got %d messages, expected 2", len(msgs))
+	}
+
+	// Removed files should be forgotten
+	os.Remove(filePath)
+	if msgs, _ := h.Messages(DIR_OUTBOX); len(msgs) != 1 {
+		t.Errorf("Messages after remove: Got %d messages, expected 1", len(msgs))
+	}
+	if _, ok := h.cache[filePath]; ok {
+		t.Error("Removed file still in cache")
+	}
+
+	if _, err := h.Messages("/foo/"); err == nil {
+		t.Error("Expected error on unknown folder")
+	}
+}
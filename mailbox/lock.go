@@ -0,0 +1,42 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package mailbox
+
+import (
+	"fmt"
+	"path"
+)
+
+// The name of the lock file in the root of the mailbox directory.
+const lockFileName = ".lock"
+
+// Lock acquires an advisory lock on the mailbox directory, blocking until it is available.
+//
+// The lock is held across processes (using flock(2) where available, or a lock file otherwise), so that
+// e.g. a gateway and an interactive client can safely share the same mailbox directory.
+//
+// AddOut, SetSent and ProcessInbound acquires the lock on their own, and must not be called while
+// holding it. Lock is intended for multi-step operations on the mailbox files.
+func (h *DirHandler) Lock() error {
+	h.lockMu.Lock()
+	f, err := lockFile(path.Join(h.MBoxPath, lockFileName))
+	if err != nil {
+		h.lockMu.Unlock()
+		return fmt.Errorf("Unable to lock mailbox: %w", err)
+	}
+	h.lockFile = f
+	return nil
+}
+
+// Unlock releases the lock acquired by Lock.
+func (h *DirHandler) Unlock() error {
+	defer h.lockMu.Unlock()
+	if h.lockFile == nil {
+		panic("mailbox: unlock of unlocked DirHandler")
+	}
+	err := unlockFile(h.lockFile)
+	h.lockFile = nil
+	return err
+}
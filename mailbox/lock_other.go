@@ -0,0 +1,41 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package mailbox
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Lock files older than this are assumed to be left behind by a crashed process.
+const staleLockAge = 5 * time.Minute
+
+// lockFile acquires the lock by exclusively creating the lock file, polling until it succeeds.
+func lockFile(filePath string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			return f, nil
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if fi, err := os.Stat(filePath); err == nil && time.Since(fi.ModTime()) > staleLockAge {
+			os.Remove(filePath)
+			continue
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func unlockFile(f *os.File) error {
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package mailbox
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+func TestDirHandlerConcurrentAddOut(t *testing.T) {
+	dir := t.TempDir()
+
+	// Two handlers sharing the same directory, as two processes would.
+	handlers := []*DirHandler{NewDirHandler(dir, false), NewDirHandler(dir, false)}
+	for _, h := range handlers {
+		if err := h.Prepare(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const n = 25
+	var wg sync.WaitGroup
+	want := make(map[string]string)
+	var mu sync.Mutex
+	for i, h := range handlers {
+		wg.Add(1)
+		go func(i int, h *DirHandler) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				msg := fbb.NewMessage(fbb.Private, "N0CALL")
+				msg.Header.Set("Mid", fmt.Sprintf("MSG%d%08d", i, j))
+				msg.AddTo("LA5NTA")
+				msg.SetSubject(fmt.Sprintf("Message %d from %d", j, i))
+				msg.SetBody("Test")
+				if err := h.AddOut(msg); err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				want[msg.MID()] = msg.Subject()
+				mu.Unlock()
+			}
+		}(i, h)
+	}
+	wg.Wait()
+
+	msgs, err := handlers[0].Outbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != len(handlers)*n {
+		t.Errorf("Got %d messages, expected %d", len(msgs), len(handlers)*n)
+	}
+	for _, msg := range msgs {
+		if subject := want[msg.MID()]; msg.Subject() != subject {
+			t.Errorf("%s: Got subject '%s', expected '%s'", msg.MID(), msg.Subject(), subject)
+		}
+	}
+}
+
+func TestDirHandlerLock(t *testing.T) {
+	dir := t.TempDir()
+	a, b := NewDirHandler(dir, false), NewDirHandler(dir, false)
+	if err := a.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	b.Prepare()
+
+	if err := a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := fbb.NewMessage(fbb.Private, "N0CALL")
+	msg.AddTo("LA5NTA")
+	msg.SetSubject("Test")
+	msg.SetBody("Test")
+	done := make(chan error, 1)
+	go func() { done <- b.AddOut(msg) }()
+
+	select {
+	case <-done:
+		t.Fatal("AddOut completed while the mailbox was locked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AddOut did not complete after Unlock")
+	}
+	if n := a.OutboxCount(); n != 1 {
+		t.Errorf("Got %d messages in outbox, expected 1", n)
+	}
+}
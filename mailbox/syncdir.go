@@ -14,6 +14,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/la5nta/wl2k-go/fbb"
 )
@@ -28,10 +29,19 @@ const (
 const Ext = ".b2f"
 
 // NewDirHandler is a file system (directory) oriented mailbox handler.
+//
+// Writes to the mailbox directory are guarded by an advisory lock, so that several processes
+// (or DirHandlers) can coordinate access to the same mailbox. See Lock.
 type DirHandler struct {
 	MBoxPath string
 	deferred map[string]bool
 	sendOnly bool
+
+	lockMu   sync.Mutex
+	lockFile *os.File // The lock file while locked (see Lock)
+
+	cacheMu sync.Mutex
+	cache   map[string]cachedMessage // Parsed messages by file path (see Messages)
 }
 
 // NewDirHandler wraps the directory given by path as a DirHandler.
@@ -77,10 +87,20 @@ func (h *DirHandler) AddOut(msg *fbb.Message) error {
 		return err
 	}
 
+	if err := h.Lock(); err != nil {
+		return err
+	}
+	defer h.Unlock()
+
 	return ioutil.WriteFile(path.Join(h.MBoxPath, DIR_OUTBOX, msg.MID()+Ext), data, 0644)
 }
 
 func (h *DirHandler) ProcessInbound(msgs ...*fbb.Message) (err error) {
+	if err := h.Lock(); err != nil {
+		return err
+	}
+	defer h.Unlock()
+
 	dir := path.Join(h.MBoxPath, DIR_INBOX)
 	for _, m := range msgs {
 		filename := path.Join(dir, m.MID()+Ext)
@@ -122,6 +142,11 @@ func (h *DirHandler) SetSent(MID string, rejected bool) {
 	oldPath := path.Join(h.MBoxPath, DIR_OUTBOX, MID+Ext)
 	newPath := path.Join(h.MBoxPath, DIR_SENT, MID+Ext)
 
+	if err := h.Lock(); err != nil {
+		log.Fatal(err)
+	}
+	defer h.Unlock()
+
 	if err := os.Rename(oldPath, newPath); err != nil {
 		log.Fatalf("Unable to move %s to %s: %s", oldPath, newPath, err)
 	}
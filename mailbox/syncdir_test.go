@@ -0,0 +1,49 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package mailbox
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/la5nta/wl2k-go/fbb"
+)
+
+func TestOutboundSummary(t *testing.T) {
+	h := NewDirHandler(t.TempDir(), false)
+	if err := h.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, body := range []string{"Short", strings.Repeat("Lorem ipsum ", 100), strings.Repeat("Dolor sit amet ", 1000)} {
+		msg := fbb.NewMessage(fbb.Private, "N0CALL")
+		msg.AddTo("LA5NTA")
+		msg.SetSubject("Test")
+		msg.SetBody(body)
+		if i == 0 {
+			msg.Header.Del("To") // Invalid, never proposed
+		}
+		if err := h.AddOut(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, total, err := fbb.OutboundSummary(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Got count %d, expected 2", count)
+	}
+
+	// The total should match the sizes proposed by a session
+	var expect int
+	for _, m := range fbb.NewSession("N0CALL", "LA1B-10", "JO39EQ", h).OutboundSummary() {
+		expect += m.Size
+	}
+	if total != expect || total == 0 {
+		t.Errorf("Got total compressed size %d, expected %d", total, expect)
+	}
+}
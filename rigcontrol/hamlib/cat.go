@@ -0,0 +1,558 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package hamlib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/albenik/go-serial/v2"
+)
+
+// CATTimeout defines the timeout duration of a CAT command's response.
+var CATTimeout = time.Second
+
+var (
+	ErrUnsupportedModel = errors.New("Rig model not supported by the CAT backend")
+	ErrCommandRejected  = errors.New("Command rejected by rig")
+)
+
+// catFamily is the CAT protocol spoken by a rig model.
+type catFamily int
+
+const (
+	familyKenwood catFamily = 2 // The hamlib backend number (model/1000).
+	familyIcom    catFamily = 3
+)
+
+type catModel struct {
+	name    string
+	civAddr byte // Default CI-V address (Icom only)
+}
+
+// catModels holds the rigs known to the CAT backend, by hamlib model number.
+//
+// Other models of the Kenwood (2xxx) and Icom (3xxx) backends are handled as generic rigs of the
+// same family. Icom rigs not listed here must be given a CI-V address.
+var catModels = map[RigModel]catModel{
+	2014: {name: "Kenwood TS-2000"},
+	2028: {name: "Kenwood TS-480"},
+	2029: {name: "Elecraft K3"},
+	2031: {name: "Kenwood TS-590S"},
+	2037: {name: "Kenwood TS-590SG"},
+	3011: {name: "Icom IC-706MkIIG", civAddr: 0x58},
+	3061: {name: "Icom IC-7200", civAddr: 0x76},
+	3070: {name: "Icom IC-7100", civAddr: 0x88},
+	3073: {name: "Icom IC-7300", civAddr: 0x94},
+	3078: {name: "Icom IC-7610", civAddr: 0x98},
+	3081: {name: "Icom IC-9700", civAddr: 0xA2},
+	3085: {name: "Icom IC-705", civAddr: 0xA4},
+}
+
+// CATRig is a rig controlled directly over its serial CAT port, without hamlib or rigctld.
+//
+// Only basic control (frequency, mode and PTT) of Kenwood (and compatible) and Icom CI-V rigs is supported.
+// Kenwood rigs do not acknowledge set commands, so errors are only reported for the queries.
+type CATRig struct {
+	mu    sync.Mutex
+	port  *catPort
+	proto catProtocol
+}
+
+// catVFO is a VFO of a CATRig.
+type catVFO struct {
+	r   *CATRig
+	vfo vfoSelect
+}
+
+type vfoSelect int
+
+const (
+	vfoCurrent vfoSelect = iota
+	vfoA
+	vfoB
+)
+
+// catProtocol implements the rig control for a CAT protocol family.
+type catProtocol interface {
+	getFreq(p *catPort, vfo vfoSelect) (int, error)
+	setFreq(p *catPort, vfo vfoSelect, freq int) error
+	getMode(p *catPort, vfo vfoSelect) (Mode, error)
+	setMode(p *catPort, vfo vfoSelect, m Mode) error
+	getPTT(p *catPort) (bool, error)
+	setPTT(p *catPort, on bool) error
+}
+
+// openCATPort opens the rig's serial port.
+var openCATPort = func(path string, baudrate int) (io.ReadWriteCloser, error) {
+	return serial.Open(path, serial.WithBaudrate(baudrate))
+}
+
+// OpenCAT opens the serial port at path and returns a ready to use Rig controlled by the CAT backend.
+//
+// The CAT protocol is given by the hamlib model number. See OpenSerialURI for a CI-V address override.
+//
+// Caller must remember to Close the Rig after use.
+func OpenCAT(model RigModel, path string, baudrate int) (*CATRig, error) {
+	return openCAT(model, path, baudrate, 0)
+}
+
+func openCAT(model RigModel, path string, baudrate int, civAddr byte) (*CATRig, error) {
+	proto, err := newCATProtocol(model, civAddr)
+	if err != nil {
+		return nil, err
+	}
+	rwc, err := openCATPort(path, baudrate)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open rig: %w", err)
+	}
+	return &CATRig{port: newCATPort(rwc), proto: proto}, nil
+}
+
+func newCATProtocol(model RigModel, civAddr byte) (catProtocol, error) {
+	switch catFamily(model / 1000) {
+	case familyKenwood:
+		return kenwoodCAT{}, nil
+	case familyIcom:
+		if civAddr == 0 {
+			civAddr = catModels[model].civAddr
+		}
+		if civAddr == 0 {
+			return nil, fmt.Errorf("Missing CI-V address for Icom model %d", model)
+		}
+		return icomCAT{civAddr: civAddr}, nil
+	default:
+		return nil, ErrUnsupportedModel
+	}
+}
+
+// Closes the connection to the Rig.
+func (r *CATRig) Close() error { return r.port.Close() }
+
+// Returns the Rig's active VFO (for control).
+func (r *CATRig) CurrentVFO() VFO { return catVFO{r, vfoCurrent} }
+
+// Returns the Rig's A vfo.
+//
+// On Icom rigs, VFO A is selected as the active VFO by every operation on it.
+func (r *CATRig) VFOA() (VFO, error) { return catVFO{r, vfoA}, nil }
+
+// Returns the Rig's B vfo.
+//
+// On Icom rigs, VFO B is selected as the active VFO by every operation on it.
+func (r *CATRig) VFOB() (VFO, error) { return catVFO{r, vfoB}, nil }
+
+// Gets the dial frequency for this VFO.
+func (v catVFO) GetFreq() (int, error) {
+	v.r.mu.Lock()
+	defer v.r.mu.Unlock()
+	return v.r.proto.getFreq(v.r.port, v.vfo)
+}
+
+// Sets the dial frequency for this VFO.
+func (v catVFO) SetFreq(freq int) error {
+	v.r.mu.Lock()
+	defer v.r.mu.Unlock()
+	return v.r.proto.setFreq(v.r.port, v.vfo, freq)
+}
+
+// GetMode returns this VFO's active Mode.
+//
+// The passband is not reported by the CAT backend (always 0). On Kenwood rigs, the mode of the active VFO is returned.
+func (v catVFO) GetMode() (m Mode, pbw int, err error) {
+	v.r.mu.Lock()
+	defer v.r.mu.Unlock()
+	m, err = v.r.proto.getMode(v.r.port, v.vfo)
+	return m, 0, err
+}
+
+// SetMode switches to the given Mode using the rig's default passband (pbw is ignored).
+//
+// On Kenwood rigs, the mode of the active VFO is set.
+func (v catVFO) SetMode(m Mode, pbw int) error {
+	v.r.mu.Lock()
+	defer v.r.mu.Unlock()
+	return v.r.proto.setMode(v.r.port, v.vfo, m)
+}
+
+// GetPTT returns the PTT state of the rig.
+func (v catVFO) GetPTT() (bool, error) {
+	v.r.mu.Lock()
+	defer v.r.mu.Unlock()
+	return v.r.proto.getPTT(v.r.port)
+}
+
+// Enable (or disable) PTT on the rig.
+func (v catVFO) SetPTT(on bool) error {
+	v.r.mu.Lock()
+	defer v.r.mu.Unlock()
+	return v.r.proto.setPTT(v.r.port, on)
+}
+
+// catPort reads the rig's responses in the background, so that reads can time out regardless of the
+// serial port's read timeout.
+type catPort struct {
+	rwc     io.ReadWriteCloser
+	rx      chan []byte
+	pending []byte
+}
+
+func newCATPort(rwc io.ReadWriteCloser) *catPort {
+	p := &catPort{rwc: rwc, rx: make(chan []byte, 16)}
+	go func() {
+		defer close(p.rx)
+		for {
+			buf := make([]byte, 64)
+			n, err := rwc.Read(buf)
+			if n > 0 {
+				p.rx <- buf[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *catPort) Close() error { return p.rwc.Close() }
+
+// cmd discards any unread input and writes the command.
+func (p *catPort) cmd(b []byte) error {
+	p.pending = nil
+	for len(p.rx) > 0 {
+		<-p.rx
+	}
+	_, err := p.rwc.Write(b)
+	return err
+}
+
+// readUntil reads until (and including) the delimiter, failing if it's not received within CATTimeout.
+func (p *catPort) readUntil(delim byte) ([]byte, error) {
+	timeout := time.NewTimer(CATTimeout)
+	defer timeout.Stop()
+	for {
+		if idx := bytes.IndexByte(p.pending, delim); idx >= 0 {
+			resp := p.pending[:idx+1]
+			p.pending = p.pending[idx+1:]
+			return resp, nil
+		}
+		select {
+		case b, ok := <-p.rx:
+			if !ok {
+				return nil, io.EOF
+			}
+			p.pending = append(p.pending, b...)
+		case <-timeout.C:
+			return nil, fmt.Errorf("Response timeout")
+		}
+	}
+}
+
+// kenwoodCAT implements the Kenwood CAT protocol (ASCII commands terminated by ';').
+type kenwoodCAT struct{}
+
+var kenwoodModes = map[Mode]int{
+	LSB:   1,
+	USB:   2,
+	CW:    3,
+	FM:    4,
+	AM:    5,
+	RTTY:  6,
+	CWR:   7,
+	RTTYR: 9,
+}
+
+// query sends the command and returns the response parameters (the response without command name and terminator).
+func (kenwoodCAT) query(p *catPort, cmd string) (string, error) {
+	if err := p.cmd([]byte(cmd + ";")); err != nil {
+		return "", err
+	}
+	resp, err := p.readUntil(';')
+	switch {
+	case err != nil:
+		return "", err
+	case string(resp) == "?;":
+		return "", ErrCommandRejected
+	case !bytes.HasPrefix(resp, []byte(cmd)):
+		return "", fmt.Errorf("Unexpected response to %s: %q", cmd, resp)
+	}
+	return string(resp[len(cmd) : len(resp)-1]), nil
+}
+
+// info returns the response parameters of the IF (information) command.
+//
+// The parameters are: frequency (11), step (5), RIT/XIT (5), RIT, XIT, memory channel (3), TX/RX (1), mode (1), VFO (1), ...
+func (k kenwoodCAT) info(p *catPort) (string, error) {
+	resp, err := k.query(p, "IF")
+	if err == nil && len(resp) < 29 {
+		err = fmt.Errorf("Unexpected response to IF: %q", resp)
+	}
+	return resp, err
+}
+
+func (k kenwoodCAT) vfoCmd(p *catPort, vfo vfoSelect) (string, error) {
+	switch vfo {
+	case vfoA:
+		return "FA", nil
+	case vfoB:
+		return "FB", nil
+	}
+	info, err := k.info(p)
+	if err != nil {
+		return "", err
+	}
+	if info[28] == '1' {
+		return "FB", nil
+	}
+	return "FA", nil
+}
+
+func (k kenwoodCAT) getFreq(p *catPort, vfo vfoSelect) (int, error) {
+	var resp string
+	var err error
+	if vfo == vfoCurrent {
+		resp, err = k.info(p)
+	} else {
+		cmd, _ := k.vfoCmd(p, vfo)
+		resp, err = k.query(p, cmd)
+	}
+	if err != nil {
+		return -1, err
+	}
+	if len(resp) < 11 {
+		return -1, ErrUnexpectedValue
+	}
+	return strconv.Atoi(resp[:11])
+}
+
+func (k kenwoodCAT) setFreq(p *catPort, vfo vfoSelect, freq int) error {
+	cmd, err := k.vfoCmd(p, vfo)
+	if err != nil {
+		return err
+	}
+	return p.cmd([]byte(fmt.Sprintf("%s%011d;", cmd, freq)))
+}
+
+func (k kenwoodCAT) getMode(p *catPort, _ vfoSelect) (Mode, error) {
+	resp, err := k.query(p, "MD")
+	if err != nil {
+		return NoMode, err
+	}
+	n, err := strconv.Atoi(resp)
+	if err != nil {
+		return NoMode, ErrUnexpectedValue
+	}
+	for m, v := range kenwoodModes {
+		if v == n {
+			return m, nil
+		}
+	}
+	return NoMode, fmt.Errorf("Unknown mode %d", n)
+}
+
+func (k kenwoodCAT) setMode(p *catPort, _ vfoSelect, m Mode) error {
+	v, ok := kenwoodModes[m]
+	if !ok {
+		return fmt.Errorf("Mode %s not supported", m)
+	}
+	return p.cmd([]byte(fmt.Sprintf("MD%d;", v)))
+}
+
+func (k kenwoodCAT) getPTT(p *catPort) (bool, error) {
+	info, err := k.info(p)
+	if err != nil {
+		return false, err
+	}
+	return info[26] == '1', nil
+}
+
+func (k kenwoodCAT) setPTT(p *catPort, on bool) error {
+	if on {
+		return p.cmd([]byte("TX;"))
+	}
+	return p.cmd([]byte("RX;"))
+}
+
+// icomCAT implements the Icom CI-V protocol.
+type icomCAT struct{ civAddr byte }
+
+const (
+	civPreamble   = 0xFE
+	civEnd        = 0xFD
+	civController = 0xE0 // Our address on the CI-V bus
+	civOK         = 0xFB
+	civNG         = 0xFA
+)
+
+const (
+	civCmdReadFreq  = 0x03
+	civCmdReadMode  = 0x04
+	civCmdSetFreq   = 0x05
+	civCmdSetMode   = 0x06
+	civCmdSelectVFO = 0x07
+	civCmdPTT       = 0x1C
+)
+
+var icomModes = map[Mode]byte{
+	LSB:   0x00,
+	USB:   0x01,
+	AM:    0x02,
+	CW:    0x03,
+	RTTY:  0x04,
+	FM:    0x05,
+	WFM:   0x06,
+	CWR:   0x07,
+	RTTYR: 0x08,
+}
+
+// transact sends the command and returns the payload (command and data) of the rig's response.
+//
+// Echoes of our own frames (the CI-V bus is shared) are skipped.
+func (ic icomCAT) transact(p *catPort, cmd ...byte) ([]byte, error) {
+	frame := append([]byte{civPreamble, civPreamble, ic.civAddr, civController}, cmd...)
+	if err := p.cmd(append(frame, civEnd)); err != nil {
+		return nil, err
+	}
+	for {
+		resp, err := p.readUntil(civEnd)
+		if err != nil {
+			return nil, err
+		}
+		// Skip any noise before the preamble
+		idx := bytes.Index(resp, []byte{civPreamble, civPreamble})
+		if idx < 0 {
+			continue
+		}
+		resp = resp[idx+2 : len(resp)-1]
+		if len(resp) < 3 || resp[0] != civController || resp[1] != ic.civAddr {
+			continue // Echo or traffic to/from others
+		}
+		switch payload := resp[2:]; payload[0] {
+		case civNG:
+			return nil, ErrCommandRejected
+		default:
+			return payload, nil
+		}
+	}
+}
+
+// exec sends a command expecting an OK response.
+func (ic icomCAT) exec(p *catPort, cmd ...byte) error {
+	resp, err := ic.transact(p, cmd...)
+	if err == nil && resp[0] != civOK {
+		err = fmt.Errorf("Unexpected response: % X", resp)
+	}
+	return err
+}
+
+func (ic icomCAT) selectVFO(p *catPort, vfo vfoSelect) error {
+	switch vfo {
+	case vfoA:
+		return ic.exec(p, civCmdSelectVFO, 0x00)
+	case vfoB:
+		return ic.exec(p, civCmdSelectVFO, 0x01)
+	default:
+		return nil
+	}
+}
+
+func (ic icomCAT) getFreq(p *catPort, vfo vfoSelect) (int, error) {
+	if err := ic.selectVFO(p, vfo); err != nil {
+		return -1, err
+	}
+	resp, err := ic.transact(p, civCmdReadFreq)
+	if err != nil {
+		return -1, err
+	}
+	if resp[0] != civCmdReadFreq || len(resp) < 6 {
+		return -1, ErrUnexpectedValue
+	}
+	return bcdToFreq(resp[1:6])
+}
+
+func (ic icomCAT) setFreq(p *catPort, vfo vfoSelect, freq int) error {
+	if err := ic.selectVFO(p, vfo); err != nil {
+		return err
+	}
+	return ic.exec(p, append([]byte{civCmdSetFreq}, freqToBCD(freq)...)...)
+}
+
+func (ic icomCAT) getMode(p *catPort, vfo vfoSelect) (Mode, error) {
+	if err := ic.selectVFO(p, vfo); err != nil {
+		return NoMode, err
+	}
+	resp, err := ic.transact(p, civCmdReadMode)
+	if err != nil {
+		return NoMode, err
+	}
+	if resp[0] != civCmdReadMode || len(resp) < 2 {
+		return NoMode, ErrUnexpectedValue
+	}
+	for m, v := range icomModes {
+		if v == resp[1] {
+			return m, nil
+		}
+	}
+	return NoMode, fmt.Errorf("Unknown mode %#02x", resp[1])
+}
+
+func (ic icomCAT) setMode(p *catPort, vfo vfoSelect, m Mode) error {
+	v, ok := icomModes[m]
+	if !ok {
+		return fmt.Errorf("Mode %s not supported", m)
+	}
+	if err := ic.selectVFO(p, vfo); err != nil {
+		return err
+	}
+	return ic.exec(p, civCmdSetMode, v)
+}
+
+func (ic icomCAT) getPTT(p *catPort) (bool, error) {
+	resp, err := ic.transact(p, civCmdPTT, 0x00)
+	if err != nil {
+		return false, err
+	}
+	if resp[0] != civCmdPTT || len(resp) < 3 {
+		return false, ErrUnexpectedValue
+	}
+	return resp[2] == 0x01, nil
+}
+
+func (ic icomCAT) setPTT(p *catPort, on bool) error {
+	var v byte
+	if on {
+		v = 0x01
+	}
+	return ic.exec(p, civCmdPTT, 0x00, v)
+}
+
+// freqToBCD encodes the frequency as CI-V BCD (least significant byte first).
+func freqToBCD(freq int) []byte {
+	b := make([]byte, 5)
+	for i := range b {
+		b[i] = byte(freq%10) | byte(freq/10%10)<<4
+		freq /= 100
+	}
+	return b
+}
+
+// bcdToFreq decodes a CI-V BCD frequency (least significant byte first).
+func bcdToFreq(b []byte) (int, error) {
+	var freq int
+	for i := len(b) - 1; i >= 0; i-- {
+		hi, lo := int(b[i]>>4), int(b[i]&0x0F)
+		if hi > 9 || lo > 9 {
+			return -1, ErrUnexpectedValue
+		}
+		freq = freq*100 + hi*10 + lo
+	}
+	return freq, nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+//go:build !cgo || !libhamlib
+// +build !cgo !libhamlib
+
+package hamlib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// stubRig is the state of a simulated rig.
+type stubRig struct {
+	mu   sync.Mutex
+	freq [2]int // VFO A and B
+	vfo  int    // Active VFO (0=A, 1=B)
+	mode int    // Rig specific mode value
+	tx   bool
+	cmds []string // Commands received
+}
+
+// stubSerial opens a simulated serial port for the rig's CAT protocol in place of the real one.
+func stubSerial(t *testing.T, rig *stubRig, serve func(io.ReadWriter, *stubRig)) (path string, baudrate *int) {
+	baudrate = new(int)
+	orig := openCATPort
+	openCATPort = func(p string, b int) (io.ReadWriteCloser, error) {
+		path, *baudrate = p, b
+		client, srv := net.Pipe()
+		go serve(srv, rig)
+		return client, nil
+	}
+	t.Cleanup(func() { openCATPort = orig })
+	return "/dev/ttyUSB0", baudrate
+}
+
+// serveKenwood answers Kenwood CAT commands.
+func serveKenwood(rw io.ReadWriter, rig *stubRig) {
+	rd := bufio.NewReader(rw)
+	for {
+		cmd, err := rd.ReadString(';')
+		if err != nil {
+			return
+		}
+		cmd = cmd[:len(cmd)-1]
+		rig.mu.Lock()
+		rig.cmds = append(rig.cmds, cmd)
+		switch {
+		case cmd == "FA" || cmd == "FB":
+			fmt.Fprintf(rw, "%s%011d;", cmd, rig.freq[cmd[1]-'A'])
+		case len(cmd) > 2 && (cmd[:2] == "FA" || cmd[:2] == "FB"):
+			rig.freq[cmd[1]-'A'], _ = strconv.Atoi(cmd[2:])
+		case cmd == "IF":
+			tx := 0
+			if rig.tx {
+				tx = 1
+			}
+			fmt.Fprintf(rw, "IF%011d     +000000000%d%d%d0000 ;", rig.freq[rig.vfo], tx, rig.mode, rig.vfo)
+		case cmd == "MD":
+			fmt.Fprintf(rw, "MD%d;", rig.mode)
+		case len(cmd) == 3 && cmd[:2] == "MD":
+			rig.mode = int(cmd[2] - '0')
+		case cmd == "TX" || cmd == "RX":
+			rig.tx = cmd == "TX"
+		default:
+			fmt.Fprint(rw, "?;")
+		}
+		rig.mu.Unlock()
+	}
+}
+
+// serveIcom answers CI-V commands addressed to 0x94 (IC-7300), echoing every frame as on a CI-V bus.
+func serveIcom(rw io.ReadWriter, rig *stubRig) {
+	rd := bufio.NewReader(rw)
+	for {
+		frame, err := rd.ReadBytes(civEnd)
+		if err != nil {
+			return
+		}
+		rw.Write(frame) // Echo
+		if len(frame) < 6 || frame[2] != 0x94 {
+			continue
+		}
+		cmd := frame[4 : len(frame)-1]
+		rig.mu.Lock()
+		rig.cmds = append(rig.cmds, fmt.Sprintf("% X", cmd))
+
+		resp := []byte{civNG}
+		switch cmd[0] {
+		case civCmdReadFreq:
+			resp = append([]byte{civCmdReadFreq}, freqToBCD(rig.freq[rig.vfo])...)
+		case civCmdSetFreq:
+			rig.freq[rig.vfo], _ = bcdToFreq(cmd[1:])
+			resp = []byte{civOK}
+		case civCmdReadMode:
+			resp = []byte{civCmdReadMode, byte(rig.mode), 0x01}
+		case civCmdSetMode:
+			rig.mode, resp = int(cmd[1]), []byte{civOK}
+		case civCmdSelectVFO:
+			rig.vfo, resp = int(cmd[1]), []byte{civOK}
+		case civCmdPTT:
+			switch {
+			case len(cmd) == 3:
+				rig.tx, resp = cmd[2] == 0x01, []byte{civOK}
+			case rig.tx:
+				resp = []byte{civCmdPTT, 0x00, 0x01}
+			default:
+				resp = []byte{civCmdPTT, 0x00, 0x00}
+			}
+		}
+		rig.mu.Unlock()
+		rw.Write(append(append([]byte{civPreamble, civPreamble, civController, 0x94}, resp...), civEnd))
+	}
+}
+
+// state returns a copy of the rig's state.
+func (rig *stubRig) state() (freq [2]int, mode int, cmds []string) {
+	rig.mu.Lock()
+	defer rig.mu.Unlock()
+	return rig.freq, rig.mode, append([]string{}, rig.cmds...)
+}
+
+// testCATRig exercises frequency, mode and PTT control through the backend-agnostic VFO interface.
+func testCATRig(t *testing.T, uri string, rig *stubRig, usbMode int) {
+	r, err := Open("serial", uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	vfoA, _ := r.VFOA()
+	vfoB, _ := r.VFOB()
+	if err := vfoA.SetFreq(14105000); err != nil {
+		t.Fatal(err)
+	}
+	if err := vfoB.SetFreq(7050000); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := vfoA.GetFreq(); err != nil || got != 14105000 {
+		t.Errorf("Got VFO A frequency %d (%v), expected 14105000", got, err)
+	}
+	if got, err := vfoB.GetFreq(); err != nil || got != 7050000 {
+		t.Errorf("Got VFO B frequency %d (%v), expected 7050000", got, err)
+	}
+	if freq, _, _ := rig.state(); freq != [2]int{14105000, 7050000} {
+		t.Errorf("Got rig frequencies %v", freq)
+	}
+
+	if err := vfoA.SetFreq(14105000); err != nil { // Make VFO A the active VFO of Icom rigs
+		t.Fatal(err)
+	}
+	if got, err := r.CurrentVFO().GetFreq(); err != nil || got != 14105000 {
+		t.Errorf("Got current frequency %d (%v), expected 14105000", got, err)
+	}
+
+	type modeVFO interface {
+		SetMode(m Mode, pbw int) error
+		GetMode() (Mode, int, error)
+	}
+	vfo := r.CurrentVFO().(modeVFO)
+	if err := vfo.SetMode(USB, 0); err != nil {
+		t.Fatal(err)
+	}
+	if m, _, err := vfo.GetMode(); err != nil || m != USB {
+		t.Errorf("Got mode %s (%v), expected USB", m, err)
+	}
+	if _, mode, _ := rig.state(); mode != usbMode {
+		t.Errorf("Got rig mode %d, expected %d", mode, usbMode)
+	}
+	if err := vfo.SetMode(SAM, 0); err == nil {
+		t.Errorf("Expected error setting unsupported mode")
+	}
+
+	for _, on := range []bool{true, false} {
+		if err := r.CurrentVFO().SetPTT(on); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := r.CurrentVFO().GetPTT(); err != nil || got != on {
+			t.Errorf("Got PTT %t (%v), expected %t", got, err, on)
+		}
+	}
+}
+
+func TestCATKenwood(t *testing.T) {
+	rig := &stubRig{}
+	path, baudrate := stubSerial(t, rig, serveKenwood)
+	testCATRig(t, path+"?model=2031&baudrate=9600", rig, 2)
+	if *baudrate != 9600 {
+		t.Errorf("Got baudrate %d, expected 9600", *baudrate)
+	}
+}
+
+func TestCATIcom(t *testing.T) {
+	rig := &stubRig{}
+	path, _ := stubSerial(t, rig, serveIcom)
+	testCATRig(t, path+"?model=3073&baudrate=19200", rig, 0x01)
+
+	// Unknown Icom model with CI-V address override
+	_, _, before := rig.state()
+	r, err := OpenSerialURI(path + "?model=3999&baudrate=19200&civaddr=94")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.CurrentVFO().GetFreq(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, cmds := rig.state(); !reflect.DeepEqual(cmds[len(before):], []string{"03"}) {
+		t.Errorf("Got commands %q, expected [\"03\"]", cmds[len(before):])
+	}
+}
+
+func TestCATRejected(t *testing.T) {
+	rig := &stubRig{}
+	path, _ := stubSerial(t, rig, func(rw io.ReadWriter, _ *stubRig) {
+		buf := make([]byte, 64)
+		for {
+			if _, err := rw.Read(buf); err != nil {
+				return
+			}
+			fmt.Fprint(rw, "?;")
+		}
+	})
+	r, err := OpenSerialURI(path + "?model=2028&baudrate=9600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.CurrentVFO().GetFreq(); err != ErrCommandRejected {
+		t.Errorf("Got %v, expected ErrCommandRejected", err)
+	}
+}
+
+func TestOpenSerialURIErrors(t *testing.T) {
+	stubSerial(t, &stubRig{}, func(io.ReadWriter, *stubRig) {})
+	tests := map[string]string{
+		"/dev/ttyUSB0?baudrate=9600":                        "Missing model parameter",
+		"/dev/ttyUSB0?model=2031":                           "Missing baudrate parameter",
+		"/dev/ttyUSB0?model=1035&baudrate=9600":             ErrUnsupportedModel.Error(),
+		"/dev/ttyUSB0?model=3999&baudrate=9600":             "Missing CI-V address for Icom model 3999",
+		"/dev/ttyUSB0?model=3073&baudrate=9600&civaddr=xyz": "Invalid civaddr format",
+	}
+	for uri, expect := range tests {
+		if _, err := OpenSerialURI(uri); err == nil || err.Error() != expect {
+			t.Errorf("'%s': Got %v, expected '%s'", uri, err, expect)
+		}
+	}
+}
+
+func TestCIVFrequencyBCD(t *testing.T) {
+	tests := map[int][]byte{
+		14105000:   {0x00, 0x50, 0x10, 0x14, 0x00},
+		7050000:    {0x00, 0x00, 0x05, 0x07, 0x00},
+		1296123450: {0x50, 0x34, 0x12, 0x96, 0x12},
+	}
+	for freq, bcd := range tests {
+		if got := freqToBCD(freq); !bytes.Equal(got, bcd) {
+			t.Errorf("%d: Got % X, expected % X", freq, got, bcd)
+		}
+		if got, err := bcdToFreq(bcd); err != nil || got != freq {
+			t.Errorf("% X: Got %d (%v), expected %d", bcd, got, err, freq)
+		}
+	}
+	if _, err := bcdToFreq([]byte{0x0A, 0, 0, 0, 0}); err == nil {
+		t.Errorf("Expected error on invalid BCD")
+	}
+}
@@ -5,10 +5,14 @@
 // Package hamlib provides bindings for a _subset_ of hamlib.
 // It provides both native cgo bindings and a rigctld client.
 //
-// Use build tag "libhamlib" to build with native C library support.
+// Use build tag "libhamlib" to build with native C library support. Without it, serial
+// rigs are controlled by a pure Go CAT backend supporting a few Kenwood and Icom rigs (see CATRig).
 package hamlib
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 // RigModel is the hamlib ID identifying a spesific tranceiver model.
 type RigModel int
@@ -47,6 +51,23 @@ type VFO interface {
 	SetPTT(on bool) error
 }
 
+// ContextVFO is implemented by VFOs supporting cancellation of blocking operations.
+type ContextVFO interface {
+	VFO
+
+	// GetFreqContext is like GetFreq, but aborts if the context is cancelled.
+	GetFreqContext(ctx context.Context) (int, error)
+
+	// SetFreqContext is like SetFreq, but aborts if the context is cancelled.
+	SetFreqContext(ctx context.Context, f int) error
+
+	// GetPTTContext is like GetPTT, but aborts if the context is cancelled.
+	GetPTTContext(ctx context.Context) (bool, error)
+
+	// SetPTTContext is like SetPTT, but aborts if the context is cancelled.
+	SetPTTContext(ctx context.Context, on bool) error
+}
+
 func Open(network, address string) (Rig, error) {
 	switch network {
 	case "tcp":
@@ -57,3 +78,24 @@ func Open(network, address string) (Rig, error) {
 		return nil, fmt.Errorf("Unknown network")
 	}
 }
+
+// OpenContext is like Open, but the connection to the rig is established
+// immediately and aborted if the context is cancelled before it's ready.
+//
+// The context only applies to the opening of the rig.
+func OpenContext(ctx context.Context, network, address string) (Rig, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	switch network {
+	case "tcp":
+		r, _ := OpenTCP(address)
+		if err := r.PingContext(ctx); err != nil {
+			r.Close()
+			return nil, err
+		}
+		return r, nil
+	default:
+		return Open(network, address)
+	}
+}
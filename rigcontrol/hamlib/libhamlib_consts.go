@@ -14,26 +14,26 @@ type Mode int
 
 const (
 	NoMode  Mode = C.RIG_MODE_NONE
-	AM           = C.RIG_MODE_AM
-	CW           = C.RIG_MODE_CW
-	USB          = C.RIG_MODE_USB
-	LSB          = C.RIG_MODE_LSB
-	RTTY         = C.RIG_MODE_RTTY
-	FM           = C.RIG_MODE_FM
-	WFM          = C.RIG_MODE_WFM
-	CWR          = C.RIG_MODE_CWR
-	RTTYR        = C.RIG_MODE_RTTYR
-	AMS          = C.RIG_MODE_AMS
-	PKTLSB       = C.RIG_MODE_PKTLSB
-	PKTUSB       = C.RIG_MODE_PKTUSB
-	PKTFM        = C.RIG_MODE_PKTFM
-	ECSSUSB      = C.RIG_MODE_ECSSUSB
-	ECSSLSB      = C.RIG_MODE_ECSSLSB
-	FAX          = C.RIG_MODE_FAX
-	SAM          = C.RIG_MODE_SAM
-	SAL          = C.RIG_MODE_SAL
-	SAH          = C.RIG_MODE_SAH
-	DSB          = C.RIG_MODE_DSB
+	AM      Mode = C.RIG_MODE_AM
+	CW      Mode = C.RIG_MODE_CW
+	USB     Mode = C.RIG_MODE_USB
+	LSB     Mode = C.RIG_MODE_LSB
+	RTTY    Mode = C.RIG_MODE_RTTY
+	FM      Mode = C.RIG_MODE_FM
+	WFM     Mode = C.RIG_MODE_WFM
+	CWR     Mode = C.RIG_MODE_CWR
+	RTTYR   Mode = C.RIG_MODE_RTTYR
+	AMS     Mode = C.RIG_MODE_AMS
+	PKTLSB  Mode = C.RIG_MODE_PKTLSB
+	PKTUSB  Mode = C.RIG_MODE_PKTUSB
+	PKTFM   Mode = C.RIG_MODE_PKTFM
+	ECSSUSB Mode = C.RIG_MODE_ECSSUSB
+	ECSSLSB Mode = C.RIG_MODE_ECSSLSB
+	FAX     Mode = C.RIG_MODE_FAX
+	SAM     Mode = C.RIG_MODE_SAM
+	SAL     Mode = C.RIG_MODE_SAL
+	SAH     Mode = C.RIG_MODE_SAH
+	DSB     Mode = C.RIG_MODE_DSB
 )
 
 type PowerState int
@@ -0,0 +1,52 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package hamlib
+
+import (
+	"fmt"
+	"strings"
+)
+
+var modeStrings = map[Mode]string{
+	AM:      "AM",
+	CW:      "CW",
+	USB:     "USB",
+	LSB:     "LSB",
+	RTTY:    "RTTY",
+	FM:      "FM",
+	WFM:     "WFM",
+	CWR:     "CWR",
+	RTTYR:   "RTTYR",
+	AMS:     "AMS",
+	PKTLSB:  "PKTLSB",
+	PKTUSB:  "PKTUSB",
+	PKTFM:   "PKTFM",
+	ECSSUSB: "ECSSUSB",
+	ECSSLSB: "ECSSLSB",
+	FAX:     "FAX",
+	SAM:     "SAM",
+	SAL:     "SAL",
+	SAH:     "SAH",
+	DSB:     "DSB",
+}
+
+// StringToMode returns the Mode represented by the given hamlib mode string (e.g. "PKTUSB").
+func StringToMode(str string) (Mode, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	for m, s := range modeStrings {
+		if s == str {
+			return m, nil
+		}
+	}
+	return NoMode, fmt.Errorf("Unknown mode '%s'", str)
+}
+
+// String returns the hamlib string representation of the mode.
+func (m Mode) String() string {
+	if s, ok := modeStrings[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
@@ -0,0 +1,35 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+//go:build !cgo || !libhamlib
+// +build !cgo !libhamlib
+
+package hamlib
+
+type Mode int
+
+// The mode values are identical to hamlib's rmode_t bit flags.
+const (
+	NoMode  Mode = 0
+	AM      Mode = 1 << 0
+	CW      Mode = 1 << 1
+	USB     Mode = 1 << 2
+	LSB     Mode = 1 << 3
+	RTTY    Mode = 1 << 4
+	FM      Mode = 1 << 5
+	WFM     Mode = 1 << 6
+	CWR     Mode = 1 << 7
+	RTTYR   Mode = 1 << 8
+	AMS     Mode = 1 << 9
+	PKTLSB  Mode = 1 << 10
+	PKTUSB  Mode = 1 << 11
+	PKTFM   Mode = 1 << 12
+	ECSSUSB Mode = 1 << 13
+	ECSSLSB Mode = 1 << 14
+	FAX     Mode = 1 << 15
+	SAM     Mode = 1 << 16
+	SAL     Mode = 1 << 17
+	SAH     Mode = 1 << 18
+	DSB     Mode = 1 << 19
+)
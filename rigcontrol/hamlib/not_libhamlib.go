@@ -2,16 +2,75 @@
 // Use of this source code is governed by the MIT-license that can be
 // found in the LICENSE file.
 
-// +build !cgo, !libhamlib
+//go:build !cgo || !libhamlib
+// +build !cgo !libhamlib
 
 package hamlib
 
-import "errors"
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
 
-var errNotAvailable = errors.New("Not available in this build")
+// OpenSerialURI connects to the transceiver using the native Go CAT backend (see CATRig) and returns a ready to use Rig.
+//
+// Expects a valid URI with path to a tty or COM-port.
+// Additional query parameters:
+//
+//	model    (integer, hamlib model number)
+//	baudrate (integer)
+//	civaddr  (hex, optional CI-V address for Icom rigs)
+//
+// E.g. "/dev/ttyUSB0?model=3073&baudrate=19200".
+//
+// Use build tag 'libhamlib' for hamlib's full rig support.
+//
+// Caller must remember to Close the Rig after use.
+func OpenSerialURI(uri string) (Rig, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid address format")
+	}
 
-// OpenSerialURI is here for compatibility (use build tag 'libhamlib').
-func OpenSerialURI(uri string) (Rig, error) { return nil, errNotAvailable }
+	modelStr := u.Query().Get("model")
+	if modelStr == "" {
+		return nil, fmt.Errorf("Missing model parameter")
+	}
+	model, err := strconv.Atoi(modelStr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid model format")
+	}
 
-// Rigs is here for compatibility (use build tag 'libhamlib').
-func Rigs() map[RigModel]string { return map[RigModel]string{} }
+	baudStr := u.Query().Get("baudrate")
+	if baudStr == "" {
+		return nil, fmt.Errorf("Missing baudrate parameter")
+	}
+	baudrate, err := strconv.Atoi(baudStr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid baudrate format")
+	}
+
+	var civAddr uint64
+	if str := u.Query().Get("civaddr"); str != "" {
+		if civAddr, err = strconv.ParseUint(str, 16, 8); err != nil || civAddr == 0 {
+			return nil, fmt.Errorf("Invalid civaddr format")
+		}
+	}
+
+	rig, err := openCAT(RigModel(model), u.Path, baudrate, byte(civAddr))
+	if err != nil {
+		return nil, err
+	}
+	return rig, nil
+}
+
+// Rigs returns a map from RigModel to description (manufacturer and model) of the rigs known to
+// the CAT backend (use build tag 'libhamlib' for all rigs known to hamlib).
+func Rigs() map[RigModel]string {
+	list := make(map[RigModel]string, len(catModels))
+	for model, m := range catModels {
+		list[model] = m.name
+	}
+	return list
+}
@@ -5,6 +5,7 @@
 package hamlib
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -33,6 +34,7 @@ type TCPRig struct {
 	conn    *textproto.Conn
 	tcpConn net.Conn
 	addr    string
+	modes   []Mode // Cached list of supported modes (per connection)
 }
 
 // VFO (Variable Frequency Oscillator) represents a tunable channel,
@@ -58,18 +60,63 @@ func OpenTCP(addr string) (*TCPRig, error) {
 // Ping checks that a connection to rigctld is open and valid.
 //
 // If no connection is active, it will try to establish one.
-func (r *TCPRig) Ping() error { _, err := r.cmd(`dump_caps`); return err }
+func (r *TCPRig) Ping() error { return r.PingContext(context.Background()) }
 
-func (r *TCPRig) dial() (err error) {
+// PingContext is like Ping, but aborts if the context is cancelled before rigctld responds.
+func (r *TCPRig) PingContext(ctx context.Context) error {
+	_, err := r.cmdContext(ctx, `dump_caps`)
+	return err
+}
+
+// SupportedModes returns the list of modes supported by the rig.
+//
+// The list is parsed from rigctld's capabilities dump and cached for
+// the lifetime of the connection. Modes not known to this package
+// are omitted.
+func (r *TCPRig) SupportedModes() ([]Mode, error) {
+	r.mu.Lock()
+	modes := r.modes
+	r.mu.Unlock()
+	if modes != nil {
+		return modes, nil
+	}
+
+	lines, err := r.cmdLines(context.Background(), true, `\dump_caps`)
+	if err != nil {
+		return nil, err
+	}
+
+	modes = []Mode{}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "Mode list:") {
+			continue
+		}
+		for _, str := range strings.Fields(strings.TrimPrefix(line, "Mode list:")) {
+			if m, err := StringToMode(str); err == nil {
+				modes = append(modes, m)
+			}
+		}
+		break
+	}
+
+	r.mu.Lock()
+	r.modes = modes
+	r.mu.Unlock()
+	return modes, nil
+}
+
+func (r *TCPRig) dial(ctx context.Context) (err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if r.conn != nil {
 		r.conn.Close()
 	}
+	r.modes = nil
 
-	// Dial with 3 second timeout
-	r.tcpConn, err = net.DialTimeout("tcp", r.addr, TCPTimeout)
+	// Dial with TCPTimeout
+	d := net.Dialer{Timeout: TCPTimeout}
+	r.tcpConn, err = d.DialContext(ctx, "tcp", r.addr)
 	if err != nil {
 		return err
 	}
@@ -124,9 +171,55 @@ func (r *TCPRig) VFOMode() (bool, error) {
 	return strings.TrimPrefix(resp, "CHKVFO ") == "1", nil
 }
 
+// WatchFreq polls the dial frequency of the current VFO at the given interval.
+//
+// The current frequency is sent on the returned channel first, then only when it changes.
+// The channel is closed when ctx is done or when polling fails (after the usual reconnect
+// attempts). An error is returned if the initial frequency can't be read.
+func (r *TCPRig) WatchFreq(ctx context.Context, interval time.Duration) (<-chan int, error) {
+	vfo := &tcpVFO{r, ""}
+	freq, err := vfo.GetFreqContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan int, 1)
+	c <- freq
+	go func() {
+		defer close(c)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			f, err := vfo.GetFreqContext(ctx)
+			if err != nil {
+				return
+			}
+			if f == freq {
+				continue
+			}
+			freq = f
+			select {
+			case c <- freq:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
 // Gets the dial frequency for this VFO.
-func (v *tcpVFO) GetFreq() (int, error) {
-	resp, err := v.cmd(`\get_freq`)
+func (v *tcpVFO) GetFreq() (int, error) { return v.GetFreqContext(context.Background()) }
+
+// GetFreqContext is like GetFreq, but aborts if the context is cancelled.
+func (v *tcpVFO) GetFreqContext(ctx context.Context) (int, error) {
+	resp, err := v.cmd(ctx, `\get_freq`)
 	if err != nil {
 		return -1, err
 	}
@@ -140,14 +233,20 @@ func (v *tcpVFO) GetFreq() (int, error) {
 }
 
 // Sets the dial frequency for this VFO.
-func (v *tcpVFO) SetFreq(freq int) error {
-	_, err := v.cmd(`\set_freq %d`, freq)
+func (v *tcpVFO) SetFreq(freq int) error { return v.SetFreqContext(context.Background(), freq) }
+
+// SetFreqContext is like SetFreq, but aborts if the context is cancelled.
+func (v *tcpVFO) SetFreqContext(ctx context.Context, freq int) error {
+	_, err := v.cmd(ctx, `\set_freq %d`, freq)
 	return err
 }
 
 // GetPTT returns the PTT state for this VFO.
-func (v *tcpVFO) GetPTT() (bool, error) {
-	resp, err := v.cmd("t")
+func (v *tcpVFO) GetPTT() (bool, error) { return v.GetPTTContext(context.Background()) }
+
+// GetPTTContext is like GetPTT, but aborts if the context is cancelled.
+func (v *tcpVFO) GetPTTContext(ctx context.Context) (bool, error) {
+	resp, err := v.cmd(ctx, "t")
 	if err != nil {
 		return false, err
 	}
@@ -163,7 +262,10 @@ func (v *tcpVFO) GetPTT() (bool, error) {
 }
 
 // Enable (or disable) PTT on this VFO.
-func (v *tcpVFO) SetPTT(on bool) error {
+func (v *tcpVFO) SetPTT(on bool) error { return v.SetPTTContext(context.Background(), on) }
+
+// SetPTTContext is like SetPTT, but aborts if the context is cancelled.
+func (v *tcpVFO) SetPTTContext(ctx context.Context, on bool) error {
 	bInt := 0
 	if on {
 		bInt = 1
@@ -172,35 +274,61 @@ func (v *tcpVFO) SetPTT(on bool) error {
 		}
 	}
 
-	_, err := v.cmd(`\set_ptt %d`, bInt)
+	_, err := v.cmd(ctx, `\set_ptt %d`, bInt)
 	return err
 }
 
-func (v *tcpVFO) cmd(format string, args ...interface{}) (string, error) {
+func (v *tcpVFO) cmd(ctx context.Context, format string, args ...interface{}) (string, error) {
 	// Add VFO argument (if set)
 	if v.prefix != "" {
 		parts := strings.Split(format, " ")
 		parts = append([]string{parts[0], v.prefix}, parts[1:]...)
 		format = strings.Join(parts, " ")
 	}
-	return v.r.cmd(format, args...)
+	return v.r.cmdContext(ctx, format, args...)
 }
 
 func (r *TCPRig) cmd(format string, args ...interface{}) (resp string, err error) {
+	return r.cmdContext(context.Background(), format, args...)
+}
+
+func (r *TCPRig) cmdContext(ctx context.Context, format string, args ...interface{}) (string, error) {
+	lines, err := r.cmdLines(ctx, false, format, args...)
+	if len(lines) == 0 {
+		return "", err
+	}
+	return lines[0], err
+}
+
+// cmdLines executes the command and returns the response lines.
+//
+// If multiline is true, lines are read until the terminating RPRT line.
+func (r *TCPRig) cmdLines(ctx context.Context, multiline bool, format string, args ...interface{}) (resp []string, err error) {
 	// Retry
 	for i := 0; i < 3; i++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
 		if r.conn == nil {
 			// Try re-dialing
-			if err = r.dial(); err != nil {
+			if err = r.dial(ctx); err != nil {
 				break
 			}
 		}
 
-		resp, err = r.doCmd(format, args...)
+		resp, err = r.doCmd(ctx, multiline, format, args...)
 		if err == nil {
 			break
 		}
 
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// The connection state is unknown after an aborted command
+			r.conn.Close()
+			r.conn = nil
+			return nil, ctxErr
+		}
+
 		_, isNetError := err.(net.Error)
 		if err == io.EOF || isNetError {
 			r.conn = nil
@@ -209,29 +337,78 @@ func (r *TCPRig) cmd(format string, args ...interface{}) (resp string, err error
 	return resp, err
 }
 
-func (r *TCPRig) doCmd(format string, args ...interface{}) (string, error) {
-	r.tcpConn.SetDeadline(time.Now().Add(TCPTimeout))
+func (r *TCPRig) doCmd(ctx context.Context, multiline bool, format string, args ...interface{}) ([]string, error) {
+	// Each read/write is limited by TCPTimeout and ctx's deadline. If ctx is cancelled, the deadline is
+	// expired to unblock any pending read/write, and kept expired for the rest of the command.
+	var (
+		mu        sync.Mutex
+		cancelled bool
+	)
+	setDeadline := func(t time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cancelled {
+			t = time.Now()
+		}
+		r.tcpConn.SetDeadline(t)
+	}
+	timeout := func() time.Time {
+		t := time.Now().Add(TCPTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(t) {
+			return d
+		}
+		return t
+	}
+	stop, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			cancelled = true
+			r.tcpConn.SetDeadline(time.Now())
+			mu.Unlock()
+		case <-stop:
+		}
+	}()
+	defer func() { close(stop); <-exited }()
+
+	setDeadline(timeout())
 	id, err := r.conn.Cmd(format, args...)
-	r.tcpConn.SetDeadline(time.Time{})
+	setDeadline(time.Time{})
 
 	if err != nil {
-		return "", err
+		return nil, err
+	} else if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	r.conn.StartResponse(id)
 	defer r.conn.EndResponse(id)
 
-	r.tcpConn.SetDeadline(time.Now().Add(TCPTimeout))
-	resp, err := r.conn.ReadLine()
-	r.tcpConn.SetDeadline(time.Time{})
+	var lines []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-	if err != nil {
-		return "", err
-	} else if err := toError(resp); err != nil {
-		return resp, err
-	}
+		setDeadline(timeout())
+		resp, err := r.conn.ReadLine()
+		setDeadline(time.Time{})
 
-	return resp, nil
+		if err != nil {
+			return nil, err
+		} else if err := toError(resp); err != nil {
+			return append(lines, resp), err
+		}
+
+		if !multiline {
+			return []string{resp}, nil
+		} else if strings.HasPrefix(resp, "RPRT ") {
+			return lines, nil
+		}
+		lines = append(lines, resp)
+	}
 }
 
 func toError(str string) error {
@@ -0,0 +1,269 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package hamlib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRigctld answers \get_freq with the given frequencies, one per request.
+//
+// The last frequency is repeated once the list is exhausted.
+func fakeRigctld(t *testing.T, freqs ...int) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rd := bufio.NewReader(conn)
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.TrimSpace(line) {
+			case `\get_freq`:
+				fmt.Fprintf(conn, "%d\n", freqs[0])
+				if len(freqs) > 1 {
+					freqs = freqs[1:]
+				}
+			default:
+				fmt.Fprint(conn, "RPRT -11\n")
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestWatchFreq(t *testing.T) {
+	rig, _ := OpenTCP(fakeRigctld(t, 7050000, 7050000, 7052000, 7052000, 7052000, 14105000))
+	defer rig.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := rig.WatchFreq(ctx, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int
+	timeout := time.After(time.Second)
+	for len(got) < 3 {
+		select {
+		case f := <-c:
+			got = append(got, f)
+		case <-timeout:
+			t.Fatalf("Timeout waiting for frequency changes. Got %v", got)
+		}
+	}
+	if expect := []int{7050000, 7052000, 14105000}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got %v, expected %v", got, expect)
+	}
+
+	cancel()
+	select {
+	case f, ok := <-c:
+		if ok {
+			t.Errorf("Got unexpected frequency %d after cancel", f)
+		}
+	case <-time.After(time.Second):
+		t.Error("Channel not closed after cancel")
+	}
+}
+
+// stallingRigctld answers every command with the given lines, then stalls without completing the response.
+func stallingRigctld(t *testing.T, lines ...string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done); ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					if _, err := rd.ReadString('\n'); err != nil {
+						return
+					}
+					for _, line := range lines {
+						fmt.Fprintf(conn, "%s\n", line)
+					}
+				}
+			}()
+			go func() { <-done; conn.Close() }()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestCmdContextCancelMidResponse(t *testing.T) {
+	defer func(d time.Duration) { TCPTimeout = d }(TCPTimeout)
+	TCPTimeout = 10 * time.Second
+
+	rig, _ := OpenTCP(stallingRigctld(t, "Caps dump for model: 1", "Model name: Dummy"))
+	defer rig.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := rig.cmdLines(ctx, true, `\dump_caps`); err != context.Canceled {
+		t.Errorf("Got %v, expected context.Canceled", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Cancelled command returned after %s", d)
+	}
+}
+
+func TestOpenContext(t *testing.T) {
+	defer func(d time.Duration) { TCPTimeout = d }(TCPTimeout)
+	TCPTimeout = 10 * time.Second
+
+	// The deadline may expire while dialing (i/o timeout) or while waiting for the stalled response
+	isTimeout := func(err error) bool {
+		var netErr net.Error
+		return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := OpenContext(ctx, "tcp", stallingRigctld(t)); !isTimeout(err) {
+		t.Errorf("Got %v, expected a timeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("OpenContext returned after %s", d)
+	}
+
+	<-ctx.Done()
+	if _, err := OpenContext(ctx, "tcp", stallingRigctld(t)); err != context.DeadlineExceeded {
+		t.Errorf("Got %v for expired context, expected context.DeadlineExceeded", err)
+	}
+}
+
+func TestContextCommands(t *testing.T) {
+	defer func(d time.Duration) { TCPTimeout = d }(TCPTimeout)
+	TCPTimeout = 10 * time.Second
+
+	rig, _ := OpenTCP(stallingRigctld(t))
+	defer rig.Close()
+	vfo := rig.CurrentVFO().(*tcpVFO)
+
+	tests := map[string]func(ctx context.Context) error{
+		"PingContext":    rig.PingContext,
+		"GetFreqContext": func(ctx context.Context) error { _, err := vfo.GetFreqContext(ctx); return err },
+		"SetFreqContext": func(ctx context.Context) error { return vfo.SetFreqContext(ctx, 7050000) },
+		"GetPTTContext":  func(ctx context.Context) error { _, err := vfo.GetPTTContext(ctx); return err },
+		"SetPTTContext":  func(ctx context.Context) error { return vfo.SetPTTContext(ctx, true) },
+	}
+	for name, fn := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		start := time.Now()
+		if err := fn(ctx); err != context.Canceled {
+			t.Errorf("%s: Got %v, expected context.Canceled", name, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: Cancelled command returned after %s", name, d)
+		}
+	}
+}
+
+func TestSupportedModes(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Each connection reports its own mode list (including modes unknown to this package)
+	modeLists := []string{"AM CW FOO USB", "PKTUSB BAR"}
+	dumps := make(chan struct{}, 10)
+	go func() {
+		for _, modeList := range modeLists {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(modeList string) {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					line, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch strings.TrimSpace(line) {
+					case `\dump_caps`:
+						dumps <- struct{}{}
+						fmt.Fprint(conn, "Caps dump for model: 1\n")
+						fmt.Fprintf(conn, "Mode list: %s\n", modeList)
+						fmt.Fprint(conn, "RPRT 0\n")
+					case `\get_freq`:
+						fmt.Fprint(conn, "7050000\n")
+					}
+				}
+			}(modeList)
+		}
+	}()
+
+	rig, _ := OpenTCP(ln.Addr().String())
+	defer rig.Close()
+
+	for i := 0; i < 2; i++ {
+		modes, err := rig.SupportedModes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := []Mode{AM, CW, USB}; !reflect.DeepEqual(modes, expect) {
+			t.Errorf("Got %v, expected %v", modes, expect)
+		}
+	}
+	if len(dumps) != 1 {
+		t.Errorf("Got %d dump_caps requests, expected 1 (cached)", len(dumps))
+	}
+
+	// The cache is cleared when reconnecting
+	rig.Close()
+	if _, err := rig.CurrentVFO().GetFreq(); err != nil {
+		t.Fatal(err)
+	}
+	modes, err := rig.SupportedModes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []Mode{PKTUSB}; !reflect.DeepEqual(modes, expect) {
+		t.Errorf("Got %v after reconnect, expected %v", modes, expect)
+	}
+	if len(dumps) != 2 {
+		t.Errorf("Got %d dump_caps requests, expected 2", len(dumps))
+	}
+	if got := AM.String(); got != "AM" { // The mode constants are typed
+		t.Errorf("Got %q, expected AM", got)
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
 )
 
 const (
@@ -43,9 +45,15 @@ var (
 	ErrActiveListenerExists = errors.New("An active listener is already registered with this TNC.")
 	ErrDisconnectTimeout    = errors.New("Disconnect timeout: aborted connection.")
 	ErrConnectTimeout       = errors.New("Connect timeout")
+	ErrConnectAborted       = errors.New("Connect aborted")
+	ErrRejectedBusy         = errors.New("Connect rejected: busy channel")
+	ErrRejectedBandwidth    = errors.New("Connect rejected: incompatible bandwidth")
 	ErrChecksumMismatch     = errors.New("Control protocol checksum mismatch")
 	ErrTNCClosed            = errors.New("TNC closed")
 	ErrUnsupportedBandwidth = errors.New("Unsupported ARQ bandwidth")
+	ErrNotIdle              = errors.New("TNC is not idle")
+	ErrInputLevelTimeout    = errors.New("Timeout waiting for input level report")
+	ErrPingTimeout          = errors.New("Ping timeout: TNC not responding")
 )
 
 // Bandwidth definitions of all supported ARQ bandwidths.
@@ -99,6 +107,18 @@ func BandwidthFromString(str string) (Bandwidth, error) {
 	return Bandwidth{}, ErrUnsupportedBandwidth
 }
 
+// BandwidthFromHint returns the ARDOP ARQ bandwidth of a scheme-neutral bandwidth hint.
+//
+// ErrUnsupportedBandwidth is returned if ARDOP does not support the bandwidth.
+func BandwidthFromHint(h transport.BandwidthHint) (Bandwidth, error) {
+	for _, bw := range Bandwidths() {
+		if bw.Forced == h.Forced && int(bw.Max) == h.Hz {
+			return bw, nil
+		}
+	}
+	return Bandwidth{}, ErrUnsupportedBandwidth
+}
+
 // Bandwidths returns a list of all ARDOP ARQ bandwidths.
 func Bandwidths() []Bandwidth {
 	return []Bandwidth{
@@ -120,8 +140,8 @@ var stateMap = map[string]State{
 	"ISS":     ISS,
 	"IRS":     IRS,
 	"IDLE":    Idle,
-	"FECRcv":  FECReceive,
-	"FECSend": FECSend,
+	"FECRCV":  FECReceive,
+	"FECSEND": FECSend,
 }
 
 func strToState(str string) (State, bool) {
@@ -44,12 +44,14 @@ const (
 	cmdFault           command = "FAULT"           // <[string]: Error message
 	cmdBusy            command = "BUSY"            // <[bool]: Returns whether the channel is busy
 	cmdTarget          command = "TARGET"          // <[string]: Identifies the target call sign of the connect request. The target call will be either MYC or one of the MYAUX call signs.
-	cmdCaptureDevices  command = "CATPUREDEVICES"  // Returns a comma delimited list of all currently installed capture devices
+	cmdCaptureDevices  command = "CAPTUREDEVICES"  // Returns a comma delimited list of all currently installed capture devices
 	cmdPlaybackDevices command = "PLAYBACKDEVICES" // Returns a comma delimited list of all currently installed playback devices.
 	cmdAutoBreak       command = "AUTOBREAK"       // <>[bool]: Disables/enables automatic link turnover (BREAK) by IRS when IRS has outbound data pending and receives an IDLE frame from ISS indicating its’ outbound queue is empty. Default is True.
 	cmdSendID          command = "SENDID"
-	cmdFrequency       command = "FREQUENCY"  // <Frequency in Hz>  If TNC Radio control is enabled the FREQUENCY command is sent to the Host upon a change in frequency of the radio. The frequency reported is the DIAL frequency of the radio.
-	cmdInputPeaks      command = "INPUTPEAKS" // Async info sent by ARDOPc
+	cmdFrequency       command = "FREQUENCY"    // <Frequency in Hz>  If TNC Radio control is enabled the FREQUENCY command is sent to the Host upon a change in frequency of the radio. The frequency reported is the DIAL frequency of the radio.
+	cmdInputPeaks      command = "INPUTPEAKS"   // Async info sent by ARDOPc
+	cmdRejectedBW      command = "REJECTEDBW"   // <[string]: Connect request rejected by the remote due to incompatible bandwidth (ARDOPc)
+	cmdRejectedBusy    command = "REJECTEDBUSY" // <[string]: Connect request rejected by the remote due to a busy channel (ARDOPc)
 
 	// Some of the commands that has not been implemented:
 	cmdBreak         command = "BREAK"
@@ -111,6 +113,42 @@ func (msg ctrlMsg) Int() int {
 	return msg.value.(int)
 }
 
+// ConnectedCall returns the remote call sign of a CONNECTED message (e.g. "CONNECTED W1ABC 500").
+func (msg ctrlMsg) ConnectedCall() string {
+	if fields, _ := msg.value.([]string); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// connectError returns the typed error (e.g. ErrRejectedBusy) reported by msg during an ARQ connect.
+//
+// nil is returned if msg does not report a connect failure, or if the failure is unknown.
+func (msg ctrlMsg) connectError() error {
+	switch msg.cmd {
+	case cmdRejectedBusy:
+		return ErrRejectedBusy
+	case cmdRejectedBW:
+		return ErrRejectedBandwidth
+	case cmdAbort:
+		return ErrConnectAborted
+	case cmdFault, cmdStatus:
+		str, _ := msg.value.(string)
+		str = strings.ToUpper(str)
+		switch {
+		case strings.Contains(str, "BUSY"):
+			return ErrRejectedBusy
+		case strings.Contains(str, "REJECTED") && (strings.Contains(str, "BW") || strings.Contains(str, "BANDWIDTH")):
+			return ErrRejectedBandwidth
+		case strings.Contains(str, "ABORT"):
+			return ErrConnectAborted
+		case strings.Contains(str, "TIMEOUT"), strings.Contains(str, "FAILED"):
+			return ErrConnectTimeout
+		}
+	}
+	return nil
+}
+
 func parseCtrlMsg(str string) ctrlMsg {
 	// Work around for ARDOPc trailing space in NEWSTATE
 	str = strings.TrimSpace(str)
@@ -132,14 +170,15 @@ func parseCtrlMsg(str string) ctrlMsg {
 	case cmdCodec, cmdPTT, cmdBusy, cmdTwoToneTest, cmdCWID, cmdListen, cmdAutoBreak, cmdFSKOnly:
 		msg.value = strings.ToLower(parts[1]) == "true"
 
-	// Undocumented
+	// int (undocumented ARDOPc report of the min and max input sample value, e.g. "INPUTPEAKS -1234 5678")
 	case cmdInputPeaks:
+		msg.value = parseInputPeaks(parts[1:])
 
 	// (no params)
 	case cmdAbort, cmdDisconnect, cmdClose, cmdDisconnected, cmdCRCFault, cmdPending, cmdCancelPending, cmdSendID:
 
 	// (echo-back only)
-	case cmdInitialize, cmdARQCall, cmdProtocolMode:
+	case cmdInitialize, cmdARQCall, cmdProtocolMode, cmdFECmode, cmdFECsend, cmdFECrepeats:
 
 	// State
 	case cmdNewState, cmdState:
@@ -147,15 +186,24 @@ func parseCtrlMsg(str string) ctrlMsg {
 
 	// string
 	case cmdFault, cmdMyCall, cmdGridSquare, cmdCapture,
-		cmdPlayback, cmdVersion, cmdTarget, cmdStatus, cmdARQBW:
+		cmdPlayback, cmdVersion, cmdTarget, cmdStatus, cmdARQBW,
+		cmdRejectedBW, cmdRejectedBusy:
 		msg.value = parts[1]
 
 	// []string (space separated)
 	case cmdConnected:
+		if len(parts) < 2 {
+			msg.value = []string{}
+			break
+		}
 		msg.value = parseList(parts[1], " ")
 
 	// []string (comma separated)
 	case cmdCaptureDevices, cmdPlaybackDevices, cmdMyAux:
+		if len(parts) < 2 {
+			msg.value = []string{} // Empty list
+			break
+		}
 		msg.value = parseList(parts[1], ",")
 
 	// int
@@ -173,6 +221,28 @@ func parseCtrlMsg(str string) ctrlMsg {
 	return msg
 }
 
+// parseInputPeaks returns the input level as the largest absolute peak value reported.
+func parseInputPeaks(params []string) int {
+	var level int
+	if len(params) == 0 {
+		return level
+	}
+	for _, str := range strings.Fields(params[0]) {
+		i, err := strconv.Atoi(str)
+		if err != nil {
+			log.Printf("Failed to parse %s value: %s", cmdInputPeaks, err)
+			return 0
+		}
+		if i < 0 {
+			i = -i
+		}
+		if i > level {
+			level = i
+		}
+	}
+	return level
+}
+
 func parseList(str, sep string) []string {
 	parts := strings.Split(str, sep)
 	for i, p := range parts {
@@ -27,6 +27,21 @@ func TestParse(t *testing.T) {
 		"VERSION 1.4.7.0":                   {cmdVersion, "1.4.7.0"},
 		"FREQUENCY 14096400":                {cmdFrequency, 14096400},
 		"ARQBW 200MAX":                      {cmdARQBW, "200MAX"},
+		"CAPTURE Microphone (USB Audio)":    {cmdCapture, "Microphone (USB Audio)"},
+		"CAPTUREDEVICES plughw:1,0,default": {cmdCaptureDevices, []string{"plughw:1", "0", "default"}},
+		"PLAYBACKDEVICES Speakers, ARDOP":   {cmdPlaybackDevices, []string{"Speakers", "ARDOP"}},
+		"PLAYBACKDEVICES":                   {cmdPlaybackDevices, []string{}},
+		"INPUTPEAKS -1234 5678":             {cmdInputPeaks, 5678},
+		"INPUTPEAKS -32768 1024":            {cmdInputPeaks, 32768},
+		"INPUTPEAKS 300":                    {cmdInputPeaks, 300},
+		"INPUTPEAKS":                        {cmdInputPeaks, 0},
+		"INPUTPEAKS foo bar":                {cmdInputPeaks, 0},
+		"CONNECTED LA5NTA-1 500":            {cmdConnected, []string{"LA5NTA-1", "500"}},
+		"CONNECTED":                         {cmdConnected, []string{}},
+		"TARGET N0CALL-10":                  {cmdTarget, "N0CALL-10"},
+		"PENDING":                           {cmdPending, nil},
+		"REJECTEDBW LA5NTA":                 {cmdRejectedBW, "LA5NTA"},
+		"REJECTEDBUSY LA5NTA":               {cmdRejectedBusy, "LA5NTA"},
 	}
 	for input, expected := range tests {
 		got := parseCtrlMsg(input)
@@ -38,3 +53,38 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestConnectedCall(t *testing.T) {
+	tests := map[string]string{
+		"CONNECTED W1ABC 500":     "W1ABC",
+		"CONNECTED LA5NTA-1 2000": "LA5NTA-1",
+		"CONNECTED LA5NTA":        "LA5NTA",
+		"CONNECTED":               "",
+	}
+	for input, expect := range tests {
+		if got := parseCtrlMsg(input).ConnectedCall(); got != expect {
+			t.Errorf("Got '%s', expected '%s' when parsing '%s'", got, expect, input)
+		}
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	tests := map[string]error{
+		"REJECTEDBUSY LA5NTA":                      ErrRejectedBusy,
+		"REJECTEDBW LA5NTA":                        ErrRejectedBandwidth,
+		"ABORT":                                    ErrConnectAborted,
+		"STATUS CONNECT TO LA3F FAILED!":           ErrConnectTimeout,
+		"STATUS ARQ CONNECT REQUEST TIMEOUT":       ErrConnectTimeout,
+		"STATUS ARQ CONNECTION REJECTED BY LA5NTA": nil,
+		"STATUS CONNECT REJECTED BW LA5NTA":        ErrRejectedBandwidth,
+		"STATUS ARQ CONNECT ABORTED":               ErrConnectAborted,
+		"FAULT Channel busy":                       ErrRejectedBusy,
+		"FAULT Not from state FEC":                 nil,
+		"NEWSTATE DISC":                            nil,
+	}
+	for input, expect := range tests {
+		if got := parseCtrlMsg(input).connectError(); got != expect {
+			t.Errorf("Got %v, expected %v when parsing '%s'", got, expect, input)
+		}
+	}
+}
@@ -5,8 +5,6 @@
 package ardop
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -53,9 +51,15 @@ func (conn *tncConn) Read(p []byte) (int, error) {
 		return 0, nil
 	}
 
-	data, ok := <-conn.dataIn
-	if !ok {
-		return 0, io.EOF
+	var data []byte
+	select {
+	case data = <-conn.dataIn:
+	case <-conn.eofChan:
+		select { // Data received before EOF
+		case data = <-conn.dataIn:
+		default:
+			return 0, io.EOF
+		}
 	}
 
 	if len(data) > len(p) {
@@ -78,26 +82,7 @@ func (conn *tncConn) Write(p []byte) (int, error) {
 		p = p[:65535]
 	}
 
-	var buf bytes.Buffer
-
-	//"D:" + 2 byte count big endian + binary data + 2 byte CRC
-
-	// D:
-	if !conn.isTCP {
-		fmt.Fprint(&buf, "D:")
-	}
-
-	// 2 byte length
-	binary.Write(&buf, binary.BigEndian, uint16(len(p)))
-
-	// Binary data
-	n, _ := buf.Write(p)
-
-	// 2 byte CRC
-	if !conn.isTCP {
-		sum := crc16Sum(buf.Bytes()[2:]) // [2:], don't include D: in CRC sum.
-		binary.Write(&buf, binary.BigEndian, sum)
-	}
+	data, n := dataFrame(conn.isTCP, p), len(p)
 
 	r := conn.ctrlIn.Listen()
 	defer r.Close()
@@ -108,7 +93,11 @@ L:
 			return 0, fmt.Errorf("CRC failure")
 		}
 
-		conn.dataOut <- buf.Bytes()
+		select {
+		case conn.dataOut <- data:
+		case <-conn.eofChan:
+			return 0, io.EOF
+		}
 		conn.mu.Lock()
 		conn.nWritten += n
 		conn.mu.Unlock()
@@ -144,11 +133,20 @@ func (conn *tncConn) Flush() error {
 
 func (conn *tncConn) signalClosed() { close(conn.eofChan) }
 
-const flushAndCloseTimeout = 30 * time.Second // TODO: Remove when time is right (see Close).
+// sendCtrl writes a command to the TNC, unless the connection is already closed.
+func (conn *tncConn) sendCtrl(cmd command) {
+	select {
+	case conn.ctrlOut <- string(cmd):
+	case <-conn.eofChan:
+	}
+}
+
+var flushAndCloseTimeout = 30 * time.Second // TODO: Remove when time is right (see Close).
 
 // Close closes the current connection.
 //
-// Will abort ("dirty disconnect") after 30 seconds if normal "disconnect" have not succeeded yet.
+// Will abort ("dirty disconnect") if the transmit buffer is not flushed within 30 seconds,
+// or after another 30 seconds if normal "disconnect" have not succeeded yet.
 func (conn *tncConn) Close() error {
 	if conn == nil {
 		return nil
@@ -175,13 +173,20 @@ func (conn *tncConn) Close() error {
 	// }
 	select {
 	case <-conn.flushLock.WaitChan():
+	case <-conn.eofChan:
+		// The link is already gone, nothing left to disconnect.
+		return nil
 	case <-time.After(flushAndCloseTimeout):
+		// The buffer never drained (dead link?). A normal disconnect would most
+		// likely hang just as long, so go straight for the dirty disconnect.
+		conn.sendCtrl(cmdAbort)
+		return ErrFlushTimeout
 	}
 
 	r := conn.ctrlIn.Listen()
 	defer r.Close()
 
-	conn.ctrlOut <- string(cmdDisconnect)
+	conn.sendCtrl(cmdDisconnect)
 	timeout := time.After(flushAndCloseTimeout)
 	for {
 		select {
@@ -195,7 +200,7 @@ func (conn *tncConn) Close() error {
 				return nil
 			}
 		case <-timeout:
-			conn.ctrlOut <- string(cmdAbort)
+			conn.sendCtrl(cmdAbort)
 			return ErrDisconnectTimeout
 		}
 	}
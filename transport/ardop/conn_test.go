@@ -0,0 +1,44 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package ardop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCloseFlushTimeoutAborts(t *testing.T) {
+	defer func(d time.Duration) { flushAndCloseTimeout = d }(flushAndCloseTimeout)
+	flushAndCloseTimeout = 50 * time.Millisecond
+
+	ctrlOut := make(chan string, 1)
+	conn := &tncConn{
+		ctrlOut: ctrlOut,
+		ctrlIn:  newBroadcaster(),
+		eofChan: make(chan struct{}),
+	}
+	conn.flushLock.Lock() // The buffer never confirms
+
+	errs := make(chan error, 1)
+	go func() { errs <- conn.Close() }()
+
+	select {
+	case err := <-errs:
+		if err != ErrFlushTimeout {
+			t.Errorf("Expected ErrFlushTimeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return within the expected time")
+	}
+
+	select {
+	case cmd := <-ctrlOut:
+		if cmd != string(cmdAbort) {
+			t.Errorf("Expected %s, got %s", cmdAbort, cmd)
+		}
+	default:
+		t.Error("Close did not escalate to dirty disconnect")
+	}
+}
@@ -6,6 +6,7 @@ package ardop
 
 import (
 	"log"
+	"sync"
 	"time"
 )
 
@@ -58,16 +59,20 @@ func (r rawReceiver) doneChan() <-chan struct{} {
 }
 
 type broadcaster struct {
-	msgs     chan ctrlMsg  // send on this will broadcast
-	register chan receiver // send on this will register
+	msgs      chan ctrlMsg  // send on this will broadcast
+	register  chan receiver // send on this will register
+	done      chan struct{} // closed by Close
+	closeOnce *sync.Once
 }
 
 func newBroadcaster() broadcaster {
 	receivers := make([]receiver, 0, 1)
 
 	b := broadcaster{
-		msgs:     make(chan ctrlMsg),
-		register: make(chan receiver),
+		msgs:      make(chan ctrlMsg),
+		register:  make(chan receiver),
+		done:      make(chan struct{}),
+		closeOnce: new(sync.Once),
 	}
 
 	go func() {
@@ -80,12 +85,11 @@ func newBroadcaster() broadcaster {
 
 		for {
 			select {
+			case <-b.done:
+				return
 			case r := <-b.register:
 				receivers = append(receivers, r)
-			case msg, ok := <-b.msgs:
-				if !ok {
-					return
-				}
+			case msg := <-b.msgs:
 				for i := 0; i < len(receivers); i++ {
 					r := receivers[i]
 					select {
@@ -117,7 +121,11 @@ func (b *broadcaster) Listen() rawReceiver {
 		make(chan ctrlMsg, 3),
 		make(chan struct{}),
 	}
-	b.register <- r
+	select {
+	case b.register <- r:
+	case <-b.done:
+		close(r.msgs)
+	}
 	return r
 }
 
@@ -136,14 +144,23 @@ func (b *broadcaster) ListenState() StateReceiver {
 		}
 		close(cs)
 	}()
-	b.register <- r
+	select {
+	case b.register <- r:
+	case <-b.done:
+		close(r.msgs)
+	}
 	return r
 }
 
+// Send broadcasts msg to all receivers. The message is dropped if the broadcaster is closed.
 func (b *broadcaster) Send(msg ctrlMsg) {
-	b.msgs <- msg
+	select {
+	case b.msgs <- msg:
+	case <-b.done:
+	}
 }
 
+// Close closes all receivers. It is safe to call Close multiple times, also concurrently with Send.
 func (b *broadcaster) Close() {
-	close(b.msgs)
+	b.closeOnce.Do(func() { close(b.done) })
 }
@@ -8,26 +8,55 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/la5nta/wl2k-go/transport"
 )
 
 // DialURL dials ardop:// URLs.
 //
-// Parameter bw can be used to set the ARQ bandwidth for this connection. See DialBandwidth for details.
+// Parameter bw can be used to set the ARQ bandwidth for this connection (e.g. 500, 500MAX or 500FORCED).
+// See transport.BandwidthHint and DialBandwidth for details.
+//
+// Parameter arq_timeout (e.g. 120s) can be used to set the ARQ timeout for this connection. The ARQ timeout
+// must be between 30 and 240 seconds, and is reverted on any Dial error and when calling conn.Close().
 func (tnc *TNC) DialURL(url *transport.URL) (net.Conn, error) {
 	if url.Scheme != "ardop" {
 		return nil, transport.ErrUnsupportedScheme
 	}
-	bwStr := url.Params.Get("bw")
-	if bwStr == "" {
-		return tnc.Dial(url.Target)
-	}
-	bw, err := BandwidthFromString(bwStr)
-	if err != nil {
+
+	var bw Bandwidth
+	if hint, err := url.BandwidthHint(); err != nil {
 		return nil, err
+	} else if !hint.IsZero() {
+		if bw, err = BandwidthFromHint(hint); err != nil {
+			return nil, err
+		}
+	}
+
+	var arqTimeout time.Duration
+	if str := url.Params.Get("arq_timeout"); str != "" {
+		var err error
+		if arqTimeout, err = parseARQTimeout(str); err != nil {
+			return nil, err
+		}
+	}
+
+	return tnc.dial(url.Target, bw, arqTimeout)
+}
+
+// parseARQTimeout parses the arq_timeout URL parameter.
+func parseARQTimeout(str string) (time.Duration, error) {
+	d, err := time.ParseDuration(str)
+	switch {
+	case err != nil:
+		return 0, fmt.Errorf("Invalid arq_timeout: %w", err)
+	case d%time.Second != 0:
+		return 0, fmt.Errorf("Invalid arq_timeout %s: must be whole seconds", d)
+	case d < 30*time.Second || d > 240*time.Second:
+		return 0, fmt.Errorf("Invalid arq_timeout %s: must be between 30s and 240s", d)
 	}
-	return tnc.DialBandwidth(url.Target, bw)
+	return d, nil
 }
 
 // DialURLContext dials ardop:// URLs with cancellation support. See DialURL.
@@ -62,10 +91,20 @@ func (tnc *TNC) Dial(targetcall string) (net.Conn, error) {
 //
 // The ARQ bandwidth setting is reverted on any Dial error and when calling conn.Close().
 func (tnc *TNC) DialBandwidth(targetcall string, bw Bandwidth) (net.Conn, error) {
-	if tnc.closed {
+	return tnc.dial(targetcall, bw, 0)
+}
+
+// dial dials a ARQ connection after setting the given ARQ bandwidth and ARQ timeout temporarily.
+//
+// Zero values leaves the current TNC settings untouched.
+func (tnc *TNC) dial(targetcall string, bw Bandwidth, arqTimeout time.Duration) (net.Conn, error) {
+	if tnc.isClosed() {
 		return nil, ErrTNCClosed
 	}
 
+	tnc.dialing.Store(true)
+	defer tnc.dialing.Store(false)
+
 	var defers []func() error
 	if !bw.IsZero() {
 		currentBw, err := tnc.ARQBandwidth()
@@ -77,6 +116,22 @@ func (tnc *TNC) DialBandwidth(targetcall string, bw Bandwidth) (net.Conn, error)
 		}
 		defers = append(defers, func() error { return tnc.SetARQBandwidth(currentBw) })
 	}
+	if arqTimeout > 0 {
+		currentTimeout, err := tnc.ARQTimeout()
+		if err != nil {
+			for _, fn := range defers {
+				_ = fn()
+			}
+			return nil, err
+		}
+		if err := tnc.SetARQTimeout(arqTimeout); err != nil {
+			for _, fn := range defers {
+				_ = fn()
+			}
+			return nil, err
+		}
+		defers = append(defers, func() error { return tnc.SetARQTimeout(currentTimeout) })
+	}
 
 	if err := tnc.arqCall(targetcall, 10); err != nil {
 		for _, fn := range defers {
@@ -93,17 +148,17 @@ func (tnc *TNC) DialBandwidth(targetcall string, bw Bandwidth) (net.Conn, error)
 		return nil, fmt.Errorf("Error when getting mycall: %s", err)
 	}
 
-	tnc.data = &tncConn{
+	conn := &tncConn{
 		remoteAddr: Addr{targetcall},
 		localAddr:  Addr{mycall},
 		ctrlOut:    tnc.out,
 		dataOut:    tnc.dataOut,
 		ctrlIn:     tnc.in,
-		dataIn:     tnc.dataIn,
 		eofChan:    make(chan struct{}),
 		isTCP:      tnc.isTCP,
 		onClose:    defers,
 	}
+	tnc.setConn(conn)
 
-	return tnc.data, nil
+	return conn, nil
 }
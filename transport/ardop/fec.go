@@ -0,0 +1,174 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package ardop
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// The FEC mode used by SendFEC for each bandwidth.
+var fecModes = map[uint]string{
+	200:  "4FSK.200.50S",
+	500:  "4PSK.500.100",
+	1000: "4PSK.1000.100",
+	2000: "4PSK.2000.100",
+}
+
+// SendFEC transmits data as a FEC (unproto) broadcast using the given bandwidth.
+//
+// The TNC is switched to FEC mode for the transmission, and restored to ARQ mode afterwards
+// (unless ListenFEC is active). The method blocks until the transmission is done. Use Abort
+// to cancel an ongoing transmission.
+func (tnc *TNC) SendFEC(data []byte, bw Bandwidth) error {
+	mode, ok := fecModes[bw.Max]
+	if !ok {
+		return ErrUnsupportedBandwidth
+	}
+	if tnc.isClosed() {
+		return ErrTNCClosed
+	}
+	if !tnc.Idle() {
+		return ErrNotIdle
+	}
+
+	if err := tnc.set(cmdProtocolMode, ModeFEC); err != nil {
+		return fmt.Errorf("Set protocol mode FEC failed: %w", err)
+	}
+	defer func() {
+		if !tnc.fecListening() {
+			tnc.set(cmdProtocolMode, ModeARQ)
+		}
+	}()
+
+	if err := tnc.set(cmdFECmode, mode); err != nil {
+		return fmt.Errorf("Set FEC mode failed: %w", err)
+	}
+
+	for len(data) > 0 {
+		n := len(data)
+		if n > 65535 { // uint16 (length bytes) max
+			n = 65535
+		}
+		if err := tnc.writeData(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	r := tnc.in.Listen()
+	defer r.Close()
+
+	if err := tnc.send(fmt.Sprintf("%s TRUE", cmdFECsend)); err != nil {
+		return err
+	}
+	var sending bool
+	for msg := range r.Msgs() {
+		switch msg.cmd {
+		case cmdFault:
+			return errors.New(msg.String())
+		case cmdFECsend:
+			sending = true
+		case cmdNewState:
+			if sending && msg.State() == Disconnected {
+				return nil // Done (or aborted)
+			}
+		}
+	}
+	return ErrTNCClosed
+}
+
+// ListenFEC switches the TNC to FEC mode and returns a channel of received FEC (unproto) data.
+//
+// The returned function stops the listener, closes the channel and restores the TNC to ARQ
+// mode. Data is dropped if the channel is not drained fast enough.
+func (tnc *TNC) ListenFEC() (<-chan []byte, func(), error) {
+	if tnc.isClosed() {
+		return nil, nil, ErrTNCClosed
+	}
+	if !tnc.Idle() {
+		return nil, nil, ErrNotIdle
+	}
+
+	tnc.fecMu.Lock()
+	if tnc.fecIn != nil {
+		tnc.fecMu.Unlock()
+		return nil, nil, ErrActiveListenerExists
+	}
+	c := make(chan []byte, 64)
+	tnc.fecIn = c
+	tnc.fecMu.Unlock()
+
+	stop := func() {
+		tnc.fecMu.Lock()
+		if tnc.fecIn != c {
+			tnc.fecMu.Unlock()
+			return // Already stopped
+		}
+		tnc.fecIn = nil
+		close(c)
+		tnc.fecMu.Unlock()
+
+		tnc.set(cmdProtocolMode, ModeARQ)
+	}
+
+	if err := tnc.set(cmdProtocolMode, ModeFEC); err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("Set protocol mode FEC failed: %w", err)
+	}
+	return c, stop, nil
+}
+
+func (tnc *TNC) fecListening() bool {
+	tnc.fecMu.Lock()
+	defer tnc.fecMu.Unlock()
+	return tnc.fecIn != nil
+}
+
+// deliverFEC passes received FEC data on to the active FEC listener (if any).
+func (tnc *TNC) deliverFEC(data []byte) {
+	tnc.fecMu.Lock()
+	defer tnc.fecMu.Unlock()
+	if tnc.fecIn == nil {
+		return
+	}
+	select {
+	case tnc.fecIn <- data:
+	default:
+		if debugEnabled() {
+			log.Println("FEC listener buffer full. Dropping data.")
+		}
+	}
+}
+
+// writeData writes p to the TNC's data buffer, outside of an ARQ connection.
+//
+// Blocks until the TNC has acknowledged the data.
+func (tnc *TNC) writeData(p []byte) error {
+	r := tnc.in.Listen()
+	defer r.Close()
+
+	frame := dataFrame(tnc.isTCP, p)
+	for i := 0; i < 3; i++ {
+		if err := tnc.sendData(frame); err != nil {
+			return err
+		}
+		for msg := range r.Msgs() {
+			if msg.cmd == cmdBuffer {
+				return nil
+			} else if msg.cmd == cmdCRCFault {
+				if debugEnabled() {
+					log.Printf("writeData: Got CRCFault. Retry %d", i)
+				}
+				break
+			}
+		}
+		if tnc.isClosed() {
+			return ErrTNCClosed
+		}
+	}
+	return fmt.Errorf("CRC failure")
+}
@@ -0,0 +1,229 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package ardop
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubTNC is a loopback ARDOP TNC speaking the serial (non-TCP) host protocol.
+type stubTNC struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	cmds []string // Commands received from the host (after initialization)
+	data []byte   // Data received from the host
+	init bool
+	aux  string // Auxiliary calls set by the host (MYAUX)
+	mute bool   // Don't answer commands (an unresponsive TNC)
+
+	onCommand func(cmd string) // Called after the command has been answered
+}
+
+// openStub opens a TNC connected to a stubTNC.
+func openStub(t *testing.T) (*TNC, *stubTNC) {
+	host, tncSide := net.Pipe()
+	stub := &stubTNC{conn: tncSide}
+	go stub.run()
+
+	tnc, err := Open(host, "N0CALL", "JO39EQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.mu.Lock()
+	stub.init = true
+	stub.mu.Unlock()
+	t.Cleanup(func() { tnc.Close() })
+	return tnc, stub
+}
+
+func (s *stubTNC) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+func (s *stubTNC) send(line string) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	payload := line + "\r"
+	s.conn.Write([]byte("c:" + payload))
+	binary.Write(s.conn, binary.BigEndian, crc16Sum([]byte(payload)))
+}
+
+func (s *stubTNC) sendData(dataType string, data []byte) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint16(len(dataType)+len(data)))
+	buf.WriteString(dataType)
+	buf.Write(data)
+	binary.Write(&buf, binary.BigEndian, crc16Sum(buf.Bytes()))
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.Write(append([]byte("d:"), buf.Bytes()...))
+}
+
+func (s *stubTNC) run() {
+	rd := bufio.NewReader(s.conn)
+	for {
+		prefix := make([]byte, 2)
+		if _, err := io.ReadFull(rd, prefix); err != nil {
+			return
+		}
+		switch string(prefix) {
+		case "C:":
+			line, err := rd.ReadString('\r')
+			if err != nil {
+				return
+			}
+			rd.Discard(2) // CRC
+			s.handleCommand(strings.TrimSuffix(line, "\r"))
+		case "D:":
+			var length uint16
+			binary.Read(rd, binary.BigEndian, &length)
+			data := make([]byte, length)
+			io.ReadFull(rd, data)
+			rd.Discard(2) // CRC
+			s.mu.Lock()
+			s.data = append(s.data, data...)
+			s.mu.Unlock()
+			s.send(fmt.Sprintf("BUFFER %d", len(data)))
+		default:
+			return
+		}
+	}
+}
+
+func (s *stubTNC) handleCommand(cmd string) {
+	s.mu.Lock()
+	if s.init {
+		s.cmds = append(s.cmds, cmd)
+	}
+	onCommand, mute := s.onCommand, s.mute
+	s.mu.Unlock()
+	if mute {
+		return
+	}
+
+	parts := strings.SplitN(cmd, " ", 2)
+	switch {
+	case parts[0] == "STATE":
+		s.send("STATE DISC")
+	case cmd == "MYCALL":
+		s.send("MYCALL N0CALL")
+	case cmd == "VERSION":
+		s.send("VERSION stub_1.0")
+	case cmd == "ARQTIMEOUT":
+		s.send("ARQTIMEOUT 90")
+	case cmd == "MYAUX":
+		s.mu.Lock()
+		aux := s.aux
+		s.mu.Unlock()
+		s.send(strings.TrimSpace("MYAUX " + aux))
+	case parts[0] == "MYAUX":
+		s.mu.Lock()
+		s.aux = parts[1]
+		s.mu.Unlock()
+		s.send(cmd)
+	case cmd == "DISCONNECT":
+		s.send("DISCONNECT")
+		s.send("DISCONNECTED")
+		s.send("NEWSTATE DISC")
+	case parts[0] == "FECSEND":
+		s.send("FECSEND now TRUE")
+		s.send("NEWSTATE FECSEND")
+		s.send("BUFFER 0")
+		s.send("NEWSTATE DISC")
+	case len(parts) == 2:
+		s.send(parts[0] + " now " + parts[1])
+	default:
+		s.send(cmd)
+	}
+
+	if onCommand != nil {
+		onCommand(cmd)
+	}
+}
+
+func TestSendFEC(t *testing.T) {
+	tnc, stub := openStub(t)
+
+	if err := tnc.SendFEC([]byte("CQ CQ CQ"), Bandwidth1000Max); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		"PROTOCOLMODE FEC",
+		"FECMODE 4PSK.1000.100",
+		"FECSEND TRUE",
+		"PROTOCOLMODE ARQ",
+	}
+	if got := stub.commands(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got commands %q, expected %q", got, expect)
+	}
+	stub.mu.Lock()
+	if string(stub.data) != "CQ CQ CQ" {
+		t.Errorf("Got data %q, expected %q", stub.data, "CQ CQ CQ")
+	}
+	stub.mu.Unlock()
+	if tnc.State() != Disconnected {
+		t.Errorf("Got state %s, expected %s", tnc.State(), Disconnected)
+	}
+
+	if err := tnc.SendFEC([]byte("CQ"), Bandwidth{Max: 300}); err != ErrUnsupportedBandwidth {
+		t.Errorf("Got %v, expected ErrUnsupportedBandwidth", err)
+	}
+}
+
+func TestListenFEC(t *testing.T) {
+	tnc, stub := openStub(t)
+
+	// Broadcast some FEC data when the TNC is set to FEC mode
+	stub.mu.Lock()
+	stub.onCommand = func(cmd string) {
+		if cmd == "PROTOCOLMODE FEC" {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				stub.sendData("FEC", []byte("QST de LA5NTA"))
+			}()
+		}
+	}
+	stub.mu.Unlock()
+
+	c, stop, err := tnc.ListenFEC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tnc.ListenFEC(); err != ErrActiveListenerExists {
+		t.Errorf("Got %v, expected ErrActiveListenerExists", err)
+	}
+
+	select {
+	case data := <-c:
+		if string(data) != "QST de LA5NTA" {
+			t.Errorf("Got %q, expected %q", data, "QST de LA5NTA")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No FEC data received")
+	}
+
+	stop()
+	if _, ok := <-c; ok {
+		t.Error("Expected channel to be closed after stop")
+	}
+	if expect := []string{"PROTOCOLMODE FEC", "PROTOCOLMODE ARQ"}; !reflect.DeepEqual(stub.commands(), expect) {
+		t.Errorf("Got commands %q, expected %q", stub.commands(), expect)
+	}
+}
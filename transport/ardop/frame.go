@@ -6,6 +6,7 @@ package ardop
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -46,6 +47,31 @@ func writeCtrlFrame(isTCP bool, w io.Writer, format string, params ...interface{
 	return err
 }
 
+// dataFrame returns the host to TNC data frame carrying p.
+func dataFrame(isTCP bool, p []byte) []byte {
+	var buf bytes.Buffer
+
+	//"D:" + 2 byte count big endian + binary data + 2 byte CRC
+
+	// D:
+	if !isTCP {
+		fmt.Fprint(&buf, "D:")
+	}
+
+	// 2 byte length
+	binary.Write(&buf, binary.BigEndian, uint16(len(p)))
+
+	// Binary data
+	buf.Write(p)
+
+	// 2 byte CRC
+	if !isTCP {
+		sum := crc16Sum(buf.Bytes()[2:]) // [2:], don't include D: in CRC sum.
+		binary.Write(&buf, binary.BigEndian, sum)
+	}
+	return buf.Bytes()
+}
+
 func readFrameOfType(fType byte, reader *bufio.Reader, isTCP bool) (frame, error) {
 	var err error
 	var data []byte
@@ -7,7 +7,11 @@ package ardop
 import (
 	"fmt"
 	"io"
+	"log"
 	"net"
+	"strings"
+
+	"github.com/la5nta/wl2k-go/transport"
 )
 
 type listener struct {
@@ -38,8 +42,47 @@ func (l listener) Close() error {
 	return nil
 }
 
-func (tnc *TNC) Listen() (ln net.Listener, err error) {
-	if tnc.closed {
+// ListenURL implements the transport.URLListener interface for ardop:// URLs.
+//
+// Inbound connections are accepted on the TNC's mycall and auxiliary calls (see SetAuxiliaryCalls).
+// If the URL gives one of the auxiliary calls, only connections to that call are accepted and others
+// are disconnected right away. Any other callsign given by the URL is an error.
+func (tnc *TNC) ListenURL(url *transport.URL) (net.Listener, error) {
+	if url.Scheme != "ardop" {
+		return nil, transport.ErrUnsupportedScheme
+	}
+	call := url.MyCall()
+	if call == "" {
+		return tnc.Listen()
+	}
+	mycall, err := tnc.MyCall()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get mycall: %s", err)
+	}
+	if strings.EqualFold(call, mycall) {
+		return tnc.Listen()
+	}
+	auxCalls, err := tnc.AuxiliaryCalls()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get auxiliary calls: %s", err)
+	}
+	for _, aux := range auxCalls {
+		if strings.EqualFold(call, aux) {
+			return tnc.listen(strings.ToUpper(aux))
+		}
+	}
+	return nil, fmt.Errorf("TNC mycall is %s, not %s (auxiliary calls: %v)", mycall, call, auxCalls)
+}
+
+// Listen returns a listener accepting inbound connections to the TNC's mycall and auxiliary calls.
+//
+// The LocalAddr of an accepted connection is the callsign that was called by the remote, so that
+// a station serving multiple callsigns can dispatch accordingly.
+func (tnc *TNC) Listen() (ln net.Listener, err error) { return tnc.listen("") }
+
+// listen starts listening for inbound connections. If call is non-empty, only connections to call is accepted.
+func (tnc *TNC) listen(call string) (ln net.Listener, err error) {
+	if tnc.isClosed() {
 		return nil, ErrTNCClosed
 	}
 
@@ -57,6 +100,11 @@ func (tnc *TNC) Listen() (ln net.Listener, err error) {
 		return nil, fmt.Errorf("Unable to get mycall: %s", err)
 	}
 
+	addr := Addr{mycall}
+	if call != "" {
+		addr = Addr{call}
+	}
+
 	if err := tnc.SetListenEnabled(true); err != nil {
 		return nil, fmt.Errorf("TNC failed to enable listening: %s", err)
 	}
@@ -88,31 +136,43 @@ func (tnc *TNC) Listen() (ln net.Listener, err error) {
 				case cmdCancelPending, cmdDisconnected:
 					targetcall = "" // Reset
 				case cmdTarget:
-					targetcall = msg.String()
+					targetcall = strings.ToUpper(strings.TrimSpace(msg.String()))
 				case cmdConnected:
 					if targetcall == "" {
 						// This can not be an incoming connection.
 						// Incoming connections always gets cmdTarget before cmdConnected according to the spec
 						continue
 					}
-					remotecall := msg.value.([]string)[0]
-					tnc.data = &tncConn{
+					remotecall := msg.ConnectedCall()
+					if call != "" && targetcall != call {
+						log.Printf("Refusing inbound connection from %s to %s (listening on %s)", remotecall, targetcall, call)
+						targetcall = ""
+						go tnc.Disconnect()
+						continue
+					}
+					if tnc.connectGuard != nil && !tnc.connectGuard(remotecall) {
+						log.Printf("Refusing inbound connection from %s", remotecall)
+						targetcall = ""
+						go tnc.Disconnect()
+						continue
+					}
+					conn := &tncConn{
 						remoteAddr: Addr{remotecall},
 						localAddr:  Addr{targetcall},
 						ctrlOut:    tnc.out,
 						dataOut:    tnc.dataOut,
 						ctrlIn:     tnc.in,
-						dataIn:     tnc.dataIn,
 						eofChan:    make(chan struct{}),
 						isTCP:      tnc.isTCP,
 					}
-					tnc.connected = true
-					incoming <- tnc.data
+					tnc.setConn(conn)
+					tnc.connected.Store(true)
+					incoming <- conn
 					targetcall = ""
 				}
 			}
 		}
 	}()
 
-	return listener{incoming, quit, errors, Addr{mycall}}, nil
+	return listener{incoming, quit, errors, addr}, nil
 }
@@ -0,0 +1,163 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package ardop
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+func TestConnectGuard(t *testing.T) {
+	tnc, stub := openStub(t)
+	tnc.SetConnectGuard(func(remote string) bool { return remote != "N0SPAM" })
+
+	ln, err := tnc.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conns := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conns <- conn
+		}
+	}()
+	time.Sleep(10 * time.Millisecond) // Let the listener subscribe to control messages
+
+	hasCommand := func(cmd string) bool {
+		for _, c := range stub.commands() {
+			if c == cmd {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Refused connection
+	stub.send("NEWSTATE IRS")
+	stub.send("TARGET N0CALL")
+	stub.send("CONNECTED N0SPAM 500")
+	for deadline := time.Now().Add(time.Second); !hasCommand("DISCONNECT") || tnc.State() != Disconnected; {
+		if time.Now().After(deadline) {
+			t.Fatal("Refused connection was not disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Accepted connection
+	stub.send("NEWSTATE IRS")
+	stub.send("TARGET N0CALL")
+	stub.send("CONNECTED LA5NTA 500")
+	select {
+	case conn := <-conns:
+		if got := conn.RemoteAddr().String(); got != "LA5NTA" {
+			t.Errorf("Got RemoteAddr '%s', expected 'LA5NTA'", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Inbound connection not accepted")
+	}
+}
+
+func TestListenAuxiliaryCall(t *testing.T) {
+	tnc, stub := openStub(t)
+	if err := tnc.SetAuxiliaryCalls([]string{"N0CALL-1", "N0CALL-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls, err := tnc.AuxiliaryCalls(); err != nil || len(calls) != 2 || calls[1] != "N0CALL-2" {
+		t.Fatalf("Got auxiliary calls %v (err: %v), expected [N0CALL-1 N0CALL-2]", calls, err)
+	}
+
+	ln, err := tnc.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conns := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conns <- conn
+		}
+	}()
+	time.Sleep(10 * time.Millisecond) // Let the listener subscribe to control messages
+
+	stub.send("NEWSTATE IRS")
+	stub.send("TARGET N0CALL-2")
+	stub.send("CONNECTED LA5NTA 500")
+	select {
+	case conn := <-conns:
+		if got := conn.LocalAddr().String(); got != "N0CALL-2" {
+			t.Errorf("Got LocalAddr '%s', expected 'N0CALL-2'", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Inbound connection not accepted")
+	}
+}
+
+func TestListenURLAuxiliaryCall(t *testing.T) {
+	tnc, stub := openStub(t)
+	if err := tnc.SetAuxiliaryCalls([]string{"N0CALL-1", "N0CALL-2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	url, _ := transport.ParseURL("ardop:///N0CALL-3")
+	if _, err := tnc.ListenURL(url); err == nil {
+		t.Fatal("Expected error when listening on an unknown callsign")
+	}
+
+	url, _ = transport.ParseURL("ardop:///N0CALL-1")
+	ln, err := tnc.ListenURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if got := ln.Addr().String(); got != "N0CALL-1" {
+		t.Errorf("Got listener Addr '%s', expected 'N0CALL-1'", got)
+	}
+
+	conns := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conns <- conn
+		}
+	}()
+	time.Sleep(10 * time.Millisecond) // Let the listener subscribe to control messages
+
+	hasCommand := func(cmd string) bool {
+		for _, c := range stub.commands() {
+			if c == cmd {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Connection to another auxiliary call is refused
+	stub.send("NEWSTATE IRS")
+	stub.send("TARGET N0CALL-2")
+	stub.send("CONNECTED LA5NTA 500")
+	for deadline := time.Now().Add(time.Second); !hasCommand("DISCONNECT") || tnc.State() != Disconnected; {
+		if time.Now().After(deadline) {
+			t.Fatal("Refused connection was not disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stub.send("NEWSTATE IRS")
+	stub.send("TARGET N0CALL-1")
+	stub.send("CONNECTED LA5NTA 500")
+	select {
+	case conn := <-conns:
+		if got := conn.LocalAddr().String(); got != "N0CALL-1" {
+			t.Errorf("Got LocalAddr '%s', expected 'N0CALL-1'", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Inbound connection not accepted")
+	}
+}
@@ -6,6 +6,7 @@ package ardop
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -15,6 +16,8 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/la5nta/wl2k-go/transport"
@@ -31,36 +34,72 @@ type TNC struct {
 	dataOut chan<- []byte
 	dataIn  chan []byte
 
-	busy bool
+	busy atomic.Bool
 
-	state State
+	state State // Guarded by mu
 	heard map[string]time.Time
 
 	selfClose bool
 
 	ptt transport.PTTController
 
+	connectGuard func(remote string) bool // See SetConnectGuard
+
 	// CRC checksum of frames and frame type prefixes is not used over TCPIP
 	isTCP bool
 
-	connected      bool
+	connected      atomic.Bool
 	listenerActive bool
-	closed         bool
+	dialing        atomic.Bool // True while a Dial is in progress (the TNC may still report Disconnected)
+
+	unhealthy atomic.Bool // Control channel desync or unanswered Ping (see Healthy)
+
+	closeMu sync.Mutex // Serializes calls to Close
+	mu      sync.Mutex // Guards closed, state, data and dataIn
+	closed  bool
+	done    chan struct{} // Closed on close. Unblocks pending writes to out and dataOut.
 
 	beacon *beacon
+
+	fecMu sync.Mutex
+	fecIn chan []byte // Received FEC data (see ListenFEC)
+}
+
+// TCPOptions holds optional settings for OpenTCPOpts.
+type TCPOptions struct {
+	// DataPort is the TCP port of the TNC's data channel. Zero means the control port + 1.
+	DataPort int
 }
 
 // OpenTCP opens and initializes an ardop TNC over TCP.
+//
+// The data channel is expected at the control port + 1. See OpenTCPOpts.
 func OpenTCP(addr string, mycall, gridSquare string) (*TNC, error) {
+	return OpenTCPOpts(addr, mycall, gridSquare, TCPOptions{})
+}
+
+// OpenTCPOpts opens and initializes an ardop TNC over TCP, using the given options.
+//
+// addr is the host:port of the TNC's control channel.
+func OpenTCPOpts(addr string, mycall, gridSquare string, opts TCPOptions) (*TNC, error) {
+	dataAddr, err := tcpDataAddr(addr, opts.DataPort)
+	if err != nil {
+		return nil, err
+	}
+
 	ctrlConn, err := net.Dial(`tcp`, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	dataAddr := string(append([]byte(addr[:len(addr)-1]), addr[len(addr)-1]+1)) // Oh no he didn't!
-	raddr, _ := net.ResolveTCPAddr("tcp", dataAddr)
+	raddr, err := net.ResolveTCPAddr("tcp", dataAddr)
+	if err != nil {
+		ctrlConn.Close()
+		return nil, err
+	}
 	dataConn, err := net.DialTCP(`tcp`, nil, raddr)
 	if err != nil {
+		ctrlConn.Close()
 		return nil, err
 	}
 
@@ -70,6 +109,27 @@ func OpenTCP(addr string, mycall, gridSquare string) (*TNC, error) {
 	return tnc, open(tnc, mycall, gridSquare)
 }
 
+// tcpDataAddr returns the address of the data channel given the control channel's address.
+//
+// A zero dataPort means the control port + 1.
+func tcpDataAddr(ctrlAddr string, dataPort int) (string, error) {
+	host, portStr, err := net.SplitHostPort(ctrlAddr)
+	if err != nil {
+		return "", err
+	}
+	if dataPort == 0 {
+		ctrlPort, err := strconv.Atoi(portStr)
+		if err != nil || ctrlPort < 1 || ctrlPort > 65535 {
+			return "", fmt.Errorf("Invalid control port '%s'", portStr)
+		}
+		dataPort = ctrlPort + 1
+	}
+	if dataPort < 1 || dataPort > 65535 {
+		return "", fmt.Errorf("Invalid data port %d", dataPort)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(dataPort)), nil
+}
+
 func newTNC(ctrl io.ReadWriteCloser, dataConn *net.TCPConn) *TNC {
 	return &TNC{
 		in:       newBroadcaster(),
@@ -77,6 +137,7 @@ func newTNC(ctrl io.ReadWriteCloser, dataConn *net.TCPConn) *TNC {
 		ctrl:     ctrl,
 		dataConn: dataConn,
 		heard:    make(map[string]time.Time),
+		done:     make(chan struct{}),
 	}
 }
 
@@ -121,16 +182,27 @@ func (tnc *TNC) SetPTT(ptt transport.PTTController) {
 	tnc.ptt = ptt
 }
 
+// SetConnectGuard sets a function that decides whether an inbound connection should be accepted.
+//
+// The function is called with the remote call sign when a remote station connects to a listener (see
+// Listen). If it returns false, the connection is disconnected right away and never returned by Accept.
+//
+// If nil, all inbound connections are accepted.
+func (tnc *TNC) SetConnectGuard(f func(remote string) bool) {
+	tnc.connectGuard = f
+}
+
 func (tnc *TNC) init() (err error) {
 	if err = tnc.set(cmdInitialize, nil); err != nil {
 		return err
 	}
 
-	tnc.state, err = tnc.getState()
+	state, err := tnc.getState()
 	if err != nil {
 		return err
 	}
-	if tnc.state == Offline {
+	tnc.setState(state)
+	if state == Offline {
 		if err = tnc.SetCodec(true); err != nil {
 			return fmt.Errorf("Enable codec failed: %s", err)
 		}
@@ -165,6 +237,16 @@ func (tnc *TNC) init() (err error) {
 	return nil
 }
 
+// Control channel desync detection: the control channel is considered out of sync (unhealthy) when
+// desyncThreshold frame decode errors occur within desyncWindow.
+const (
+	desyncThreshold = 5
+	desyncWindow    = 10 * time.Second
+)
+
+// The time Ping waits for the TNC to answer.
+var pingTimeout = 10 * time.Second
+
 func decodeTNCStream(fType byte, rd *bufio.Reader, isTCP bool, frames chan<- frame, errors chan<- error) {
 	for {
 		frame, err := readFrameOfType(fType, rd, isTCP)
@@ -174,7 +256,7 @@ func decodeTNCStream(fType byte, rd *bufio.Reader, isTCP bool, frames chan<- fra
 			frames <- frame
 		}
 
-		if err == io.EOF {
+		if err == io.EOF || err == io.ErrClosedPipe {
 			break
 		}
 	}
@@ -195,6 +277,8 @@ func (tnc *TNC) runControlLoop() error {
 	}
 
 	go func() {
+		var decodeErrs []time.Time // Recent frame decode errors (for desync detection)
+
 		for { // Handle incoming TNC data
 			var frame frame
 			var err error
@@ -203,12 +287,19 @@ func (tnc *TNC) runControlLoop() error {
 			case err = <-errors:
 			}
 
-			if _, ok := err.(*net.OpError); err == io.EOF || ok {
+			if _, ok := err.(*net.OpError); err == io.EOF || err == io.ErrClosedPipe || ok {
 				break
 			} else if err != nil {
 				if debugEnabled() {
 					log.Printf("Error reading frame: %s", err)
 				}
+				decodeErrs = append(decodeErrs, time.Now())
+				for len(decodeErrs) > 0 && time.Since(decodeErrs[0]) > desyncWindow {
+					decodeErrs = decodeErrs[1:]
+				}
+				if len(decodeErrs) >= desyncThreshold && !tnc.unhealthy.Swap(true) {
+					log.Printf("ARDOP control channel out of sync (%d frame errors within %s)", len(decodeErrs), desyncWindow)
+				}
 				continue
 			}
 
@@ -219,15 +310,22 @@ func (tnc *TNC) runControlLoop() error {
 			if d, ok := frame.(dFrame); ok {
 				switch {
 				case d.ARQFrame():
-					if !tnc.connected {
+					if !tnc.connected.Load() {
 						// ARDOPc is sending non-ARQ data as ARQ frames when not connected
 						continue
 					}
+					// Don't hold the lock while sending. eof replaces (but never closes) dataIn, and
+					// signals the connection's eofChan to unblock us.
+					dataIn, eofChan := tnc.pendingData()
 					select {
-					case tnc.dataIn <- d.data:
+					case dataIn <- d.data:
+					case <-eofChan:
+					case <-tnc.done:
 					case <-time.After(time.Minute):
 						go tnc.Disconnect() // Buffer full and timeout
 					}
+				case d.FECFrame():
+					tnc.deliverFEC(d.data)
 				case d.IDFrame():
 					call, _, err := parseIDFrame(d)
 					if err == nil {
@@ -250,19 +348,21 @@ func (tnc *TNC) runControlLoop() error {
 					tnc.ptt.SetPTT(msg.Bool())
 				}
 			case cmdDisconnected:
-				tnc.state = Disconnected
+				tnc.setState(Disconnected)
 				tnc.eof()
 			case cmdBuffer:
+				tnc.mu.Lock()
 				tnc.data.updateBuffer(msg.value.(int))
+				tnc.mu.Unlock()
 			case cmdNewState:
-				tnc.state = msg.State()
+				tnc.setState(msg.State())
 
 				// Close ongoing connections if the new state is Disconnected
 				if msg.State() == Disconnected {
 					tnc.eof()
 				}
 			case cmdBusy:
-				tnc.busy = msg.value.(bool)
+				tnc.busy.Store(msg.value.(bool))
 			}
 
 			if debugEnabled() {
@@ -283,6 +383,8 @@ func (tnc *TNC) runControlLoop() error {
 	go func() {
 		for {
 			select {
+			case <-tnc.done:
+				return
 			case str, ok := <-out:
 				if !ok {
 					return
@@ -320,28 +422,110 @@ func (tnc *TNC) runControlLoop() error {
 }
 
 func (tnc *TNC) eof() {
+	tnc.mu.Lock()
+	defer tnc.mu.Unlock()
 	if tnc.data != nil {
-		close(tnc.dataIn)       // Signals EOF to pending reads
-		tnc.data.signalClosed() // Signals EOF to pending writes
-		tnc.connected = false   // connect() is responsible for setting it to true
+		tnc.data.signalClosed()    // Signals EOF to pending reads and writes
+		tnc.connected.Store(false) // connect() is responsible for setting it to true
 		tnc.dataIn = make(chan []byte, 4096)
 		tnc.data = nil
 	}
 }
 
-// Ping checks the TNC connection for errors
+// setConn sets the active ARQ connection.
+//
+// The connection receives the data buffered since the TNC connected.
+func (tnc *TNC) setConn(conn *tncConn) {
+	tnc.mu.Lock()
+	defer tnc.mu.Unlock()
+	conn.dataIn = tnc.dataIn
+	tnc.data = conn
+}
+
+// pendingData returns the channel of received ARQ data, and the active connection's eofChan (nil
+// if the connection is not yet set).
+func (tnc *TNC) pendingData() (chan<- []byte, <-chan struct{}) {
+	tnc.mu.Lock()
+	defer tnc.mu.Unlock()
+	if tnc.data == nil {
+		return tnc.dataIn, nil
+	}
+	return tnc.dataIn, tnc.data.eofChan
+}
+
+// send writes a command to the TNC.
+//
+// ErrTNCClosed is returned if the TNC is closed before the command is written.
+func (tnc *TNC) send(cmd string) error { return tnc.sendContext(context.Background(), cmd) }
+
+// sendContext is like send, but gives up when ctx is done.
+func (tnc *TNC) sendContext(ctx context.Context, cmd string) error {
+	select {
+	case tnc.out <- cmd:
+		return nil
+	case <-tnc.done:
+		return ErrTNCClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendData writes a data frame to the TNC.
+//
+// ErrTNCClosed is returned if the TNC is closed before the frame is written.
+func (tnc *TNC) sendData(frame []byte) error {
+	select {
+	case tnc.dataOut <- frame:
+		return nil
+	case <-tnc.done:
+		return ErrTNCClosed
+	}
+}
+
+func (tnc *TNC) isClosed() bool {
+	tnc.mu.Lock()
+	defer tnc.mu.Unlock()
+	return tnc.closed
+}
+
+// Ping checks the TNC connection for errors.
+//
+// ErrPingTimeout is returned if the TNC does not answer in time, in which case the TNC is
+// considered unhealthy (see Healthy). A successful Ping marks the TNC as healthy.
 func (tnc *TNC) Ping() error {
-	if tnc.closed {
+	if tnc.isClosed() {
 		return ErrTNCClosed
 	}
 
-	_, err := tnc.getString(cmdVersion)
-	return err
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	_, err := tnc.getContext(ctx, cmdVersion)
+	switch {
+	case err == context.DeadlineExceeded:
+		tnc.unhealthy.Store(true)
+		return ErrPingTimeout
+	case err != nil:
+		return err
+	}
+	tnc.unhealthy.Store(false)
+	return nil
 }
 
+// Healthy returns false if the TNC is closed, or the control channel is believed to be out of sync.
+//
+// The control channel is considered out of sync when repeated frame errors are seen (e.g. after a partial
+// frame or a TNC restart), or when the TNC did not answer a Ping. Commands may then hang until the TNC
+// is closed. Callers should Ping the TNC, and re-open it if the Ping fails. A successful Ping marks the
+// TNC as healthy again.
+func (tnc *TNC) Healthy() bool { return !tnc.isClosed() && !tnc.unhealthy.Load() }
+
 // Closes the connection to the TNC (and any on-going connections).
+//
+// It is safe to call Close multiple times, also concurrently. Subsequent calls are no-ops.
 func (tnc *TNC) Close() error {
-	if tnc.closed {
+	tnc.closeMu.Lock()
+	defer tnc.closeMu.Unlock()
+	if tnc.isClosed() {
 		return nil
 	}
 
@@ -357,20 +541,25 @@ func (tnc *TNC) Close() error {
 	return nil
 }
 
+// close releases all resources. Only the first call has any effect.
+//
+// Called by Close and by the control loop when the TNC connection is lost.
 func (tnc *TNC) close() {
+	tnc.mu.Lock()
 	if tnc.closed {
+		tnc.mu.Unlock()
 		return
 	}
-	tnc.closed = true // bug(martinhpedersen): Data race in tnc.Close can cause panic on duplicate calls
+	tnc.closed = true
+	tnc.mu.Unlock()
 
 	tnc.beacon.Close()
 	tnc.eof()
 
 	tnc.ctrl.Close()
 
-	tnc.in.Close() // TODO: This may panic due to the race mentioned above. Consider using a mutex to guard tnc.closed.
-	close(tnc.out)
-	close(tnc.dataOut)
+	tnc.in.Close()
+	close(tnc.done)
 
 	// no need for a finalizer anymore
 	runtime.SetFinalizer(tnc, nil)
@@ -378,7 +567,67 @@ func (tnc *TNC) close() {
 
 // Returns true if channel is not clear
 func (tnc *TNC) Busy() bool {
-	return tnc.busy
+	return tnc.busy.Load()
+}
+
+// ListenInputLevel returns a channel of the input (audio) levels reported by the TNC.
+//
+// The level is the absolute peak sample value of the last report (0-32768). This is useful
+// for adjusting the receive audio level during setup. The returned function stops the listener
+// and closes the channel. Reports are dropped if the channel is not drained fast enough.
+//
+// Note that input levels are only reported by some TNC implementations (e.g. ARDOPc).
+func (tnc *TNC) ListenInputLevel() (<-chan int, func()) {
+	r := tnc.in.Listen()
+	c := make(chan int, 1)
+	go func() {
+		defer close(c)
+		for {
+			select {
+			case <-r.done:
+				return
+			case msg, ok := <-r.Msgs():
+				if !ok {
+					return
+				}
+				if msg.cmd != cmdInputPeaks {
+					continue
+				}
+				select {
+				case c <- msg.Int():
+				default:
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return c, func() { once.Do(r.Close) }
+}
+
+var inputLevelTimeout = 5 * time.Second
+
+// InputLevel waits for the next input level reported by the TNC.
+//
+// ErrInputLevelTimeout is returned if the TNC does not report its input level within a few
+// seconds. See ListenInputLevel.
+func (tnc *TNC) InputLevel() (int, error) {
+	if tnc.isClosed() {
+		return 0, ErrTNCClosed
+	}
+
+	c, stop := tnc.ListenInputLevel()
+	defer stop()
+
+	select {
+	case level, ok := <-c:
+		if !ok {
+			return 0, ErrTNCClosed
+		}
+		return level, nil
+	case <-time.After(inputLevelTimeout):
+		return 0, ErrInputLevelTimeout
+	}
 }
 
 // Version returns the software version of the TNC
@@ -388,9 +637,17 @@ func (tnc *TNC) Version() (string, error) {
 
 // Returns the current state of the TNC
 func (tnc *TNC) State() State {
+	tnc.mu.Lock()
+	defer tnc.mu.Unlock()
 	return tnc.state
 }
 
+func (tnc *TNC) setState(state State) {
+	tnc.mu.Lock()
+	tnc.state = state
+	tnc.mu.Unlock()
+}
+
 // Returns the grid square as reported by the TNC
 func (tnc *TNC) GridSquare() (string, error) {
 	return tnc.getString(cmdGridSquare)
@@ -449,6 +706,40 @@ func (tnc *TNC) SetMycall(mycall string) error {
 	return tnc.set(cmdMyCall, mycall)
 }
 
+// SetCaptureDevice sets the sound card capture device used by the TNC.
+//
+// See CaptureDevices for a list of valid device names.
+func (tnc *TNC) SetCaptureDevice(name string) error {
+	return tnc.set(cmdCapture, name)
+}
+
+// CaptureDevice returns the currently assigned sound card capture device.
+func (tnc *TNC) CaptureDevice() (string, error) {
+	return tnc.getString(cmdCapture)
+}
+
+// CaptureDevices returns the capture devices currently installed, as reported by the TNC.
+func (tnc *TNC) CaptureDevices() ([]string, error) {
+	return tnc.getList(cmdCaptureDevices)
+}
+
+// SetPlaybackDevice sets the sound card playback device used by the TNC.
+//
+// See PlaybackDevices for a list of valid device names.
+func (tnc *TNC) SetPlaybackDevice(name string) error {
+	return tnc.set(cmdPlayback, name)
+}
+
+// PlaybackDevice returns the currently assigned sound card playback device.
+func (tnc *TNC) PlaybackDevice() (string, error) {
+	return tnc.getString(cmdPlayback)
+}
+
+// PlaybackDevices returns the playback devices currently installed, as reported by the TNC.
+func (tnc *TNC) PlaybackDevices() ([]string, error) {
+	return tnc.getList(cmdPlaybackDevices)
+}
+
 // SetCWID sets wether or not to send FSK CW ID after an ID frame.
 func (tnc *TNC) SetCWID(enabled bool) error {
 	return tnc.set(cmdCWID, enabled)
@@ -467,11 +758,19 @@ func (tnc *TNC) SendID() error {
 }
 
 type beacon struct {
-	reset chan time.Duration
-	close chan struct{}
+	reset    chan time.Duration
+	close    chan struct{}
+	done     chan struct{} // Closed when the beacon goroutine has exited
+	interval atomic.Int64  // The current interval (time.Duration)
 }
 
-func (b *beacon) Reset(d time.Duration) { b.reset <- d }
+func (b *beacon) Reset(d time.Duration) {
+	b.interval.Store(int64(d))
+	select {
+	case b.reset <- d:
+	case <-b.done:
+	}
+}
 
 func (b *beacon) Close() {
 	if b == nil {
@@ -484,8 +783,9 @@ func (b *beacon) Close() {
 }
 
 func initBeacon(tnc *TNC) *beacon {
-	b := &beacon{reset: make(chan time.Duration, 1), close: make(chan struct{}, 1)}
+	b := &beacon{reset: make(chan time.Duration, 1), close: make(chan struct{}, 1), done: make(chan struct{})}
 	go func() {
+		defer close(b.done)
 		t := time.NewTimer(10)
 		t.Stop()
 		var d time.Duration
@@ -497,7 +797,8 @@ func initBeacon(tnc *TNC) *beacon {
 			case d = <-b.reset:
 				t.Stop()
 			case <-t.C:
-				if tnc.Idle() {
+				// Don't interfere with an ongoing dial (before the TNC leaves the Disconnected state)
+				if tnc.Idle() && !tnc.connected.Load() && !tnc.dialing.Load() {
 					tnc.SendID()
 				}
 			}
@@ -511,14 +812,33 @@ func initBeacon(tnc *TNC) *beacon {
 
 // BeaconEvery starts a goroutine that sends an ID frame (SendID) at the regular interval d
 //
-// The gorutine will be closed on Close() or if d equals 0.
-func (tnc *TNC) BeaconEvery(d time.Duration) error { tnc.beacon.Reset(d); return nil }
+// The ID frame is only sent when the TNC is idle (not dialing or connected). The goroutine
+// will be closed on Close(). Call BeaconEvery with d equal to 0 to stop beaconing.
+func (tnc *TNC) BeaconEvery(d time.Duration) error {
+	if tnc.isClosed() {
+		return ErrTNCClosed
+	}
+	tnc.beacon.Reset(d)
+	return nil
+}
+
+// BeaconInterval returns the interval set by BeaconEvery. Zero if beaconing is stopped.
+func (tnc *TNC) BeaconInterval() time.Duration { return time.Duration(tnc.beacon.interval.Load()) }
 
 // Sets the auxiliary call signs that the TNC should answer to on incoming connections.
 func (tnc *TNC) SetAuxiliaryCalls(calls []string) (err error) {
 	return tnc.set(cmdMyAux, strings.Join(calls, ", "))
 }
 
+// AuxiliaryCalls returns the auxiliary call signs that the TNC answers to on incoming connections.
+func (tnc *TNC) AuxiliaryCalls() ([]string, error) {
+	v, err := tnc.get(cmdMyAux)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]string), nil
+}
+
 // Enable/disable sound card and other resources
 //
 // This is done automatically on Open(), users should
@@ -559,6 +879,9 @@ func (tnc *TNC) setFSKOnly(t bool) error {
 // If the TNC is not connecting/connected, Disconnect is
 // a noop.
 func (tnc *TNC) Disconnect() error {
+	if tnc.isClosed() {
+		return ErrTNCClosed
+	}
 	if tnc.Idle() {
 		return nil
 	}
@@ -568,7 +891,9 @@ func (tnc *TNC) Disconnect() error {
 	r := tnc.in.Listen()
 	defer r.Close()
 
-	tnc.out <- fmt.Sprintf("%s", cmdDisconnect)
+	if err := tnc.send(string(cmdDisconnect)); err != nil {
+		return err
+	}
 	for msg := range r.Msgs() {
 		if msg.cmd == cmdDisconnected {
 			return nil
@@ -582,10 +907,11 @@ func (tnc *TNC) Disconnect() error {
 
 // Idle returns true if the TNC is not in a connecting or connected state.
 func (tnc *TNC) Idle() bool {
-	return tnc.state == Disconnected || tnc.state == Offline
+	state := tnc.State()
+	return state == Disconnected || state == Offline
 }
 
-// Abort immediately aborts an ARQ Connection or a FEC Send session.
+// Abort immediately aborts an ARQ Connection or a FEC Send session (see SendFEC).
 func (tnc *TNC) Abort() error {
 	return tnc.set(cmdAbort, nil)
 }
@@ -599,6 +925,9 @@ func (tnc *TNC) getState() (State, error) {
 }
 
 // Sends a connect command to the TNC. Users should call Dial().
+//
+// Known connect failures are reported as typed errors (ErrRejectedBusy, ErrRejectedBandwidth,
+// ErrConnectAborted and ErrConnectTimeout).
 func (tnc *TNC) arqCall(targetcall string, repeat int) error {
 	if !tnc.Idle() {
 		return ErrConnectInProgress
@@ -607,17 +936,31 @@ func (tnc *TNC) arqCall(targetcall string, repeat int) error {
 	r := tnc.in.Listen()
 	defer r.Close()
 
-	tnc.out <- fmt.Sprintf("%s %s %d", cmdARQCall, targetcall, repeat)
+	if err := tnc.send(fmt.Sprintf("%s %s %d", cmdARQCall, targetcall, repeat)); err != nil {
+		return err
+	}
+	// The reason for a failed connect is reported before the TNC returns to the disconnected state
+	var connectErr error
 	for msg := range r.Msgs() {
 		switch msg.cmd {
 		case cmdFault:
-			return fmt.Errorf(msg.String())
+			if err := msg.connectError(); err != nil {
+				return fmt.Errorf("%w: %s", err, msg.String())
+			}
+			return errors.New(msg.String())
+		case cmdRejectedBusy, cmdRejectedBW, cmdAbort, cmdStatus:
+			if err := msg.connectError(); err != nil && connectErr == nil {
+				connectErr = err
+			}
 		case cmdNewState:
-			if tnc.state == Disconnected {
+			if tnc.State() == Disconnected {
+				if connectErr != nil {
+					return connectErr
+				}
 				return ErrConnectTimeout
 			}
 		case cmdConnected: // TODO: Probably not what we should look for
-			tnc.connected = true
+			tnc.connected.Store(true)
 			return nil
 		}
 	}
@@ -625,17 +968,19 @@ func (tnc *TNC) arqCall(targetcall string, repeat int) error {
 }
 
 func (tnc *TNC) set(cmd command, param interface{}) (err error) {
-	if tnc.closed {
+	if tnc.isClosed() {
 		return ErrTNCClosed
 	}
 
 	r := tnc.in.Listen()
 	defer r.Close()
 
+	str := string(cmd)
 	if param != nil {
-		tnc.out <- fmt.Sprintf("%s %v", cmd, param)
-	} else {
-		tnc.out <- string(cmd)
+		str = fmt.Sprintf("%s %v", cmd, param)
+	}
+	if err := tnc.send(str); err != nil {
+		return err
 	}
 
 	for msg := range r.Msgs() {
@@ -672,22 +1017,49 @@ func (tnc *TNC) getInt(cmd command) (int, error) {
 	return v.(int), nil
 }
 
+func (tnc *TNC) getList(cmd command) ([]string, error) {
+	v, err := tnc.get(cmd)
+	if err != nil {
+		return nil, err
+	}
+	list, _ := v.([]string)
+	if len(list) == 1 && list[0] == "" {
+		return nil, nil // Empty list
+	}
+	return list, nil
+}
+
 func (tnc *TNC) get(cmd command) (interface{}, error) {
-	if tnc.closed {
+	return tnc.getContext(context.Background(), cmd)
+}
+
+// getContext is like get, but gives up when ctx is done.
+func (tnc *TNC) getContext(ctx context.Context, cmd command) (interface{}, error) {
+	if tnc.isClosed() {
 		return nil, ErrTNCClosed
 	}
 
 	r := tnc.in.Listen()
 	defer r.Close()
 
-	tnc.out <- string(cmd)
-	for msg := range r.Msgs() {
-		switch msg.cmd {
-		case cmd:
-			return msg.value, nil
-		case cmdFault:
-			return nil, errors.New(msg.String())
+	if err := tnc.sendContext(ctx, string(cmd)); err != nil {
+		return nil, err
+	}
+	msgs := r.Msgs()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil, ErrTNCClosed
+			}
+			switch msg.cmd {
+			case cmd:
+				return msg.value, nil
+			case cmdFault:
+				return nil, errors.New(msg.String())
+			}
 		}
 	}
-	return nil, ErrTNCClosed
 }
@@ -0,0 +1,501 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package ardop
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+// fakeTNC returns a TNC that answers each command using the given function.
+//
+// All commands sent by the TNC are recorded in the returned slice.
+func fakeTNC(reply func(cmd string) string) (*TNC, *[]string) {
+	out := make(chan string)
+	tnc := newTNC(nil, nil)
+	tnc.out = out
+
+	var sent []string
+	go func() {
+		for cmd := range out {
+			sent = append(sent, cmd)
+			tnc.in.msgs <- parseCtrlMsg(reply(cmd))
+		}
+	}()
+	return tnc, &sent
+}
+
+func TestSoundCardDevices(t *testing.T) {
+	tnc, sent := fakeTNC(func(cmd string) string {
+		switch cmd {
+		case "CAPTUREDEVICES":
+			return "CAPTUREDEVICES Microphone (USB Audio),Line In"
+		case "PLAYBACKDEVICES":
+			return "PLAYBACKDEVICES"
+		default:
+			return cmd // Echo
+		}
+	})
+	defer close(tnc.out)
+
+	if err := tnc.SetCaptureDevice("Line In"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tnc.SetPlaybackDevice("Speakers (USB Audio)"); err != nil {
+		t.Fatal(err)
+	}
+
+	capture, err := tnc.CaptureDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"Microphone (USB Audio)", "Line In"}; !reflect.DeepEqual(capture, expect) {
+		t.Errorf("Got capture devices %q, expected %q", capture, expect)
+	}
+
+	playback, err := tnc.PlaybackDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(playback) != 0 {
+		t.Errorf("Got playback devices %q, expected none", playback)
+	}
+
+	expect := []string{
+		"CAPTURE Line In",
+		"PLAYBACK Speakers (USB Audio)",
+		"CAPTUREDEVICES",
+		"PLAYBACKDEVICES",
+	}
+	if !reflect.DeepEqual(*sent, expect) {
+		t.Errorf("Got commands %q, expected %q", *sent, expect)
+	}
+}
+
+func TestSetDeviceFault(t *testing.T) {
+	tnc, _ := fakeTNC(func(cmd string) string { return "FAULT Unknown device" })
+	defer close(tnc.out)
+
+	if err := tnc.SetCaptureDevice("foo"); err == nil || err.Error() != "Unknown device" {
+		t.Errorf("Got %v, expected fault", err)
+	}
+}
+
+func TestBeaconEvery(t *testing.T) {
+	tnc, stub := openStub(t)
+
+	if err := tnc.BeaconEvery(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if d := tnc.BeaconInterval(); d != 10*time.Millisecond {
+		t.Errorf("Got interval %s, expected 10ms", d)
+	}
+
+	// Hold the dial in the Disconnected state for a while before connecting
+	dialStarted := make(chan int, 1)
+	stub.mu.Lock()
+	stub.onCommand = func(cmd string) {
+		if !strings.HasPrefix(cmd, "ARQCALL") {
+			return
+		}
+		stub.mu.Lock()
+		dialStarted <- len(stub.cmds)
+		stub.mu.Unlock()
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			stub.send("NEWSTATE ISS")
+			stub.send("CONNECTED LA1B 500")
+		}()
+	}
+	stub.mu.Unlock()
+
+	// Wait for the first beacon, so that we know the beacon is running
+	for start := time.Now(); !contains(stub.commands(), "SENDID"); {
+		if time.Since(start) > time.Second {
+			t.Fatal("No ID frame sent while idle")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := tnc.Dial("LA1B"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // Give the beacon a few intervals to (not) fire while connected
+
+	n := <-dialStarted
+	if cmds := stub.commands()[n:]; contains(cmds, "SENDID") {
+		t.Errorf("ID frame sent while dialing/connected: %q", cmds)
+	}
+
+	if err := tnc.BeaconEvery(0); err != nil {
+		t.Fatal(err)
+	}
+	if d := tnc.BeaconInterval(); d != 0 {
+		t.Errorf("Got interval %s after stop, expected 0", d)
+	}
+
+	tnc.Close()
+	if err := tnc.BeaconEvery(time.Minute); err != ErrTNCClosed {
+		t.Errorf("Got %v, expected ErrTNCClosed", err)
+	}
+
+	// A Reset racing with Close must not block once the beacon has exited
+	reset := make(chan struct{})
+	go func() {
+		tnc.beacon.Reset(time.Minute)
+		tnc.beacon.Reset(time.Minute)
+		close(reset)
+	}()
+	select {
+	case <-reset:
+	case <-time.After(time.Second):
+		t.Error("Beacon reset blocked after close")
+	}
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInputLevel(t *testing.T) {
+	tnc, stub := openStub(t)
+
+	c, stop := tnc.ListenInputLevel()
+	go stub.send("INPUTPEAKS -1200 3400")
+	select {
+	case level := <-c:
+		if level != 3400 {
+			t.Errorf("Got level %d, expected 3400", level)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No input level received")
+	}
+	stop()
+	stop() // Must be safe to call twice
+	select {
+	case _, ok := <-c:
+		if ok {
+			t.Error("Expected channel to be closed after stop")
+		}
+	case <-time.After(time.Second):
+		t.Error("Channel not closed after stop")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		stub.send("INPUTPEAKS -20000 100")
+	}()
+	if level, err := tnc.InputLevel(); err != nil || level != 20000 {
+		t.Errorf("Got %d, %v, expected 20000, nil", level, err)
+	}
+
+	defer func(d time.Duration) { inputLevelTimeout = d }(inputLevelTimeout)
+	inputLevelTimeout = 10 * time.Millisecond
+	if _, err := tnc.InputLevel(); err != ErrInputLevelTimeout {
+		t.Errorf("Got %v, expected ErrInputLevelTimeout", err)
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	tnc, stub := openStub(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- tnc.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Got %v, expected nil", err)
+		}
+	}
+	if err := tnc.Close(); err != nil {
+		t.Errorf("Got %v on duplicate Close, expected nil", err)
+	}
+	if err := tnc.Ping(); err != ErrTNCClosed {
+		t.Errorf("Got %v, expected ErrTNCClosed", err)
+	}
+	if err := tnc.Disconnect(); err != ErrTNCClosed {
+		t.Errorf("Got %v from Disconnect, expected ErrTNCClosed", err)
+	}
+	if n := strings.Count(strings.Join(stub.commands(), "\n"), "LISTEN false"); n != 1 {
+		t.Errorf("Got %d LISTEN false commands, expected 1", n)
+	}
+}
+
+func TestCloseOnConnectionLoss(t *testing.T) {
+	tnc, stub := openStub(t)
+
+	// Close while the control loop is tearing down the TNC due to connection loss
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tnc.Close()
+		}()
+	}
+	stub.conn.Close()
+	wg.Wait()
+
+	if err := tnc.Close(); err != nil {
+		t.Errorf("Got %v on duplicate Close, expected nil", err)
+	}
+}
+
+func TestBroadcasterCloseWhileSending(t *testing.T) {
+	b := newBroadcaster()
+	r := b.Listen()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b.Send(ctrlMsg{cmd: cmdBuffer, value: j})
+			}
+		}()
+	}
+	go func() {
+		for range r.Msgs() {
+		}
+	}()
+
+	b.Close()
+	b.Close()
+	wg.Wait() // Send must not panic or block after Close
+
+	if _, ok := <-b.Listen().Msgs(); ok {
+		t.Error("Expected closed receiver when listening on a closed broadcaster")
+	}
+}
+
+func TestTCPDataAddr(t *testing.T) {
+	tests := []struct {
+		addr      string
+		dataPort  int
+		expect    string
+		expectErr bool
+	}{
+		{addr: "localhost:8515", expect: "localhost:8516"},
+		{addr: "localhost:8519", expect: "localhost:8520"},
+		{addr: "[::1]:8999", expect: "[::1]:9000"},
+		{addr: "localhost:8515", dataPort: 9000, expect: "localhost:9000"},
+		{addr: "localhost:65535", expectErr: true},
+		{addr: "localhost:8515", dataPort: 65536, expectErr: true},
+		{addr: "localhost:foo", expectErr: true},
+		{addr: "localhost", expectErr: true},
+	}
+	for _, tt := range tests {
+		got, err := tcpDataAddr(tt.addr, tt.dataPort)
+		switch {
+		case tt.expectErr && err == nil:
+			t.Errorf("%s (%d): Expected error, got '%s'", tt.addr, tt.dataPort, got)
+		case !tt.expectErr && err != nil:
+			t.Errorf("%s (%d): Unexpected error: %s", tt.addr, tt.dataPort, err)
+		case got != tt.expect:
+			t.Errorf("%s (%d): Got '%s', expected '%s'", tt.addr, tt.dataPort, got, tt.expect)
+		}
+	}
+}
+
+func TestDialRejectedBusy(t *testing.T) {
+	tnc, stub := openStub(t)
+	defer tnc.Close()
+
+	stub.mu.Lock()
+	stub.onCommand = func(cmd string) {
+		if !strings.HasPrefix(cmd, "ARQCALL") {
+			return
+		}
+		go func() {
+			stub.send("REJECTEDBUSY LA1B")
+			stub.send("NEWSTATE DISC")
+		}()
+	}
+	stub.mu.Unlock()
+
+	if _, err := tnc.Dial("LA1B"); err != ErrRejectedBusy {
+		t.Errorf("Got %v, expected %v", err, ErrRejectedBusy)
+	}
+}
+
+func TestDialURLARQTimeout(t *testing.T) {
+	tnc, stub := openStub(t)
+	defer tnc.Close()
+
+	stub.mu.Lock()
+	stub.onCommand = func(cmd string) {
+		if !strings.HasPrefix(cmd, "ARQCALL") {
+			return
+		}
+		go func() {
+			stub.send("NEWSTATE ISS")
+			stub.send("CONNECTED LA1B 500")
+		}()
+	}
+	stub.mu.Unlock()
+
+	for _, str := range []string{"ardop:///LA1B?arq_timeout=foo", "ardop:///LA1B?arq_timeout=10s", "ardop:///LA1B?arq_timeout=90.5s"} {
+		url, _ := transport.ParseURL(str)
+		if _, err := tnc.DialURL(url); err == nil {
+			t.Errorf("Expected error on %s", str)
+		}
+	}
+
+	url, _ := transport.ParseURL("ardop:///LA1B?arq_timeout=2m")
+	conn, err := tnc.DialURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmds := stub.commands(); !contains(cmds, "ARQTIMEOUT 120") {
+		t.Errorf("ARQ timeout not set before dial: %q", cmds)
+	}
+	conn.Close()
+	if cmds := stub.commands(); cmds[len(cmds)-1] != "ARQTIMEOUT 90" {
+		t.Errorf("ARQ timeout not reverted on close: %q", cmds)
+	}
+}
+
+func TestBandwidthFromHint(t *testing.T) {
+	for _, str := range []string{"200", "500", "1000", "2000", "500MAX", "500FORCED", "2000FORCED"} {
+		hint, err := transport.ParseBandwidthHint(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect, _ := BandwidthFromString(str)
+		if got, err := BandwidthFromHint(hint); err != nil || got != expect {
+			t.Errorf("%s: Got %s (%v), expected %s", str, got, err, expect)
+		}
+	}
+
+	if _, err := BandwidthFromHint(transport.BandwidthHint{Hz: 2500}); err != ErrUnsupportedBandwidth {
+		t.Errorf("Got %v, expected ErrUnsupportedBandwidth", err)
+	}
+}
+
+func TestPingDesync(t *testing.T) {
+	defer func(d time.Duration) { pingTimeout = d }(pingTimeout)
+	pingTimeout = 100 * time.Millisecond
+
+	tnc, stub := openStub(t)
+	if err := tnc.Ping(); err != nil || !tnc.Healthy() {
+		t.Fatalf("Got %v (healthy: %t), expected healthy TNC", err, tnc.Healthy())
+	}
+
+	// Garbage on the control channel (e.g. a partial frame after a TNC restart)
+	stub.writeMu.Lock()
+	stub.conn.Write([]byte(strings.Repeat("x", 4*desyncThreshold)))
+	stub.writeMu.Unlock()
+	for start := time.Now(); tnc.Healthy(); time.Sleep(5 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("Desync not detected")
+		}
+	}
+
+	// An unresponsive TNC
+	stub.mu.Lock()
+	stub.mute = true
+	stub.mu.Unlock()
+	start := time.Now()
+	if err := tnc.Ping(); err != ErrPingTimeout {
+		t.Errorf("Got %v, expected %v", err, ErrPingTimeout)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Ping returned after %s, expected ~%s", d, pingTimeout)
+	}
+	if tnc.Healthy() {
+		t.Error("Got healthy after Ping timeout")
+	}
+
+	// Recovered
+	stub.mu.Lock()
+	stub.mute = false
+	stub.mu.Unlock()
+	if err := tnc.Ping(); err != nil || !tnc.Healthy() {
+		t.Errorf("Got %v (healthy: %t), expected healthy TNC after successful Ping", err, tnc.Healthy())
+	}
+}
+
+func TestDataDeliveryBlocked(t *testing.T) {
+	tnc, stub := openStub(t)
+
+	stub.mu.Lock()
+	stub.onCommand = func(cmd string) {
+		if !strings.HasPrefix(cmd, "ARQCALL") {
+			return
+		}
+		go func() {
+			stub.send("NEWSTATE ISS")
+			stub.send("CONNECTED LA1B 500")
+		}()
+	}
+	stub.mu.Unlock()
+
+	conn, err := tnc.Dial("LA1B")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the receive buffer, leaving the control loop blocked on the last frame
+	const n = 4096
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i <= n; i++ {
+			stub.sendData("ARQ", []byte("x"))
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout sending data")
+	}
+
+	// The TNC must remain usable while the delivery is blocked
+	done := make(chan struct{})
+	go func() { tnc.State(); tnc.Healthy(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("TNC blocked while data delivery is pending")
+	}
+
+	// Data received before the disconnect is still readable, followed by EOF
+	stub.send("DISCONNECTED")
+	var got int
+	buf := make([]byte, 16)
+	for {
+		_, err := conn.Read(buf)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got++
+	}
+	if got < n {
+		t.Errorf("Got %d frames, expected at least %d", got, n)
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,10 +23,21 @@ type Conn struct {
 
 	srcCall, dstCall string
 	via              []string
+	pid              uint8 // The PID of data frames sent on this connection
 
 	readDeadline, writeDeadline time.Time
 
-	closing bool // Guard against Write calls once Close() is called.
+	// Local estimate of the number of outstanding frames, corrected whenever the TNC is queried.
+	// Used to avoid querying the TNC on every Write.
+	mu          sync.Mutex
+	outstanding int
+
+	// Estimated time when the TNC is done transmitting the data written so far.
+	// Used by Flush when the TNC does not support 'Y' frames.
+	txDoneAt time.Time
+
+	closing bool          // Guard against Write calls once Close() is called.
+	closed  chan struct{} // Closed by Close() to unblock pending Read calls.
 }
 
 func newConn(p *Port, dstCall string, via ...string) *Conn {
@@ -47,17 +60,31 @@ func newConn(p *Port, dstCall string, via ...string) *Conn {
 		srcCall:    p.mycall,
 		dstCall:    dstCall,
 		via:        via,
+		pid:        PIDNoLayer3,
 		dataFrames: dataFrames,
+		closed:     make(chan struct{}),
 	}
 }
 
 func reverseToFrom() bool { t, _ := strconv.ParseBool(os.Getenv("AGWPE_REVERSE_TO_FROM")); return t }
 
+// The time to wait for an answer to a 'Y' frame before concluding that the TNC does not support it.
+var outstandingFramesTimeout = 30 * time.Second
+
+// errOutstandingFramesUnsupported is returned by numOutstandingFrames if the TNC does not answer 'Y' frames.
+var errOutstandingFramesUnsupported = errors.New("'Y' frame not supported by TNC")
+
 // This requires Direwolf >= 1.4, but reliability improved as late as 1.6. It's required in order to flush tx buffers before link teardown.
+//
+// Older TNCs (e.g. older Direwolf, some QtSoundModem versions) never answer. This is detected on the first
+// timeout and cached on the Port, so that subsequent calls fail right away with errOutstandingFramesUnsupported.
 func (c *Conn) numOutstandingFrames() (int, error) {
 	if c.demux.isClosed() {
 		return 0, io.EOF
 	}
+	if c.p.noOutstandingFrames.Load() {
+		return 0, errOutstandingFramesUnsupported
+	}
 	resp := c.demux.NextFrame(kindOutstandingFramesForConn)
 
 	from, to := c.srcCall, c.dstCall
@@ -81,23 +108,85 @@ func (c *Conn) numOutstandingFrames() (int, error) {
 			return 0, fmt.Errorf("'%c' frame with unexpected data length", f.DataKind)
 		}
 		return int(binary.LittleEndian.Uint32(f.Data)), nil
-	case <-time.After(30 * time.Second):
+	case <-time.After(outstandingFramesTimeout):
 		debugf("'%c' answer timeout. frame kind probably unsupported by TNC.", f.DataKind)
-		return 0, fmt.Errorf("'%c' frame timeout", f.DataKind)
+		c.p.noOutstandingFrames.Store(true)
+		return 0, errOutstandingFramesUnsupported
+	}
+}
+
+// ErrStatsUnsupported is returned by Conn.Stats when the TNC does not report link statistics.
+var ErrStatsUnsupported = errors.New("link statistics not supported by TNC")
+
+// Stats returns the number of frame retries and the round trip time of the connection, for warning
+// the user that the link is struggling before it drops.
+//
+// The AGWPE protocol has no frame kind reporting retries or round trip times, and none of the known
+// TNCs (AGW Packet Engine, Direwolf and QtSoundModem) extends it with one. ErrStatsUnsupported is
+// therefore returned for all of them. io.EOF is returned if the connection is closed.
+//
+// The number of outstanding frames (see Flush) is the only link state these TNCs report. A number
+// that keeps growing indicates a struggling link.
+func (c *Conn) Stats() (retries int, rtt time.Duration, err error) {
+	if c.demux.isClosed() {
+		return 0, 0, io.EOF
 	}
+	return 0, 0, ErrStatsUnsupported
 }
 
 // Flush implements the transport.Flusher interface.
+//
+// If the TNC does not support 'Y' frames, Flush falls back to waiting for the estimated time it takes to
+// transmit the data written so far at the port's baud rate.
 func (c *Conn) Flush() error {
 	debugf("flushing...")
 	defer debugf("flushed")
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
-	return c.waitOutstandingFrames(ctx, func(n int) bool { return n == 0 })
+	n, err := c.waitOutstandingFrames(ctx, func(n int) bool { return n == 0 })
+	c.setOutstanding(n)
+	if errors.Is(err, errOutstandingFramesUnsupported) {
+		return c.flushEstimated(ctx)
+	}
+	return err
+}
+
+// flushEstimated blocks until the TNC is estimated to be done transmitting (see txDoneAt).
+func (c *Conn) flushEstimated(ctx context.Context) error {
+	c.mu.Lock()
+	d := time.Until(c.txDoneAt)
+	c.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	debugf("'Y' frame unsupported. waiting %s for the estimated transmit time...", d)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
 }
 
-// waitOutstandingFrames blocks until the number of outstanding frames is less than the given limit.
-func (c *Conn) waitOutstandingFrames(ctx context.Context, stop func(int) bool) error {
+// addTxTime adds the estimated time it takes to transmit n bytes at the port's baud rate to txDoneAt.
+func (c *Conn) addTxTime(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now := time.Now(); c.txDoneAt.Before(now) {
+		c.txDoneAt = now
+	}
+	c.txDoneAt = c.txDoneAt.Add(time.Duration(n) * 8 * time.Second / time.Duration(c.p.baud))
+}
+
+func (c *Conn) setOutstanding(n int) { c.mu.Lock(); c.outstanding = n; c.mu.Unlock() }
+
+func (c *Conn) getOutstanding() int { c.mu.Lock(); defer c.mu.Unlock(); return c.outstanding }
+
+// waitOutstandingFrames blocks until stop returns true for the number of outstanding frames.
+//
+// The last number of outstanding frames reported by the TNC is returned.
+func (c *Conn) waitOutstandingFrames(ctx context.Context, stop func(int) bool) (int, error) {
+	var last int
 	errs := make(chan error, 1)
 	go func() {
 		defer close(errs)
@@ -109,6 +198,9 @@ func (c *Conn) waitOutstandingFrames(ctx context.Context, stop func(int) bool) e
 				errs <- err
 				return
 			}
+			c.mu.Lock()
+			last = n
+			c.mu.Unlock()
 			if stop(n) {
 				return
 			}
@@ -120,18 +212,25 @@ func (c *Conn) waitOutstandingFrames(ctx context.Context, stop func(int) bool) e
 			}
 		}
 	}()
+	var err error
 	select {
 	case <-ctx.Done():
 		debugf("outstanding frames wait ended: %v", ctx.Err())
-		return ctx.Err()
-	case err := <-errs:
+		err = ctx.Err()
+	case err = <-errs:
 		if err != nil {
 			debugf("outstanding frames wait error: %v", err)
 		}
-		return err
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return last, err
 }
 
+// Write writes data to the connection.
+//
+// The TNC is only queried for outstanding frames when the local estimate exceeds MAXFRAME, and when
+// starting a new burst of writes (to make sure a subsequent Flush sees the data written).
 func (c *Conn) Write(p []byte) (int, error) {
 	if c.closing {
 		return 0, io.EOF
@@ -145,22 +244,44 @@ func (c *Conn) Write(p []byte) (int, error) {
 	}
 	// Block until we have no more than MAXFRAME outstanding frames, so we don't keep filling the TX buffer.
 	// bug(martinhpedersen): MAXFRAME is not always correct. EMAXFRAME could apply for this connection, but there is no way of knowing.
-	if err := c.waitOutstandingFrames(ctx, func(n int) bool { return n <= c.p.maxFrame }); err != nil {
-		return 0, err
+	if c.getOutstanding() > c.p.maxFrame {
+		n, err := c.waitOutstandingFrames(ctx, func(n int) bool { return n <= c.p.maxFrame })
+		if err != nil {
+			return 0, err
+		}
+		c.setOutstanding(n)
 	}
 	cp := make([]byte, len(p))
 	copy(cp, p)
-	f := connectedDataFrame(c.p.port, c.srcCall, c.dstCall, p)
+	f := connectedDataFrame(c.p.port, c.pid, c.srcCall, c.dstCall, p)
 	if err := c.p.write(f); err != nil {
 		return 0, err
 	}
+	c.addTxTime(len(p))
+	if c.p.noOutstandingFrames.Load() {
+		return len(p), nil // No way of knowing. Flush will wait for the estimated transmit time.
+	}
+	if c.getOutstanding() > 0 {
+		c.mu.Lock()
+		c.outstanding++
+		c.mu.Unlock()
+		return len(p), nil
+	}
 	// Block until we see at least one outstanding frame to avoid race condition if Flush() is called immediately after this.
-	if err := c.waitOutstandingFrames(ctx, func(n int) bool { return n > 0 }); err != nil {
+	n, err := c.waitOutstandingFrames(ctx, func(n int) bool { return n > 0 })
+	if errors.Is(err, errOutstandingFramesUnsupported) {
+		return len(p), nil // Detected just now. The data was written.
+	}
+	if err != nil {
 		return 0, err
 	}
+	c.setOutstanding(n)
 	return len(p), nil
 }
 
+// Read reads data from the connection.
+//
+// A Read blocked waiting for data returns promptly with io.EOF once Close is called (from any goroutine).
 func (c *Conn) Read(p []byte) (int, error) {
 	ctx := context.Background()
 	if !c.readDeadline.IsZero() {
@@ -172,6 +293,8 @@ func (c *Conn) Read(p []byte) (int, error) {
 	case <-ctx.Done():
 		// TODO (read timeout error)
 		return 0, ctx.Err()
+	case <-c.closed:
+		return 0, io.EOF
 	case f, ok := <-c.dataFrames:
 		if !ok {
 			return 0, io.EOF
@@ -189,6 +312,7 @@ func (c *Conn) Close() error {
 		return nil
 	}
 	c.closing = true
+	close(c.closed)
 	defer c.demux.Close()
 	if err := c.Flush(); err == io.EOF {
 		debugf("link closed while flushing")
@@ -208,7 +332,17 @@ func (c *Conn) Close() error {
 	}
 }
 
-func (c *Conn) connect(ctx context.Context) error {
+// connect dials the remote station.
+//
+// On failure, the connection's demux is closed so that no goroutines or frame
+// subscriptions are left behind.
+func (c *Conn) connect(ctx context.Context) (err error) {
+	defer func() {
+		if err != nil {
+			c.demux.Close()
+		}
+	}()
+
 	// We handle context cancellation by sending a disconect to the TNC. This will
 	// cause the TNC to send a disconnect frame back to us if the TNC supports it, or
 	// keep dialing until connect or timeout. The latter is the case with Direwolf as
@@ -226,11 +360,13 @@ func (c *Conn) connect(ctx context.Context) error {
 	}()
 
 	ack := c.demux.NextFrame(kindConnect, kindDisconnect)
-	if err := c.p.write(connectFrame(c.srcCall, c.dstCall, c.p.port, c.via)); err != nil {
+	if err := c.p.write(connectPIDFrame(c.srcCall, c.dstCall, c.p.port, c.pid, c.via)); err != nil {
 		return err
 	}
 	f, ok := <-ack
 	if !ok {
+		// Make sure the TNC is not left with a half-open link.
+		c.p.write(disconnectFrame(c.srcCall, c.dstCall, c.p.port))
 		return ErrPortClosed
 	}
 	done <- struct{}{} // Dial cancellation is no longer possible.
@@ -0,0 +1,523 @@
+package agwpe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+// fakeTNC answers the frames sent during a connection teardown.
+//
+// It returns the TNC end of the pipe, which can be used to inject frames.
+func fakeTNC(t *testing.T) (*TNC, net.Conn) {
+	t.Helper()
+	client, srv := net.Pipe()
+	go func() {
+		for {
+			var f frame
+			if _, err := f.ReadFrom(srv); err != nil {
+				return
+			}
+			switch f.DataKind {
+			case kindOutstandingFramesForConn:
+				f.Data = make([]byte, 4)
+				binary.LittleEndian.PutUint32(f.Data, 0)
+			case kindDisconnect:
+				f.Data = []byte("*** DISCONNECTED From Station " + f.To.String())
+			default:
+				continue
+			}
+			if _, err := f.WriteTo(srv); err != nil {
+				return
+			}
+		}
+	}()
+	tnc := newTNC(client)
+	t.Cleanup(func() { tnc.Close() })
+	return tnc, srv
+}
+
+func TestCloseUnblocksRead(t *testing.T) {
+	tnc, _ := fakeTNC(t)
+	p := newPort(tnc, 0, "N0CALL")
+	conn := newConn(p, "LA5NTA")
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 256))
+		errs <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond) // Let Read block
+	go conn.Close()
+
+	select {
+	case err := <-errs:
+		if err != io.EOF {
+			t.Errorf("Got %v, expected io.EOF", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after Close")
+	}
+}
+
+func TestStatsUnsupported(t *testing.T) {
+	tnc, _ := fakeTNC(t)
+	p := newPort(tnc, 0, "N0CALL")
+	conn := newConn(p, "LA5NTA")
+
+	if _, _, err := conn.Stats(); !errors.Is(err, ErrStatsUnsupported) {
+		t.Errorf("Got %v, expected ErrStatsUnsupported", err)
+	}
+	conn.Close()
+	if _, _, err := conn.Stats(); err != io.EOF {
+		t.Errorf("Got %v after Close, expected io.EOF", err)
+	}
+}
+
+func TestInboundRemoteAddrVia(t *testing.T) {
+	tnc, srv := fakeTNC(t)
+	p := newPort(tnc, 0, "N0CALL")
+
+	ln, _ := p.Listen()
+	defer ln.Close()
+
+	conns := make(chan *Conn, 1)
+	go func() { conns <- <-p.inboundConns }()
+	time.Sleep(50 * time.Millisecond) // Let the receiver block
+
+	f := frame{Data: []byte("*** CONNECTED To Station LA5NTA via LA1B-10,LA3F\r")}
+	f.DataKind = kindConnect
+	f.From, f.To = callsignFromString("LA5NTA"), callsignFromString("N0CALL")
+	if _, err := f.WriteTo(srv); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case conn := <-conns:
+		if got, expect := conn.RemoteAddr().String(), "LA5NTA via LA1B-10 LA3F"; got != expect {
+			t.Errorf("Got RemoteAddr '%s', expected '%s'", got, expect)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Inbound connection not accepted")
+	}
+}
+
+func TestListenerBacklog(t *testing.T) {
+	client, srv := net.Pipe()
+	var srvMu sync.Mutex // Frames are written in two parts. Don't interleave the responder's writes with connect's.
+	writeFrame := func(f frame) error {
+		srvMu.Lock()
+		defer srvMu.Unlock()
+		_, err := f.WriteTo(srv)
+		return err
+	}
+	refused := make(chan string, 10)
+	go func() {
+		for {
+			var f frame
+			if _, err := f.ReadFrom(srv); err != nil {
+				return
+			}
+			switch f.DataKind {
+			case kindOutstandingFramesForConn:
+				f.Data = make([]byte, 4)
+			case kindDisconnect:
+				refused <- f.To.String()
+				f.Data = []byte("*** DISCONNECTED From Station " + f.To.String())
+			default:
+				continue
+			}
+			f.DataLen = uint32(len(f.Data))
+			if err := writeFrame(f); err != nil {
+				return
+			}
+		}
+	}()
+	tnc := newTNC(client)
+	defer tnc.Close()
+	p := newPort(tnc, 0, "N0CALL")
+
+	connect := func(from string) {
+		f := frame{Data: []byte("*** CONNECTED To Station " + from + "\r")}
+		f.DataKind = kindConnect
+		f.DataLen = uint32(len(f.Data))
+		f.From, f.To = callsignFromString(from), callsignFromString("N0CALL")
+		if err := writeFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectRefused := func(expect ...string) {
+		t.Helper()
+		var got []string
+	L:
+		for {
+			select {
+			case call := <-refused:
+				got = append(got, call)
+			case <-time.After(100 * time.Millisecond):
+				break L
+			}
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("Got refused %q, expected %q", got, expect)
+		}
+	}
+
+	// Connects are refused when no one is listening
+	connect("LA1B")
+	expectRefused("LA1B")
+
+	// Connects arriving while the listener is busy are queued, up to the backlog size
+	ln, _ := p.Listen()
+	calls := []string{"LA3F", "LA5NTA", "LD5SK", "LD5GU", "LA9SSA"}[:ListenBacklog+1]
+	for i, call := range calls[:ListenBacklog] {
+		connect(call)
+		// The port buffer drops frames when full. Wait for each connect to be queued before sending the next.
+		for deadline := time.Now().Add(time.Second); len(p.backlog.conns) < i+1; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Connect from %s not queued", call)
+			}
+		}
+	}
+	connect(calls[ListenBacklog])
+	expectRefused(calls[ListenBacklog])
+	for _, expect := range calls[:ListenBacklog] {
+		time.Sleep(10 * time.Millisecond) // Slow server
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conn.RemoteAddr().String(); got != expect {
+			t.Errorf("Got connection from %s, expected %s", got, expect)
+		}
+	}
+	expectRefused()
+
+	// Queued connections are closed with the last listener
+	connect("LA1B")
+	time.Sleep(50 * time.Millisecond)
+	ln.Close()
+	expectRefused("LA1B")
+}
+
+func TestDialURLSource(t *testing.T) {
+	client, srv := net.Pipe()
+	connects := make(chan frame, 2)
+	go func() {
+		for {
+			var f frame
+			if _, err := f.ReadFrom(srv); err != nil {
+				return
+			}
+			if f.DataKind != kindConnect {
+				continue
+			}
+			connects <- f
+			f.From, f.To = f.To, f.From
+			f.Data = []byte("*** CONNECTED With Station " + f.From.String() + "\r")
+			f.DataLen = uint32(len(f.Data))
+			if _, err := f.WriteTo(srv); err != nil {
+				return
+			}
+		}
+	}()
+	tnc := newTNC(client)
+	defer tnc.Close()
+	p := newPort(tnc, 0, "N0CALL")
+
+	tests := map[string]string{
+		"ax25://N0CALL@/LA1B":                    "N0CALL",
+		"ax25://N0CALL@/LA1B-10?source=N0CALL-7": "N0CALL-7",
+	}
+	for str, expect := range tests {
+		url, err := transport.ParseURL(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := p.DialURLContext(context.Background(), url)
+		if err != nil {
+			t.Fatalf("'%s': %v", str, err)
+		}
+		if got := conn.LocalAddr().String(); got != expect {
+			t.Errorf("'%s': Got local address %s, expected %s", str, got, expect)
+		}
+		if f := <-connects; f.From.String() != expect || f.To.String() != url.Target {
+			t.Errorf("'%s': Got connect frame from %s to %s, expected from %s to %s", str, f.From, f.To, expect, url.Target)
+		}
+	}
+}
+
+func TestDialContextPID(t *testing.T) {
+	client, srv := net.Pipe()
+	frames := make(chan frame, 4)
+	go func() {
+		for {
+			var f frame
+			if _, err := f.ReadFrom(srv); err != nil {
+				return
+			}
+			switch f.DataKind {
+			case kindConnect, kindConnectPID:
+				frames <- f
+				f.DataKind = kindConnect
+				f.From, f.To = f.To, f.From
+				f.Data = []byte("*** CONNECTED With Station " + f.From.String() + "\r")
+				f.DataLen = uint32(len(f.Data))
+				if _, err := f.WriteTo(srv); err != nil {
+					return
+				}
+			case kindConnectedData:
+				frames <- f
+			case kindOutstandingFramesForConn:
+				f.Data = make([]byte, 4)
+				binary.LittleEndian.PutUint32(f.Data, 1) // The frame just written
+				if _, err := f.WriteTo(srv); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	tnc := newTNC(client)
+	defer tnc.Close()
+	p := newPort(tnc, 0, "N0CALL")
+
+	tests := []struct {
+		pid         byte
+		connectKind kind
+	}{
+		{PIDNoLayer3, kindConnect},
+		{PIDNetROM, kindConnectPID},
+	}
+	for _, test := range tests {
+		conn, err := p.DialContextPID(context.Background(), "LA1B", test.pid)
+		if err != nil {
+			t.Fatalf("PID 0x%X: %v", test.pid, err)
+		}
+		if f := <-frames; f.DataKind != test.connectKind || (test.connectKind == kindConnectPID && f.PID != test.pid) {
+			t.Errorf("PID 0x%X: Got connect frame '%c' with PID 0x%X", test.pid, f.DataKind, f.PID)
+		}
+		if _, err := conn.Write([]byte("foo")); err != nil {
+			t.Fatalf("PID 0x%X: %v", test.pid, err)
+		}
+		if f := <-frames; f.DataKind != kindConnectedData || f.PID != test.pid {
+			t.Errorf("PID 0x%X: Got data frame '%c' with PID 0x%X", test.pid, f.DataKind, f.PID)
+		}
+	}
+}
+
+func TestConnectedVia(t *testing.T) {
+	tests := map[string][]string{
+		"*** CONNECTED To Station LA5NTA\r":                  nil,
+		"*** CONNECTED To Station LA5NTA via LA1B-10\r":      {"LA1B-10"},
+		"*** CONNECTED To Station LA5NTA Via LA1B-10,LA3F":   {"LA1B-10", "LA3F"},
+		"*** CONNECTED To Station LA5NTA via LA1B-10 LA3F\r": {"LA1B-10", "LA3F"},
+	}
+	for data, expect := range tests {
+		if got := connectedVia([]byte(data)); !reflect.DeepEqual(got, expect) {
+			t.Errorf("%q: Got %q, expected %q", data, got, expect)
+		}
+	}
+}
+
+func TestConnectFailureTeardown(t *testing.T) {
+	tests := map[string]struct {
+		reply      kind
+		data       string
+		expectErr  string
+		disconnect bool // Expect a disconnect frame to be sent to the TNC
+	}{
+		"remote disconnect": {
+			reply:     kindDisconnect,
+			data:      "*** DISCONNECTED From Station LA5NTA\r",
+			expectErr: "*** DISCONNECTED From Station LA5NTA",
+		},
+		"precondition failed": {
+			reply:      kindConnect,
+			data:       "*** foobar\r",
+			expectErr:  "connect precondition failed",
+			disconnect: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, srv := net.Pipe()
+			received := make(chan kind, 10)
+			go func() {
+				for {
+					var f frame
+					if _, err := f.ReadFrom(srv); err != nil {
+						return
+					}
+					received <- f.DataKind
+					if f.DataKind != kindConnect {
+						continue
+					}
+					f.DataKind, f.From, f.To = tt.reply, f.To, f.From
+					f.Data = []byte(tt.data)
+					f.DataLen = uint32(len(f.Data))
+					if _, err := f.WriteTo(srv); err != nil {
+						return
+					}
+				}
+			}()
+			tnc := newTNC(client)
+			defer tnc.Close()
+			p := newPort(tnc, 0, "N0CALL")
+
+			time.Sleep(10 * time.Millisecond) // Let the port's goroutines settle
+			before := runtime.NumGoroutine()
+
+			c := newConn(p, "LA5NTA")
+			err := c.connect(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+				t.Fatalf("Got error %v, expected '%s'", err, tt.expectErr)
+			}
+			if !c.demux.isClosed() {
+				t.Error("Expected demux to be closed")
+			}
+
+			var gotDisconnect bool
+		L:
+			for {
+				select {
+				case k := <-received:
+					gotDisconnect = gotDisconnect || k == kindDisconnect
+				case <-time.After(50 * time.Millisecond):
+					break L
+				}
+			}
+			if gotDisconnect != tt.disconnect {
+				t.Errorf("Got disconnect frame %t, expected %t", gotDisconnect, tt.disconnect)
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if n := runtime.NumGoroutine(); n > before {
+				t.Errorf("Got %d goroutines, expected at most %d", n, before)
+			}
+		})
+	}
+}
+
+// loopbackTNC is a TNC stub which keeps track of outstanding data frames.
+//
+// Outstanding frames are acknowledged (as if transmitted and acked by the remote) right after
+// each outstanding frames query is answered.
+func TestFlushWithoutOutstandingFramesSupport(t *testing.T) {
+	defer func(d time.Duration) { outstandingFramesTimeout = d }(outstandingFramesTimeout)
+	outstandingFramesTimeout = 50 * time.Millisecond
+
+	// A TNC that never answers 'Y' frames
+	client, srv := net.Pipe()
+	queries := make(chan struct{}, 10)
+	go func() {
+		for {
+			var f frame
+			if _, err := f.ReadFrom(srv); err != nil {
+				return
+			}
+			switch f.DataKind {
+			case kindOutstandingFramesForConn:
+				queries <- struct{}{}
+			case kindDisconnect:
+				f.Data = []byte("*** DISCONNECTED From Station " + f.To.String())
+				if _, err := f.WriteTo(srv); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	tnc := newTNC(client)
+	defer tnc.Close()
+	p := newPort(tnc, 0, "N0CALL")
+	conn := newConn(p, "LA5NTA")
+
+	// The first Write detects the missing support
+	if _, err := conn.Write([]byte("Hello, world!\r")); err != nil {
+		t.Fatal(err)
+	}
+	if !p.noOutstandingFrames.Load() {
+		t.Fatal("Missing 'Y' frame support not detected")
+	}
+
+	start := time.Now()
+	if _, err := conn.Write([]byte("Hello, world!\r")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// 28 bytes at 1200 baud is ~190ms
+	if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+		t.Errorf("Flush returned after %s, expected the estimated transmit time", d)
+	}
+	if len(queries) != 1 {
+		t.Errorf("Got %d 'Y' frames, expected 1", len(queries))
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func loopbackTNC(tb testing.TB) *TNC {
+	tb.Helper()
+	client, srv := net.Pipe()
+	go func() {
+		var outstanding uint32
+		for {
+			var f frame
+			if _, err := f.ReadFrom(srv); err != nil {
+				return
+			}
+			switch f.DataKind {
+			case kindConnectedData:
+				outstanding++
+				continue
+			case kindOutstandingFramesForConn:
+				f.Data = make([]byte, 4)
+				binary.LittleEndian.PutUint32(f.Data, outstanding)
+				outstanding = 0
+			case kindDisconnect:
+				f.Data = []byte("*** DISCONNECTED From Station " + f.To.String())
+			default:
+				continue
+			}
+			if _, err := f.WriteTo(srv); err != nil {
+				return
+			}
+		}
+	}()
+	tnc := newTNC(client)
+	tb.Cleanup(func() { tnc.Close() })
+	return tnc
+}
+
+func BenchmarkConnWrite(b *testing.B) {
+	p := newPort(loopbackTNC(b), 0, "N0CALL")
+	p.maxFrame = 7
+	conn := newConn(p, "LA5NTA")
+	data := make([]byte, 128)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		b.Fatal(err)
+	}
+}
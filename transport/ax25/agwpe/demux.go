@@ -53,12 +53,14 @@ type demux struct {
 
 	mu     sync.Mutex
 	closed bool
+	done   chan struct{} // Closed by Close
 	in     chan frame
 }
 
 func newDemux() *demux {
 	d := demux{
 		in:       make(chan frame, 1),
+		done:     make(chan struct{}),
 		requests: make(chan framesReq),
 	}
 	go d.run()
@@ -82,9 +84,15 @@ func (d *demux) Chain(filter framesFilter) *demux {
 		defer next.Close()
 		defer debugf("chain exited")
 		for {
-			f, ok := <-filtered
-			if !ok {
-				return
+			var f frame
+			var ok bool
+			select {
+			case f, ok = <-filtered:
+				if !ok {
+					return
+				}
+			case <-next.done:
+				return // Don't wait for the next frame to find out.
 			}
 			if !next.Enqueue(f) {
 				return
@@ -101,6 +109,7 @@ func (d *demux) Close() error {
 		return nil
 	}
 	close(d.in)
+	close(d.done)
 	d.closed = true
 	return nil
 }
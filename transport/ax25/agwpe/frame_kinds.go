@@ -15,6 +15,7 @@ const (
 	kindPortCapabilities         kind = 'g'
 
 	kindConnect                  kind = 'C'
+	kindConnectPID               kind = 'c' // Connect with non-standard PID
 	kindConnectVia               kind = 'v'
 	kindDisconnect               kind = 'd'
 	kindConnectedData            kind = 'D'
@@ -35,12 +36,12 @@ func portCapabilitiesFrame(port uint8) frame {
 	}
 }
 
-func connectedDataFrame(port uint8, from, to string, data []byte) frame {
+func connectedDataFrame(port, pid uint8, from, to string, data []byte) frame {
 	return frame{
 		header: header{
 			Port:     port,
 			DataKind: kindConnectedData,
-			PID:      0xf0,
+			PID:      pid,
 			From:     callsignFromString(from),
 			To:       callsignFromString(to),
 			DataLen:  uint32(len(data)),
@@ -96,18 +97,38 @@ func connectFrame(from, to string, port uint8, digis []string) frame {
 		return connectViaFrame(from, to, port, digis)
 	}
 	return frame{header: header{
+		Port:     port,
 		DataKind: kindConnect,
 		From:     callsignFromString(from),
 		To:       callsignFromString(to),
 	}}
 }
 
+// connectPIDFrame returns a connect frame for a connection using the given PID.
+//
+// The standard connect frame is used for PIDNoLayer3, and whenever digipeaters are given (not supported
+// by the non-standard connect frame).
+func connectPIDFrame(from, to string, port, pid uint8, digis []string) frame {
+	if pid == PIDNoLayer3 || len(digis) > 0 {
+		return connectFrame(from, to, port, digis)
+	}
+	return frame{header: header{
+		Port:     port,
+		DataKind: kindConnectPID,
+		PID:      pid,
+		From:     callsignFromString(from),
+		To:       callsignFromString(to),
+	}}
+}
+
 func connectViaFrame(from, to string, port uint8, digis []string) frame {
 	h := header{
+		Port:     port,
 		DataKind: kindConnectVia,
 		From:     callsignFromString(from),
 		To:       callsignFromString(to),
 	}
+	// The digipeaters are given in transmit order (first digi first), as in transport.URL.
 	var buf bytes.Buffer
 	buf.WriteByte(uint8(len(digis)))
 	for _, str := range digis {
@@ -89,3 +89,17 @@ func TestFrameDecode(t *testing.T) {
 		t.Error("got unexpected output")
 	}
 }
+
+func TestConnectViaFrame(t *testing.T) {
+	f := connectFrame("LA5NTA", "LA1B-10", 1, []string{"LD5SK", "LD5GU"})
+	if f.DataKind != kindConnectVia || f.Port != 1 {
+		t.Errorf("Got kind %c on port %d, expected %c on port 1", f.DataKind, f.Port, kindConnectVia)
+	}
+
+	// Number of digis followed by the digis in transmit order
+	first, second := callsignFromString("LD5SK"), callsignFromString("LD5GU")
+	expect := append(append([]byte{2}, first[:]...), second[:]...)
+	if !bytes.Equal(f.Data, expect) {
+		t.Errorf("Got data %q, expected %q", f.Data, expect)
+	}
+}
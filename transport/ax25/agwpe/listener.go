@@ -8,6 +8,72 @@ import (
 
 var ErrListenerClosed = errors.New("listener closed")
 
+// ListenBacklog is the number of inbound connections queued while waiting for Listener.Accept.
+//
+// Inbound connections are refused when no listener is open on the port, or when the backlog is full.
+// Changes apply to ports registered afterwards.
+var ListenBacklog = 4
+
+// backlog holds inbound connections waiting to be accepted.
+type backlog struct {
+	conns chan *Conn
+
+	mu        sync.Mutex
+	listeners int  // Number of open listeners
+	closed    bool // Set when conns is closed
+}
+
+func newBacklog(size int) *backlog {
+	if size < 0 {
+		size = 0
+	}
+	return &backlog{conns: make(chan *Conn, size)}
+}
+
+// enqueue queues conn for Accept, returning false if the connection should be refused.
+func (b *backlog) enqueue(conn *Conn) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.listeners == 0 || b.closed {
+		return false
+	}
+	select {
+	case b.conns <- conn:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *backlog) listen() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners++
+}
+
+// unlisten removes a listener. Queued connections are closed when the last listener is gone.
+func (b *backlog) unlisten() {
+	b.mu.Lock()
+	b.listeners--
+	var pending []*Conn
+	for b.listeners == 0 && !b.closed && len(b.conns) > 0 {
+		pending = append(pending, <-b.conns)
+	}
+	b.mu.Unlock()
+
+	for _, conn := range pending {
+		debugf("closing queued inbound connection from %s", conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+func (b *backlog) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	close(b.conns)
+}
+
 type Listener struct {
 	p *Port
 
@@ -15,7 +81,10 @@ type Listener struct {
 	done      chan struct{}
 }
 
-func newListener(p *Port) *Listener { return &Listener{p: p, done: make(chan struct{})} }
+func newListener(p *Port) *Listener {
+	p.backlog.listen()
+	return &Listener{p: p, done: make(chan struct{})}
+}
 
 func (ln *Listener) Accept() (net.Conn, error) {
 	select {
@@ -32,6 +101,9 @@ func (ln *Listener) Accept() (net.Conn, error) {
 func (ln *Listener) Addr() net.Addr { return addr{dest: ln.p.mycall} }
 
 func (ln *Listener) Close() error {
-	ln.closeOnce.Do(func() { close(ln.done) })
+	ln.closeOnce.Do(func() {
+		close(ln.done)
+		ln.p.backlog.unlisten()
+	})
 	return nil
 }
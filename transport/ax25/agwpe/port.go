@@ -6,6 +6,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/la5nta/wl2k-go/transport"
@@ -17,8 +19,13 @@ type Port struct {
 	port         uint8
 	mycall       string
 	maxFrame     int
+	baud         int // On air baud rate (used to estimate flush time when 'Y' frames are unsupported)
 	demux        *demux
 	inboundConns <-chan *Conn
+	backlog      *backlog
+
+	// Set once the TNC is known to not answer 'Y' (outstanding frames) queries.
+	noOutstandingFrames *atomic.Bool // A pointer, as TNCPort holds a copy of the Port
 }
 
 func newPort(tnc *TNC, port uint8, mycall string) *Port {
@@ -27,33 +34,37 @@ func newPort(tnc *TNC, port uint8, mycall string) *Port {
 		tnc:    tnc,
 		port:   port,
 		mycall: mycall,
+		baud:   1200,
 		demux:  demux,
+
+		noOutstandingFrames: new(atomic.Bool),
 	}
+	p.backlog = newBacklog(ListenBacklog)
 	p.inboundConns = p.handleInbound()
 	return p
 }
 
 func (p *Port) handleInbound() <-chan *Conn {
-	conns := make(chan *Conn)
+	conns := p.backlog.conns
+	// Subscribe before returning, so connects arriving right after registration are not missed.
+	connects, cancel := p.demux.Frames(1, framesFilter{
+		kinds: []kind{kindConnect},
+		to:    callsignFromString(p.mycall),
+	})
 	go func() {
-		defer close(conns)
-		connects, cancel := p.demux.Frames(1, framesFilter{
-			kinds: []kind{kindConnect},
-			to:    callsignFromString(p.mycall),
-		})
+		defer p.backlog.close()
 		defer cancel()
 		for f := range connects {
 			if !bytes.HasPrefix(f.Data, []byte("*** CONNECTED To ")) {
 				debugf("inbound connection from %s not initiated by remote. ignoring.", f.From)
 				continue
 			}
-			conn := newConn(p, f.From.String())
+			conn := newConn(p, f.From.String(), connectedVia(f.Data)...)
 			conn.inbound = true
-			select {
-			case conns <- conn:
+			if p.backlog.enqueue(conn) {
 				debugf("inbound connection from %s accepted", f.From)
-			default:
-				// No one is calling Listener.Accept() just now. Close it.
+			} else {
+				// No one is listening, or the backlog is full. Close it.
 				conn.Close()
 				debugf("inbound connection from %s refused", f.From)
 			}
@@ -62,6 +73,18 @@ func (p *Port) handleInbound() <-chan *Conn {
 	return conns
 }
 
+// connectedVia returns the digipeater path from a connect frame's data, if the TNC included one.
+//
+// Example: "*** CONNECTED To Station LA5NTA via LA1B-10,LA3F".
+func connectedVia(data []byte) []string {
+	str := strings.TrimRight(strFromBytes(data), "\r\n ")
+	idx := strings.Index(strings.ToLower(str), " via ")
+	if idx < 0 {
+		return nil
+	}
+	return strings.FieldsFunc(str[idx+len(" via "):], func(r rune) bool { return r == ',' || r == ' ' })
+}
+
 func (p *Port) register(ctx context.Context) error {
 	capabilities, err := p.getCapabilities(ctx)
 	if err != nil {
@@ -69,6 +92,7 @@ func (p *Port) register(ctx context.Context) error {
 		p.maxFrame = 7 // Set a reasonable default.
 	} else {
 		p.maxFrame = int(capabilities.MaxFrame)
+		p.baud = capabilities.baud()
 	}
 
 	// QtSoundModem responds with a 'x' frame instead of the expected 'X' frame.
@@ -92,7 +116,7 @@ func (p *Port) register(ctx context.Context) error {
 }
 
 type portCapabilities struct {
-	_        byte  // On air baud rate (0=1200/1=2400/2=4800/3=9600…)
+	BaudRate byte  // On air baud rate (0=1200/1=2400/2=4800/3=9600…)
 	_        byte  // Traffic level (if 0xFF the port is not in autoupdate mode)
 	_        byte  // TX Delay
 	_        byte  // TX Tail
@@ -103,6 +127,14 @@ type portCapabilities struct {
 	_        int32 // HowManyBytes (received in the last 2 minutes)
 }
 
+// baud returns the on air baud rate.
+func (c portCapabilities) baud() int {
+	if c.BaudRate > 7 {
+		return 1200 // Unknown. Assume the worst.
+	}
+	return 1200 << c.BaudRate
+}
+
 func (p *Port) getCapabilities(ctx context.Context) (*portCapabilities, error) {
 	resp := p.demux.NextFrame(kindPortCapabilities)
 	if err := p.write(portCapabilitiesFrame(p.port)); err != nil {
@@ -132,20 +164,49 @@ func (p *Port) Close() error {
 	return p.demux.Close()
 }
 
+// DialURLContext dials ax25://, ax25+agwpe:// and agwpe+ax25:// URLs.
+//
+// The connection is made from the port's registered callsign, unless the URL's source query parameter
+// overrides it (see transport.URL.Source). The TNC must accept connects from the given source callsign.
 func (p *Port) DialURLContext(ctx context.Context, url *transport.URL) (net.Conn, error) {
 	if url.Scheme != "ax25" && url.Scheme != "ax25+agwpe" && url.Scheme != "agwpe+ax25" {
 		return nil, fmt.Errorf("unsupported scheme '%s'", url.Scheme)
 	}
-	return p.DialContext(ctx, url.Target, url.Digis...)
+	return p.dial(ctx, url.Params.Get("source"), url.Target, PIDNoLayer3, url.Digis...)
 }
 
 func (p *Port) DialContext(ctx context.Context, target string, via ...string) (net.Conn, error) {
+	return p.dial(ctx, "", target, PIDNoLayer3, via...)
+}
+
+// Common AX.25 protocol identifiers (PID) for use with DialContextPID.
+const (
+	PIDIP       = 0xCC // ARPA Internet Protocol
+	PIDARP      = 0xCD // ARPA Address Resolution Protocol
+	PIDFlexNet  = 0xCE // FlexNet
+	PIDNetROM   = 0xCF // NET/ROM
+	PIDNoLayer3 = 0xF0 // No layer 3 protocol (plain text, e.g. Winlink). The default.
+)
+
+// DialContextPID is like DialContext, but the data frames of the connection are sent with the given
+// PID instead of PIDNoLayer3. This is required for tunneling non-text protocols (e.g. IP or NET/ROM).
+//
+// A non-standard connect frame is used when pid is not PIDNoLayer3, unless digipeaters are given.
+func (p *Port) DialContextPID(ctx context.Context, target string, pid byte, via ...string) (net.Conn, error) {
+	return p.dial(ctx, "", target, pid, via...)
+}
+
+// dial connects to target from the given source callsign (the port's callsign if empty).
+func (p *Port) dial(ctx context.Context, source, target string, pid byte, via ...string) (net.Conn, error) {
 	if p.demux.isClosed() {
 		return nil, ErrPortClosed
 	}
 	c := newConn(p, target, via...)
+	c.pid = pid
+	if source != "" {
+		c.srcCall = source
+	}
 	if err := c.connect(ctx); err != nil {
-		c.demux.Close()
 		return nil, err
 	}
 	return c, nil
@@ -158,6 +219,20 @@ func (p *Port) Listen() (net.Listener, error) {
 	return newListener(p), nil
 }
 
+// ListenURL implements the transport.URLListener interface for ax25://, ax25+agwpe:// and agwpe+ax25:// URLs.
+//
+// Inbound connections are accepted on the port's registered callsign. A different callsign given by the
+// URL is an error.
+func (p *Port) ListenURL(url *transport.URL) (net.Listener, error) {
+	if url.Scheme != "ax25" && url.Scheme != "ax25+agwpe" && url.Scheme != "agwpe+ax25" {
+		return nil, fmt.Errorf("unsupported scheme '%s'", url.Scheme)
+	}
+	if call := url.MyCall(); call != "" && !strings.EqualFold(call, p.mycall) {
+		return nil, fmt.Errorf("port is registered as %s, not %s", p.mycall, call)
+	}
+	return p.Listen()
+}
+
 func (p *Port) SendUI(data []byte, dst string) error {
 	if p.demux.isClosed() {
 		return ErrPortClosed
@@ -37,6 +37,13 @@ const (
 
 const _NETWORK = "AX.25"
 
+// bug(martinhpedersen): The AX.25 stack does not support SOCK_STREAM, so any write to the connection
+// that is larger than maximum packet length will fail. The b2f impl. requires 125 bytes long packets.
+//
+// ErrMessageTooLong is returned by Write when the message exceeds the maximum packet length.
+// KenwoodConn enforces the configured packet length for consistency with the Linux AX.25 stack.
+var ErrMessageTooLong = errors.New("Write: Message too long. Consider increasing maximum packet length to >= 125.")
+
 var DefaultDialer = &Dialer{Timeout: 45 * time.Second}
 
 func init() {
@@ -44,6 +51,10 @@ func init() {
 	transport.RegisterDialer("serial-tnc", DefaultDialer)
 	transport.RegisterDialer("ax25+linux", DefaultDialer)
 	transport.RegisterDialer("ax25+serial-tnc", DefaultDialer)
+
+	for _, scheme := range []string{"ax25", "serial-tnc", "ax25+linux", "ax25+serial-tnc"} {
+		transport.RegisterListener(scheme, transport.ListenerFunc(ListenURL))
+	}
 }
 
 type addr interface {
@@ -130,6 +141,8 @@ func (d Dialer) DialURL(url *transport.URL) (net.Conn, error) {
 
 // DialURLContext dials ax25://, ax25+linux://, serial-tnc:// and ax25+serial-tnc:// URLs.
 //
+// The local address is bound to the URL's source callsign (see transport.URL.Source).
+//
 // If the context is cancelled while dialing, the connection may be closed gracefully before returning an error.
 func (d Dialer) DialURLContext(ctx context.Context, url *transport.URL) (net.Conn, error) {
 	target := url.Target
@@ -141,29 +154,18 @@ func (d Dialer) DialURLContext(ctx context.Context, url *transport.URL) (net.Con
 	case "ax25", "ax25+linux":
 		ctx, cancel := context.WithTimeout(ctx, d.Timeout)
 		defer cancel()
-		conn, err := DialAX25Context(ctx, url.Host, url.User.Username(), target)
+		conn, err := DialAX25Context(ctx, url.Host, url.Source(), target)
 		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
 			// Local timeout reached.
 			err = fmt.Errorf("Dial timeout")
 		}
 		return conn, err
 	case "serial-tnc", "ax25+serial-tnc":
-		// TODO: This is some badly designed legacy stuff. Need to re-think the whole
-		// serial-tnc scheme. See issue #34.
-		hbaud := HBaud(1200)
-		if i, _ := strconv.Atoi(url.Params.Get("hbaud")); i > 0 {
-			hbaud = HBaud(i)
-		}
-		serialBaud := DefaultSerialBaud
-		if i, _ := strconv.Atoi(url.Params.Get("serial_baud")); i > 0 {
-			serialBaud = i
-		}
-
 		return DialKenwood(
 			url.Host,
-			url.User.Username(),
+			url.Source(),
 			target,
-			NewConfig(hbaud, serialBaud),
+			kenwoodConfig(url),
 			nil,
 		)
 	default:
@@ -171,6 +173,35 @@ func (d Dialer) DialURLContext(ctx context.Context, url *transport.URL) (net.Con
 	}
 }
 
+// ListenURL listens on ax25://, ax25+linux://, serial-tnc:// and ax25+serial-tnc:// URLs.
+//
+// The local callsign is given by the URL's user or target (see transport.URL.MyCall), e.g. ax25://axport/N0CALL.
+func ListenURL(url *transport.URL) (net.Listener, error) {
+	switch url.Scheme {
+	case "ax25", "ax25+linux":
+		return ListenAX25(url.Host, url.MyCall())
+	case "serial-tnc", "ax25+serial-tnc":
+		return ListenKenwood(url.Host, url.MyCall(), kenwoodConfig(url))
+	default:
+		return nil, transport.ErrUnsupportedScheme
+	}
+}
+
+// kenwoodConfig returns the Config given by the hbaud and serial_baud parameters of a serial-tnc URL.
+func kenwoodConfig(url *transport.URL) Config {
+	// TODO: This is some badly designed legacy stuff. Need to re-think the whole
+	// serial-tnc scheme. See issue #34.
+	hbaud := HBaud(1200)
+	if i, _ := strconv.Atoi(url.Params.Get("hbaud")); i > 0 {
+		hbaud = HBaud(i)
+	}
+	serialBaud := DefaultSerialBaud
+	if i, _ := strconv.Atoi(url.Params.Get("serial_baud")); i > 0 {
+		serialBaud = i
+	}
+	return NewConfig(hbaud, serialBaud)
+}
+
 func AddressFromString(str string) Address {
 	parts := strings.Split(str, "-")
 	addr := Address{Call: parts[0]}
@@ -32,12 +32,7 @@ type ax25Addr C.struct_full_sockaddr_ax25
 
 var numAXPorts int
 
-// bug(martinhpedersen): The AX.25 stack does not support SOCK_STREAM, so any write to the connection
-// that is larger than maximum packet length will fail. The b2f impl. requires 125 bytes long packets.
-var (
-	ErrMessageTooLong = errors.New("Write: Message too long. Consider increasing maximum packet length to >= 125.")
-	ErrPortNotExist   = errors.New("No such AX port found")
-)
+var ErrPortNotExist = errors.New("No such AX port found")
 
 type fd uintptr
 
@@ -6,10 +6,12 @@ package ax25
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -30,7 +32,31 @@ import (
 // as hardware flow is not supported by many USB->RS232 adapters
 // including the adapter build into TH-D72 (at least, not using the
 // current linux kernel module.
-type KenwoodConn struct{ Conn }
+type KenwoodConn struct {
+	Conn
+	hbaud  HBaud
+	paclen int
+}
+
+// HBaud returns the packet channel baudrate the TNC was configured with.
+func (c *KenwoodConn) HBaud() HBaud { return c.hbaud }
+
+// PacketLength returns the maximum packet length the TNC was configured with.
+//
+// Zero means that the TNC's default is used.
+func (c *KenwoodConn) PacketLength() int { return c.paclen }
+
+// Write writes p to the TNC. ErrMessageTooLong is returned if p is larger
+// than the configured packet length, consistent with the Linux AX.25 stack.
+func (c *KenwoodConn) Write(p []byte) (int, error) {
+	if !c.ok() {
+		return 0, syscall.EINVAL
+	}
+	if c.paclen > 0 && len(p) > c.paclen {
+		return 0, ErrMessageTooLong
+	}
+	return c.Conn.Write(p)
+}
 
 // Dial a packet node using a Kenwood (or similar) radio over serial
 func DialKenwood(dev, mycall, targetcall string, config Config, logger *log.Logger) (*KenwoodConn, error) {
@@ -39,82 +65,23 @@ func DialKenwood(dev, mycall, targetcall string, config Config, logger *log.Logg
 	}
 
 	localAddr, remoteAddr := tncAddrFromString(mycall), tncAddrFromString(targetcall)
-	conn := &KenwoodConn{Conn{
-		localAddr:  AX25Addr{localAddr},
-		remoteAddr: AX25Addr{remoteAddr},
-	}}
-
-	if dev == "socket" {
-		c, err := net.Dial("tcp", "127.0.0.1:8081")
-		if err != nil {
-			panic(err)
-		}
-		conn.Conn.ReadWriteCloser = c
-	} else {
-		s, err := serial.Open(dev, serial.WithBaudrate(config.SerialBaud))
-		if err != nil {
-			return conn, err
-		} else {
-			conn.Conn.ReadWriteCloser = s
-		}
+	conn := &KenwoodConn{
+		Conn: Conn{
+			localAddr:  AX25Addr{localAddr},
+			remoteAddr: AX25Addr{remoteAddr},
+		},
+		hbaud:  config.HBaud,
+		paclen: int(config.PacketLength),
 	}
 
-	// Initialize the TNC (with timeout)
-	initErr := make(chan error, 1)
-	go func() {
-		defer close(initErr)
-		conn.Write([]byte{3, 3, 3}) // ETX
-		fmt.Fprint(conn, "\r\nrestart\r\n")
-		// Wait for prompt, then send all the init commands
-		for {
-			line, err := fbb.ReadLine(conn)
-			if err != nil {
-				conn.Close()
-				initErr <- err
-				return
-			}
-
-			if strings.HasPrefix(line, "cmd:") {
-				fmt.Fprint(conn, "ECHO OFF\r") // Don't echo commands
-				fmt.Fprint(conn, "FLOW OFF\r")
-				fmt.Fprint(conn, "XFLOW ON\r")    // Enable software flow control
-				fmt.Fprint(conn, "LFIGNORE ON\r") // Ignore linefeed (\n)
-				fmt.Fprint(conn, "AUTOLF OFF\r")  // Don't auto-insert linefeed
-				fmt.Fprint(conn, "CR ON\r")
-				fmt.Fprint(conn, "8BITCONV ON\r") // Use 8-bit characters
-
-				// Return to command mode if station of current I/O stream disconnects.
-				fmt.Fprint(conn, "NEWMODE ON\r")
-
-				time.Sleep(500 * time.Millisecond)
-
-				fmt.Fprintf(conn, "MYCALL %s\r", mycall)
-				fmt.Fprintf(conn, "HBAUD %d\r", config.HBaud)
-				fmt.Fprintf(conn, "PACLEN %d\r", config.PacketLength)
-				fmt.Fprintf(conn, "TXDELAY %d\r", config.TXDelay/_CONFIG_TXDELAY_UNIT)
-				fmt.Fprintf(conn, "PERSIST %d\r", config.Persist)
-				time.Sleep(500 * time.Millisecond)
-
-				fmt.Fprintf(conn, "SLOTTIME %d\r", config.SlotTime/_CONFIG_SLOT_TIME_UNIT)
-				fmt.Fprint(conn, "FULLDUP OFF\r")
-				fmt.Fprintf(conn, "MAXFRAME %d\r", config.MaxFrame)
-				fmt.Fprintf(conn, "FRACK %d\r", config.FRACK/_CONFIG_FRACK_UNIT)
-				fmt.Fprintf(conn, "RESPTIME %d\r", config.ResponseTime/_CONFIG_RESPONSE_TIME_UNIT)
-				fmt.Fprintf(conn, "NOMODE ON\r")
+	port, err := openKenwoodPort(dev, config.SerialBaud)
+	if err != nil {
+		return conn, err
+	}
+	conn.Conn.ReadWriteCloser = port
 
-				break
-			}
-		}
-	}()
-	select {
-	case <-time.After(3 * time.Second):
-		conn.Close()
-		return nil, fmt.Errorf("initialization failed: deadline exceeded")
-	case err := <-initErr:
-		if err != nil {
-			conn.Close()
-			return nil, fmt.Errorf("initialization failed: %w", err)
-		}
+	if err := initKenwood(port, mycall, config); err != nil {
+		return nil, err
 	}
 	time.Sleep(2 * time.Second)
 
@@ -146,9 +113,9 @@ func DialKenwood(dev, mycall, targetcall string, config Config, logger *log.Logg
 	case <-time.After(5 * time.Minute):
 		conn.Close()
 		return nil, fmt.Errorf("connect failed: deadline exceeded")
-	case err := <-initErr:
+	case err := <-dialErr:
 		if err != nil {
-			conn.Close()
+			conn.Conn.Close() // Not connected, just close the port
 			return nil, fmt.Errorf("connect failed: %w", err)
 		}
 	}
@@ -158,20 +125,94 @@ func DialKenwood(dev, mycall, targetcall string, config Config, logger *log.Logg
 	return conn, nil
 }
 
+// openKenwoodPort opens the TNC's serial port (or a TCP connection to localhost:8081 if dev is "socket").
+var openKenwoodPort = func(dev string, serialBaud int) (io.ReadWriteCloser, error) {
+	if dev == "socket" {
+		return net.Dial("tcp", "127.0.0.1:8081")
+	}
+	return serial.Open(dev, serial.WithBaudrate(serialBaud))
+}
+
+var (
+	kenwoodInitDelay = 500 * time.Millisecond // The delay between batches of TNC commands during initialization.
+	kenwoodGuardTime = time.Second            // The idle time before and after the TRANS mode escape sequence.
+)
+
+// initKenwood resets and configures the TNC (with timeout). The port is closed on error.
+func initKenwood(port io.ReadWriteCloser, mycall string, config Config) error {
+	initErr := make(chan error, 1)
+	go func() {
+		defer close(initErr)
+		port.Write([]byte{3, 3, 3}) // ETX
+		fmt.Fprint(port, "\r\nrestart\r\n")
+		// Wait for prompt, then send all the init commands
+		for {
+			line, err := fbb.ReadLine(port)
+			if err != nil {
+				port.Close()
+				initErr <- err
+				return
+			}
+
+			if strings.HasPrefix(line, "cmd:") {
+				fmt.Fprint(port, "ECHO OFF\r") // Don't echo commands
+				fmt.Fprint(port, "FLOW OFF\r")
+				fmt.Fprint(port, "XFLOW ON\r")    // Enable software flow control
+				fmt.Fprint(port, "LFIGNORE ON\r") // Ignore linefeed (\n)
+				fmt.Fprint(port, "AUTOLF OFF\r")  // Don't auto-insert linefeed
+				fmt.Fprint(port, "CR ON\r")
+				fmt.Fprint(port, "8BITCONV ON\r") // Use 8-bit characters
+
+				// Return to command mode if station of current I/O stream disconnects.
+				fmt.Fprint(port, "NEWMODE ON\r")
+
+				time.Sleep(kenwoodInitDelay)
+
+				fmt.Fprintf(port, "MYCALL %s\r", mycall)
+				fmt.Fprintf(port, "HBAUD %d\r", config.HBaud)
+				fmt.Fprintf(port, "PACLEN %d\r", config.PacketLength)
+				fmt.Fprintf(port, "TXDELAY %d\r", config.TXDelay/_CONFIG_TXDELAY_UNIT)
+				fmt.Fprintf(port, "PERSIST %d\r", config.Persist)
+				time.Sleep(kenwoodInitDelay)
+
+				fmt.Fprintf(port, "SLOTTIME %d\r", config.SlotTime/_CONFIG_SLOT_TIME_UNIT)
+				fmt.Fprint(port, "FULLDUP OFF\r")
+				fmt.Fprintf(port, "MAXFRAME %d\r", config.MaxFrame)
+				fmt.Fprintf(port, "FRACK %d\r", config.FRACK/_CONFIG_FRACK_UNIT)
+				fmt.Fprintf(port, "RESPTIME %d\r", config.ResponseTime/_CONFIG_RESPONSE_TIME_UNIT)
+				fmt.Fprintf(port, "NOMODE ON\r")
+
+				break
+			}
+		}
+	}()
+	select {
+	case <-time.After(3 * time.Second):
+		port.Close()
+		return fmt.Errorf("initialization failed: deadline exceeded")
+	case err := <-initErr:
+		if err != nil {
+			port.Close()
+			return fmt.Errorf("initialization failed: %w", err)
+		}
+	}
+	return nil
+}
+
 func (c *KenwoodConn) Close() error {
 	if !c.ok() {
 		return syscall.EINVAL
 	}
 
 	// Exit TRANS mode
-	time.Sleep(1 * time.Second)
+	time.Sleep(kenwoodGuardTime)
 	for i := 0; i < 3; i++ {
 		c.Write([]byte{3}) // ETX
 		time.Sleep(200 * time.Millisecond)
 	}
 
 	// Wait for prompt
-	time.Sleep(1 * time.Second)
+	time.Sleep(kenwoodGuardTime)
 
 	// Disconnect
 	fmt.Fprint(c, "\r\nD\r\n")
@@ -184,3 +225,99 @@ func (c *KenwoodConn) Close() error {
 	}
 	return c.Conn.Close()
 }
+
+type kenwoodListener struct {
+	port      io.ReadWriteCloser
+	localAddr AX25Addr
+	config    Config
+
+	mu        sync.Mutex // Held while a connection is active (only a single connection is supported)
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ListenKenwood announces on a Kenwood (or similar) TNC over serial using mycall as the local address.
+//
+// The TNC is configured to accept inbound connects and switch to transparent mode when a station
+// connects. Only a single connection is supported at a time: Accept blocks until the previously
+// accepted connection is closed.
+func ListenKenwood(dev, mycall string, config Config) (net.Listener, error) {
+	port, err := openKenwoodPort(dev, config.SerialBaud)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := initKenwood(port, mycall, config); err != nil {
+		return nil, err
+	}
+	fmt.Fprint(port, "CONOK ON\r")      // Accept inbound connects
+	fmt.Fprint(port, "CONMODE TRANS\r") // Switch to transparent mode when connected
+
+	return &kenwoodListener{
+		port:      port,
+		localAddr: AX25Addr{tncAddrFromString(mycall)},
+		config:    config,
+		closed:    make(chan struct{}),
+	}, nil
+}
+
+// Addr returns the listener's network address, an AX25Addr.
+func (ln *kenwoodListener) Addr() net.Addr { return ln.localAddr }
+
+// Close stops listening and closes the serial port, including any active connection.
+func (ln *kenwoodListener) Close() error {
+	err := net.ErrClosed
+	ln.closeOnce.Do(func() {
+		close(ln.closed)
+		err = ln.port.Close()
+	})
+	return err
+}
+
+// Accept waits for the next inbound connect and returns a *KenwoodConn.
+//
+// See net.Listener for more information.
+func (ln *kenwoodListener) Accept() (net.Conn, error) {
+	ln.mu.Lock() // Wait for the active connection (if any) to be closed
+
+	for {
+		line, err := fbb.ReadLine(ln.port)
+		if err != nil {
+			ln.mu.Unlock()
+			select {
+			case <-ln.closed:
+				return nil, net.ErrClosed
+			default:
+				return nil, err
+			}
+		}
+
+		// E.g. "*** CONNECTED to LA5NTA-10"
+		idx := strings.Index(line, "*** CONNECTED to ")
+		if idx < 0 {
+			continue
+		}
+		remote := strings.TrimSpace(line[idx+len("*** CONNECTED to "):])
+
+		return &KenwoodConn{
+			Conn: Conn{
+				localAddr:       ln.localAddr,
+				remoteAddr:      AX25Addr{tncAddrFromString(remote)},
+				ReadWriteCloser: &kenwoodStream{ReadWriter: ln.port, release: ln.mu.Unlock},
+			},
+			hbaud:  ln.config.HBaud,
+			paclen: int(ln.config.PacketLength),
+		}, nil
+	}
+}
+
+// kenwoodStream is the I/O stream of a connection accepted by kenwoodListener.
+//
+// Closing the stream leaves the serial port open, and lets the listener accept a new connection.
+type kenwoodStream struct {
+	io.ReadWriter
+	release func()
+	once    sync.Once
+}
+
+func (s *kenwoodStream) Close() error { s.once.Do(s.release); return nil }
@@ -0,0 +1,136 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package ax25
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// kenwoodStub replays a Kenwood TNC in command mode, accepting the given inbound connects.
+func kenwoodStub(port net.Conn, connects ...string) {
+	rd := bufio.NewReader(port)
+	for {
+		line, err := rd.ReadString('\r')
+		if err != nil {
+			return
+		}
+		switch strings.Trim(line, "\x03\r\n") {
+		case "restart":
+			fmt.Fprint(port, "cmd:\r")
+			continue
+		case "D":
+			fmt.Fprint(port, "*** DISCONNECTED\r")
+		case "CONMODE TRANS": // Initialization done
+		default:
+			continue
+		}
+
+		// Replay the next inbound connect
+		if len(connects) > 0 {
+			fmt.Fprintf(port, "*** CONNECTED to %s\r", connects[0])
+			fmt.Fprintf(port, "Hello from %s\r", connects[0])
+			connects = connects[1:]
+		}
+	}
+}
+
+func TestListenKenwood(t *testing.T) {
+	defer func(init, guard time.Duration) { kenwoodInitDelay, kenwoodGuardTime = init, guard }(kenwoodInitDelay, kenwoodGuardTime)
+	kenwoodInitDelay, kenwoodGuardTime = 0, 0
+
+	host, tnc := net.Pipe()
+	go kenwoodStub(tnc, "LA1B-10", "LA5NTA-2")
+	defer func(f func(string, int) (io.ReadWriteCloser, error)) { openKenwoodPort = f }(openKenwoodPort)
+	openKenwoodPort = func(dev string, serialBaud int) (io.ReadWriteCloser, error) { return host, nil }
+
+	ln, err := ListenKenwood("/dev/ttyUSB0", "N0CALL", NewConfig(B1200, DefaultSerialBaud))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ln.Addr().String(); got != "N0CALL" {
+		t.Errorf("Got listener address %s, expected N0CALL", got)
+	}
+
+	for _, remote := range []string{"LA1B-10", "LA5NTA-2"} {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conn.RemoteAddr().String(); got != remote {
+			t.Errorf("Got remote address %s, expected %s", got, remote)
+		}
+		if line, _ := bufio.NewReader(conn).ReadString('\r'); line != "Hello from "+remote+"\r" {
+			t.Errorf("Got %q from %s", line, remote)
+		}
+		if err := conn.Close(); err != nil {
+			t.Errorf("Got error on close: %v", err)
+		}
+	}
+
+	accepted := make(chan error, 1)
+	go func() { _, err := ln.Accept(); accepted <- err }()
+	ln.Close()
+	select {
+	case err := <-accepted:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Got %v, expected net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
+
+func TestKenwoodConnPacketLength(t *testing.T) {
+	defer func(init, guard time.Duration) { kenwoodInitDelay, kenwoodGuardTime = init, guard }(kenwoodInitDelay, kenwoodGuardTime)
+	kenwoodInitDelay, kenwoodGuardTime = 0, 0
+	defer func(f func(string, int) (io.ReadWriteCloser, error)) { openKenwoodPort = f }(openKenwoodPort)
+
+	tests := []struct {
+		hbaud  HBaud
+		paclen int
+	}{
+		{B1200, 128}, // The default
+		{B9600, 255},
+	}
+	for _, test := range tests {
+		host, tnc := net.Pipe()
+		go kenwoodStub(tnc, "LA1B-10")
+		openKenwoodPort = func(dev string, serialBaud int) (io.ReadWriteCloser, error) { return host, nil }
+
+		ln, err := ListenKenwood("/dev/ttyUSB0", "N0CALL", NewConfig(test.hbaud, DefaultSerialBaud))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := c.(*KenwoodConn)
+		bufio.NewReader(conn).ReadString('\r') // Greeting from the stub
+		if got := conn.HBaud(); got != test.hbaud {
+			t.Errorf("Got HBaud %d, expected %d", got, test.hbaud)
+		}
+		if got := conn.PacketLength(); got != test.paclen {
+			t.Errorf("Got PacketLength %d, expected %d", got, test.paclen)
+		}
+
+		if n, err := conn.Write(make([]byte, test.paclen+1)); n != 0 || err != ErrMessageTooLong {
+			t.Errorf("Got %d, %v for oversized write with PacketLength %d, expected 0, ErrMessageTooLong", n, err, test.paclen)
+		}
+
+		// Writes within the packet length reaches the TNC (consumed by the stub)
+		if n, err := conn.Write(make([]byte, test.paclen)); n != test.paclen || err != nil {
+			t.Errorf("Got %d, %v for write of packet length, expected %d, nil", n, err, test.paclen)
+		}
+		ln.Close()
+	}
+}
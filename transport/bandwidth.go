@@ -0,0 +1,61 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BandwidthHint is a scheme-neutral ARQ bandwidth, as given by the bw URL query parameter.
+//
+// Each ARQ transport maps the hint to its own bandwidth type, so that e.g. bw=500 can be used regardless of modem.
+type BandwidthHint struct {
+	Hz     int  // The bandwidth in Hz.
+	Forced bool // True if the bandwidth must be used as is, false if it is the maximum bandwidth to use.
+}
+
+// IsZero returns true if h is the zero value (no bandwidth given).
+func (h BandwidthHint) IsZero() bool { return h.Hz == 0 }
+
+// String returns the hint in the format accepted by ParseBandwidthHint (e.g. 500MAX or 2000FORCED).
+func (h BandwidthHint) String() string {
+	if h.Forced {
+		return fmt.Sprintf("%dFORCED", h.Hz)
+	}
+	return fmt.Sprintf("%dMAX", h.Hz)
+}
+
+// ParseBandwidthHint parses a bandwidth hint, given as the bandwidth in Hz with an optional (case-insensitive)
+// MAX or FORCED suffix (e.g. 500, 500MAX or 500FORCED). MAX is assumed when no suffix is given.
+func ParseBandwidthHint(str string) (BandwidthHint, error) {
+	var h BandwidthHint
+	digits := strings.ToUpper(strings.TrimSpace(str))
+	switch {
+	case strings.HasSuffix(digits, "FORCED"):
+		digits, h.Forced = strings.TrimSuffix(digits, "FORCED"), true
+	case strings.HasSuffix(digits, "MAX"):
+		digits = strings.TrimSuffix(digits, "MAX")
+	}
+
+	hz, err := strconv.ParseUint(digits, 10, 16)
+	if err != nil || hz == 0 {
+		return BandwidthHint{}, fmt.Errorf("Invalid bandwidth '%s'", str)
+	}
+	h.Hz = int(hz)
+	return h, nil
+}
+
+// BandwidthHint returns the bandwidth hint given by the bw query parameter.
+//
+// The zero value is returned if the parameter is not set.
+func (u *URL) BandwidthHint() (BandwidthHint, error) {
+	str := u.Params.Get("bw")
+	if str == "" {
+		return BandwidthHint{}, nil
+	}
+	return ParseBandwidthHint(str)
+}
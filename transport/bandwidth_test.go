@@ -0,0 +1,43 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import "testing"
+
+func TestParseBandwidthHint(t *testing.T) {
+	tests := map[string]BandwidthHint{
+		"500":        {Hz: 500},
+		"500MAX":     {Hz: 500},
+		"500max":     {Hz: 500},
+		"2000FORCED": {Hz: 2000, Forced: true},
+		" 200forced": {Hz: 200, Forced: true},
+	}
+	for str, expect := range tests {
+		got, err := ParseBandwidthHint(str)
+		if err != nil {
+			t.Errorf("%q: Unexpected error: %s", str, err)
+		} else if got != expect {
+			t.Errorf("%q: Got %+v, expected %+v", str, got, expect)
+		}
+	}
+
+	for _, str := range []string{"", "MAX", "0", "-500", "500HZ", "99999999"} {
+		if _, err := ParseBandwidthHint(str); err == nil {
+			t.Errorf("%q: Expected error", str)
+		}
+	}
+}
+
+func TestURLBandwidthHint(t *testing.T) {
+	url, _ := ParseURL("ardop:///LA1B?bw=500")
+	if h, err := url.BandwidthHint(); err != nil || h != (BandwidthHint{Hz: 500}) {
+		t.Errorf("Got %+v, %v, expected 500MAX", h, err)
+	}
+
+	url, _ = ParseURL("ardop:///LA1B")
+	if h, err := url.BandwidthHint(); err != nil || !h.IsZero() {
+		t.Errorf("Got %+v, %v, expected zero value", h, err)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultBusyPollInterval is the poll interval used by WaitForClearChannel when none is given.
+const DefaultBusyPollInterval = time.Second
+
+// WaitForClearChannel blocks until bcc reports a clear channel (Busy returns false) or ctx is done.
+//
+// The channel is checked immediately, then at every poll interval. A poll interval <= 0 defaults to
+// DefaultBusyPollInterval. If ctx is done before the channel clears, ctx.Err() is returned.
+func WaitForClearChannel(ctx context.Context, bcc BusyChannelChecker, poll time.Duration) error {
+	if poll <= 0 {
+		poll = DefaultBusyPollInterval
+	}
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for bcc.Busy() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+type multiBusyChecker []BusyChannelChecker
+
+// MultiBusyChecker returns a BusyChannelChecker that reports busy if any of the given checkers reports busy.
+//
+// This can be used to combine several busy detectors, e.g. the modem's busy detector and a rig's squelch.
+// The checkers are polled in order, stopping at the first busy one. Nil checkers are ignored.
+func MultiBusyChecker(checkers ...BusyChannelChecker) BusyChannelChecker {
+	m := make(multiBusyChecker, 0, len(checkers))
+	for _, c := range checkers {
+		if c != nil {
+			m = append(m, c)
+		}
+	}
+	return m
+}
+
+func (m multiBusyChecker) Busy() bool {
+	for _, c := range m {
+		if c.Busy() {
+			return true
+		}
+	}
+	return false
+}
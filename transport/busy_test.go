@@ -0,0 +1,61 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// busyChecker reports busy for the first n polls.
+type busyChecker struct{ n, polls int }
+
+func (b *busyChecker) Busy() bool { b.polls++; return b.polls <= b.n }
+
+func TestWaitForClearChannel(t *testing.T) {
+	bcc := &busyChecker{n: 3}
+	if err := WaitForClearChannel(context.Background(), bcc, time.Millisecond); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if bcc.polls != 4 {
+		t.Errorf("Got %d polls, expected 4", bcc.polls)
+	}
+
+	// Clear channel should return immediately
+	bcc = &busyChecker{}
+	if err := WaitForClearChannel(context.Background(), bcc, time.Hour); err != nil || bcc.polls != 1 {
+		t.Errorf("Got %v after %d polls, expected nil after 1 poll", err, bcc.polls)
+	}
+}
+
+func TestWaitForClearChannelContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	bcc := &busyChecker{n: 1 << 30}
+	if err := WaitForClearChannel(ctx, bcc, time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("Got %v, expected context.DeadlineExceeded", err)
+	}
+}
+
+func TestMultiBusyChecker(t *testing.T) {
+	rig, tnc := &busyChecker{n: 1}, &busyChecker{n: 1}
+	bcc := MultiBusyChecker(rig, nil, tnc)
+
+	// rig busy, then tnc busy, then both clear
+	for i, expect := range []bool{true, true, false} {
+		if got := bcc.Busy(); got != expect {
+			t.Errorf("Poll %d: Got busy %t, expected %t", i, got, expect)
+		}
+	}
+	if rig.polls != 3 || tnc.polls != 2 {
+		t.Errorf("Got %d rig polls and %d tnc polls, expected 3 and 2", rig.polls, tnc.polls)
+	}
+
+	if MultiBusyChecker().Busy() {
+		t.Error("Got busy with no checkers, expected clear")
+	}
+}
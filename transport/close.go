@@ -0,0 +1,51 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"net"
+	"time"
+)
+
+// ErrFlushTimeout is returned by GracefulClose if the transmit buffer was not flushed before the timeout.
+//
+// It implements net.Error with Timeout() returning true.
+var ErrFlushTimeout error = flushTimeoutError{}
+
+type flushTimeoutError struct{}
+
+func (flushTimeoutError) Error() string   { return "Flush timeout" }
+func (flushTimeoutError) Timeout() bool   { return true }
+func (flushTimeoutError) Temporary() bool { return false }
+
+// GracefulClose flushes the transmit buffer of c (if c implements Flusher) before closing it.
+//
+// If the flush does not complete within flushTimeout, the connection is closed anyway (dirty disconnect)
+// and ErrFlushTimeout is returned. Closing the connection is expected to abort the pending flush.
+//
+// The connection is always closed. If the flush failed, the flush error is returned.
+func GracefulClose(c net.Conn, flushTimeout time.Duration) error {
+	f, ok := c.(Flusher)
+	if !ok {
+		return c.Close()
+	}
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- f.Flush() }()
+
+	timer := time.NewTimer(flushTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-flushed:
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	case <-timer.C:
+		c.Close()
+		return ErrFlushTimeout
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// stuckConn is a net.Conn with a transmit buffer that never drains.
+type stuckConn struct {
+	net.Conn
+	closed chan struct{}
+}
+
+func (c *stuckConn) Flush() error {
+	<-c.closed // Aborted by Close
+	return errors.New("Flush aborted")
+}
+
+func (c *stuckConn) Close() error {
+	close(c.closed)
+	return c.Conn.Close()
+}
+
+// flushConn is a net.Conn that flushes immediately.
+type flushConn struct {
+	net.Conn
+	flushed bool
+}
+
+func (c *flushConn) Flush() error { c.flushed = true; return nil }
+
+func TestGracefulCloseTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := &stuckConn{Conn: a, closed: make(chan struct{})}
+
+	start := time.Now()
+	err := GracefulClose(conn, 50*time.Millisecond)
+	if err != ErrFlushTimeout {
+		t.Errorf("Got %v, expected %v", err, ErrFlushTimeout)
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("Got %#v, expected a net.Error with Timeout() true", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("GracefulClose returned after %s, expected ~50ms", d)
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Error("Connection not closed after flush timeout")
+	}
+}
+
+func TestGracefulClose(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := &flushConn{Conn: a}
+
+	if err := GracefulClose(conn, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.flushed {
+		t.Error("Connection not flushed before close")
+	}
+	if _, err := a.Write([]byte("foo")); err == nil {
+		t.Error("Connection not closed")
+	}
+}
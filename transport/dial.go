@@ -8,11 +8,14 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sort"
 	"sync"
+	"time"
 )
 
 var (
 	ErrInvalidTarget     = errors.New("Invalid or missing target callsign")
+	ErrInvalidSource     = errors.New("Invalid source callsign")
 	ErrDigisUnsupported  = errors.New("Digipeater path is not supported by this scheme")
 	ErrMissingDialer     = errors.New("No dialer has been registered for this scheme")
 	ErrUnsupportedScheme = errors.New("Unsupported URL scheme")
@@ -25,6 +28,31 @@ func (d noCtxDialer) DialURLContext(_ context.Context, url *URL) (net.Conn, erro
 	return d.DialURL(url)
 }
 
+// DialOptions holds the dial policy applied by DialURLOpts, regardless of scheme.
+//
+// Scheme specific options are given as URL query parameters. The zero value dials once, without
+// any timeout other than the context's.
+type DialOptions struct {
+	// Timeout is the time limit of each dial attempt. Zero means no limit.
+	Timeout time.Duration
+
+	// Retries is the number of times a failed dial attempt is retried.
+	Retries int
+
+	// RetryDelay is the time to wait between dial attempts.
+	RetryDelay time.Duration
+
+	// BusyChecker, if set, is used to wait for a clear channel before each dial attempt
+	// (see WaitForClearChannel).
+	BusyChecker BusyChannelChecker
+
+	// BusyTimeout is the time limit of each wait for a clear channel. Zero means no limit.
+	BusyTimeout time.Duration
+
+	// BusyPollInterval is the interval between busy checks. Zero means DefaultBusyPollInterval.
+	BusyPollInterval time.Duration
+}
+
 // DialURL calls the url.Scheme's Dialer.
 //
 // If the URL's scheme is not registered, ErrMissingDialer is returned.
@@ -34,20 +62,121 @@ func DialURL(url *URL) (net.Conn, error) {
 
 // DialURLContext calls the url.Scheme's ContextDialer.
 //
-// If the URL's scheme is not registered, ErrMissingDialer is returned.
+// Scheme aliases (see RegisterAlias) are resolved before lookup. If the URL's scheme
+// is not registered, ErrMissingDialer is returned.
 func DialURLContext(ctx context.Context, url *URL) (net.Conn, error) {
+	return DialURLOpts(ctx, url, DialOptions{})
+}
+
+// DialURLOpts is like DialURLContext, but applies the dial policy given by opts.
+//
+// The error of the last dial attempt is returned if all attempts fail. Dialing is not retried if
+// ctx is done or the URL's scheme is not registered.
+func DialURLOpts(ctx context.Context, url *URL, opts DialOptions) (net.Conn, error) {
 	dialers.mu.Lock()
-	dialer, ok := dialers.m[url.Scheme]
+	scheme := resolveScheme(url.Scheme)
+	dialer, ok := dialers.m[scheme]
 	dialers.mu.Unlock()
 	if !ok {
 		return nil, ErrMissingDialer
 	}
+	url = url.withScheme(scheme)
+
+	var err error
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		if attempt > 0 && opts.RetryDelay > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(opts.RetryDelay):
+			}
+		}
+
+		var conn net.Conn
+		conn, err = dialAttempt(ctx, dialer, url, opts)
+		if err == nil {
+			return conn, nil
+		} else if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func dialAttempt(ctx context.Context, dialer ContextDialer, url *URL, opts DialOptions) (net.Conn, error) {
+	if opts.BusyChecker != nil {
+		busyCtx, cancel := ctx, context.CancelFunc(func() {})
+		if opts.BusyTimeout > 0 {
+			busyCtx, cancel = context.WithTimeout(ctx, opts.BusyTimeout)
+		}
+		err := WaitForClearChannel(busyCtx, opts.BusyChecker, opts.BusyPollInterval)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
 	return dialer.DialURLContext(ctx, url)
 }
 
 var dialers struct {
-	mu sync.Mutex
-	m  map[string]ContextDialer
+	mu      sync.Mutex
+	m       map[string]ContextDialer
+	aliases map[string]string
+}
+
+// RegisterAlias registers alias as an alternative name for the target scheme.
+//
+// URLs with the alias scheme are dialed using the target scheme's dialer, e.g. to
+// let ardopc:// URLs reuse the ardop:// dialer when migrating configurations. The
+// dialer is given a copy of the URL with the target scheme.
+// The target does not need to be registered at the time the alias is registered.
+//
+// Aliases are not resolved recursively, and a registered dialer takes precedence
+// over an alias by the same name.
+func RegisterAlias(alias, target string) {
+	dialers.mu.Lock()
+	defer dialers.mu.Unlock()
+
+	if dialers.aliases == nil {
+		dialers.aliases = make(map[string]string)
+	}
+	dialers.aliases[alias] = target
+}
+
+// UnregisterAlias removes the given scheme alias.
+func UnregisterAlias(alias string) {
+	dialers.mu.Lock()
+	delete(dialers.aliases, alias)
+	dialers.mu.Unlock()
+}
+
+// resolveScheme returns the scheme given by the alias, or scheme if it's not an alias.
+//
+// The caller must hold dialers.mu.
+func resolveScheme(scheme string) string {
+	if _, ok := dialers.m[scheme]; ok {
+		return scheme
+	}
+	if target, ok := dialers.aliases[scheme]; ok {
+		return target
+	}
+	return scheme
+}
+
+// withScheme returns u if it has the given scheme, otherwise a copy of u with the given scheme.
+func (u *URL) withScheme(scheme string) *URL {
+	if u.Scheme == scheme {
+		return u
+	}
+	cpy := *u
+	cpy.Scheme = scheme
+	return &cpy
 }
 
 // RegisterContextDialer registers a new scheme and it's ContextDialer.
@@ -76,6 +205,22 @@ func RegisterDialer(scheme string, dialer Dialer) {
 	RegisterContextDialer(scheme, d)
 }
 
+// RegisteredSchemes returns the schemes with a registered dialer, in sorted order.
+//
+// This can be used to report which transports are available in the current build
+// (e.g. whether ax25 was built with libax25 support). Aliases are not included.
+func RegisteredSchemes() []string {
+	dialers.mu.Lock()
+	defer dialers.mu.Unlock()
+
+	schemes := make([]string, 0, len(dialers.m))
+	for scheme := range dialers.m {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
 // UnregisterDialer removes the given scheme's dialer from the list of dialers.
 func UnregisterDialer(scheme string) {
 	dialers.mu.Lock()
@@ -0,0 +1,181 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testDialer struct{ dialed []*URL }
+
+// schemeDialer rejects URLs of any other scheme, like the real dialers do.
+type schemeDialer struct {
+	testDialer
+	scheme string
+}
+
+func (d *schemeDialer) DialURL(url *URL) (net.Conn, error) {
+	if url.Scheme != d.scheme {
+		return nil, ErrUnsupportedScheme
+	}
+	return d.testDialer.DialURL(url)
+}
+
+func (d *testDialer) DialURL(url *URL) (net.Conn, error) {
+	d.dialed = append(d.dialed, url)
+	c, _ := net.Pipe()
+	return c, nil
+}
+
+// flakyDialer fails the first n dial attempts.
+//
+// If block is true, failing attempts block until the context is done.
+type flakyDialer struct {
+	n, attempts int
+	block       bool
+}
+
+func (d *flakyDialer) DialURLContext(ctx context.Context, url *URL) (net.Conn, error) {
+	d.attempts++
+	if d.attempts > d.n {
+		c, _ := net.Pipe()
+		return c, nil
+	}
+	if d.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return nil, errors.New("Dial failed")
+}
+
+func TestDialURLOpts(t *testing.T) {
+	url := &URL{Scheme: "test-flaky", Target: "LA1B"}
+	tests := map[string]struct {
+		dialer      *flakyDialer
+		opts        DialOptions
+		busy        *busyChecker
+		expectErr   bool
+		expectTries int
+		expectPolls int
+	}{
+		"no retries":        {dialer: &flakyDialer{n: 1}, expectErr: true, expectTries: 1},
+		"retry until dial":  {dialer: &flakyDialer{n: 2}, opts: DialOptions{Retries: 3, RetryDelay: time.Millisecond}, expectTries: 3},
+		"retries exhausted": {dialer: &flakyDialer{n: 5}, opts: DialOptions{Retries: 2}, expectErr: true, expectTries: 3},
+		"attempt timeout":   {dialer: &flakyDialer{n: 1, block: true}, opts: DialOptions{Retries: 1, Timeout: 10 * time.Millisecond}, expectTries: 2},
+		"busy wait": {
+			dialer:      &flakyDialer{},
+			opts:        DialOptions{BusyPollInterval: time.Millisecond},
+			busy:        &busyChecker{n: 2},
+			expectTries: 1,
+			expectPolls: 3,
+		},
+		"busy timeout": {
+			dialer:      &flakyDialer{},
+			opts:        DialOptions{BusyPollInterval: time.Millisecond, BusyTimeout: 10 * time.Millisecond},
+			busy:        &busyChecker{n: 1000},
+			expectErr:   true,
+			expectTries: 0,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			RegisterContextDialer("test-flaky", tt.dialer)
+			defer UnregisterDialer("test-flaky")
+			if tt.busy != nil {
+				tt.opts.BusyChecker = tt.busy
+			}
+
+			conn, err := DialURLOpts(context.Background(), url, tt.opts)
+			if conn != nil {
+				conn.Close()
+			}
+			if (err != nil) != tt.expectErr {
+				t.Errorf("Got error %v, expected error %t", err, tt.expectErr)
+			}
+			if tt.dialer.attempts != tt.expectTries {
+				t.Errorf("Got %d dial attempts, expected %d", tt.dialer.attempts, tt.expectTries)
+			}
+			if tt.busy != nil && tt.expectPolls > 0 && tt.busy.polls != tt.expectPolls {
+				t.Errorf("Got %d busy polls, expected %d", tt.busy.polls, tt.expectPolls)
+			}
+		})
+	}
+
+	// No retries once the context is done
+	RegisterContextDialer("test-flaky", &flakyDialer{n: 5})
+	defer UnregisterDialer("test-flaky")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DialURLOpts(ctx, url, DialOptions{Retries: 3, RetryDelay: time.Hour}); err == nil {
+		t.Error("Expected error with cancelled context")
+	}
+}
+
+func TestRegisterAlias(t *testing.T) {
+	d := &schemeDialer{scheme: "test-ardop"}
+	RegisterDialer("test-ardop", d)
+	RegisterAlias("test-ardop2", "test-ardop")
+	defer func() {
+		UnregisterDialer("test-ardop")
+		UnregisterAlias("test-ardop2")
+	}()
+
+	url, err := ParseURL("test-ardop2:///LA1B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := DialURL(url)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	conn.Close()
+	if len(d.dialed) != 1 || d.dialed[0].Scheme != "test-ardop" || d.dialed[0].Target != "LA1B" {
+		t.Errorf("Unexpected dials: %v", d.dialed)
+	}
+	if url.Scheme != "test-ardop2" {
+		t.Errorf("Got scheme '%s' after dial, expected the URL to be left unchanged", url.Scheme)
+	}
+
+	UnregisterAlias("test-ardop2")
+	if _, err := DialURL(url); err != ErrMissingDialer {
+		t.Errorf("Got %v, expected ErrMissingDialer after unregistering alias", err)
+	}
+}
+
+func TestAliasDigisUnsupported(t *testing.T) {
+	RegisterAlias("test-ardopc", "ardop")
+	defer UnregisterAlias("test-ardopc")
+
+	if _, err := ParseURL("test-ardopc:///LA5NTA/LA1B"); err != ErrDigisUnsupported {
+		t.Errorf("Got %v, expected ErrDigisUnsupported", err)
+	}
+}
+
+func TestRegisteredSchemes(t *testing.T) {
+	RegisterDialer("test-b", &testDialer{})
+	RegisterDialer("test-a", &testDialer{})
+	RegisterAlias("test-c", "test-a")
+	defer func() {
+		UnregisterDialer("test-a")
+		UnregisterDialer("test-b")
+		UnregisterAlias("test-c")
+	}()
+
+	var got []string
+	for _, scheme := range RegisteredSchemes() {
+		if strings.HasPrefix(scheme, "test-") {
+			got = append(got, scheme)
+		}
+	}
+	if expect := []string{"test-a", "test-b"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got %v, expected %v", got, expect)
+	}
+}
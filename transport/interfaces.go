@@ -24,6 +24,31 @@ type Robust interface {
 	SetRobust(r bool) error
 }
 
+// RobustWrapper is implemented by connections wrapping another connection (e.g. logging or
+// throttling decorators) that don't implement Robust themselves, to expose the Robust
+// implementation of the wrapped connection.
+//
+// RobustConn returns nil if the wrapped connection does not support robust mode.
+type RobustWrapper interface {
+	RobustConn() Robust
+}
+
+// AsRobust returns the Robust implementation of conn.
+//
+// If conn does not implement Robust, but implements RobustWrapper, the wrapped connection's
+// Robust implementation is returned. ok is false if no Robust implementation was found.
+func AsRobust(conn interface{}) (r Robust, ok bool) {
+	switch c := conn.(type) {
+	case Robust:
+		return c, true
+	case RobustWrapper:
+		r = c.RobustConn()
+		return r, r != nil
+	default:
+		return nil, false
+	}
+}
+
 // A BusyChannelChecker is a generic busy detector for a physical transmission medium.
 type BusyChannelChecker interface {
 	// Returns true if the channel is not clear
@@ -47,3 +72,9 @@ type ContextDialer interface {
 	// Once successfully connected, any expiration of the context will not affect the connection.
 	DialURLContext(ctx context.Context, url *URL) (net.Conn, error)
 }
+
+// URLListener is implemented by transports that supports listening on a transport.URL.
+type URLListener interface {
+	// ListenURL announces on the local interface given by the URL.
+	ListenURL(url *URL) (net.Listener, error)
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+var ErrMissingListener = errors.New("No listener has been registered for this scheme")
+
+// ListenerFunc is an adapter to allow the use of ordinary functions as URLListeners.
+type ListenerFunc func(url *URL) (net.Listener, error)
+
+// ListenURL calls f(url).
+func (f ListenerFunc) ListenURL(url *URL) (net.Listener, error) { return f(url) }
+
+var listeners struct {
+	mu sync.Mutex
+	m  map[string]URLListener
+}
+
+// ListenURL calls the url.Scheme's URLListener.
+//
+// The local station's callsign is given by the URL's user (mycall@) or target. Scheme aliases
+// (see RegisterAlias) are resolved before lookup, and the listener is given a copy of the URL
+// with the target scheme. If the URL's scheme is not registered, ErrMissingListener is returned.
+func ListenURL(url *URL) (net.Listener, error) {
+	scheme := url.Scheme
+	listeners.mu.Lock()
+	ln, ok := listeners.m[scheme]
+	listeners.mu.Unlock()
+	if !ok {
+		dialers.mu.Lock()
+		scheme, ok = dialers.aliases[url.Scheme]
+		dialers.mu.Unlock()
+		if ok {
+			listeners.mu.Lock()
+			ln, ok = listeners.m[scheme]
+			listeners.mu.Unlock()
+		}
+	}
+	if !ok {
+		return nil, ErrMissingListener
+	}
+	return ln.ListenURL(url.withScheme(scheme))
+}
+
+// RegisterListener registers a new scheme and it's URLListener.
+//
+// The list of registered listeners is used by ListenURL.
+func RegisterListener(scheme string, ln URLListener) {
+	listeners.mu.Lock()
+
+	if listeners.m == nil {
+		listeners.m = make(map[string]URLListener)
+	}
+
+	listeners.m[scheme] = ln
+
+	listeners.mu.Unlock()
+}
+
+// UnregisterListener removes the given scheme's listener from the list of listeners.
+func UnregisterListener(scheme string) {
+	listeners.mu.Lock()
+	delete(listeners.m, scheme)
+	listeners.mu.Unlock()
+}
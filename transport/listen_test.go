@@ -0,0 +1,49 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenURL(t *testing.T) {
+	var got []*URL
+	RegisterListener("test-listen", ListenerFunc(func(url *URL) (net.Listener, error) {
+		got = append(got, url)
+		return net.Listen("tcp", "127.0.0.1:0")
+	}))
+	RegisterAlias("test-listen2", "test-listen")
+	defer func() {
+		UnregisterListener("test-listen")
+		UnregisterAlias("test-listen2")
+	}()
+
+	for _, str := range []string{"test-listen://axport/N0CALL", "test-listen2://axport/N0CALL"} {
+		url, err := ParseURL(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, err := ListenURL(url)
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %s", str, err)
+		}
+		ln.Close()
+		if last := got[len(got)-1]; last.Host != "axport" || last.MyCall() != "N0CALL" {
+			t.Errorf("%s: Got host '%s' and mycall '%s', expected 'axport' and 'N0CALL'", str, last.Host, last.MyCall())
+		} else if last.Scheme != "test-listen" {
+			t.Errorf("%s: Got scheme '%s', expected 'test-listen'", str, last.Scheme)
+		}
+	}
+	if len(got) != 2 {
+		t.Errorf("Got %d calls, expected 2", len(got))
+	}
+
+	UnregisterListener("test-listen")
+	url, _ := ParseURL("test-listen://axport/N0CALL")
+	if _, err := ListenURL(url); err != ErrMissingListener {
+		t.Errorf("Got %v, expected ErrMissingListener after unregistering listener", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package mock_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/la5nta/wl2k-go/fbb"
+	"github.com/la5nta/wl2k-go/transport"
+	_ "github.com/la5nta/wl2k-go/transport/mock"
+)
+
+func Example() {
+	// Listen as N0CALL
+	lnURL, _ := transport.ParseURL("mock:///N0CALL?latency=10ms")
+	ln, err := transport.ListenURL(lnURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The remote station (N0CALL)
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		s := fbb.NewSession("N0CALL", "LA5NTA", "JO39EQ", nil)
+		s.SetLogger(log.New(io.Discard, "", 0))
+		s.IsMaster(true)
+		_, err = s.Exchange(conn)
+		done <- err
+	}()
+
+	// Dial N0CALL as LA5NTA
+	url, _ := transport.ParseURL("mock://LA5NTA@/N0CALL?latency=10ms")
+	conn, err := transport.DialURL(url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := fbb.NewSession("LA5NTA", "N0CALL", "JO39EQ", nil)
+	s.SetLogger(log.New(io.Discard, "", 0))
+	if _, err := s.Exchange(conn); err != nil {
+		log.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Exchange completed")
+	// Output: Exchange completed
+}
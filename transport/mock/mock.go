@@ -0,0 +1,323 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// Package mock provides an in-memory transport for testing higher layers without real radios.
+//
+// Connections are made between a Listener and a Dialer in the same process, addressed by callsign:
+//
+//	mock:///N0CALL         // Listen as N0CALL (see transport.ListenURL)
+//	mock://LA5NTA@/N0CALL  // Dial N0CALL as LA5NTA (see transport.DialURL)
+//
+// The connections implement the transport.Flusher, transport.TxBuffer and transport.Robust interfaces.
+// Written data is delivered to the remote after the configured latency, which can be given by the
+// URL's latency parameter (e.g. mock:///N0CALL?latency=100ms).
+package mock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+const network = "mock"
+
+var (
+	ErrAddressInUse = errors.New("Address already in use")
+	ErrNoListener   = errors.New("No listener for this address")
+)
+
+// DefaultDialer is the Dialer registered for the mock scheme.
+var DefaultDialer = &Dialer{}
+
+func init() {
+	transport.RegisterDialer("mock", DefaultDialer)
+	transport.RegisterListener("mock", transport.ListenerFunc(ListenURL))
+}
+
+// Addr is the callsign of a mock connection endpoint.
+type Addr string
+
+func (a Addr) Network() string { return network }
+func (a Addr) String() string  { return string(a) }
+
+var listeners struct {
+	mu sync.Mutex
+	m  map[string]*Listener
+}
+
+// Listener is a net.Listener accepting mock connections to a callsign.
+type Listener struct {
+	addr    Addr
+	latency time.Duration
+	conns   chan net.Conn
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Listen announces on the given callsign. Accepted connections deliver written data after latency.
+func Listen(mycall string, latency time.Duration) (*Listener, error) {
+	key := strings.ToUpper(mycall)
+
+	listeners.mu.Lock()
+	defer listeners.mu.Unlock()
+	if _, ok := listeners.m[key]; ok {
+		return nil, ErrAddressInUse
+	}
+	if listeners.m == nil {
+		listeners.m = make(map[string]*Listener)
+	}
+
+	ln := &Listener{
+		addr:    Addr(key),
+		latency: latency,
+		conns:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+	}
+	listeners.m[key] = ln
+	return ln, nil
+}
+
+// ListenURL listens on mock:// URLs. See the package documentation.
+func ListenURL(url *transport.URL) (net.Listener, error) {
+	latency, err := latencyParam(url)
+	if err != nil {
+		return nil, err
+	}
+	return Listen(url.MyCall(), latency)
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (ln *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case <-ln.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ln *Listener) Addr() net.Addr { return ln.addr }
+
+// Close stops listening. Already accepted connections are not closed.
+func (ln *Listener) Close() error {
+	ln.closeOnce.Do(func() {
+		close(ln.closed)
+		listeners.mu.Lock()
+		delete(listeners.m, string(ln.addr))
+		listeners.mu.Unlock()
+	})
+	return nil
+}
+
+// Dialer dials mock connections.
+type Dialer struct {
+	// Latency is the delay before written data is delivered to the remote, unless given by the URL.
+	Latency time.Duration
+}
+
+// DialURL dials mock:// URLs. See the package documentation.
+func (d Dialer) DialURL(url *transport.URL) (net.Conn, error) {
+	if url.Scheme != "mock" {
+		return nil, transport.ErrUnsupportedScheme
+	}
+	latency := d.Latency
+	if url.Params.Get("latency") != "" {
+		var err error
+		if latency, err = latencyParam(url); err != nil {
+			return nil, err
+		}
+	}
+	return Dial(url.User.Username(), url.Target, latency)
+}
+
+// Dial connects to the listener on target. Data written to the returned connection is delivered after latency.
+func Dial(mycall, target string, latency time.Duration) (*Conn, error) {
+	listeners.mu.Lock()
+	ln, ok := listeners.m[strings.ToUpper(target)]
+	listeners.mu.Unlock()
+	if !ok {
+		return nil, ErrNoListener
+	}
+
+	local, remote := net.Pipe()
+	c := newConn(local, Addr(strings.ToUpper(mycall)), ln.addr, latency)
+	select {
+	case ln.conns <- newConn(remote, ln.addr, c.localAddr, ln.latency):
+		return c, nil
+	case <-ln.closed:
+		c.Close()
+		return nil, ErrNoListener
+	}
+}
+
+func latencyParam(url *transport.URL) (time.Duration, error) {
+	str := url.Params.Get("latency")
+	if str == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid latency parameter: %w", err)
+	}
+	return d, nil
+}
+
+// Conn is one end of a mock connection.
+//
+// Written data is queued and delivered to the remote after the connection's latency, one write at a time.
+type Conn struct {
+	pipe                  net.Conn
+	localAddr, remoteAddr Addr
+	latency               time.Duration
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   [][]byte
+	queued  int   // Number of bytes written, but not yet read by the remote
+	err     error // Set when the connection is broken or closed
+	closing bool  // Set by Close. Pending data is still delivered.
+	robust  bool
+}
+
+func newConn(pipe net.Conn, local, remote Addr, latency time.Duration) *Conn {
+	c := &Conn{
+		pipe:       pipe,
+		localAddr:  local,
+		remoteAddr: remote,
+		latency:    latency,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	go c.deliver()
+	return c
+}
+
+// deliver writes the queued data to the remote after the connection's latency.
+func (c *Conn) deliver() {
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 && c.err == nil {
+			c.cond.Wait()
+		}
+		if c.err != nil {
+			c.mu.Unlock()
+			return
+		}
+		p := c.queue[0]
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+
+		time.Sleep(c.latency)
+		_, err := c.pipe.Write(p)
+
+		c.mu.Lock()
+		switch {
+		case err != nil && c.err == nil:
+			c.err, c.queue = io.ErrClosedPipe, nil // The remote is gone. Nothing more will be delivered.
+		case err == nil:
+			c.queued -= len(p)
+		}
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	}
+}
+
+func (c *Conn) Read(p []byte) (int, error) { return c.pipe.Read(p) }
+
+// Write queues p for delivery to the remote. It does not block.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.closing:
+		return 0, net.ErrClosed
+	case c.err != nil:
+		return 0, c.err
+	}
+	c.queue = append(c.queue, append([]byte(nil), p...))
+	c.queued += len(p)
+	c.cond.Broadcast()
+	return len(p), nil
+}
+
+// Flush implements the transport.Flusher interface.
+//
+// It blocks until all written data has been read by the remote.
+func (c *Conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.queued > 0 && c.err == nil {
+		c.cond.Wait()
+	}
+	if c.queued > 0 {
+		return c.err
+	}
+	return nil
+}
+
+// TxBufferLen implements the transport.TxBuffer interface.
+func (c *Conn) TxBufferLen() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.queued
+}
+
+// SetRobust implements the transport.Robust interface.
+func (c *Conn) SetRobust(r bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.robust = r
+	return nil
+}
+
+// Robust returns the robust mode last set by SetRobust.
+func (c *Conn) Robust() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.robust
+}
+
+// CloseTimeout is the maximum time Close waits for pending data to be delivered.
+var CloseTimeout = 5 * time.Second
+
+// Close closes the connection.
+//
+// Like a graceful disconnect, pending data is delivered to the remote before the connection is
+// closed. Data not delivered within CloseTimeout is discarded.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closing = true
+	c.mu.Unlock()
+
+	flushed := make(chan struct{})
+	go func() { c.Flush(); close(flushed) }()
+	select {
+	case <-flushed:
+	case <-time.After(CloseTimeout):
+	}
+
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = net.ErrClosed
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return c.pipe.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr                { return c.localAddr }
+func (c *Conn) RemoteAddr() net.Addr               { return c.remoteAddr }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.pipe.SetReadDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.pipe.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return nil } // Write never blocks
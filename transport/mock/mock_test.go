@@ -0,0 +1,126 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package mock
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+func dialPair(t *testing.T, latency string) (local, remote net.Conn) {
+	t.Helper()
+	lnURL, err := transport.ParseURL("mock:///N0CALL?latency=" + latency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := transport.ParseURL("mock://LA5NTA@/N0CALL?latency=" + latency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := transport.ListenURL(lnURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		conns <- conn
+	}()
+	if local, err = transport.DialURL(url); err != nil {
+		t.Fatal(err)
+	}
+	remote = <-conns
+	t.Cleanup(func() { local.Close(); remote.Close() })
+	return local, remote
+}
+
+func TestDialURL(t *testing.T) {
+	local, remote := dialPair(t, "0")
+
+	if got := local.RemoteAddr().String(); got != "N0CALL" {
+		t.Errorf("Got remote addr '%s', expected 'N0CALL'", got)
+	}
+	if got := remote.RemoteAddr().String(); got != "LA5NTA" {
+		t.Errorf("Got remote addr '%s', expected 'LA5NTA'", got)
+	}
+
+	for _, pair := range [][2]net.Conn{{local, remote}, {remote, local}} {
+		if _, err := pair[0].Write([]byte("Hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(pair[1], buf); err != nil || string(buf) != "Hello" {
+			t.Errorf("Got '%s' (%v), expected 'Hello'", buf, err)
+		}
+	}
+
+	if _, err := Dial("LA5NTA", "N0CALL", 0); err != ErrNoListener {
+		t.Errorf("Got %v after listener close, expected ErrNoListener", err)
+	}
+}
+
+func TestListenAddressInUse(t *testing.T) {
+	ln, err := Listen("N0CALL", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if _, err := Listen("n0call", 0); err != ErrAddressInUse {
+		t.Errorf("Got %v, expected ErrAddressInUse", err)
+	}
+}
+
+func TestFlushLatency(t *testing.T) {
+	local, remote := dialPair(t, "50ms")
+
+	start := time.Now()
+	if _, err := local.Write([]byte("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	if n := local.(transport.TxBuffer).TxBufferLen(); n != 5 {
+		t.Errorf("Got TxBufferLen %d, expected 5", n)
+	}
+
+	go io.ReadFull(remote, make([]byte, 5))
+	if err := local.(transport.Flusher).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Flush returned after %s, expected at least 50ms", elapsed)
+	}
+	if n := local.(transport.TxBuffer).TxBufferLen(); n != 0 {
+		t.Errorf("Got TxBufferLen %d after flush, expected 0", n)
+	}
+}
+
+func TestFlushRemoteClosed(t *testing.T) {
+	local, remote := dialPair(t, "0")
+
+	remote.Close()
+	local.Write([]byte("Hello"))
+	if err := local.(transport.Flusher).Flush(); err == nil {
+		t.Error("Expected error when flushing to a closed remote")
+	}
+}
+
+func TestRobust(t *testing.T) {
+	local, _ := dialPair(t, "0")
+
+	conn := local.(*Conn)
+	for _, r := range []bool{true, false} {
+		if err := transport.Robust(conn).SetRobust(r); err != nil {
+			t.Fatal(err)
+		}
+		if conn.Robust() != r {
+			t.Errorf("Got robust %t, expected %t", conn.Robust(), r)
+		}
+	}
+}
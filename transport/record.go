@@ -0,0 +1,91 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Direction markers used in captures written by a recording conn.
+const (
+	RecordRx = '<' // Data read from the connection
+	RecordTx = '>' // Data written to the connection
+)
+
+type recordingConn struct {
+	net.Conn
+
+	mu sync.Mutex // Guards w
+	w  io.Writer
+}
+
+// NewRecordingConn returns a net.Conn that records all data read from and written to c.
+//
+// Each chunk of data is written to w as a line holding the direction marker (RecordRx or
+// RecordTx) and the chunk length, followed by the raw bytes and a newline:
+//
+//	> 3\nFF\r\n
+//	< 3\nFQ\r\n
+//
+// The capture can be used to replay a session when debugging interoperability issues.
+// Errors writing to w are ignored.
+//
+// The returned conn implements the Flusher, TxBuffer and Robust interfaces by passing the
+// calls through to c. If c does not implement Flusher or TxBuffer, Flush is a no-op and
+// TxBufferLen returns 0.
+func NewRecordingConn(c net.Conn, w io.Writer) net.Conn {
+	return &recordingConn{Conn: c, w: w}
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.record(RecordRx, p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.record(RecordTx, p[:n])
+	return n, err
+}
+
+func (c *recordingConn) record(dir byte, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.w, "%c %d\n", dir, len(p))
+	c.w.Write(p)
+	io.WriteString(c.w, "\n")
+}
+
+// Flush implements the Flusher interface.
+func (c *recordingConn) Flush() error {
+	if f, ok := c.Conn.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// TxBufferLen implements the TxBuffer interface.
+func (c *recordingConn) TxBufferLen() int {
+	if b, ok := c.Conn.(TxBuffer); ok {
+		return b.TxBufferLen()
+	}
+	return 0
+}
+
+// SetRobust implements the Robust interface.
+func (c *recordingConn) SetRobust(r bool) error {
+	if rc, ok := AsRobust(c.Conn); ok {
+		return rc.SetRobust(r)
+	}
+	return errors.New("Robust mode not supported by the underlying connection")
+}
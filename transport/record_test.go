@@ -0,0 +1,73 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// modemConn is a net.Conn implementing Flusher and Robust.
+type modemConn struct {
+	net.Conn
+	flushed int
+	robust  bool
+}
+
+func (c *modemConn) Flush() error           { c.flushed++; return nil }
+func (c *modemConn) SetRobust(r bool) error { c.robust = r; return nil }
+
+func TestRecordingConn(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+	modem := &modemConn{Conn: client}
+
+	var capture bytes.Buffer
+	conn := NewRecordingConn(modem, &capture)
+
+	go func() {
+		buf := make([]byte, 3)
+		io.ReadFull(srv, buf)
+		srv.Write([]byte("FQ\r"))
+	}()
+	conn.Write([]byte("FF\r"))
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := "> 3\nFF\r\n< 3\nFQ\r\n"; capture.String() != expect {
+		t.Errorf("Got capture %q, expected %q", capture.String(), expect)
+	}
+
+	// Capabilities of the underlying conn should be reachable
+	f, ok := conn.(Flusher)
+	if !ok {
+		t.Fatal("Recording conn does not implement Flusher")
+	}
+	f.Flush()
+	if modem.flushed != 1 {
+		t.Errorf("Flush did not reach the underlying conn")
+	}
+	r, ok := conn.(Robust)
+	if !ok {
+		t.Fatal("Recording conn does not implement Robust")
+	}
+	r.SetRobust(true)
+	if !modem.robust {
+		t.Errorf("SetRobust did not reach the underlying conn")
+	}
+
+	// Unsupported by the underlying conn
+	plain := NewRecordingConn(srv, io.Discard)
+	if err := plain.(Robust).SetRobust(true); err == nil {
+		t.Errorf("Expected error from SetRobust on a conn without robust mode")
+	}
+	if n := plain.(TxBuffer).TxBufferLen(); n != 0 {
+		t.Errorf("Got TxBufferLen %d, expected 0", n)
+	}
+}
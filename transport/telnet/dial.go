@@ -8,7 +8,9 @@ package telnet
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"strings"
 	"time"
@@ -28,22 +30,94 @@ func init() {
 	transport.RegisterDialer("telnet", DefaultDialer)
 }
 
+// The minimum number of attempts made by DialCMSHosts before giving up.
+const cmsDialAttempts = 4
+
+// DefaultCMSHosts is the list of CMS telnet addresses dialed by DialCMS and DialCMSContext.
+var DefaultCMSHosts = []string{CMSAddress}
+
+// CMSDialError is returned by DialCMS, DialCMSContext and DialCMSHosts when all attempts failed.
+type CMSDialError struct {
+	// The error of each failed attempt, in order.
+	Attempts []error
+}
+
+func (e *CMSDialError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Unable to connect to CMS after %d attempt(s)", len(e.Attempts))
+	for i, err := range e.Attempts {
+		fmt.Fprintf(&b, "; attempt %d: %s", i+1, err)
+	}
+	return b.String()
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *CMSDialError) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1]
+}
+
 // DialCMS dials a random CMS server through server.winlink.org.
 //
-// The function will retry 4 times before giving up and returning an error.
+// The function will retry 4 times before giving up and returning an error. See DialCMSHosts.
 func DialCMS(mycall string) (net.Conn, error) {
-	var conn net.Conn
-	var err error
+	return DialCMSContext(context.Background(), mycall)
+}
 
-	// Dial with retry, in case we hit an unavailable CMS.
-	for i := 0; i < 4; i++ {
-		conn, err = Dial(CMSAddress, mycall, CMSPassword)
-		if err == nil {
+// DialCMSContext dials a random CMS server from DefaultCMSHosts.
+//
+// Each attempt is limited by DefaultDialer's Timeout. See DialCMSHosts.
+func DialCMSContext(ctx context.Context, mycall string) (net.Conn, error) {
+	return DialCMSHosts(ctx, mycall, DefaultCMSHosts, DefaultDialer.Timeout)
+}
+
+// DialCMSHosts dials the given CMS telnet addresses in turn, returning the first successful connection.
+//
+// The hosts are tried in a rotating order starting at a random host, to spread the load. Every
+// host is tried at least once, and at least 4 attempts are made in total unless ctx is done
+// first. On failure, a *CMSDialError listing the error of each attempt is returned.
+//
+// Each attempt (connect and login) is limited by attemptTimeout. If zero, the time left until ctx's
+// deadline is shared evenly by the remaining attempts. Without either, attempts are bounded by ctx only.
+func DialCMSHosts(ctx context.Context, mycall string, hosts []string, attemptTimeout time.Duration) (net.Conn, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("No CMS hosts given")
+	}
+
+	attempts := cmsDialAttempts
+	if len(hosts) > attempts {
+		attempts = len(hosts)
+	}
+
+	dialErr := new(CMSDialError)
+	offset := rand.Intn(len(hosts))
+	for i := 0; i < attempts; i++ {
+		if err := ctx.Err(); err != nil {
+			dialErr.Attempts = append(dialErr.Attempts, err)
 			break
 		}
+
+		timeout := attemptTimeout
+		if deadline, ok := ctx.Deadline(); ok && timeout == 0 {
+			timeout = time.Until(deadline) / time.Duration(attempts-i)
+		}
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+
+		host := hosts[(offset+i)%len(hosts)]
+		conn, err := DialContext(attemptCtx, host, mycall, CMSPassword)
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		dialErr.Attempts = append(dialErr.Attempts, fmt.Errorf("%s: %w", host, err))
 	}
 
-	return conn, err
+	return nil, dialErr
 }
 
 // Dialer implements the transport.Dialer interface.
@@ -100,7 +174,11 @@ func DialContext(ctx context.Context, addr, mycall, password string) (net.Conn,
 		return nil, err
 	}
 
-	// Log in to telnet server
+	// Log in to telnet server (within the context's deadline)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
 	reader := bufio.NewReader(conn)
 L:
 	for {
@@ -109,7 +187,7 @@ L:
 		switch {
 		case err != nil:
 			conn.Close()
-			return nil, fmt.Errorf("Error while logging in: %s", err)
+			return nil, fmt.Errorf("Error while logging in: %w", err)
 		case strings.HasPrefix(line, "callsign"):
 			fmt.Fprintf(conn, "%s\r", mycall)
 		case strings.HasPrefix(line, "password"):
@@ -0,0 +1,166 @@
+// Copyright 2015 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package telnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/la5nta/wl2k-go/transport"
+)
+
+func TestRegisteredScheme(t *testing.T) {
+	for _, scheme := range transport.RegisteredSchemes() {
+		if scheme == "telnet" {
+			return
+		}
+	}
+	t.Errorf("telnet not found in %v", transport.RegisteredSchemes())
+}
+
+// cmsServer starts a fake CMS telnet server, failing the first n connections.
+//
+// The server is used as the only entry in DefaultCMSHosts until the test completes.
+func cmsServer(t *testing.T, fail int) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	DefaultCMSHosts = []string{ln.Addr().String()}
+	t.Cleanup(func() { DefaultCMSHosts = []string{CMSAddress}; ln.Close() })
+
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if i < fail {
+				conn.Close()
+				continue
+			}
+			fmt.Fprint(conn, "Callsign :\r")
+			fmt.Fprint(conn, "Password :\r")
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestDialCMSRetry(t *testing.T) {
+	cmsServer(t, 2)
+	conn, err := DialCMS("N0CALL")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	conn.Close()
+}
+
+func TestDialCMSAttemptErrors(t *testing.T) {
+	cmsServer(t, cmsDialAttempts)
+	_, err := DialCMS("N0CALL")
+
+	var dialErr *CMSDialError
+	if !errors.As(err, &dialErr) {
+		t.Fatalf("Got %v, expected *CMSDialError", err)
+	}
+	if len(dialErr.Attempts) != cmsDialAttempts {
+		t.Errorf("Got %d attempts, expected %d", len(dialErr.Attempts), cmsDialAttempts)
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Expected last attempt to fail with EOF, got %v", errors.Unwrap(err))
+	}
+}
+
+func TestDialCMSContextCancel(t *testing.T) {
+	cmsServer(t, cmsDialAttempts)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := DialCMSContext(ctx, "N0CALL")
+	var dialErr *CMSDialError
+	if !errors.As(err, &dialErr) || len(dialErr.Attempts) != 1 {
+		t.Fatalf("Got %v, expected a single attempt", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Got %v, expected context.Canceled", err)
+	}
+}
+
+func TestDialCMSHostsFailover(t *testing.T) {
+	// A host that refuses connections
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := ln.Addr().String()
+	ln.Close()
+
+	working := cmsServer(t, 0)
+	for i := 0; i < 5; i++ { // The start host is random
+		conn, err := DialCMSHosts(context.Background(), "N0CALL", []string{failing, working}, time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		conn.Close()
+	}
+
+	_, err = DialCMSHosts(context.Background(), "N0CALL", []string{failing}, time.Second)
+	var dialErr *CMSDialError
+	if !errors.As(err, &dialErr) || len(dialErr.Attempts) != cmsDialAttempts {
+		t.Fatalf("Got %v, expected %d failed attempts", err, cmsDialAttempts)
+	}
+	if !strings.HasPrefix(dialErr.Attempts[0].Error(), failing) {
+		t.Errorf("Expected attempt error to be prefixed by host, got %q", dialErr.Attempts[0])
+	}
+}
+
+func TestDialCMSHostsAttemptTimeout(t *testing.T) {
+	// A host that accepts connections, but never prompts for login
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() { io.Copy(io.Discard, conn); conn.Close() }()
+		}
+	}()
+	silent := ln.Addr().String()
+
+	// Given timeout
+	_, err = DialCMSHosts(context.Background(), "N0CALL", []string{silent}, 10*time.Millisecond)
+	var dialErr *CMSDialError
+	if !errors.As(err, &dialErr) || len(dialErr.Attempts) != cmsDialAttempts {
+		t.Fatalf("Got %v, expected %d failed attempts", err, cmsDialAttempts)
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Got %v, expected os.ErrDeadlineExceeded", errors.Unwrap(err))
+	}
+
+	// Derived from the caller's deadline, so that every attempt is made
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = DialCMSHosts(ctx, "N0CALL", []string{silent}, 0)
+	if !errors.As(err, &dialErr) || len(dialErr.Attempts) != cmsDialAttempts {
+		t.Fatalf("Got %v, expected %d failed attempts", err, cmsDialAttempts)
+	}
+	for i, err := range dialErr.Attempts {
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Attempt %d: Got %v, expected os.ErrDeadlineExceeded", i+1, err)
+		}
+	}
+}
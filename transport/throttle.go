@@ -0,0 +1,49 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// The interval between TxBufferLen polls while a ThrottledWriter is blocked.
+var throttlePollInterval = 100 * time.Millisecond
+
+type throttledWriter struct {
+	ctx       context.Context
+	w         io.Writer
+	b         TxBuffer
+	maxQueued int
+}
+
+// NewThrottledWriter returns a writer that provides backpressure based on the transmit buffer of a modem.
+//
+// Each Write blocks until the number of bytes in b's transmit buffer (TxBufferLen) drops below maxQueued,
+// before writing to w. This prevents the writer from overflowing the modem's transmit buffer when writing
+// faster than the air rate.
+//
+// The wait is aborted with ctx.Err() when ctx is done, so a transport that stops draining its transmit
+// buffer (e.g. after a disconnect) does not block the writer forever. The context should be cancelled when
+// the underlying connection is closed.
+func NewThrottledWriter(ctx context.Context, w io.Writer, b TxBuffer, maxQueued int) io.Writer {
+	return &throttledWriter{ctx: ctx, w: w, b: b, maxQueued: maxQueued}
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	if t.b.TxBufferLen() >= t.maxQueued {
+		ticker := time.NewTicker(throttlePollInterval)
+		defer ticker.Stop()
+		for t.b.TxBufferLen() >= t.maxQueued {
+			select {
+			case <-t.ctx.Done():
+				return 0, t.ctx.Err()
+			case <-ticker.C:
+			}
+		}
+	}
+	return t.w.Write(p)
+}
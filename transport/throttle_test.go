@@ -0,0 +1,106 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowModem is a TxBuffer that transmits (drains) n bytes of its buffer at every tick.
+type slowModem struct {
+	mu        sync.Mutex
+	queued    int
+	maxQueued int // The largest number of bytes queued before a write
+}
+
+func (m *slowModem) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queued > m.maxQueued {
+		m.maxQueued = m.queued
+	}
+	m.queued += len(p)
+	return len(p), nil
+}
+
+func (m *slowModem) TxBufferLen() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queued
+}
+
+func (m *slowModem) drain(n int, tick time.Duration, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(tick):
+		}
+		m.mu.Lock()
+		if m.queued -= n; m.queued < 0 {
+			m.queued = 0
+		}
+		m.mu.Unlock()
+	}
+}
+
+func TestThrottledWriter(t *testing.T) {
+	defer func(d time.Duration) { throttlePollInterval = d }(throttlePollInterval)
+	throttlePollInterval = time.Millisecond
+
+	modem := &slowModem{}
+	done := make(chan struct{})
+	defer close(done)
+	go modem.drain(10, 5*time.Millisecond, done)
+
+	w := NewThrottledWriter(context.Background(), modem, modem, 50)
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if n, err := w.Write(make([]byte, 25)); n != 25 || err != nil {
+			t.Fatalf("Got %d, %v, expected 25, nil", n, err)
+		}
+	}
+
+	if modem.maxQueued >= 50 {
+		t.Errorf("Write with %d bytes queued, expected less than 50", modem.maxQueued)
+	}
+	// 500 bytes written, at most 50+25 bytes queued: At least 425 bytes must have been drained (10 bytes every 5ms).
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Writes returned after %s, expected backpressure", elapsed)
+	}
+}
+
+func TestThrottledWriterCancel(t *testing.T) {
+	defer func(d time.Duration) { throttlePollInterval = d }(throttlePollInterval)
+	throttlePollInterval = time.Millisecond
+
+	// The modem is never drained, like a TNC that stopped transmitting after a disconnect.
+	modem := &slowModem{queued: 50}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	w := NewThrottledWriter(ctx, modem, modem, 50)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 25))
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Got %v, expected %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write did not return after the context was done")
+	}
+	if n := modem.TxBufferLen(); n != 50 {
+		t.Errorf("Got %d bytes queued, expected 50 (nothing written)", n)
+	}
+}
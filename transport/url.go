@@ -5,12 +5,23 @@
 package transport
 
 import (
+	"fmt"
 	"net/url"
 	"path"
-	"sort"
+	"regexp"
 	"strings"
 )
 
+// callsignPattern matches plausible AX.25 callsigns with an optional SSID (e.g. LA1B-10).
+var callsignPattern = regexp.MustCompile(`^[A-Z0-9]+(-[A-Z0-9]{1,2})?$`)
+
+// validCallsign reports whether call is a plausible callsign.
+//
+// Email addresses (containing '@') are exempt, as these are valid targets for some schemes.
+func validCallsign(call string) bool {
+	return strings.Contains(call, "@") || callsignPattern.MatchString(call)
+}
+
 // URL contains all information needed to dial a remote node.
 type URL struct {
 	// TNC/modem/interface/network type.
@@ -25,7 +36,9 @@ type URL struct {
 	// Target callsign.
 	Target string
 
-	// List of digipeaters ("path" between origin and target).
+	// List of digipeaters ("path" between origin and target), in transmit order.
+	//
+	// The first digipeater is the one closest to the origin.
 	Digis []string
 
 	// List of query parameters.
@@ -38,8 +51,17 @@ type URL struct {
 // Examples:
 //   - ardop:///LA1B                        (Addresses LA1B on ARDOP).
 //   - ax25://mycall@myaxport/LD5SK/LA1B-10 (Addresses LA1B-10 via LD5SK using AX.25-port "myaxport" and "MYCALL" as source callsign).
+//   - ax25:///LD5SK/LD5GU/LA1B-10          (Addresses LA1B-10 via LD5SK, then LD5GU).
+//
+// The digipeaters are given in transmit order, i.e. the order they are traversed from origin to target.
 //
 // The special query parameter host will override the host part of the path. (E.g. ax25:///LA1B?host=ax0 == ax25://ax0/LA1B).
+//
+// The special query parameter source overrides the local (source) callsign bound by the dialer, without changing the session
+// identity given by the user (see Source). (E.g. ax25://mycall@axport/LA1B?source=mycall-7).
+//
+// The source (mycall) and target callsigns are normalized to upper case, and must be plausible
+// callsigns (alphanumeric with an optional -SSID). Email addresses are accepted as-is.
 func ParseURL(rawurl string) (*URL, error) {
 	u, err := url.Parse(rawurl)
 	if err != nil {
@@ -53,6 +75,22 @@ func ParseURL(rawurl string) (*URL, error) {
 	if len(target) < 3 {
 		return nil, ErrInvalidTarget
 	}
+	if !validCallsign(target) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+
+	// The source callsign should be upper case as well
+	if call := u.User.Username(); call != "" {
+		call = strings.ToUpper(call)
+		if !validCallsign(call) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSource, call)
+		}
+		if password, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(call, password)
+		} else {
+			u.User = url.User(call)
+		}
+	}
 
 	url := &URL{
 		Scheme: u.Scheme,
@@ -66,15 +104,26 @@ func ParseURL(rawurl string) (*URL, error) {
 		url.Host = str
 	}
 
+	// The source callsign override should be upper case as well
+	if str := url.Params.Get("source"); str != "" {
+		str = strings.ToUpper(str)
+		if !validCallsign(str) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSource, str)
+		}
+		url.Params.Set("source", str)
+	}
+
 	// Digis
 	url.Digis = strings.Split(strings.Trim(via, "/"), "/")
-	_ = sort.Reverse(sort.StringSlice(url.Digis))
 	if len(url.Digis) == 1 && url.Digis[0] == "" {
 		url.Digis = []string{}
 	}
 
 	// TODO: This should be up to the specific transport to decide.
-	digisUnsupported := url.Scheme == "ardop" || url.Scheme == "telnet"
+	dialers.mu.Lock()
+	scheme := resolveScheme(url.Scheme)
+	dialers.mu.Unlock()
+	digisUnsupported := scheme == "ardop" || scheme == "telnet"
 	if len(url.Digis) > 0 && digisUnsupported {
 		return url, ErrDigisUnsupported
 	}
@@ -84,3 +133,52 @@ func ParseURL(rawurl string) (*URL, error) {
 
 // Set the URL.User's username (usually the source callsign).
 func (u *URL) SetUser(call string) { u.User = url.User(call) }
+
+// MyCall returns the local station's callsign given by the URL, as used by ListenURL.
+//
+// This is the URL's user if set, otherwise the target.
+func (u *URL) MyCall() string {
+	if call := u.User.Username(); call != "" {
+		return call
+	}
+	return u.Target
+}
+
+// Source returns the local (source) callsign to bind to when dialing.
+//
+// This is the source query parameter if set, otherwise the URL's user. The parameter takes precedence over the user,
+// which allows the bound local address (e.g. MYCALL-7) to differ from the callsign the session identifies as (MYCALL).
+func (u *URL) Source() string {
+	if call := u.Params.Get("source"); call != "" {
+		return call
+	}
+	return u.User.Username()
+}
+
+// String reassembles the URL into a valid URL string.
+//
+// The result can be parsed by ParseURL. A host containing slashes (e.g. a serial device path) is
+// given as the host query parameter.
+func (u *URL) String() string {
+	params := u.Params
+	host := u.Host
+	switch {
+	case params.Get("host") != "":
+		host = "" // The host parameter takes precedence (see ParseURL).
+	case strings.Contains(host, "/"):
+		params = make(url.Values, len(u.Params)+1)
+		for k, v := range u.Params {
+			params[k] = v
+		}
+		params.Set("host", host)
+		host = ""
+	}
+
+	return (&url.URL{
+		Scheme:   u.Scheme,
+		User:     u.User,
+		Host:     host,
+		Path:     "/" + path.Join(append(append([]string{}, u.Digis...), u.Target)...),
+		RawQuery: params.Encode(),
+	}).String()
+}